@@ -2,12 +2,8 @@ package backend
 
 import (
 	"context"
-	"crypto/rand"
-	"encoding/hex"
 	"fmt"
 	"math"
-	"os"
-	"path/filepath"
 	"sort"
 	"strings"
 	"time"
@@ -15,37 +11,120 @@ import (
 	"github.com/wailsapp/wails/v2/pkg/runtime"
 
 	"github.com/atotto/clipboard"
+	"github.com/simp-lee/passwordmanager/internal/config"
 	"github.com/simp-lee/passwordmanager/internal/crypto"
 	"github.com/simp-lee/passwordmanager/internal/errors"
 	"github.com/simp-lee/passwordmanager/internal/generator"
+	"github.com/simp-lee/passwordmanager/internal/gitrepo"
+	"github.com/simp-lee/passwordmanager/internal/importer"
+	"github.com/simp-lee/passwordmanager/internal/interchange"
 	"github.com/simp-lee/passwordmanager/internal/model"
 	"github.com/simp-lee/passwordmanager/internal/storage"
+	"github.com/simp-lee/passwordmanager/internal/vaults"
 )
 
 type App struct {
-	ctx        context.Context
-	store      *storage.Storage
-	dataDir    string
-	isUnlocked bool
+	ctx          context.Context
+	store        *storage.Storage
+	dataDir      string
+	vaultName    string // 当前打开的命名密码库
+	isUnlocked   bool
+	pendingMerge *storage.MergePlan // 已预览、等待确认的合并
 }
 
 func NewApp() *App {
-	homeDir, err := os.UserHomeDir()
+	a := &App{}
+	// 打开默认密码库（PASSWORDMANAGER_DIR 或 ~/.passwordmanager 中登记的默认项）
+	if err := a.openVault(""); err != nil {
+		panic(fmt.Sprintf("failed to open vault: %v", err))
+	}
+	return a
+}
+
+// openVault 打开名为 name 的密码库（为空时打开默认密码库），打开后处于锁定状态
+func (a *App) openVault(name string) error {
+	registry, err := a.registry()
+	if err != nil {
+		return err
+	}
+	vault, err := registry.Get(name)
+	if err != nil {
+		return err
+	}
+
+	backend, err := storage.OpenBackend(vault.Dir)
 	if err != nil {
-		panic(fmt.Sprintf("failed to get home directory: %v", err))
+		return fmt.Errorf("failed to create storage backend: %w", err)
+	}
+	store, err := storage.NewWithBackend(vault.Dir, backend)
+	if err != nil {
+		return fmt.Errorf("failed to create storage: %w", err)
+	}
+
+	// 数据目录是 git 仓库时，每次保存都创建一个提交
+	if _, local := backend.(*storage.LocalBackend); local && gitrepo.IsRepository(vault.Dir) {
+		if repo, err := gitrepo.Open(vault.Dir, storage.VaultFileName); err == nil {
+			store.SetSaveHook(repo.Commit)
+		}
 	}
-	dataDir := filepath.Join(homeDir, ".passwordmanager")
 
-	store, err := storage.New(dataDir)
+	a.store = store
+	a.dataDir = vault.Dir
+	a.vaultName = vault.Name
+	a.isUnlocked = false
+	a.pendingMerge = nil
+	return nil
+}
+
+// registry 读取根目录中的密码库列表
+func (a *App) registry() (*vaults.Registry, error) {
+	root, err := vaults.RootDir("")
 	if err != nil {
-		panic(fmt.Sprintf("failed to create storage: %v", err))
+		return nil, err
 	}
+	return vaults.Load(root)
+}
 
-	return &App{
-		store:      store,
-		dataDir:    dataDir,
-		isUnlocked: false,
+// ListVaults 返回所有命名密码库
+func (a *App) ListVaults() ([]vaults.Vault, error) {
+	registry, err := a.registry()
+	if err != nil {
+		return nil, err
 	}
+	return registry.List(), nil
+}
+
+// CurrentVault 返回当前打开的密码库名称
+func (a *App) CurrentVault() string {
+	return a.vaultName
+}
+
+// SwitchVault 锁定当前密码库并切换到名为 name 的密码库
+func (a *App) SwitchVault(name string) error {
+	return a.openVault(name)
+}
+
+// GetSettings 返回与命令行共用的设置（根目录中的 config.json）
+func (a *App) GetSettings() (*config.Config, error) {
+	root, err := vaults.RootDir("")
+	if err != nil {
+		return nil, err
+	}
+	return config.Load(root)
+}
+
+// SettingFields 返回设置项的说明和取值范围
+func (a *App) SettingFields() []config.Field {
+	return config.Fields()
+}
+
+// SaveSettings 校验并保存设置
+func (a *App) SaveSettings(settings *config.Config) error {
+	root, err := vaults.RootDir("")
+	if err != nil {
+		return err
+	}
+	return settings.Save(root)
 }
 
 func (a *App) SetContext(ctx context.Context) {
@@ -66,6 +145,9 @@ func (a *App) UnlockVault(masterPassword string) error {
 }
 
 func (a *App) CreateVault(masterPassword string) error {
+	if err := a.checkMasterPasswordLength(masterPassword); err != nil {
+		return err
+	}
 	if err := a.store.CreateVault(masterPassword); err != nil {
 		return err
 	}
@@ -85,9 +167,13 @@ func (a *App) AddAccount(account *model.Account, password string) error {
 		return errors.ErrVaultLocked
 	}
 
+	if err := validatePasswordRules(account.PasswordRules); err != nil {
+		return err
+	}
+
 	now := time.Now()
 	if account.ID == "" {
-		account.ID = a.generateID()
+		account.ID = crypto.GenerateID()
 	}
 	account.CreatedAt = now
 	account.UpdatedAt = now
@@ -128,6 +214,10 @@ func (a *App) UpdateAccount(account *model.Account, password *string) error {
 		return errors.ErrVaultLocked
 	}
 
+	if err := validatePasswordRules(account.PasswordRules); err != nil {
+		return err
+	}
+
 	// Encrypt the password before storing it
 	if password != nil {
 		encrptedPassword, err := crypto.Encrypt([]byte(*password), a.store.GetEncryptionKey())
@@ -160,6 +250,9 @@ func (a *App) DecryptPassword(id string) (string, error) {
 	if account == nil {
 		return "", errors.ErrAccountNotFound
 	}
+	if account.Derived != nil {
+		return "", errors.ErrPasswordDerived
+	}
 	decryptedPassword, err := crypto.Decrypt(account.EncryptedPassword, a.store.GetEncryptionKey())
 	if err != nil {
 		return "", fmt.Errorf("failed to decrypt password: %w", err)
@@ -183,20 +276,90 @@ func (a *App) CopyToClipboard(content string, clearAfterSeconds int) error {
 	return nil
 }
 
-func (a *App) GeneratePassword(opts generator.Options) (string, error) {
+func (a *App) GeneratePassword(opts generator.Options) (generator.Result, error) {
 	return generator.GeneratePassword(opts)
 }
 
+// PasswordEntropy returns the theoretical entropy in bits of passwords generated with opts.
+func (a *App) PasswordEntropy(opts generator.Options) (float64, error) {
+	return generator.Entropy(opts)
+}
+
+// GeneratePasswordWithRules generates a password that satisfies the account's site rules.
+// Falls back to the plain options when rules is empty.
+func (a *App) GeneratePasswordWithRules(opts generator.Options, rules string) (generator.Result, error) {
+	return generator.GeneratePasswordWithRules(rules, opts)
+}
+
+// DefaultUsernameOptions returns the default username generation options.
+func (a *App) DefaultUsernameOptions() generator.UsernameOptions {
+	return generator.DefaultUsernameOptions()
+}
+
+// GenerateUsername generates a random, non-identifying username.
+func (a *App) GenerateUsername(opts generator.UsernameOptions) (string, error) {
+	return generator.GenerateUsername(opts)
+}
+
+// GetEmailAliases returns the email alias templates stored in the vault.
+func (a *App) GetEmailAliases() ([]string, error) {
+	if !a.isUnlocked {
+		return nil, errors.ErrVaultLocked
+	}
+	return a.store.GetEmailAliases()
+}
+
+// SetEmailAliases validates and stores the email alias templates.
+func (a *App) SetEmailAliases(templates []string) error {
+	if !a.isUnlocked {
+		return errors.ErrVaultLocked
+	}
+	for _, template := range templates {
+		if err := generator.ValidateEmailAliasTemplate(template); err != nil {
+			return err
+		}
+	}
+	return a.store.SetEmailAliases(templates)
+}
+
+// GenerateEmailAlias expands an email alias template, using tag (usually the platform) for {tag}.
+func (a *App) GenerateEmailAlias(template, tag string) (string, error) {
+	return generator.GenerateEmailAlias(template, tag)
+}
+
 func (a *App) ChangeMasterPassword(oldPassword, newPassword string) error {
 	if !a.isUnlocked {
 		return errors.ErrVaultLocked
 	}
 
+	if err := a.checkMasterPasswordLength(newPassword); err != nil {
+		return err
+	}
 	if err := a.store.UnlockVault(oldPassword); err != nil {
 		return errors.ErrInvalidPassword
 	}
 
-	return a.store.ChangeMasterPassword(newPassword)
+	return a.store.ChangeMasterPassword(oldPassword, newPassword)
+}
+
+// HasDerivedAccounts 判断密码库中是否有派生账户；派生密码只能用主密码计算
+func (a *App) HasDerivedAccounts() (bool, error) {
+	if !a.isUnlocked {
+		return false, errors.ErrVaultLocked
+	}
+	return a.store.HasDerivedAccounts()
+}
+
+// checkMasterPasswordLength 按设置检查新主密码的最小长度
+func (a *App) checkMasterPasswordLength(password string) error {
+	settings, err := a.GetSettings()
+	if err != nil {
+		return err
+	}
+	if minLength := settings.Security.MasterPasswordMinLength; len(password) < minLength {
+		return fmt.Errorf("主密码至少需要%d个字符", minLength)
+	}
+	return nil
 }
 
 func (a *App) SearchAccounts(query string) ([]*model.Account, error) {
@@ -206,13 +369,13 @@ func (a *App) SearchAccounts(query string) ([]*model.Account, error) {
 	return a.store.SearchAccounts(query)
 }
 
-func (a *App) generateID() string {
-	buf := make([]byte, 8)
-	_, err := rand.Read(buf)
-	if err != nil {
-		panic(fmt.Sprintf("failed to generate ID: %v", err))
+// validatePasswordRules checks that a stored rule string can be parsed.
+func validatePasswordRules(rules string) error {
+	if strings.TrimSpace(rules) == "" {
+		return nil
 	}
-	return hex.EncodeToString(buf)
+	_, err := generator.ParsePasswordRules(rules)
+	return err
 }
 
 // ShowExportDialog 显示导出文件对话框并返回选择的路径
@@ -250,8 +413,8 @@ func (a *App) ShowImportDialog() (string, error) {
 		Title: "导入密码库",
 		Filters: []runtime.FileFilter{
 			{
-				DisplayName: "加密备份文件 (*.encrypted)",
-				Pattern:     "*.encrypted",
+				DisplayName: "加密备份文件 (*.encrypted;*.json)",
+				Pattern:     "*.encrypted;*.json",
 			},
 		},
 	})
@@ -321,8 +484,8 @@ func (a *App) ImportVault() error {
 	return a.ImportVaultFromPath(path)
 }
 
-// ExportCsv 显示对话框并导出密码库为CSV格式
-func (a *App) ExportCsv() error {
+// ExportCsv 显示对话框并导出密码库为CSV格式，派生密码用 masterPassword 计算
+func (a *App) ExportCsv(masterPassword string) error {
 	if !a.isUnlocked {
 		return errors.ErrVaultLocked
 	}
@@ -354,7 +517,298 @@ func (a *App) ExportCsv() error {
 		path += ".csv" // 如果没有扩展名，则添加.csv
 	}
 
-	return a.store.ExportToCSV(path)
+	return a.store.ExportToCSV(path, masterPassword)
+}
+
+// ImportPreviewItem 导入向导预览中的一行（不包含密码）
+type ImportPreviewItem struct {
+	Platform  string
+	Username  string
+	URL       string
+	Group     string
+	Duplicate bool
+}
+
+// ImportPreview 导入向导的预览结果
+type ImportPreview struct {
+	Items      []ImportPreviewItem
+	New        int
+	Duplicates int
+	Skipped    int
+}
+
+// ImportFormats 返回支持的导入格式
+func (a *App) ImportFormats() []string {
+	return importer.Formats()
+}
+
+// ShowImportCsvDialog 显示选择其他密码管理器导出文件的对话框
+func (a *App) ShowImportCsvDialog() (string, error) {
+	return runtime.OpenFileDialog(a.ctx, runtime.OpenDialogOptions{
+		Title: "选择要导入的导出文件",
+		Filters: []runtime.FileFilter{
+			{
+				DisplayName: "导出文件 (*.csv, *.json)",
+				Pattern:     "*.csv;*.json",
+			},
+		},
+	})
+}
+
+// PreviewImportCsv 读取导出文件并返回将要导入的账户预览，不写入密码库
+func (a *App) PreviewImportCsv(path string, options importer.Options) (*ImportPreview, error) {
+	preview, err := a.loadImport(path, options)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ImportPreview{
+		New:        len(preview.Entries),
+		Duplicates: len(preview.Duplicates),
+		Skipped:    preview.Skipped,
+	}
+	for _, entry := range preview.Entries {
+		result.Items = append(result.Items, ImportPreviewItem{entry.Platform, entry.Username, entry.URL, entry.Group, false})
+	}
+	for _, entry := range preview.Duplicates {
+		result.Items = append(result.Items, ImportPreviewItem{entry.Platform, entry.Username, entry.URL, entry.Group, true})
+	}
+	return result, nil
+}
+
+// ImportCsv 导入导出文件中的账户，返回新增的账户数量
+func (a *App) ImportCsv(path string, options importer.Options) (int, error) {
+	preview, err := a.loadImport(path, options)
+	if err != nil {
+		return 0, err
+	}
+	return importer.Commit(a.store, preview)
+}
+
+func (a *App) loadImport(path string, options importer.Options) (*importer.Preview, error) {
+	if !a.isUnlocked {
+		return nil, errors.ErrVaultLocked
+	}
+	if path == "" {
+		return nil, fmt.Errorf("未指定导入文件路径")
+	}
+
+	accounts, err := a.store.GetAccounts()
+	if err != nil {
+		return nil, err
+	}
+	return importer.Load(path, accounts, options)
+}
+
+// MergeReportItem 合并预览中的一行（不包含密码）
+type MergeReportItem struct {
+	Action   string
+	Platform string
+	Username string
+	Fields   []string
+}
+
+// MergeReport 合并导入的差异报告，相同的账户只计数
+type MergeReport struct {
+	Items      []MergeReportItem
+	Added      int
+	Replaced   int
+	Duplicated int
+	Kept       int
+	Unchanged  int
+}
+
+// MergeResult 是应用合并的结果
+type MergeResult struct {
+	Added    int
+	Replaced int
+}
+
+// MergeStrategies 返回支持的冲突处理策略
+func (a *App) MergeStrategies() []string {
+	return storage.MergeStrategies()
+}
+
+// PreviewMerge 用导入文件自己的密码解锁它，返回合并到当前密码库的差异报告，不写入密码库
+func (a *App) PreviewMerge(path, password, strategy string) (*MergeReport, error) {
+	a.pendingMerge = nil
+	if !a.isUnlocked {
+		return nil, errors.ErrVaultLocked
+	}
+	if path == "" {
+		return nil, fmt.Errorf("未指定导入文件路径")
+	}
+
+	plan, err := a.store.PlanMerge(path, password, storage.MergeStrategy(strategy))
+	if err != nil {
+		return nil, err
+	}
+	a.pendingMerge = plan
+
+	report := &MergeReport{
+		Items:      []MergeReportItem{},
+		Added:      plan.Count(storage.MergeAdd),
+		Replaced:   plan.Count(storage.MergeReplace),
+		Duplicated: plan.Count(storage.MergeDuplicate),
+		Kept:       plan.Count(storage.MergeKeep),
+		Unchanged:  plan.Count(storage.MergeUnchanged),
+	}
+	for _, change := range plan.Changes {
+		if change.Action == storage.MergeUnchanged {
+			continue
+		}
+		report.Items = append(report.Items, MergeReportItem{
+			Action:   string(change.Action),
+			Platform: change.Incoming.Platform,
+			Username: change.Incoming.Username,
+			Fields:   change.Fields,
+		})
+	}
+	return report, nil
+}
+
+// ApplyMerge 应用最近一次预览的合并
+func (a *App) ApplyMerge() (*MergeResult, error) {
+	if !a.isUnlocked {
+		return nil, errors.ErrVaultLocked
+	}
+	if a.pendingMerge == nil {
+		return nil, fmt.Errorf("没有待确认的合并")
+	}
+
+	added, replaced, err := a.store.ApplyMerge(a.pendingMerge)
+	a.pendingMerge = nil
+	if err != nil {
+		return nil, err
+	}
+	return &MergeResult{Added: added, Replaced: replaced}, nil
+}
+
+// CancelMerge 放弃最近一次预览的合并
+func (a *App) CancelMerge() {
+	a.pendingMerge = nil
+}
+
+// PortableImportResult 是合并便携导出文件的结果
+type PortableImportResult struct {
+	Added   int
+	Skipped int
+}
+
+// ShowPortableDialog 显示保存便携导出文件的对话框
+func (a *App) ShowPortableDialog() (string, error) {
+	return runtime.SaveFileDialog(a.ctx, runtime.SaveDialogOptions{
+		DefaultFilename: fmt.Sprintf("passwordmanager-portable-%s.json", time.Now().Format("2006-01-02")),
+		Title:           "导出加密便携文件",
+		Filters: []runtime.FileFilter{
+			{
+				DisplayName: "便携导出文件 (*.json)",
+				Pattern:     "*.json",
+			},
+		},
+	})
+}
+
+// IsPortableExport 判断文件是否为便携导出文件
+func (a *App) IsPortableExport(path string) bool {
+	return storage.IsPortableExport(path)
+}
+
+// ExportPortable 将选中的账户导出为使用独立口令加密的便携文件，返回导出的账户数量。
+// 派生密码用 masterPassword 计算后保存在文件中
+func (a *App) ExportPortable(path, passphrase string, selection storage.ExportSelection, masterPassword string) (int, error) {
+	if !a.isUnlocked {
+		return 0, errors.ErrVaultLocked
+	}
+	if path == "" {
+		return 0, fmt.Errorf("未指定导出文件路径")
+	}
+	if len(passphrase) < 8 {
+		return 0, fmt.Errorf("导出口令至少需要8个字符")
+	}
+
+	return a.store.ExportPortable(path, passphrase, selection, masterPassword)
+}
+
+// ImportPortable 将便携导出文件合并到当前密码库
+func (a *App) ImportPortable(path, passphrase string) (*PortableImportResult, error) {
+	if !a.isUnlocked {
+		return nil, errors.ErrVaultLocked
+	}
+	if path == "" {
+		return nil, fmt.Errorf("未指定导入文件路径")
+	}
+
+	added, skipped, err := a.store.ImportPortable(path, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return &PortableImportResult{Added: added, Skipped: skipped}, nil
+}
+
+// KdbxImportResult 是导入 KeePass 数据库的结果
+type KdbxImportResult struct {
+	Added   int
+	Skipped int
+}
+
+// ShowKdbxDialog 显示选择 KeePass 数据库的对话框，save 为 true 时显示保存对话框
+func (a *App) ShowKdbxDialog(save bool) (string, error) {
+	filters := []runtime.FileFilter{
+		{
+			DisplayName: "KeePass 数据库 (*.kdbx)",
+			Pattern:     "*.kdbx",
+		},
+	}
+	if save {
+		return runtime.SaveFileDialog(a.ctx, runtime.SaveDialogOptions{
+			DefaultFilename: fmt.Sprintf("passwordmanager-%s.kdbx", time.Now().Format("2006-01-02")),
+			Title:           "导出为 KeePass 数据库",
+			Filters:         filters,
+		})
+	}
+	return runtime.OpenFileDialog(a.ctx, runtime.OpenDialogOptions{
+		Title:   "选择 KeePass 数据库",
+		Filters: filters,
+	})
+}
+
+// ShowKeyFileDialog 显示选择 KeePass 密钥文件的对话框
+func (a *App) ShowKeyFileDialog() (string, error) {
+	return runtime.OpenFileDialog(a.ctx, runtime.OpenDialogOptions{
+		Title: "选择密钥文件",
+	})
+}
+
+// ImportKdbx 从 KeePass 数据库导入账户
+func (a *App) ImportKdbx(path, password, keyFile string) (*KdbxImportResult, error) {
+	if !a.isUnlocked {
+		return nil, errors.ErrVaultLocked
+	}
+	if path == "" {
+		return nil, fmt.Errorf("未指定导入文件路径")
+	}
+
+	added, skipped, err := interchange.ImportKDBX(a.store, path, interchange.Credentials{Password: password, KeyFile: keyFile})
+	if err != nil {
+		return nil, err
+	}
+	return &KdbxImportResult{Added: added, Skipped: skipped}, nil
+}
+
+// ExportKdbx 将所有账户导出为 KeePass 数据库，返回导出的账户数量
+func (a *App) ExportKdbx(path, password, keyFile string) (int, error) {
+	if !a.isUnlocked {
+		return 0, errors.ErrVaultLocked
+	}
+	if path == "" {
+		return 0, fmt.Errorf("未指定导出文件路径")
+	}
+	if password == "" && keyFile == "" {
+		return 0, fmt.Errorf("请设置密码或选择密钥文件")
+	}
+
+	return interchange.ExportKDBX(a.store, path, interchange.Credentials{Password: password, KeyFile: keyFile})
 }
 
 // UpdateAccountsOrder 更新账户的排序顺序
@@ -2,50 +2,67 @@ package main
 
 import (
 	"bufio"
-	"crypto/rand"
-	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
+	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
 	"github.com/atotto/clipboard"
+	"golang.org/x/crypto/ssh"
 	"golang.org/x/term"
 
 	"github.com/olekukonko/tablewriter"
+	"github.com/simp-lee/passwordmanager/internal/agent"
+	"github.com/simp-lee/passwordmanager/internal/api"
+	"github.com/simp-lee/passwordmanager/internal/config"
 	"github.com/simp-lee/passwordmanager/internal/crypto"
 	"github.com/simp-lee/passwordmanager/internal/errors"
 	"github.com/simp-lee/passwordmanager/internal/generator"
+	"github.com/simp-lee/passwordmanager/internal/gitcred"
+	"github.com/simp-lee/passwordmanager/internal/gitrepo"
 	"github.com/simp-lee/passwordmanager/internal/i18n"
+	"github.com/simp-lee/passwordmanager/internal/importer"
+	"github.com/simp-lee/passwordmanager/internal/interchange"
 	"github.com/simp-lee/passwordmanager/internal/model"
+	"github.com/simp-lee/passwordmanager/internal/nativehost"
+	"github.com/simp-lee/passwordmanager/internal/pinentry"
+	"github.com/simp-lee/passwordmanager/internal/secretref"
+	"github.com/simp-lee/passwordmanager/internal/sshagent"
 	"github.com/simp-lee/passwordmanager/internal/storage"
+	"github.com/simp-lee/passwordmanager/internal/vaults"
 	"github.com/spf13/cobra"
 )
 
+const (
+	envMasterPassword = "PASSWORDMANAGER_PASSWORD" // Master password for scripts and CI
+
+	// Exit codes, so scripts can tell failures apart
+	exitError         = 1 // Any other failure
+	exitNotFound      = 2 // Account, vault or backup not found
+	exitLocked        = 3 // Vault locked and no master password available
+	exitWrongPassword = 4 // Master password does not fit the vault
+)
+
 var (
-	dataDir    string
-	store      *storage.Storage
-	isUnlocked bool
+	passwordFD  int    // --password-fd; -1 reads the master password from $PASSWORDMANAGER_PASSWORD or the terminal
+	rootDirFlag string // --data-dir
+	vaultName   string // --vault
+	dataDir     string // Data directory of the selected vault
+	store       *storage.Storage
+	settings    *config.Config // Shared with the GUI
+	isUnlocked  bool
 )
 
 func main() {
-	// Get user home directory as the base path for data storage
-	homeDir, err := os.UserHomeDir()
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to get user home directory: %v\n", err)
-		os.Exit(1)
-	}
-	dataDir = filepath.Join(homeDir, ".passwordmanager")
-
-	// Initialize the storage system
-	store, err = storage.New(dataDir)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error initializing storage: %v\n", err)
-		os.Exit(1)
-	}
-
 	// Create the root command
 	rootCmd := &cobra.Command{
 		Use:     "passwordmanager",
@@ -54,44 +71,55 @@ func main() {
 		Version: "0.1.0",
 	}
 
-	rootCmd.PersistentFlags().StringP("lang", "L", "en", i18n.T("opt_lang"))
+	rootCmd.PersistentFlags().StringP("lang", "L", "", i18n.T("opt_lang"))
+	rootCmd.PersistentFlags().StringVar(&rootDirFlag, "data-dir", "", i18n.Tf("opt_data_dir", vaults.EnvDataDir))
+	rootCmd.PersistentFlags().StringVarP(&vaultName, "vault", "V", "", i18n.T("opt_vault"))
+	rootCmd.PersistentFlags().IntVar(&passwordFD, "password-fd", -1, i18n.Tf("opt_password_fd", envMasterPassword))
 
 	// Set up language and unlock logic for all commands
 	rootCmd.PersistentPreRun = func(cmd *cobra.Command, args []string) {
-		lang, _ := cmd.Flags().GetString("lang")
+		settings = loadSettings()
+		lang := settings.Language
+		if cmd.Flags().Changed("lang") {
+			lang, _ = cmd.Flags().GetString("lang")
+		}
 		i18n.SetLanguage(lang)
 
+		// Vault management, settings and the agent work on the root directory, not on a vault
+		if cmd.Name() == "help" || cmd.Name() == "lock" ||
+			(cmd.Parent() != nil && (cmd.Parent().Name() == "vault" || cmd.Parent().Name() == "config" || cmd.Parent().Name() == "agent")) {
+			return
+		}
+		openStore()
+
 		// For commands that don't require unlocking, return directly
 		switch cmd.Name() {
 		case "init", "import", "help", "version":
 			return
-		}
-
-		// If already unlocked, no need to operate
-		if isUnlocked {
+		case "derive", "unlock":
+			// Need the master password itself, so they unlock on their own
+			return
+		case "git-credential":
+			// Has no terminal; unlocks through the agent or pinentry
+			return
+		case "native-host":
+			// Started by the browser; asks the agent for the key on each request
 			return
 		}
-
-		// Check if the vault exists
-		if !store.IsVaultExists() {
-			fmt.Fprintf(os.Stderr, i18n.T("vault_not_exists")+"\n")
-			os.Exit(1)
+		if cmd.Parent() != nil && cmd.Parent().Name() == "git" && cmd.Name() != "revert" {
+			// Only a diverged pull needs the vault, and it unlocks then
+			return
 		}
-
-		// Perform unlock operation
-		masterPassword, err := readPassword(i18n.T("enter_master_password"))
-		if err != nil {
-			fmt.Fprintf(os.Stderr, i18n.Tf("error", err.Error())+"\n")
-			os.Exit(1)
+		if cmd.Parent() != nil && cmd.Parent().Name() == "backup" {
+			// Backups are opaque files; restoring one locks the vault anyway
+			return
 		}
-
-		if err := store.UnlockVault(masterPassword); err != nil {
-			fmt.Fprintf(os.Stderr, i18n.Tf("error", err.Error())+"\n")
-			os.Exit(1)
+		if cmd.Parent() != nil && cmd.Parent().Name() == "browser" {
+			// Only manifests and the list of extensions, no secrets
+			return
 		}
 
-		crypto.ClearBytes([]byte(masterPassword))
-		isUnlocked = true
+		unlockOrExit()
 	}
 
 	// --- Command Definitions ---
@@ -142,6 +170,8 @@ func main() {
 	}
 	// Update copy option description
 	showPasswordCmd.Flags().BoolP("copy", "c", false, i18n.T("opt_copy"))
+	showPasswordCmd.Flags().BoolP("yes", "y", false, i18n.T("opt_yes_show"))
+	showPasswordCmd.Flags().StringP("output", "o", "text", i18n.T("opt_output"))
 
 	changePasswordCmd := &cobra.Command{
 		Use:   "change-master-password",
@@ -184,10 +214,350 @@ func main() {
 		Run:   exportToCsv,
 	}
 
+	deriveCmd := &cobra.Command{
+		Use:   "derive [ID]",
+		Short: i18n.T("cmd_derive_short"),
+		Args:  cobra.MaximumNArgs(1),
+		Run:   derivePassword,
+	}
+
+	emailAliasCmd := &cobra.Command{
+		Use:   "email-alias",
+		Short: i18n.T("cmd_email_alias_short"),
+	}
+	emailAliasCmd.AddCommand(
+		&cobra.Command{
+			Use:   "list",
+			Short: i18n.T("cmd_email_alias_list"),
+			Args:  cobra.NoArgs,
+			Run:   listEmailAliases,
+		},
+		&cobra.Command{
+			Use:   "add [template]",
+			Short: i18n.T("cmd_email_alias_add"),
+			Args:  cobra.ExactArgs(1),
+			Run:   addEmailAlias,
+		},
+		&cobra.Command{
+			Use:   "remove [number]",
+			Short: i18n.T("cmd_email_alias_remove"),
+			Args:  cobra.ExactArgs(1),
+			Run:   removeEmailAlias,
+		},
+	)
+
+	importCsvCmd := &cobra.Command{
+		Use:   "import-csv [path]",
+		Short: i18n.T("cmd_import_csv_short"),
+		Args:  cobra.ExactArgs(1),
+		Run:   importCsv,
+	}
+
+	importKdbxCmd := &cobra.Command{
+		Use:   "import-kdbx [path]",
+		Short: i18n.T("cmd_import_kdbx_short"),
+		Args:  cobra.ExactArgs(1),
+		Run:   importKdbx,
+	}
+
+	exportKdbxCmd := &cobra.Command{
+		Use:   "export-kdbx [path]",
+		Short: i18n.T("cmd_export_kdbx_short"),
+		Args:  cobra.ExactArgs(1),
+		Run:   exportKdbx,
+	}
+
+	syncCmd := &cobra.Command{
+		Use:   "sync [remote-path]",
+		Short: i18n.T("cmd_sync_short"),
+		Args:  cobra.ExactArgs(1),
+		Run:   syncVault,
+	}
+
+	gitCmd := &cobra.Command{
+		Use:   "git",
+		Short: i18n.T("cmd_git_short"),
+	}
+	gitLogCmd := &cobra.Command{
+		Use:   "log",
+		Short: i18n.T("cmd_git_log"),
+		Args:  cobra.NoArgs,
+		Run:   gitLog,
+	}
+	gitCmd.AddCommand(
+		&cobra.Command{
+			Use:   "init [remote-url]",
+			Short: i18n.T("cmd_git_init"),
+			Args:  cobra.MaximumNArgs(1),
+			Run:   gitInit,
+		},
+		gitLogCmd,
+		&cobra.Command{
+			Use:   "push [remote]",
+			Short: i18n.T("cmd_git_push"),
+			Args:  cobra.MaximumNArgs(1),
+			Run:   gitPush,
+		},
+		&cobra.Command{
+			Use:   "pull [remote]",
+			Short: i18n.T("cmd_git_pull"),
+			Args:  cobra.MaximumNArgs(1),
+			Run:   gitPull,
+		},
+		&cobra.Command{
+			Use:   "revert [commit]",
+			Short: i18n.T("cmd_git_revert"),
+			Args:  cobra.ExactArgs(1),
+			Run:   gitRevert,
+		},
+	)
+
+	backupCmd := &cobra.Command{
+		Use:   "backup",
+		Short: i18n.T("cmd_backup_short"),
+	}
+	backupCmd.AddCommand(
+		&cobra.Command{
+			Use:   "list",
+			Short: i18n.T("cmd_backup_list"),
+			Args:  cobra.NoArgs,
+			Run:   listBackups,
+		},
+		&cobra.Command{
+			Use:   "restore [name]",
+			Short: i18n.T("cmd_backup_restore"),
+			Args:  cobra.ExactArgs(1),
+			Run:   restoreBackup,
+		},
+	)
+
+	vaultCmd := &cobra.Command{
+		Use:   "vault",
+		Short: i18n.T("cmd_vault_short"),
+	}
+	vaultCreateCmd := &cobra.Command{
+		Use:   "create [name]",
+		Short: i18n.T("cmd_vault_create"),
+		Args:  cobra.ExactArgs(1),
+		Run:   createNamedVault,
+	}
+	vaultRemoveCmd := &cobra.Command{
+		Use:   "remove [name]",
+		Short: i18n.T("cmd_vault_remove"),
+		Args:  cobra.ExactArgs(1),
+		Run:   removeNamedVault,
+	}
+	vaultCmd.AddCommand(
+		&cobra.Command{
+			Use:   "list",
+			Short: i18n.T("cmd_vault_list"),
+			Args:  cobra.NoArgs,
+			Run:   listNamedVaults,
+		},
+		vaultCreateCmd,
+		&cobra.Command{
+			Use:   "use [name]",
+			Short: i18n.T("cmd_vault_use"),
+			Args:  cobra.ExactArgs(1),
+			Run:   useNamedVault,
+		},
+		vaultRemoveCmd,
+	)
+
+	configCmd := &cobra.Command{
+		Use:   "config",
+		Short: i18n.T("cmd_config_short"),
+	}
+	configCmd.AddCommand(
+		&cobra.Command{
+			Use:   "list",
+			Short: i18n.T("cmd_config_list"),
+			Args:  cobra.NoArgs,
+			Run:   listSettings,
+		},
+		&cobra.Command{
+			Use:   "get [key]",
+			Short: i18n.T("cmd_config_get"),
+			Args:  cobra.ExactArgs(1),
+			Run:   getSetting,
+		},
+		&cobra.Command{
+			Use:   "set [key] [value]",
+			Short: i18n.T("cmd_config_set"),
+			Args:  cobra.ExactArgs(2),
+			Run:   setSetting,
+		},
+	)
+
+	agentCmd := &cobra.Command{
+		Use:   "agent",
+		Short: i18n.T("cmd_agent_short"),
+	}
+	agentStartCmd := &cobra.Command{
+		Use:   "start",
+		Short: i18n.T("cmd_agent_start"),
+		Args:  cobra.NoArgs,
+		Run:   startAgent,
+	}
+	agentCmd.AddCommand(
+		agentStartCmd,
+		&cobra.Command{
+			Use:   "stop",
+			Short: i18n.T("cmd_agent_stop"),
+			Args:  cobra.NoArgs,
+			Run:   stopAgent,
+		},
+		&cobra.Command{
+			Use:   "status",
+			Short: i18n.T("cmd_agent_status"),
+			Args:  cobra.NoArgs,
+			Run:   showAgentStatus,
+		},
+	)
+
+	unlockCmd := &cobra.Command{
+		Use:   "unlock",
+		Short: i18n.T("cmd_unlock_short"),
+		Args:  cobra.NoArgs,
+		Run:   unlockInAgent,
+	}
+
+	lockCmd := &cobra.Command{
+		Use:   "lock",
+		Short: i18n.T("cmd_lock_short"),
+		Args:  cobra.NoArgs,
+		Run:   lockInAgent,
+	}
+
+	runCmd := &cobra.Command{
+		Use:   "run [flags] -- command [args...]",
+		Short: i18n.T("cmd_run_short"),
+		Args:  cobra.MinimumNArgs(1),
+		Run:   runWithSecrets,
+	}
+	runCmd.Flags().SetInterspersed(false)
+
+	gitCredentialCmd := &cobra.Command{
+		Use:   "git-credential get|store|erase",
+		Short: i18n.T("cmd_git_credential_short"),
+		Args:  cobra.ExactArgs(1),
+		Run:   gitCredential,
+	}
+
+	sshAgentCmd := &cobra.Command{
+		Use:   "ssh-agent",
+		Short: i18n.T("cmd_ssh_agent_short"),
+		Args:  cobra.NoArgs,
+		Run:   serveSSHAgent,
+	}
+
+	sshKeyCmd := &cobra.Command{
+		Use:   "ssh-key",
+		Short: i18n.T("cmd_ssh_key_short"),
+	}
+	sshKeyAddCmd := &cobra.Command{
+		Use:   "add [private-key-file]",
+		Short: i18n.T("cmd_ssh_key_add"),
+		Args:  cobra.ExactArgs(1),
+		Run:   addSSHKey,
+	}
+	sshKeyCmd.AddCommand(
+		sshKeyAddCmd,
+		&cobra.Command{
+			Use:   "list",
+			Short: i18n.T("cmd_ssh_key_list"),
+			Args:  cobra.NoArgs,
+			Run:   listSSHKeys,
+		},
+		&cobra.Command{
+			Use:   "public [ID]",
+			Short: i18n.T("cmd_ssh_key_public"),
+			Args:  cobra.ExactArgs(1),
+			Run:   showSSHPublicKey,
+		},
+	)
+
+	serveCmd := &cobra.Command{
+		Use:   "serve",
+		Short: i18n.T("cmd_serve_short"),
+		Args:  cobra.NoArgs,
+		Run:   serveAPI,
+	}
+
+	apiTokenCmd := &cobra.Command{
+		Use:   "api-token",
+		Short: i18n.T("cmd_api_token_short"),
+	}
+	apiTokenCreateCmd := &cobra.Command{
+		Use:   "create [name]",
+		Short: i18n.T("cmd_api_token_create"),
+		Args:  cobra.ExactArgs(1),
+		Run:   createAPIToken,
+	}
+	apiTokenCmd.AddCommand(
+		apiTokenCreateCmd,
+		&cobra.Command{
+			Use:   "list",
+			Short: i18n.T("cmd_api_token_list"),
+			Args:  cobra.NoArgs,
+			Run:   listAPITokens,
+		},
+		&cobra.Command{
+			Use:   "revoke [name]",
+			Short: i18n.T("cmd_api_token_revoke"),
+			Args:  cobra.ExactArgs(1),
+			Run:   revokeAPIToken,
+		},
+	)
+
+	nativeHostCmd := &cobra.Command{
+		Use:    "native-host",
+		Short:  i18n.T("cmd_native_host_short"),
+		Hidden: true,
+		Args:   cobra.ArbitraryArgs,
+		Run:    serveNativeHost,
+	}
+
+	browserCmd := &cobra.Command{
+		Use:   "browser",
+		Short: i18n.T("cmd_browser_short"),
+	}
+	browserCmd.AddCommand(
+		&cobra.Command{
+			Use:   "install [" + strings.Join(nativehost.Browsers, "|") + "] [extension-id]",
+			Short: i18n.T("cmd_browser_install"),
+			Args:  cobra.ExactArgs(2),
+			Run:   installBrowserHost,
+		},
+		&cobra.Command{
+			Use:   "uninstall [" + strings.Join(nativehost.Browsers, "|") + "]",
+			Short: i18n.T("cmd_browser_uninstall"),
+			Args:  cobra.ExactArgs(1),
+			Run:   uninstallBrowserHost,
+		},
+		&cobra.Command{
+			Use:   "list",
+			Short: i18n.T("cmd_browser_list"),
+			Args:  cobra.NoArgs,
+			Run:   listBrowserExtensions,
+		},
+	)
+
+	injectCmd := &cobra.Command{
+		Use:   "inject [template]",
+		Short: i18n.T("cmd_inject_short"),
+		Args:  cobra.ExactArgs(1),
+		Run:   injectSecrets,
+	}
+
 	rootCmd.AddCommand(
 		initCmd, addCmd, generateCmd, listCmd, getCmd,
 		deleteCmd, showPasswordCmd, changePasswordCmd,
 		exportCmd, importCmd, updateCmd, searchCmd, exportCsvCmd,
+		deriveCmd, emailAliasCmd, importCsvCmd, importKdbxCmd, exportKdbxCmd,
+		syncCmd, gitCmd, backupCmd, vaultCmd, configCmd,
+		agentCmd, unlockCmd, lockCmd, runCmd, injectCmd, gitCredentialCmd,
+		sshAgentCmd, sshKeyCmd, serveCmd, apiTokenCmd, nativeHostCmd, browserCmd,
 	)
 
 	// Add flags for generate command
@@ -199,8 +569,108 @@ func main() {
 	generateCmd.Flags().BoolP("no-symbols", "S", false, i18n.T("opt_no_symbols"))
 	generateCmd.Flags().BoolP("exclude-similar", "e", false, i18n.T("opt_exclude_similar"))
 	generateCmd.Flags().BoolP("exclude-ambiguous", "a", false, i18n.T("opt_exclude_ambiguous"))
+	generateCmd.Flags().Int("min-lowercase", 0, i18n.T("opt_min_lowercase"))
+	generateCmd.Flags().Int("min-uppercase", 0, i18n.T("opt_min_uppercase"))
+	generateCmd.Flags().Int("min-digits", 0, i18n.T("opt_min_digits"))
+	generateCmd.Flags().Int("min-symbols", 0, i18n.T("opt_min_symbols"))
+	generateCmd.Flags().StringP("mode", "m", generator.ModeRandom, i18n.Tf("opt_mode", strings.Join(generator.ModeNames(), ", ")))
+	generateCmd.Flags().StringP("template", "t", "", i18n.Tf("opt_template", generator.TemplateHelp))
+	generateCmd.Flags().String("symbols", "", i18n.T("opt_symbols"))
+	generateCmd.Flags().String("exclude", "", i18n.T("opt_exclude"))
+	generateCmd.Flags().String("include-only", "", i18n.T("opt_include_only"))
+	generateCmd.Flags().String("extra-chars", "", i18n.T("opt_extra_chars"))
+	generateCmd.Flags().StringSlice("alphabet", nil, i18n.Tf("opt_alphabet", strings.Join(generator.AlphabetNames(), ", ")))
+	generateCmd.Flags().String("rules", "", i18n.T("opt_rules"))
+	generateCmd.Flags().Float64("min-entropy", 0, i18n.T("opt_min_entropy"))
 	generateCmd.Flags().BoolP("copy", "c", false, i18n.T("opt_copy"))
 
+	// Add flags for scripting the account commands
+	for _, c := range []*cobra.Command{addCmd, updateCmd} {
+		c.Flags().String("platform", "", i18n.T("opt_platform"))
+		c.Flags().String("username", "", i18n.T("opt_username"))
+		c.Flags().String("email", "", i18n.T("opt_email"))
+		c.Flags().String("url", "", i18n.T("opt_url"))
+		c.Flags().String("notes", "", i18n.T("opt_notes"))
+		c.Flags().String("group", "", i18n.T("opt_group"))
+		c.Flags().StringSlice("tag", nil, i18n.T("opt_tag"))
+		c.Flags().String("rules", "", i18n.T("opt_account_rules"))
+		c.Flags().Bool("password-stdin", false, i18n.T("opt_password_stdin"))
+		c.Flags().Bool("generate", false, i18n.T("opt_generate"))
+	}
+	deleteCmd.Flags().BoolP("yes", "y", false, i18n.T("opt_yes"))
+	for _, c := range []*cobra.Command{listCmd, getCmd, searchCmd} {
+		c.Flags().StringP("output", "o", "text", i18n.T("opt_output"))
+	}
+
+	// Add flags for run command
+	runCmd.Flags().StringArrayP("env", "e", nil, i18n.Tf("opt_run_env", secretref.Scheme))
+	runCmd.Flags().Bool("no-mask", false, i18n.T("opt_no_mask"))
+
+	// Add flags for inject command
+	injectCmd.Flags().String("out", "", i18n.T("opt_inject_out"))
+	injectCmd.Flags().Bool("strict", false, i18n.T("opt_inject_strict"))
+
+	// Add flags for ssh-agent and ssh-key commands
+	sshAgentCmd.Flags().String("socket", "", i18n.T("opt_ssh_agent_socket"))
+	sshKeyAddCmd.Flags().String("name", "", i18n.T("opt_ssh_key_name"))
+	sshKeyAddCmd.Flags().StringP("group", "g", "", i18n.T("opt_group"))
+	sshKeyAddCmd.Flags().Bool("confirm", false, i18n.T("opt_ssh_key_confirm"))
+	sshKeyAddCmd.Flags().Duration("lifetime", 0, i18n.T("opt_ssh_key_lifetime"))
+
+	// Add flags for serve and api-token commands
+	serveCmd.Flags().String("addr", "127.0.0.1:8377", i18n.T("opt_serve_addr"))
+	serveCmd.Flags().String("socket", "", i18n.T("opt_serve_socket"))
+	apiTokenCreateCmd.Flags().Bool("read-only", false, i18n.T("opt_token_read_only"))
+	apiTokenCreateCmd.Flags().StringP("group", "g", "", i18n.T("opt_token_group"))
+
+	// Add flags for git log command
+	gitLogCmd.Flags().IntP("number", "n", 20, i18n.T("opt_git_log_number"))
+
+	// Add flags for vault commands
+	vaultCreateCmd.Flags().String("dir", "", i18n.T("opt_vault_dir"))
+	vaultRemoveCmd.Flags().Bool("delete-files", false, i18n.T("opt_vault_delete_files"))
+
+	// Add flags for agent commands
+	agentStartCmd.Flags().Bool("foreground", false, i18n.T("opt_agent_foreground"))
+	unlockCmd.Flags().Duration("ttl", 0, i18n.T("opt_agent_ttl"))
+	lockCmd.Flags().Bool("all", false, i18n.T("opt_lock_all"))
+
+	// Add flags for import-csv command
+	importCsvCmd.Flags().StringP("format", "f", importer.FormatGenericCSV, i18n.Tf("opt_import_format", strings.Join(importer.Formats(), ", ")))
+	importCsvCmd.Flags().StringToString("map", nil, i18n.Tf("opt_import_map", strings.Join(importer.Fields(), ", ")))
+	importCsvCmd.Flags().StringToString("group-map", nil, i18n.T("opt_import_group_map"))
+	importCsvCmd.Flags().String("default-group", "", i18n.T("opt_import_default_group"))
+	importCsvCmd.Flags().Bool("keep-duplicates", false, i18n.T("opt_import_keep_duplicates"))
+	importCsvCmd.Flags().Bool("dry-run", false, i18n.T("opt_dry_run"))
+
+	// Add flags for export command (any selection implies --portable)
+	exportCmd.Flags().Bool("portable", false, i18n.T("opt_portable"))
+	exportCmd.Flags().StringSlice("group", nil, i18n.T("opt_export_group"))
+	exportCmd.Flags().StringSlice("tag", nil, i18n.T("opt_export_tag"))
+	exportCmd.Flags().StringSlice("id", nil, i18n.T("opt_export_id"))
+
+	// Add flags for import command
+	importCmd.Flags().Bool("merge", false, i18n.T("opt_merge"))
+	importCmd.Flags().String("strategy", string(storage.MergeKeepMine), i18n.Tf("opt_merge_strategy", strings.Join(storage.MergeStrategies(), ", ")))
+	importCmd.Flags().Bool("dry-run", false, i18n.T("opt_dry_run"))
+
+	// Add flags for KeePass commands
+	importKdbxCmd.Flags().StringP("key-file", "k", "", i18n.T("opt_key_file"))
+	exportKdbxCmd.Flags().StringP("key-file", "k", "", i18n.T("opt_key_file"))
+
+	// Add flags for derive command
+	deriveCmd.Flags().String("site", "", i18n.T("opt_site"))
+	deriveCmd.Flags().String("login", "", i18n.T("opt_login"))
+	deriveCmd.Flags().Int("counter", 1, i18n.T("opt_counter"))
+	deriveCmd.Flags().IntP("length", "l", 16, i18n.T("opt_length"))
+	deriveCmd.Flags().Bool("no-lowercase", false, i18n.T("opt_no_lowercase"))
+	deriveCmd.Flags().BoolP("no-uppercase", "U", false, i18n.T("opt_no_uppercase"))
+	deriveCmd.Flags().BoolP("no-digits", "D", false, i18n.T("opt_no_digits"))
+	deriveCmd.Flags().BoolP("no-symbols", "S", false, i18n.T("opt_no_symbols"))
+	deriveCmd.Flags().Bool("save", false, i18n.T("opt_save"))
+	deriveCmd.Flags().Bool("rotate", false, i18n.T("opt_rotate"))
+	deriveCmd.Flags().BoolP("copy", "c", false, i18n.T("opt_copy"))
+
 	// Execute the root command
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Fprintf(os.Stderr, i18n.Tf("error", err.Error())+"\n")
@@ -208,6 +678,195 @@ func main() {
 	}
 }
 
+// loadRegistry reads the vault registry of the root directory, exiting on
+// failure.
+func loadRegistry() *vaults.Registry {
+	root, err := vaults.RootDir(rootDirFlag)
+	if err == nil {
+		var registry *vaults.Registry
+		if registry, err = vaults.Load(root); err == nil {
+			return registry
+		}
+	}
+	fmt.Fprintf(os.Stderr, i18n.Tf("error", err.Error())+"\n")
+	os.Exit(1)
+	return nil
+}
+
+// loadSettings reads the settings of the root directory, exiting if they
+// are invalid.
+func loadSettings() *config.Config {
+	root, err := vaults.RootDir(rootDirFlag)
+	if err == nil {
+		var loaded *config.Config
+		if loaded, err = config.Load(root); err == nil {
+			return loaded
+		}
+	}
+	fmt.Fprintf(os.Stderr, i18n.Tf("error", err.Error())+"\n")
+	os.Exit(1)
+	return nil
+}
+
+// openStore initializes the storage of the vault selected with --vault, or
+// of the default vault, exiting on failure.
+func openStore() {
+	vault, err := loadRegistry().Get(vaultName)
+	if err != nil {
+		exitWith(i18n.Tf("error", err.Error()), err)
+	}
+	dataDir = vault.Dir
+
+	// Initialize the storage system (local file, WebDAV or S3 as configured)
+	backend, err := storage.OpenBackend(dataDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error initializing storage: %v\n", err)
+		os.Exit(1)
+	}
+	store, err = storage.NewWithBackend(dataDir, backend)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error initializing storage: %v\n", err)
+		os.Exit(1)
+	}
+	enableGitHistory(backend)
+}
+
+// unlockOrExit unlocks the vault with the key held by the unlock agent, or
+// else prompts for the master password, exiting on failure. Does nothing if
+// the vault is already unlocked.
+func unlockOrExit() {
+	// If already unlocked, no need to operate
+	if isUnlocked {
+		return
+	}
+
+	// Check if the vault exists
+	if !store.IsVaultExists() {
+		exitWith(i18n.T("vault_not_exists"), errors.ErrVaultNotExists)
+	}
+
+	// Use the key held by the unlock agent, if any, instead of prompting
+	if unlockFromAgent() {
+		isUnlocked = true
+		return
+	}
+	unlockWithPasswordOrExit()
+}
+
+// unlockWithPasswordOrExit prompts for the master password and unlocks the
+// vault, exiting on failure.
+func unlockWithPasswordOrExit() {
+	// Perform unlock operation
+	masterPassword, err := readMasterPassword()
+	if err != nil {
+		exitWith(i18n.Tf("error", err.Error()), err)
+	}
+
+	if err := store.UnlockVault(masterPassword); err != nil {
+		exitWith(i18n.Tf("error", err.Error()), err)
+	}
+
+	crypto.ClearBytes([]byte(masterPassword))
+	isUnlocked = true
+}
+
+// unlockWithPinentryOrExit unlocks the vault for a command without a
+// terminal of its own: with the key held by the unlock agent, the master
+// password supplied for scripts, or else a pinentry dialog. A key unlocked
+// through pinentry is handed to a running agent, so the next call need not
+// ask again.
+func unlockWithPinentryOrExit() {
+	if !store.IsVaultExists() {
+		exitWith(i18n.T("vault_not_exists"), errors.ErrVaultNotExists)
+	}
+	if unlockFromAgent() {
+		isUnlocked = true
+		return
+	}
+
+	password, supplied, err := suppliedMasterPassword()
+	if err != nil {
+		exitWith(i18n.Tf("error", err.Error()), err)
+	}
+	if supplied {
+		err = store.UnlockVault(password)
+		crypto.ClearBytes([]byte(password))
+		if err != nil {
+			exitWith(i18n.Tf("error", err.Error()), err)
+		}
+		isUnlocked = true
+		return
+	}
+
+	prompt := pinentry.Prompt{
+		Title:       i18n.T("app_name"),
+		Description: i18n.Tf("pinentry_description", dataDir),
+		Prompt:      i18n.T("pinentry_prompt"),
+	}
+	for attempt := 1; ; attempt++ {
+		password, err := pinentry.GetPin(pinentry.Program(), prompt)
+		if err != nil {
+			exitWith(i18n.Tf("error", err.Error()), err)
+		}
+		err = store.UnlockVault(password)
+		crypto.ClearBytes([]byte(password))
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, errors.ErrInvalidPassword) || attempt == 3 {
+			exitWith(i18n.Tf("error", err.Error()), err)
+		}
+		prompt.Error = i18n.T("pinentry_retry")
+	}
+	isUnlocked = true
+
+	client := agent.NewClient(agentSocket())
+	if _, err := client.Status(); err == nil {
+		client.Add(agentVaultID(dataDir), store.GetEncryptionKey(), 0)
+	}
+}
+
+// unlockFromAgent unlocks the vault with the key held by the unlock agent.
+// Returns false if no agent is running or it holds no key that fits.
+func unlockFromAgent() bool {
+	client := agent.NewClient(agentSocket())
+	id := agentVaultID(dataDir)
+	key, err := client.Key(id)
+	if err != nil {
+		return false
+	}
+	defer crypto.ClearBytes(key)
+
+	if err := store.UnlockWithKey(key); err != nil {
+		if errors.Is(err, errors.ErrInvalidPassword) {
+			// The master password was changed elsewhere; the key is useless now
+			client.Remove(id)
+		}
+		return false
+	}
+	return true
+}
+
+// agentSocket returns the socket of the unlock agent serving the root
+// directory, exiting on failure.
+func agentSocket() string {
+	root, err := vaults.RootDir(rootDirFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, i18n.Tf("error", err.Error())+"\n")
+		os.Exit(1)
+	}
+	return agent.SocketPath(root)
+}
+
+// agentVaultID returns the name under which the agent keeps the key of the
+// vault in dir: its absolute data directory.
+func agentVaultID(dir string) string {
+	if abs, err := filepath.Abs(dir); err == nil {
+		return abs
+	}
+	return dir
+}
+
 // 读取密码（不回显）
 func readPassword(prompt string) (string, error) {
 	fmt.Print(prompt)
@@ -219,6 +878,150 @@ func readPassword(prompt string) (string, error) {
 	return string(passwordBytes), nil
 }
 
+// suppliedMasterPassword returns the master password given for scripts: the
+// first line read from --password-fd, or $PASSWORDMANAGER_PASSWORD. ok is
+// false if neither is set.
+func suppliedMasterPassword() (password string, ok bool, err error) {
+	if passwordFD >= 0 {
+		file := os.NewFile(uintptr(passwordFD), "password-fd")
+		if file == nil {
+			return "", false, fmt.Errorf("invalid file descriptor %d", passwordFD)
+		}
+		line, err := bufio.NewReader(file).ReadString('\n')
+		if err != nil && err != io.EOF {
+			return "", false, errors.Wrap(err, "读取密码失败")
+		}
+		return strings.TrimRight(line, "\r\n"), true, nil
+	}
+	if password, ok := os.LookupEnv(envMasterPassword); ok {
+		return password, true, nil
+	}
+	return "", false, nil
+}
+
+// readMasterPassword returns the master password supplied for scripts, or
+// prompts for it. Without a terminal to prompt on it fails with
+// errors.ErrVaultLocked instead of waiting for input.
+func readMasterPassword() (string, error) {
+	if password, ok, err := suppliedMasterPassword(); ok || err != nil {
+		return password, err
+	}
+	if !term.IsTerminal(int(syscall.Stdin)) {
+		return "", errors.Wrap(errors.ErrVaultLocked, i18n.Tf("no_master_password", envMasterPassword))
+	}
+	return readPassword(i18n.T("enter_master_password"))
+}
+
+// readPasswordStdin reads a secret from standard input, without the trailing
+// line break.
+func readPasswordStdin() (string, error) {
+	data, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return "", errors.Wrap(err, "读取密码失败")
+	}
+	password := strings.TrimRight(string(data), "\r\n")
+	crypto.ClearBytes(data)
+	if password == "" {
+		return "", fmt.Errorf("%s", i18n.T("password_stdin_empty"))
+	}
+	return password, nil
+}
+
+// exitCode returns the exit code reporting err.
+func exitCode(err error) int {
+	switch {
+	case errors.Is(err, errors.ErrAccountNotFound), errors.Is(err, errors.ErrVaultNotExists),
+		errors.Is(err, errors.ErrVaultNotFound), errors.Is(err, errors.ErrBackupNotFound),
+		errors.Is(err, errors.ErrFieldNotFound):
+		return exitNotFound
+	case errors.Is(err, errors.ErrVaultLocked), errors.Is(err, errors.ErrAgentLocked):
+		return exitLocked
+	case errors.Is(err, errors.ErrInvalidPassword):
+		return exitWrongPassword
+	default:
+		return exitError
+	}
+}
+
+// exitWith prints message to stderr and exits with the code reporting err.
+func exitWith(message string, err error) {
+	fmt.Fprintln(os.Stderr, message)
+	os.Exit(exitCode(err))
+}
+
+// outputJSON reports whether cmd was given --output json, exiting if the
+// format is unknown.
+func outputJSON(cmd *cobra.Command) bool {
+	output, _ := cmd.Flags().GetString("output")
+	switch output {
+	case "text":
+		return false
+	case "json":
+		return true
+	}
+	exitWith(i18n.Tf("error", i18n.Tf("invalid_output", output)), nil)
+	return false
+}
+
+// printJSON writes v to stdout as indented JSON.
+func printJSON(v any) {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		exitWith(i18n.Tf("error", err.Error()), err)
+	}
+}
+
+// accountJSON is the --output json form of an account. Passwords and
+// protected field values are left out.
+type accountJSON struct {
+	ID            string              `json:"id"`
+	Platform      string              `json:"platform"`
+	Username      string              `json:"username,omitempty"`
+	Email         string              `json:"email,omitempty"`
+	URL           string              `json:"url,omitempty"`
+	Notes         string              `json:"notes,omitempty"`
+	Group         string              `json:"group,omitempty"`
+	Tags          []string            `json:"tags,omitempty"`
+	PasswordRules string              `json:"password_rules,omitempty"`
+	Derived       *model.Derived      `json:"derived,omitempty"`
+	SSHKey        *model.SSHKey       `json:"ssh_key,omitempty"`
+	CustomFields  []model.CustomField `json:"custom_fields,omitempty"`
+	CreatedAt     time.Time           `json:"created_at"`
+	UpdatedAt     time.Time           `json:"updated_at"`
+}
+
+// printAccountsJSON writes accounts to stdout as a JSON array.
+func printAccountsJSON(accounts []*model.Account) {
+	views := make([]accountJSON, 0, len(accounts))
+	for _, account := range accounts {
+		fields := make([]model.CustomField, len(account.CustomFields))
+		for i, field := range account.CustomFields {
+			fields[i] = field
+			if field.Protected {
+				fields[i].Value = ""
+			}
+		}
+		views = append(views, accountJSON{
+			ID:            account.ID,
+			Platform:      account.Platform,
+			Username:      account.Username,
+			Email:         account.Email,
+			URL:           account.URL,
+			Notes:         account.Notes,
+			Group:         account.Group,
+			Tags:          account.Tags,
+			PasswordRules: account.PasswordRules,
+			Derived:       account.Derived,
+			SSHKey:        account.SSHKey,
+			CustomFields:  fields,
+			CreatedAt:     account.CreatedAt,
+			UpdatedAt:     account.UpdatedAt,
+		})
+	}
+	printJSON(views)
+}
+
 // readAndConfirmPassword reads and confirms a password, ensuring minimum length.
 func readAndConfirmPassword(prompt, confirmPrompt string, minLength int) (string, error) {
 	password, err := readPassword(prompt)
@@ -265,17 +1068,6 @@ func readConfirmation(prompt string) bool {
 	return i18n.ReadConfirmation(prompt)
 }
 
-// generateID creates a random 16-character hex ID (8 bytes).
-func generateID() string {
-	buf := make([]byte, 8) // 8 bytes = 16 hex chars
-	_, err := rand.Read(buf)
-	if err != nil {
-		// This should not happen in production
-		panic(fmt.Sprintf("Failed to generate random ID: %v", err))
-	}
-	return hex.EncodeToString(buf)
-}
-
 // initVault handles the 'init' command.
 func initVault(cmd *cobra.Command, args []string) {
 	if store.IsVaultExists() {
@@ -283,52 +1075,131 @@ func initVault(cmd *cobra.Command, args []string) {
 		return
 	}
 
-	// Prompt user for new master password
-	masterPassword, err := readAndConfirmPassword(
-		i18n.T("enter_new_master_password"),
-		i18n.T("confirm_master_password"),
-		8)
+	// Take the new master password supplied for scripts, or prompt for it
+	minLength := settings.Security.MasterPasswordMinLength
+	masterPassword, supplied, err := suppliedMasterPassword()
+	if err == nil && supplied && len(masterPassword) < minLength {
+		err = fmt.Errorf("%s", i18n.Tf("password_min_length", minLength))
+	}
+	if err == nil && !supplied {
+		masterPassword, err = readAndConfirmPassword(
+			i18n.Tf("enter_new_master_password", minLength),
+			i18n.T("confirm_master_password"),
+			minLength)
+	}
 	if err != nil {
-		fmt.Fprintf(os.Stderr, i18n.Tf("error", err.Error())+"\n")
-		return
+		exitWith(i18n.Tf("error", err.Error()), err)
 	}
 
 	// Create the vault
 	if err := store.CreateVault(masterPassword); err != nil {
-		fmt.Fprintf(os.Stderr, i18n.Tf("error", err.Error())+"\n")
-		return
+		exitWith(i18n.Tf("error", err.Error()), err)
 	}
 
 	crypto.ClearBytes([]byte(masterPassword))
 	fmt.Println(i18n.T("vault_created"))
 }
 
-// addAccount handles the 'add' command.
+// addAccount handles the 'add' command. The account comes from the flags if
+// any are given, otherwise from prompts.
 func addAccount(cmd *cobra.Command, args []string) {
+	var account *model.Account
+	var password string
+	if accountFlagsGiven(cmd) {
+		account = &model.Account{}
+		applyAccountFlags(cmd, account)
+		if account.Platform == "" {
+			exitWith(i18n.T("platform_required"), nil)
+		}
+		var ok bool
+		if password, ok = passwordFromFlags(cmd, account.PasswordRules); !ok {
+			exitWith(i18n.Tf("error", i18n.T("password_flag_required")), nil)
+		}
+	} else {
+		var ok bool
+		if account, password, ok = readNewAccount(); !ok {
+			return
+		}
+	}
+	account.ID = crypto.GenerateID()
+
+	// Encrypt password
+	encryptedPassword, err := crypto.Encrypt([]byte(password), store.GetEncryptionKey())
+	if err != nil {
+		crypto.ClearBytes([]byte(password))
+		exitWith(i18n.Tf("encrypt_password_failed", err), err)
+	}
+	account.EncryptedPassword = encryptedPassword
+	crypto.ClearBytes([]byte(password))
+
+	// Save account
+	if err := store.AddAccount(account); err != nil {
+		exitWith(i18n.Tf("add_account_failed", err), err)
+	}
+
+	fmt.Println(i18n.Tf("account_added", account.Platform, account.ID))
+}
+
+// readNewAccount prompts for the fields and password of a new account.
+// Returns false if the input was invalid or the user gave up.
+func readNewAccount() (*model.Account, string, bool) {
 	// Read necessary info
 	platform := readUserInput(i18n.T("platform"), "")
 	if platform == "" {
 		fmt.Fprintf(os.Stderr, i18n.T("platform_required")+"\n")
-		return
+		return nil, "", false
+	}
+
+	// Offer a generated username and email alias as defaults
+	var suggestedUsername, suggestedEmail string
+	if readConfirmation(i18n.T("generate_username")) {
+		opts := generator.DefaultUsernameOptions()
+		opts.Mode = readUserInput(i18n.Tf("username_mode", strings.Join(generator.UsernameModeNames(), "/")), opts.Mode)
+		generated, err := generator.GenerateUsername(opts)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, i18n.Tf("error", err.Error())+"\n")
+			return nil, "", false
+		}
+		suggestedUsername = generated
 	}
+	username := readUserInput(i18n.T("username"), suggestedUsername)
 
-	username := readUserInput(i18n.T("username"), "")
-	email := readUserInput(i18n.T("email"), "")
+	if aliases, err := store.GetEmailAliases(); err == nil && len(aliases) > 0 && readConfirmation(i18n.T("generate_email_alias")) {
+		template, ok := chooseEmailAlias(aliases)
+		if !ok {
+			return nil, "", false
+		}
+		generated, err := generator.GenerateEmailAlias(template, platform)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, i18n.Tf("error", err.Error())+"\n")
+			return nil, "", false
+		}
+		suggestedEmail = generated
+	}
+	email := readUserInput(i18n.T("email"), suggestedEmail)
 	url := readUserInput(i18n.T("url"), "")
 	notes := readUserInput(i18n.T("notes"), "")
+	rules := readUserInput(i18n.T("password_rules"), "")
+	if rules != "" {
+		if _, err := generator.ParsePasswordRules(rules); err != nil {
+			fmt.Fprintf(os.Stderr, i18n.Tf("error", err.Error())+"\n")
+			return nil, "", false
+		}
+	}
 
 	var password string
 	var err error
 
 	// Ask to generate or input password
 	if readConfirmation(i18n.T("generate_random_password")) {
-		// Generate password with default options
-		opts := generator.DefaultOptions()
-		password, err = generator.GeneratePassword(opts)
+		// Generate password with default options, honouring the site rules
+		opts := settings.GeneratorOptions()
+		result, err := generator.GeneratePasswordWithRules(rules, opts)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, i18n.Tf("error", err.Error())+"\n")
-			return
+			return nil, "", false
 		}
+		password = result.Password
 
 		fmt.Println(i18n.Tf("generated_password", password))
 
@@ -337,10 +1208,10 @@ func addAccount(cmd *cobra.Command, args []string) {
 			if err := clipboard.WriteAll(password); err != nil {
 				fmt.Fprintf(os.Stderr, i18n.Tf("password_copy_failed", err)+"\n")
 			} else {
-				fmt.Println(i18n.Tf("password_copied", 60))
+				fmt.Println(i18n.Tf("password_copied", settings.Clipboard.GeneratedClearSeconds))
 				// Start goroutine to clear clipboard
 				go func() {
-					time.Sleep(60 * time.Second)
+					time.Sleep(time.Duration(settings.Clipboard.GeneratedClearSeconds) * time.Second)
 					clipboard.WriteAll("")
 				}()
 			}
@@ -350,37 +1221,85 @@ func addAccount(cmd *cobra.Command, args []string) {
 		password, err = readAndConfirmPassword(i18n.T("input_password"), i18n.T("confirm_password"), 1)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, i18n.Tf("error", err.Error())+"\n")
-			return
+			return nil, "", false
 		}
 	}
 
-	// Create account object
 	account := &model.Account{
-		ID:       generateID(),
-		Platform: platform,
-		Username: username,
-		Email:    email,
-		URL:      url,
-		Notes:    notes,
-	}
+		Platform:      platform,
+		Username:      username,
+		Email:         email,
+		URL:           url,
+		Notes:         notes,
+		PasswordRules: rules,
+	}
+	return account, password, true
+}
 
-	// Encrypt password
-	encryptedPassword, err := crypto.Encrypt([]byte(password), store.GetEncryptionKey())
-	if err != nil {
-		crypto.ClearBytes([]byte(password))
-		fmt.Fprintf(os.Stderr, i18n.Tf("encrypt_password_failed", err)+"\n")
-		return
+// accountFields are the flags that set account fields in 'add' and 'update'.
+var accountFields = []string{"platform", "username", "email", "url", "notes", "group", "tag", "rules", "password-stdin", "generate"}
+
+// accountFlagsGiven reports whether any account field flag was given, which
+// makes 'add' and 'update' run without prompts.
+func accountFlagsGiven(cmd *cobra.Command) bool {
+	for _, name := range accountFields {
+		if cmd.Flags().Changed(name) {
+			return true
+		}
 	}
-	account.EncryptedPassword = encryptedPassword
-	crypto.ClearBytes([]byte(password))
+	return false
+}
 
-	// Save account
-	if err := store.AddAccount(account); err != nil {
-		fmt.Fprintf(os.Stderr, i18n.Tf("add_account_failed", err)+"\n")
-		return
+// applyAccountFlags copies the account fields given as flags to account,
+// exiting if the password rules are invalid.
+func applyAccountFlags(cmd *cobra.Command, account *model.Account) {
+	flags := cmd.Flags()
+	for name, field := range map[string]*string{
+		"platform": &account.Platform,
+		"username": &account.Username,
+		"email":    &account.Email,
+		"url":      &account.URL,
+		"notes":    &account.Notes,
+		"group":    &account.Group,
+		"rules":    &account.PasswordRules,
+	} {
+		if flags.Changed(name) {
+			*field, _ = flags.GetString(name)
+		}
+	}
+	if flags.Changed("tag") {
+		account.Tags, _ = flags.GetStringSlice("tag")
+	}
+	if account.PasswordRules != "" {
+		if _, err := generator.ParsePasswordRules(account.PasswordRules); err != nil {
+			exitWith(i18n.Tf("error", err.Error()), err)
+		}
 	}
+}
 
-	fmt.Println(i18n.Tf("account_added", account.Platform, account.ID))
+// passwordFromFlags returns the password read for --password-stdin or
+// generated for --generate, honouring the site rules. Returns false if
+// neither flag is given; exits if both are.
+func passwordFromFlags(cmd *cobra.Command, rules string) (string, bool) {
+	fromStdin, _ := cmd.Flags().GetBool("password-stdin")
+	generate, _ := cmd.Flags().GetBool("generate")
+	switch {
+	case fromStdin && generate:
+		exitWith(i18n.Tf("error", i18n.T("password_flags_conflict")), nil)
+	case fromStdin:
+		password, err := readPasswordStdin()
+		if err != nil {
+			exitWith(i18n.Tf("error", err.Error()), err)
+		}
+		return password, true
+	case generate:
+		result, err := generator.GeneratePasswordWithRules(rules, settings.GeneratorOptions())
+		if err != nil {
+			exitWith(i18n.Tf("error", err.Error()), err)
+		}
+		return result.Password, true
+	}
+	return "", false
 }
 
 // generatePassword handles the 'generate' command.
@@ -393,10 +1312,51 @@ func generatePassword(cmd *cobra.Command, args []string) {
 	noSymbols, _ := cmd.Flags().GetBool("no-symbols")
 	excludeSimilar, _ := cmd.Flags().GetBool("exclude-similar")
 	excludeAmbiguous, _ := cmd.Flags().GetBool("exclude-ambiguous")
+	minLowercase, _ := cmd.Flags().GetInt("min-lowercase")
+	minUppercase, _ := cmd.Flags().GetInt("min-uppercase")
+	minDigits, _ := cmd.Flags().GetInt("min-digits")
+	minSymbols, _ := cmd.Flags().GetInt("min-symbols")
+	customSymbols, _ := cmd.Flags().GetString("symbols")
+	exclude, _ := cmd.Flags().GetString("exclude")
+	includeOnly, _ := cmd.Flags().GetString("include-only")
+	extraChars, _ := cmd.Flags().GetString("extra-chars")
+	alphabets, _ := cmd.Flags().GetStringSlice("alphabet")
+	rules, _ := cmd.Flags().GetString("rules")
+	mode, _ := cmd.Flags().GetString("mode")
+	template, _ := cmd.Flags().GetString("template")
+	minEntropy, _ := cmd.Flags().GetFloat64("min-entropy")
 	copyToClipboard, _ := cmd.Flags().GetBool("copy")
 
-	// Ensure at least one character set is selected
-	if noLowercase && noUppercase && noDigits && noSymbols {
+	// Options not given on the command line come from the settings
+	flags := cmd.Flags()
+	if !flags.Changed("length") {
+		length = settings.Generator.Length
+	}
+	if !flags.Changed("mode") {
+		mode = settings.Generator.Mode
+	}
+	if !flags.Changed("no-lowercase") {
+		noLowercase = !settings.Generator.Lowercase
+	}
+	if !flags.Changed("no-uppercase") {
+		noUppercase = !settings.Generator.Uppercase
+	}
+	if !flags.Changed("no-digits") {
+		noDigits = !settings.Generator.Digits
+	}
+	if !flags.Changed("no-symbols") {
+		noSymbols = !settings.Generator.Symbols
+	}
+	if !flags.Changed("exclude-similar") {
+		excludeSimilar = settings.Generator.ExcludeSimilar
+	}
+	if !flags.Changed("exclude-ambiguous") {
+		excludeAmbiguous = settings.Generator.ExcludeAmbiguous
+	}
+
+	// Ensure at least one character set is selected (rules bring their own)
+	if mode == generator.ModeRandom && rules == "" && includeOnly == "" && extraChars == "" && len(alphabets) == 0 &&
+		noLowercase && noUppercase && noDigits && noSymbols {
 		fmt.Fprintf(os.Stderr, i18n.T("must_select_charset")+"\n")
 		return
 	}
@@ -410,39 +1370,261 @@ func generatePassword(cmd *cobra.Command, args []string) {
 		UseSymbols:       !noSymbols,
 		ExcludeSimilar:   excludeSimilar,
 		ExcludeAmbiguous: excludeAmbiguous,
-	}
-
-	password, err := generator.GeneratePassword(opts)
+		MinLowercase:     minLowercase,
+		MinUppercase:     minUppercase,
+		MinDigits:        minDigits,
+		MinSymbols:       minSymbols,
+		CustomSymbols:    customSymbols,
+		Exclude:          exclude,
+		IncludeOnly:      includeOnly,
+		ExtraChars:       extraChars,
+		Alphabets:        alphabets,
+		Mode:             mode,
+		Template:         template,
+		MinEntropy:       minEntropy,
+	}
+
+	result, err := generator.GeneratePasswordWithRules(rules, opts)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, i18n.Tf("error", err.Error())+"\n")
 		return
 	}
+	password := result.Password
 
 	fmt.Println(i18n.Tf("generated_password", password))
+	fmt.Println(i18n.Tf("password_entropy", result.Entropy, result.CharsetSize))
 
 	// Copy to clipboard if requested
 	if copyToClipboard || readConfirmation(i18n.T("copy_to_clipboard")) {
 		if err := clipboard.WriteAll(password); err != nil {
 			fmt.Fprintf(os.Stderr, i18n.Tf("password_copy_failed", err)+"\n")
 		} else {
-			fmt.Println(i18n.Tf("password_copied", 60))
+			fmt.Println(i18n.Tf("password_copied", settings.Clipboard.GeneratedClearSeconds))
 			// Start goroutine to clear clipboard
 			go func() {
-				time.Sleep(60 * time.Second)
+				time.Sleep(time.Duration(settings.Clipboard.GeneratedClearSeconds) * time.Second)
+				clipboard.WriteAll("")
+			}()
+		}
+	}
+}
+
+// derivePassword handles the 'derive' command.
+// With an ID the stored profile is used; otherwise the profile comes from the flags.
+func derivePassword(cmd *cobra.Command, args []string) {
+	site, _ := cmd.Flags().GetString("site")
+	login, _ := cmd.Flags().GetString("login")
+	counter, _ := cmd.Flags().GetInt("counter")
+	length, _ := cmd.Flags().GetInt("length")
+	noLowercase, _ := cmd.Flags().GetBool("no-lowercase")
+	noUppercase, _ := cmd.Flags().GetBool("no-uppercase")
+	noDigits, _ := cmd.Flags().GetBool("no-digits")
+	noSymbols, _ := cmd.Flags().GetBool("no-symbols")
+	save, _ := cmd.Flags().GetBool("save")
+	rotate, _ := cmd.Flags().GetBool("rotate")
+	copyToClipboard, _ := cmd.Flags().GetBool("copy")
+
+	if len(args) == 0 && site == "" {
+		fmt.Fprintf(os.Stderr, i18n.T("derive_site_required")+"\n")
+		return
+	}
+
+	// The master password is the derivation secret. When a vault exists it is
+	// verified by unlocking, so a typo cannot silently produce a wrong password.
+	masterPassword, err := readMasterPassword()
+	if err != nil {
+		exitWith(i18n.Tf("error", err.Error()), err)
+	}
+	defer crypto.ClearBytes([]byte(masterPassword))
+
+	needsVault := len(args) > 0 || save
+	if store.IsVaultExists() {
+		if err := store.UnlockVault(masterPassword); err != nil {
+			fmt.Fprintf(os.Stderr, i18n.Tf("error", err.Error())+"\n")
+			return
+		}
+		isUnlocked = true
+	} else if needsVault {
+		fmt.Fprintf(os.Stderr, i18n.T("vault_not_exists")+"\n")
+		return
+	}
+
+	var account *model.Account
+	profile := &model.Derived{
+		Site:      site,
+		Login:     login,
+		Counter:   counter,
+		Length:    length,
+		Lowercase: !noLowercase,
+		Uppercase: !noUppercase,
+		Digits:    !noDigits,
+		Symbols:   !noSymbols,
+	}
+	if len(args) > 0 {
+		account, err = store.GetAccountByID(args[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, i18n.Tf("get_account_failed", err)+"\n")
+			return
+		}
+		if account.Derived == nil {
+			fmt.Fprintf(os.Stderr, i18n.Tf("account_not_derived", account.Platform)+"\n")
+			return
+		}
+		profile = account.Derived
+		if rotate {
+			profile.Counter++
+		}
+	}
+
+	opts := generator.Options{
+		Length:       profile.Length,
+		UseLowercase: profile.Lowercase,
+		UseUppercase: profile.Uppercase,
+		UseDigits:    profile.Digits,
+		UseSymbols:   profile.Symbols,
+	}
+	input := generator.DeriveInput{
+		Secret:  masterPassword,
+		Site:    profile.Site,
+		Login:   profile.Login,
+		Counter: profile.Counter,
+	}
+	result, err := generator.DerivePassword(input, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, i18n.Tf("error", err.Error())+"\n")
+		return
+	}
+
+	// Persist only the profile, never the password
+	switch {
+	case account != nil && rotate:
+		account.UpdatedAt = time.Now()
+		if err := store.UpdateAccount(account); err != nil {
+			fmt.Fprintf(os.Stderr, i18n.Tf("update_account_failed", err)+"\n")
+			return
+		}
+		fmt.Println(i18n.Tf("derived_counter_saved", account.Platform, profile.Counter))
+	case account == nil && save:
+		account = &model.Account{
+			ID:       crypto.GenerateID(),
+			Platform: profile.Site,
+			Username: profile.Login,
+			Derived:  profile,
+		}
+		if err := store.AddAccount(account); err != nil {
+			fmt.Fprintf(os.Stderr, i18n.Tf("add_account_failed", err)+"\n")
+			return
+		}
+		fmt.Println(i18n.Tf("account_added", account.Platform, account.ID))
+	}
+
+	fmt.Println(i18n.Tf("derived_profile", profile.Site, profile.Login, profile.Counter))
+	fmt.Println(i18n.Tf("generated_password", result.Password))
+	fmt.Println(i18n.Tf("password_entropy", result.Entropy, result.CharsetSize))
+
+	if copyToClipboard {
+		if err := clipboard.WriteAll(result.Password); err != nil {
+			fmt.Fprintf(os.Stderr, i18n.Tf("password_copy_failed", err)+"\n")
+		} else {
+			fmt.Println(i18n.Tf("password_copied", settings.Clipboard.GeneratedClearSeconds))
+			go func() {
+				time.Sleep(time.Duration(settings.Clipboard.GeneratedClearSeconds) * time.Second)
 				clipboard.WriteAll("")
 			}()
 		}
 	}
 }
 
+// chooseEmailAlias lets the user pick one of several alias templates.
+func chooseEmailAlias(aliases []string) (string, bool) {
+	if len(aliases) == 1 {
+		return aliases[0], true
+	}
+	for i, template := range aliases {
+		fmt.Printf("%d) %s\n", i+1, template)
+	}
+	choice := readUserInput(i18n.T("choose_email_alias"), "1")
+	n, err := strconv.Atoi(choice)
+	if err != nil || n < 1 || n > len(aliases) {
+		fmt.Fprintf(os.Stderr, i18n.Tf("invalid_choice", choice)+"\n")
+		return "", false
+	}
+	return aliases[n-1], true
+}
+
+// listEmailAliases handles the 'email-alias list' command.
+func listEmailAliases(cmd *cobra.Command, args []string) {
+	aliases, err := store.GetEmailAliases()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, i18n.Tf("error", err.Error())+"\n")
+		return
+	}
+	if len(aliases) == 0 {
+		fmt.Println(i18n.T("no_email_aliases"))
+		return
+	}
+	for i, template := range aliases {
+		fmt.Printf("%d) %s\n", i+1, template)
+	}
+}
+
+// addEmailAlias handles the 'email-alias add' command.
+func addEmailAlias(cmd *cobra.Command, args []string) {
+	template := args[0]
+	example, err := generator.GenerateEmailAlias(template, "vendor")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, i18n.Tf("error", err.Error())+"\n")
+		return
+	}
+
+	aliases, err := store.GetEmailAliases()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, i18n.Tf("error", err.Error())+"\n")
+		return
+	}
+	if err := store.SetEmailAliases(append(aliases, template)); err != nil {
+		fmt.Fprintf(os.Stderr, i18n.Tf("error", err.Error())+"\n")
+		return
+	}
+
+	fmt.Println(i18n.Tf("email_alias_added", template))
+	fmt.Println(i18n.Tf("email_alias_example", example))
+}
+
+// removeEmailAlias handles the 'email-alias remove' command.
+func removeEmailAlias(cmd *cobra.Command, args []string) {
+	aliases, err := store.GetEmailAliases()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, i18n.Tf("error", err.Error())+"\n")
+		return
+	}
+
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n < 1 || n > len(aliases) {
+		fmt.Fprintf(os.Stderr, i18n.Tf("invalid_choice", args[0])+"\n")
+		return
+	}
+	removed := aliases[n-1]
+	if err := store.SetEmailAliases(slices.Delete(aliases, n-1, n)); err != nil {
+		fmt.Fprintf(os.Stderr, i18n.Tf("error", err.Error())+"\n")
+		return
+	}
+
+	fmt.Println(i18n.Tf("email_alias_removed", removed))
+}
+
 // listAccounts handles the 'list' command.
 func listAccounts(cmd *cobra.Command, args []string) {
+	asJSON := outputJSON(cmd)
 	accounts, err := store.GetAccounts()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, i18n.Tf("error", err.Error())+"\n")
-		return
+		exitWith(i18n.Tf("error", err.Error()), err)
 	}
 
+	if asJSON {
+		printAccountsJSON(accounts)
+		return
+	}
 	if len(accounts) == 0 {
 		fmt.Println(i18n.T("no_accounts"))
 		return
@@ -486,10 +1668,10 @@ func listAccounts(cmd *cobra.Command, args []string) {
 // getAccount handles the 'get' command.
 func getAccount(cmd *cobra.Command, args []string) {
 	platform := args[0]
+	asJSON := outputJSON(cmd)
 	accounts, err := store.GetAccounts()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, i18n.Tf("get_account_failed", err)+"\n")
-		return
+		exitWith(i18n.Tf("get_account_failed", err), err)
 	}
 
 	var foundAccounts []*model.Account
@@ -500,7 +1682,10 @@ func getAccount(cmd *cobra.Command, args []string) {
 	}
 
 	if len(foundAccounts) == 0 {
-		fmt.Println(i18n.Tf("not_found_platform", platform))
+		exitWith(i18n.Tf("not_found_platform", platform), errors.ErrAccountNotFound)
+	}
+	if asJSON {
+		printAccountsJSON(foundAccounts)
 		return
 	}
 
@@ -527,6 +1712,34 @@ func getAccount(cmd *cobra.Command, args []string) {
 			fmt.Printf("%s: %s\n", i18n.T("notes"), account.Notes)
 		}
 
+		if len(account.Tags) > 0 {
+			fmt.Printf("%s: %s\n", i18n.T("tags_header"), strings.Join(account.Tags, ", "))
+		}
+
+		if account.PasswordRules != "" {
+			fmt.Printf("%s: %s\n", i18n.T("password_rules_header"), account.PasswordRules)
+		}
+
+		if account.Derived != nil {
+			fmt.Printf("%s: %s\n", i18n.T("derived_header"),
+				i18n.Tf("derived_profile", account.Derived.Site, account.Derived.Login, account.Derived.Counter))
+		}
+
+		if account.SSHKey != nil {
+			fmt.Printf("%s: %s\n", i18n.T("ssh_fingerprint_header"), account.SSHKey.Fingerprint)
+		}
+
+		for _, field := range account.CustomFields {
+			value := field.Value
+			if field.Protected {
+				value = "******"
+			}
+			fmt.Printf("%s: %s\n", field.Name, value)
+		}
+		if len(account.History) > 0 {
+			fmt.Printf("%s: %d\n", i18n.T("history_header"), len(account.History))
+		}
+
 		fmt.Printf("%s: %s\n", i18n.T("created_at"), account.CreatedAt.Format("2006-01-02 15:04:05"))
 		fmt.Printf("%s: %s\n", i18n.T("updated_at"), account.UpdatedAt.Format("2006-01-02 15:04:05"))
 		fmt.Println("----------------------------------------")
@@ -542,8 +1755,7 @@ func deleteAccount(cmd *cobra.Command, args []string) {
 	// Get account details for confirmation prompt
 	account, err := store.GetAccountByID(id)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, i18n.Tf("get_account_failed", err)+"\n")
-		return
+		exitWith(i18n.Tf("get_account_failed", err), err)
 	}
 
 	// Show confirmation info
@@ -555,16 +1767,15 @@ func deleteAccount(cmd *cobra.Command, args []string) {
 	}
 	fmt.Println()
 
-	// Confirm deletion
-	if !readConfirmation(i18n.T("confirm_delete_account")) {
+	// Confirm deletion unless --yes was given
+	if yes, _ := cmd.Flags().GetBool("yes"); !yes && !readConfirmation(i18n.T("confirm_delete_account")) {
 		fmt.Println(i18n.T("operation_canceled"))
 		return
 	}
 
 	// Perform deletion
 	if err := store.DeleteAccount(id); err != nil {
-		fmt.Fprintf(os.Stderr, i18n.Tf("delete_account_failed", err)+"\n")
-		return
+		exitWith(i18n.Tf("delete_account_failed", err), err)
 	}
 
 	fmt.Println(i18n.T("account_deleted"))
@@ -574,35 +1785,53 @@ func deleteAccount(cmd *cobra.Command, args []string) {
 func showPassword(cmd *cobra.Command, args []string) {
 	id := args[0]
 	copyToClipboard, _ := cmd.Flags().GetBool("copy")
+	skipConfirmation, _ := cmd.Flags().GetBool("yes")
+	asJSON := outputJSON(cmd)
 
 	account, err := store.GetAccountByID(id)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, i18n.Tf("get_account_failed", err)+"\n")
+		exitWith(i18n.Tf("get_account_failed", err), err)
+	}
+
+	if account.Derived != nil {
+		if asJSON {
+			exitWith(i18n.Tf("error", errors.ErrPasswordDerived.Error()), errors.ErrPasswordDerived)
+		}
+		fmt.Println(i18n.Tf("password_is_derived", account.Platform, account.ID))
 		return
 	}
 
 	// Decrypt the password
 	decryptedPasswordBytes, err := crypto.Decrypt(account.EncryptedPassword, store.GetEncryptionKey())
 	if err != nil {
-		fmt.Fprintf(os.Stderr, i18n.Tf("decrypt_password_failed", err)+"\n")
-		return
+		exitWith(i18n.Tf("decrypt_password_failed", err), err)
 	}
 	decryptedPassword := string(decryptedPasswordBytes)
 	defer crypto.ClearBytes(decryptedPasswordBytes)
 
 	// Handle display or copy
-	if copyToClipboard {
+	if asJSON {
+		printJSON(struct {
+			ID       string `json:"id"`
+			Platform string `json:"platform"`
+			Username string `json:"username,omitempty"`
+			Email    string `json:"email,omitempty"`
+			Password string `json:"password"`
+		}{account.ID, account.Platform, account.Username, account.Email, decryptedPassword})
+	} else if skipConfirmation && !copyToClipboard {
+		fmt.Println(i18n.Tf("account_password", account.Platform, decryptedPassword))
+	} else if copyToClipboard {
 		// Copy directly to clipboard
 		if err := clipboard.WriteAll(decryptedPassword); err != nil {
 			fmt.Fprintf(os.Stderr, i18n.Tf("password_copy_failed", err)+"\n")
 			return
 		}
 
-		fmt.Println(i18n.Tf("account_password_copied", account.Platform, 30))
+		fmt.Println(i18n.Tf("account_password_copied", account.Platform, settings.Clipboard.ClearSeconds))
 
 		// Auto-clear clipboard
 		go func() {
-			time.Sleep(30 * time.Second)
+			time.Sleep(time.Duration(settings.Clipboard.ClearSeconds) * time.Second)
 			clipboard.WriteAll("")
 		}()
 	} else {
@@ -618,11 +1847,11 @@ func showPassword(cmd *cobra.Command, args []string) {
 					return
 				}
 
-				fmt.Println(i18n.Tf("account_password_copied", account.Platform, 30))
+				fmt.Println(i18n.Tf("account_password_copied", account.Platform, settings.Clipboard.ClearSeconds))
 
 				// Auto-clear clipboard
 				go func() {
-					time.Sleep(30 * time.Second)
+					time.Sleep(time.Duration(settings.Clipboard.ClearSeconds) * time.Second)
 					clipboard.WriteAll("")
 				}()
 			}
@@ -638,11 +1867,11 @@ func showPassword(cmd *cobra.Command, args []string) {
 				return
 			}
 
-			fmt.Println(i18n.Tf("password_copied", 30))
+			fmt.Println(i18n.Tf("password_copied", settings.Clipboard.ClearSeconds))
 
 			// Auto-clear clipboard
 			go func() {
-				time.Sleep(30 * time.Second)
+				time.Sleep(time.Duration(settings.Clipboard.ClearSeconds) * time.Second)
 				clipboard.WriteAll("")
 			}()
 		}
@@ -665,21 +1894,30 @@ func changeMasterPassword(cmd *cobra.Command, args []string) {
 		fmt.Fprintf(os.Stderr, i18n.Tf("invalid_password", err)+"\n")
 		return
 	}
+	defer crypto.ClearBytes([]byte(oldPassword))
 
-	crypto.ClearBytes([]byte(oldPassword))
+	// Derived passwords depend on the master password, so they are stored from now on
+	if hasDerived, err := store.HasDerivedAccounts(); err == nil && hasDerived {
+		fmt.Println(i18n.T("derived_will_be_stored"))
+		if !readConfirmation(i18n.T("confirm_operation")) {
+			fmt.Println(i18n.T("operation_canceled"))
+			return
+		}
+	}
 
 	// Enter and confirm new password
+	minLength := settings.Security.MasterPasswordMinLength
 	newPassword, err := readAndConfirmPassword(
-		i18n.T("enter_new_master_password"),
+		i18n.Tf("enter_new_master_password", minLength),
 		i18n.T("confirm_master_password"),
-		8)
+		minLength)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, i18n.Tf("error", err.Error())+"\n")
 		return
 	}
 
 	// Change the master password in storage
-	if err := store.ChangeMasterPassword(newPassword); err != nil {
+	if err := store.ChangeMasterPassword(oldPassword, newPassword); err != nil {
 		crypto.ClearBytes([]byte(newPassword))
 		fmt.Fprintf(os.Stderr, i18n.Tf("error", err.Error())+"\n")
 		return
@@ -688,17 +1926,42 @@ func changeMasterPassword(cmd *cobra.Command, args []string) {
 	crypto.ClearBytes([]byte(newPassword))
 	isUnlocked = false // Reset unlocked state, forcing re-unlock with new password
 
+	// Keep a key held by the unlock agent in step with the new password
+	client := agent.NewClient(agentSocket())
+	if oldKey, err := client.Key(agentVaultID(dataDir)); err == nil {
+		crypto.ClearBytes(oldKey)
+		client.Add(agentVaultID(dataDir), store.GetEncryptionKey(), 0)
+	}
+
 	fmt.Println(i18n.T("master_password_changed"))
 }
 
-// updateAccount handles the 'update' command.
+// updateAccount handles the 'update' command. Only the fields given as flags
+// change if any are given; otherwise every field is prompted for.
 func updateAccount(cmd *cobra.Command, args []string) {
 	id := args[0]
 
 	// Get existing account data
 	account, err := store.GetAccountByID(id)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, i18n.Tf("get_account_failed", err)+"\n")
+		exitWith(i18n.Tf("get_account_failed", err), err)
+	}
+
+	if accountFlagsGiven(cmd) {
+		applyAccountFlags(cmd, account)
+		if account.Platform == "" {
+			exitWith(i18n.T("platform_required"), nil)
+		}
+		if password, ok := passwordFromFlags(cmd, account.PasswordRules); ok {
+			encryptedPassword, err := crypto.Encrypt([]byte(password), store.GetEncryptionKey())
+			crypto.ClearBytes([]byte(password))
+			if err != nil {
+				exitWith(i18n.Tf("encrypt_password_failed", err), err)
+			}
+			account.EncryptedPassword = encryptedPassword
+			account.Derived = nil // A stored password replaces any derived profile
+		}
+		saveUpdatedAccount(account)
 		return
 	}
 
@@ -711,21 +1974,28 @@ func updateAccount(cmd *cobra.Command, args []string) {
 	account.Email = readUserInput(i18n.T("email_header"), account.Email)
 	account.URL = readUserInput("URL", account.URL)
 	account.Notes = readUserInput(i18n.T("notes"), account.Notes)
+	account.PasswordRules = readUserInput(i18n.T("password_rules_header"), account.PasswordRules)
+	if account.PasswordRules != "" {
+		if _, err := generator.ParsePasswordRules(account.PasswordRules); err != nil {
+			fmt.Fprintf(os.Stderr, i18n.Tf("error", err.Error())+"\n")
+			return
+		}
+	}
 
 	// Ask if password should be updated
 	if readConfirmation(i18n.T("update_password")) {
 		var newPassword string
 
 		if readConfirmation(i18n.T("generate_random_password")) {
-			// Generate random password
-			opts := generator.DefaultOptions()
-			generatedPassword, err := generator.GeneratePassword(opts)
+			// Generate random password, honouring the stored site rules
+			opts := settings.GeneratorOptions()
+			result, err := generator.GeneratePasswordWithRules(account.PasswordRules, opts)
 			if err != nil {
 				fmt.Fprintf(os.Stderr, i18n.Tf("error", err.Error())+"\n")
 				return
 			}
 
-			newPassword = generatedPassword
+			newPassword = result.Password
 			fmt.Println(i18n.Tf("generated_password", newPassword))
 		} else {
 			// Manually input password
@@ -750,6 +2020,7 @@ func updateAccount(cmd *cobra.Command, args []string) {
 		}
 
 		account.EncryptedPassword = encryptedPassword
+		account.Derived = nil // A stored password replaces any derived profile
 		crypto.ClearBytes([]byte(newPassword))
 
 		// Ask to copy the new password
@@ -757,21 +2028,25 @@ func updateAccount(cmd *cobra.Command, args []string) {
 			if err := clipboard.WriteAll(newPassword); err != nil {
 				fmt.Fprintf(os.Stderr, i18n.Tf("password_copy_failed", err)+"\n")
 			} else {
-				fmt.Println(i18n.Tf("password_copied", 60))
+				fmt.Println(i18n.Tf("password_copied", settings.Clipboard.GeneratedClearSeconds))
 
 				go func() {
-					time.Sleep(60 * time.Second)
+					time.Sleep(time.Duration(settings.Clipboard.GeneratedClearSeconds) * time.Second)
 					clipboard.WriteAll("")
 				}()
 			}
 		}
 	}
 
+	saveUpdatedAccount(account)
+}
+
+// saveUpdatedAccount stores the changes to account, exiting on failure.
+func saveUpdatedAccount(account *model.Account) {
 	account.UpdatedAt = time.Now()
 
 	if err := store.UpdateAccount(account); err != nil {
-		fmt.Fprintf(os.Stderr, i18n.Tf("update_account_failed", err)+"\n")
-		return
+		exitWith(i18n.Tf("update_account_failed", err), err)
 	}
 
 	fmt.Println(i18n.T("account_updated"))
@@ -789,6 +2064,37 @@ func exportVault(cmd *cobra.Command, args []string) {
 		}
 	}
 
+	portable, _ := cmd.Flags().GetBool("portable")
+	groups, _ := cmd.Flags().GetStringSlice("group")
+	tags, _ := cmd.Flags().GetStringSlice("tag")
+	ids, _ := cmd.Flags().GetStringSlice("id")
+	if portable || len(groups) > 0 || len(tags) > 0 || len(ids) > 0 {
+		passphrase, err := readAndConfirmPassword(i18n.T("enter_export_passphrase"), i18n.T("confirm_export_passphrase"), 8)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, i18n.Tf("error", err.Error())+"\n")
+			return
+		}
+
+		// Derived passwords are computed from the master password and stored in the file
+		masterPassword := ""
+		if hasDerived, err := store.HasDerivedAccounts(); err == nil && hasDerived {
+			if masterPassword, err = readMasterPassword(); err != nil {
+				crypto.ClearBytes([]byte(passphrase))
+				exitWith(i18n.Tf("error", err.Error()), err)
+			}
+			defer crypto.ClearBytes([]byte(masterPassword))
+		}
+
+		count, err := store.ExportPortable(exportPath, passphrase, storage.ExportSelection{Groups: groups, Tags: tags, IDs: ids}, masterPassword)
+		crypto.ClearBytes([]byte(passphrase))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, i18n.Tf("error", err.Error())+"\n")
+			return
+		}
+		fmt.Println(i18n.Tf("export_portable_success", count, exportPath))
+		return
+	}
+
 	// Perform the export
 	if err := store.ExportVault(exportPath); err != nil {
 		fmt.Fprintf(os.Stderr, i18n.Tf("error", err.Error())+"\n")
@@ -809,6 +2115,30 @@ func importVault(cmd *cobra.Command, args []string) {
 		return
 	}
 
+	if merge, _ := cmd.Flags().GetBool("merge"); merge {
+		mergeVault(cmd, importPath)
+		return
+	}
+
+	// Portable exports are merged into the current vault instead of replacing it
+	if storage.IsPortableExport(importPath) {
+		unlockOrExit()
+		passphrase, err := readPassword(i18n.T("enter_export_passphrase"))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, i18n.Tf("error", err.Error())+"\n")
+			return
+		}
+
+		added, skipped, err := store.ImportPortable(importPath, passphrase)
+		crypto.ClearBytes([]byte(passphrase))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, i18n.Tf("import_failed", err)+"\n")
+			return
+		}
+		fmt.Println(i18n.Tf("import_portable_success", added, skipped))
+		return
+	}
+
 	// Show warning and confirm
 	fmt.Println(i18n.T("import_warning"))
 	if !readConfirmation(i18n.T("confirm_operation")) {
@@ -825,77 +2155,1365 @@ func importVault(cmd *cobra.Command, args []string) {
 	fmt.Println(i18n.T("import_success"))
 }
 
-// searchAccount handles the 'search' command.
-func searchAccount(cmd *cobra.Command, args []string) {
-	query := args[0]
+// mergeVault handles 'import --merge': it shows what merging the vault file
+// or portable export at importPath would change and applies it on confirmation.
+func mergeVault(cmd *cobra.Command, importPath string) {
+	strategy, _ := cmd.Flags().GetString("strategy")
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
 
-	results, err := store.SearchAccounts(query)
+	unlockOrExit()
+	prompt := i18n.T("enter_import_password")
+	if storage.IsPortableExport(importPath) {
+		prompt = i18n.T("enter_export_passphrase")
+	}
+	password, err := readPassword(prompt)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, i18n.Tf("error", err.Error())+"\n")
 		return
 	}
 
-	if len(results) == 0 {
-		fmt.Println(i18n.Tf("not_found_query", query))
+	plan, err := store.PlanMerge(importPath, password, storage.MergeStrategy(strategy))
+	crypto.ClearBytes([]byte(password))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, i18n.Tf("import_failed", err)+"\n")
 		return
 	}
 
-	fmt.Println(i18n.Tf("found_query_accounts", len(results), query))
-	fmt.Println()
-
+	// Diff report; unchanged accounts are only counted
 	table := tablewriter.NewWriter(os.Stdout)
 	table.SetHeader([]string{
-		i18n.T("id_header"),
 		i18n.T("platform_header"),
 		i18n.T("username_header"),
-		i18n.T("email_header"),
+		i18n.T("merge_fields_header"),
+		i18n.T("status_header"),
 	})
-
 	table.SetAutoFormatHeaders(true)
 	table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
 	table.SetAlignment(tablewriter.ALIGN_LEFT)
-	table.SetCenterSeparator("+")
-	table.SetColumnSeparator("|")
-	table.SetRowSeparator("-")
-
-	for _, account := range results {
-		table.Append([]string{
-			account.ID,
-			account.Platform,
-			account.Username,
-			account.Email,
-		})
+	for _, change := range plan.Changes {
+		if change.Action == storage.MergeUnchanged {
+			continue
+		}
+		table.Append([]string{change.Incoming.Platform, change.Incoming.Username,
+			strings.Join(change.Fields, ", "), i18n.T("merge_action_" + string(change.Action))})
 	}
-
 	table.Render()
+	fmt.Println(i18n.Tf("merge_summary", plan.Count(storage.MergeAdd), plan.Count(storage.MergeReplace),
+		plan.Count(storage.MergeDuplicate), plan.Count(storage.MergeKeep), plan.Count(storage.MergeUnchanged)))
 
-	fmt.Println(i18n.T("view_password_hint"))
-	fmt.Println(i18n.T("view_account_detail_hint"))
-}
-
-// exportToCsv handles the 'export-csv' command.
-func exportToCsv(cmd *cobra.Command, args []string) {
-	exportPath := args[0]
-
-	// Show security warnings
-	fmt.Println(i18n.T("csv_export_warning"))
-	fmt.Println(i18n.T("csv_post_export"))
-
+	if dryRun || plan.Count(storage.MergeAdd)+plan.Count(storage.MergeReplace)+plan.Count(storage.MergeDuplicate) == 0 {
+		return
+	}
 	if !readConfirmation(i18n.T("confirm_operation")) {
 		fmt.Println(i18n.T("operation_canceled"))
 		return
 	}
 
-	// Check if export file exists and confirm overwrite
-	if _, err := os.Stat(exportPath); err == nil {
-		if !readConfirmation(i18n.T("export_exists")) {
-			fmt.Println(i18n.T("operation_canceled"))
-			return
+	added, replaced, err := store.ApplyMerge(plan)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, i18n.Tf("import_failed", err)+"\n")
+		return
+	}
+	fmt.Println(i18n.Tf("merge_success", added, replaced))
+}
+
+// syncVault handles the 'sync' command.
+func syncVault(cmd *cobra.Command, args []string) {
+	result, err := mergeWithPasswordPrompt(func(password string) (*storage.SyncResult, error) {
+		if password == "" {
+			return store.Sync(args[0])
+		}
+		// The remote replica was re-keyed with another master password
+		return store.SyncWithPassword(args[0], password)
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, i18n.Tf("sync_failed", err)+"\n")
+		return
+	}
+
+	if result.FirstSync {
+		fmt.Println(i18n.Tf("sync_first", args[0]))
+	}
+	printSyncResult(result, "sync_success")
+}
+
+// printSyncResult prints the conflicts of a merge of replicas and the counts
+// with the message summaryKey.
+func printSyncResult(result *storage.SyncResult, summaryKey string) {
+	if len(result.Conflicts) > 0 {
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{
+			i18n.T("platform_header"),
+			i18n.T("sync_field_header"),
+			i18n.T("sync_winner_header"),
+		})
+		table.SetAutoFormatHeaders(true)
+		table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
+		table.SetAlignment(tablewriter.ALIGN_LEFT)
+		for _, conflict := range result.Conflicts {
+			table.Append([]string{conflict.Platform, conflict.Field, i18n.T("sync_winner_" + conflict.Winner)})
+		}
+		table.Render()
+	}
+	fmt.Println(i18n.Tf(summaryKey, result.Added, result.Updated, result.Deleted, len(result.Conflicts)))
+}
+
+// listBackups handles the 'backup list' command.
+func listBackups(cmd *cobra.Command, args []string) {
+	backups, err := store.ListBackups()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, i18n.Tf("error", err.Error())+"\n")
+		return
+	}
+	if len(backups) == 0 {
+		fmt.Println(i18n.T("backup_none"))
+		return
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{
+		i18n.T("backup_name_header"),
+		i18n.T("backup_date_header"),
+		i18n.T("backup_size_header"),
+	})
+	table.SetAutoFormatHeaders(true)
+	table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	for _, backup := range backups {
+		table.Append([]string{backup.Name, backup.Time.Local().Format("2006-01-02 15:04:05"), fmt.Sprintf("%d", backup.Size)})
+	}
+	table.Render()
+}
+
+// restoreBackup handles the 'backup restore' command. The current vault is
+// kept as a backup itself.
+func restoreBackup(cmd *cobra.Command, args []string) {
+	fmt.Println(i18n.Tf("backup_restore_warning", args[0]))
+	if !readConfirmation(i18n.T("confirm_operation")) {
+		fmt.Println(i18n.T("operation_canceled"))
+		return
+	}
+
+	if err := store.RestoreBackup(args[0]); err != nil {
+		fmt.Fprintf(os.Stderr, i18n.Tf("error", err.Error())+"\n")
+		return
+	}
+	fmt.Println(i18n.Tf("backup_restore_success", args[0]))
+}
+
+// listNamedVaults handles the 'vault list' command.
+func listNamedVaults(cmd *cobra.Command, args []string) {
+	registry := loadRegistry()
+	current, _ := registry.Get("")
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{
+		i18n.T("vault_name_header"),
+		i18n.T("vault_dir_header"),
+		i18n.T("vault_default_header"),
+	})
+	table.SetAutoFormatHeaders(true)
+	table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	for _, vault := range registry.List() {
+		mark := ""
+		if vault.Name == current.Name {
+			mark = "*"
+		}
+		table.Append([]string{vault.Name, vault.Dir, mark})
+	}
+	table.Render()
+}
+
+// createNamedVault handles the 'vault create' command. The vault file is
+// created by 'init' with the new vault selected.
+func createNamedVault(cmd *cobra.Command, args []string) {
+	dir, _ := cmd.Flags().GetString("dir")
+	vault, err := loadRegistry().Create(args[0], dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, i18n.Tf("error", err.Error())+"\n")
+		return
+	}
+	fmt.Println(i18n.Tf("vault_create_success", vault.Name, vault.Dir))
+}
+
+// useNamedVault handles the 'vault use' command.
+func useNamedVault(cmd *cobra.Command, args []string) {
+	if err := loadRegistry().Use(args[0]); err != nil {
+		fmt.Fprintf(os.Stderr, i18n.Tf("error", err.Error())+"\n")
+		return
+	}
+	fmt.Println(i18n.Tf("vault_use_success", args[0]))
+}
+
+// removeNamedVault handles the 'vault remove' command.
+func removeNamedVault(cmd *cobra.Command, args []string) {
+	deleteFiles, _ := cmd.Flags().GetBool("delete-files")
+	registry := loadRegistry()
+	vault, err := registry.Get(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, i18n.Tf("error", err.Error())+"\n")
+		return
+	}
+
+	if deleteFiles {
+		fmt.Println(i18n.Tf("vault_delete_files_warning", vault.Dir))
+		if !readConfirmation(i18n.T("confirm_operation")) {
+			fmt.Println(i18n.T("operation_canceled"))
+			return
+		}
+	}
+	if err := registry.Remove(vault.Name, deleteFiles); err != nil {
+		fmt.Fprintf(os.Stderr, i18n.Tf("error", err.Error())+"\n")
+		return
+	}
+	fmt.Println(i18n.Tf("vault_remove_success", vault.Name))
+	if !deleteFiles {
+		fmt.Println(i18n.Tf("vault_files_kept", vault.Dir))
+	}
+}
+
+// listSettings handles the 'config list' command.
+func listSettings(cmd *cobra.Command, args []string) {
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{
+		i18n.T("config_key_header"),
+		i18n.T("config_value_header"),
+		i18n.T("config_allowed_header"),
+		i18n.T("config_description_header"),
+	})
+	table.SetAutoFormatHeaders(true)
+	table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	for _, field := range config.Fields() {
+		value, _ := settings.Get(field.Key)
+		allowed := "true, false"
+		switch field.Type {
+		case "int":
+			allowed = fmt.Sprintf("%d-%d", field.Min, field.Max)
+		case "string":
+			allowed = strings.Join(field.Values, ", ")
+		}
+		table.Append([]string{field.Key, value, allowed, field.Description})
+	}
+	table.Render()
+}
+
+// getSetting handles the 'config get' command.
+func getSetting(cmd *cobra.Command, args []string) {
+	value, err := settings.Get(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, i18n.Tf("error", err.Error())+"\n")
+		return
+	}
+	fmt.Println(value)
+}
+
+// setSetting handles the 'config set' command.
+func setSetting(cmd *cobra.Command, args []string) {
+	if err := settings.Set(args[0], args[1]); err != nil {
+		fmt.Fprintf(os.Stderr, i18n.Tf("error", err.Error())+"\n")
+		return
+	}
+	root, err := vaults.RootDir(rootDirFlag)
+	if err == nil {
+		err = settings.Save(root)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, i18n.Tf("error", err.Error())+"\n")
+		return
+	}
+	value, _ := settings.Get(args[0])
+	fmt.Println(i18n.Tf("config_set_success", args[0], value))
+}
+
+// startAgent handles the 'agent start' command. Without --foreground it
+// starts the agent in the background and returns once it answers.
+func startAgent(cmd *cobra.Command, args []string) {
+	socket := agentSocket()
+	if foreground, _ := cmd.Flags().GetBool("foreground"); foreground {
+		ttl := time.Duration(settings.Security.AgentTTLMinutes) * time.Minute
+		server, err := agent.Listen(socket, ttl)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, i18n.Tf("error", err.Error())+"\n")
+			os.Exit(1)
+		}
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-signals
+			server.Close()
+		}()
+
+		fmt.Println(i18n.Tf("agent_started", os.Getpid(), socket))
+		if err := server.Serve(); err != nil {
+			fmt.Fprintf(os.Stderr, i18n.Tf("error", err.Error())+"\n")
+			os.Exit(1)
+		}
+		return
+	}
+
+	if status, err := agent.NewClient(socket).Status(); err == nil {
+		fmt.Println(i18n.Tf("agent_already_running", status.PID, socket))
+		return
+	}
+	status, err := spawnAgent(socket)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, i18n.Tf("error", err.Error())+"\n")
+		return
+	}
+	fmt.Println(i18n.Tf("agent_started", status.PID, socket))
+}
+
+// spawnAgent starts a background agent for the root directory on socket.
+func spawnAgent(socket string) (*agent.Status, error) {
+	root, err := vaults.RootDir(rootDirFlag)
+	if err != nil {
+		return nil, err
+	}
+	return agent.Spawn(socket, "agent", "start", "--foreground", "--data-dir", root)
+}
+
+// stopAgent handles the 'agent stop' command.
+func stopAgent(cmd *cobra.Command, args []string) {
+	status, err := agent.NewClient(agentSocket()).Stop()
+	if errors.Is(err, errors.ErrAgentNotRunning) {
+		fmt.Println(i18n.T("agent_not_running"))
+		return
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, i18n.Tf("error", err.Error())+"\n")
+		return
+	}
+	fmt.Println(i18n.Tf("agent_stopped", status.PID, len(status.Vaults)))
+}
+
+// showAgentStatus handles the 'agent status' command.
+func showAgentStatus(cmd *cobra.Command, args []string) {
+	socket := agentSocket()
+	status, err := agent.NewClient(socket).Status()
+	if errors.Is(err, errors.ErrAgentNotRunning) {
+		fmt.Println(i18n.T("agent_not_running"))
+		return
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, i18n.Tf("error", err.Error())+"\n")
+		return
+	}
+
+	fmt.Println(i18n.Tf("agent_status", status.PID, socket, time.Duration(status.DefaultTTL)*time.Second))
+	if len(status.Vaults) == 0 {
+		fmt.Println(i18n.T("agent_no_vaults"))
+		return
+	}
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{
+		i18n.T("vault_dir_header"),
+		i18n.T("agent_expires_header"),
+	})
+	table.SetAutoFormatHeaders(true)
+	table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	for _, entry := range status.Vaults {
+		table.Append([]string{entry.Vault, entry.Expires.Local().Format("2006-01-02 15:04:05")})
+	}
+	table.Render()
+}
+
+// unlockInAgent handles the 'unlock' command: it verifies the master password
+// and hands the vault key to the agent, starting the agent if needed.
+func unlockInAgent(cmd *cobra.Command, args []string) {
+	ttl, _ := cmd.Flags().GetDuration("ttl")
+	if ttl < 0 {
+		fmt.Fprintf(os.Stderr, i18n.Tf("error", i18n.T("agent_invalid_ttl"))+"\n")
+		return
+	}
+	// Always ask for the password, so a held key cannot be extended without it
+	if !store.IsVaultExists() {
+		exitWith(i18n.T("vault_not_exists"), errors.ErrVaultNotExists)
+	}
+	unlockWithPasswordOrExit()
+
+	socket := agentSocket()
+	client := agent.NewClient(socket)
+	if _, err := client.Status(); errors.Is(err, errors.ErrAgentNotRunning) {
+		status, err := spawnAgent(socket)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, i18n.Tf("error", err.Error())+"\n")
+			return
+		}
+		fmt.Println(i18n.Tf("agent_started", status.PID, socket))
+	}
+
+	id := agentVaultID(dataDir)
+	if err := client.Add(id, store.GetEncryptionKey(), ttl); err != nil {
+		fmt.Fprintf(os.Stderr, i18n.Tf("error", err.Error())+"\n")
+		return
+	}
+	status, err := client.Status()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, i18n.Tf("error", err.Error())+"\n")
+		return
+	}
+	for _, entry := range status.Vaults {
+		if entry.Vault == id {
+			fmt.Println(i18n.Tf("agent_unlocked", id, entry.Expires.Local().Format("2006-01-02 15:04:05")))
+		}
+	}
+}
+
+// lockInAgent handles the 'lock' command.
+func lockInAgent(cmd *cobra.Command, args []string) {
+	all, _ := cmd.Flags().GetBool("all")
+	id := ""
+	if !all {
+		vault, err := loadRegistry().Get(vaultName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, i18n.Tf("error", err.Error())+"\n")
+			return
+		}
+		id = agentVaultID(vault.Dir)
+	}
+
+	err := agent.NewClient(agentSocket()).Remove(id)
+	if errors.Is(err, errors.ErrAgentNotRunning) {
+		fmt.Println(i18n.T("agent_not_running"))
+		return
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, i18n.Tf("error", err.Error())+"\n")
+		return
+	}
+	if all {
+		fmt.Println(i18n.T("agent_locked_all"))
+	} else {
+		fmt.Println(i18n.Tf("agent_locked", id))
+	}
+}
+
+// runWithSecrets handles the 'run' command. Variables given with --env and
+// variables of the current environment whose value is a secret reference are
+// resolved and passed to the command; the secrets are masked in its output.
+// Exits with the command's exit code.
+func runWithSecrets(cmd *cobra.Command, args []string) {
+	assignments, _ := cmd.Flags().GetStringArray("env")
+	noMask, _ := cmd.Flags().GetBool("no-mask")
+
+	env := map[string]string{}
+	var names []string
+	for _, variable := range os.Environ() {
+		name, value, _ := strings.Cut(variable, "=")
+		if name == envMasterPassword {
+			continue // Never hand the master password on
+		}
+		if _, seen := env[name]; !seen {
+			names = append(names, name)
+		}
+		env[name] = value
+	}
+	for _, assignment := range assignments {
+		name, value, ok := strings.Cut(assignment, "=")
+		if !ok || name == "" {
+			exitWith(i18n.Tf("error", i18n.Tf("run_invalid_env", assignment)), nil)
+		}
+		if _, seen := env[name]; !seen {
+			names = append(names, name)
+		}
+		env[name] = value
+	}
+
+	resolver := secretref.NewResolver(store)
+	var secrets []string
+	childEnv := make([]string, 0, len(names))
+	for _, name := range names {
+		value := env[name]
+		if secretref.IsReference(value) {
+			secret, err := resolver.ResolveURI(value)
+			if err != nil {
+				exitWith(i18n.Tf("error", fmt.Sprintf("%s: %v", name, err)), err)
+			}
+			value = secret
+			secrets = append(secrets, secret)
+		}
+		childEnv = append(childEnv, name+"="+value)
+	}
+
+	child := exec.Command(args[0], args[1:]...)
+	child.Env = childEnv
+	child.Stdin = os.Stdin
+	child.Stdout, child.Stderr = os.Stdout, os.Stderr
+	var maskers []*secretref.Masker
+	if !noMask && len(secrets) > 0 {
+		stdout := secretref.NewMasker(os.Stdout, secrets)
+		stderr := secretref.NewMasker(os.Stderr, secrets)
+		child.Stdout, child.Stderr = stdout, stderr
+		maskers = append(maskers, stdout, stderr)
+	}
+	if err := child.Start(); err != nil {
+		exitWith(i18n.Tf("error", err.Error()), err)
+	}
+
+	// The terminal sends Ctrl+C to the command too; pass on other signals
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		for sig := range signals {
+			if sig != os.Interrupt {
+				child.Process.Signal(sig)
+			}
+		}
+	}()
+
+	err := child.Wait()
+	for _, masker := range maskers {
+		masker.Flush()
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() >= 0 {
+		os.Exit(exitErr.ExitCode())
+	}
+	if err != nil {
+		exitWith(i18n.Tf("error", err.Error()), err)
+	}
+}
+
+// gitCredential handles the 'git-credential' command, a git credential
+// helper answering from the accounts whose URL matches the remote:
+//
+//	git config --global credential.helper '!passwordmanager git-credential'
+//
+// Nothing is written to stdout except the answer to 'get', and unknown
+// operations are ignored, as git expects of helpers.
+func gitCredential(cmd *cobra.Command, args []string) {
+	operation := args[0]
+	if operation != "get" && operation != "store" && operation != "erase" {
+		return
+	}
+	req, err := gitcred.Read(os.Stdin)
+	if err != nil {
+		exitWith(i18n.Tf("error", err.Error()), err)
+	}
+	if operation != "get" && req.Password == "" {
+		return
+	}
+
+	unlockWithPinentryOrExit()
+	accounts, err := store.GetAccounts()
+	if err != nil {
+		exitWith(i18n.Tf("error", err.Error()), err)
+	}
+	account := gitcred.Find(accounts, req)
+	if account == nil && operation != "store" {
+		return
+	}
+
+	var password string
+	if account != nil {
+		passwordBytes, err := crypto.Decrypt(account.EncryptedPassword, store.GetEncryptionKey())
+		if err != nil {
+			exitWith(i18n.Tf("decrypt_password_failed", err), err)
+		}
+		password = string(passwordBytes)
+		defer crypto.ClearBytes(passwordBytes)
+	}
+
+	switch operation {
+	case "get":
+		username := account.Username
+		if username == "" {
+			username = account.Email
+		}
+		if err := gitcred.Write(os.Stdout, username, password); err != nil {
+			exitWith(i18n.Tf("error", err.Error()), err)
+		}
+
+	case "store":
+		encryptedPassword, err := crypto.Encrypt([]byte(req.Password), store.GetEncryptionKey())
+		if err != nil {
+			exitWith(i18n.Tf("encrypt_password_failed", err), err)
+		}
+		if account == nil {
+			account = &model.Account{
+				ID:                crypto.GenerateID(),
+				Platform:          req.Host,
+				Username:          req.Username,
+				URL:               req.URL(),
+				EncryptedPassword: encryptedPassword,
+			}
+			err = store.AddAccount(account)
+		} else if password != req.Password {
+			account.EncryptedPassword = encryptedPassword
+			account.UpdatedAt = time.Now()
+			err = store.UpdateAccount(account)
+		}
+		if err != nil {
+			exitWith(i18n.Tf("error", err.Error()), err)
+		}
+
+	case "erase":
+		// Git erases credentials that were rejected; keep the account if its
+		// password has changed since
+		if password != req.Password {
+			return
+		}
+		if err := store.DeleteAccount(account.ID); err != nil {
+			exitWith(i18n.Tf("delete_account_failed", err), err)
+		}
+	}
+}
+
+// injectSecrets handles the 'inject' command. The template is read from a
+// file or, for "-", from stdin; the result goes to --out with 0600
+// permissions, or to stdout.
+func injectSecrets(cmd *cobra.Command, args []string) {
+	outPath, _ := cmd.Flags().GetString("out")
+	strict, _ := cmd.Flags().GetBool("strict")
+
+	var text []byte
+	var err error
+	if args[0] == "-" {
+		text, err = io.ReadAll(os.Stdin)
+	} else {
+		text, err = os.ReadFile(args[0])
+	}
+	if err != nil {
+		exitWith(i18n.Tf("error", err.Error()), err)
+	}
+
+	output, unresolved, err := secretref.Render(string(text), secretref.NewResolver(store))
+	if err != nil {
+		exitWith(i18n.Tf("error", err.Error()), err)
+	}
+	for _, err := range unresolved {
+		fmt.Fprintln(os.Stderr, i18n.Tf("inject_unresolved", err))
+	}
+	if strict && len(unresolved) > 0 {
+		exitWith(i18n.Tf("error", i18n.Tf("inject_strict_failed", len(unresolved))), unresolved[0])
+	}
+
+	if outPath == "" {
+		fmt.Print(output)
+		return
+	}
+	if err := writePrivateFile(outPath, []byte(output)); err != nil {
+		exitWith(i18n.Tf("error", err.Error()), err)
+	}
+	fmt.Fprintln(os.Stderr, i18n.Tf("inject_written", outPath))
+}
+
+// serveSSHAgent handles the 'ssh-agent' command. It serves the SSH keys of
+// the unlocked vault until interrupted, and prints the shell commands that
+// point SSH_AUTH_SOCK at it, like ssh-agent.
+func serveSSHAgent(cmd *cobra.Command, args []string) {
+	socket, _ := cmd.Flags().GetString("socket")
+	if socket == "" {
+		socket = filepath.Join(dataDir, "ssh-agent.sock")
+	}
+	listener, err := sshagent.Listen(socket)
+	if err != nil {
+		exitWith(i18n.Tf("error", err.Error()), err)
+	}
+
+	keyring := sshagent.NewKeyring(store, func(account *model.Account) (bool, error) {
+		return pinentry.Confirm(pinentry.Program(), pinentry.Prompt{
+			Title:       i18n.T("app_name"),
+			Description: i18n.Tf("ssh_confirm_description", account.Platform, account.SSHKey.Fingerprint),
+		})
+	})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		listener.Close()
+	}()
+
+	fmt.Printf("SSH_AUTH_SOCK=%s; export SSH_AUTH_SOCK;\n", socket)
+	fmt.Fprintln(os.Stderr, i18n.Tf("ssh_agent_started", os.Getpid(), socket))
+	if err := sshagent.Serve(listener, keyring); err != nil {
+		exitWith(i18n.Tf("error", err.Error()), err)
+	}
+}
+
+// addSSHKey handles the 'ssh-key add' command. The private key is stored in
+// the vault without its passphrase, so the file can be deleted afterwards.
+func addSSHKey(cmd *cobra.Command, args []string) {
+	path := args[0]
+	data, err := os.ReadFile(path)
+	if err != nil {
+		exitWith(i18n.Tf("error", err.Error()), err)
+	}
+
+	privateKey, sshKey, err := sshagent.ParsePrivateKey(data, nil)
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		passphrase, readErr := readPassword(i18n.Tf("ssh_key_passphrase", path))
+		if readErr != nil {
+			exitWith(i18n.Tf("error", readErr.Error()), readErr)
+		}
+		privateKey, sshKey, err = sshagent.ParsePrivateKey(data, []byte(passphrase))
+		crypto.ClearBytes([]byte(passphrase))
+	}
+	if err != nil {
+		exitWith(i18n.Tf("error", err.Error()), err)
+	}
+	defer crypto.ClearBytes(privateKey)
+
+	sshKey.Confirm, _ = cmd.Flags().GetBool("confirm")
+	lifetime, _ := cmd.Flags().GetDuration("lifetime")
+	sshKey.Lifetime = int(lifetime.Seconds())
+	name, _ := cmd.Flags().GetString("name")
+	if name == "" {
+		name = filepath.Base(path)
+	}
+	group, _ := cmd.Flags().GetString("group")
+
+	encryptedKey, err := crypto.Encrypt(privateKey, store.GetEncryptionKey())
+	if err != nil {
+		exitWith(i18n.Tf("encrypt_password_failed", err), err)
+	}
+	account := &model.Account{
+		ID:                crypto.GenerateID(),
+		Platform:          name,
+		Group:             group,
+		EncryptedPassword: encryptedKey,
+		SSHKey:            sshKey,
+	}
+	if err := store.AddAccount(account); err != nil {
+		exitWith(i18n.Tf("add_account_failed", err), err)
+	}
+	fmt.Println(i18n.Tf("account_added", account.Platform, account.ID))
+	fmt.Println(sshKey.Fingerprint)
+}
+
+// listSSHKeys handles the 'ssh-key list' command.
+func listSSHKeys(cmd *cobra.Command, args []string) {
+	accounts, err := store.GetAccounts()
+	if err != nil {
+		exitWith(i18n.Tf("get_account_failed", err), err)
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{
+		"ID",
+		i18n.T("platform_header"),
+		i18n.T("ssh_fingerprint_header"),
+		i18n.T("ssh_confirm_header"),
+		i18n.T("ssh_lifetime_header"),
+	})
+	table.SetAutoFormatHeaders(true)
+	table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	count := 0
+	for _, account := range accounts {
+		if account.SSHKey == nil {
+			continue
+		}
+		confirm, lifetime := "-", "-"
+		if account.SSHKey.Confirm {
+			confirm = i18n.T("ssh_confirm_each_use")
+		}
+		if account.SSHKey.Lifetime > 0 {
+			lifetime = (time.Duration(account.SSHKey.Lifetime) * time.Second).String()
+		}
+		table.Append([]string{account.ID, account.Platform, account.SSHKey.Fingerprint, confirm, lifetime})
+		count++
+	}
+	if count == 0 {
+		fmt.Println(i18n.T("ssh_no_keys"))
+		return
+	}
+	table.Render()
+}
+
+// showSSHPublicKey handles the 'ssh-key public' command, printing the key in
+// authorized_keys format.
+func showSSHPublicKey(cmd *cobra.Command, args []string) {
+	account, err := store.GetAccountByID(args[0])
+	if err != nil {
+		exitWith(i18n.Tf("get_account_failed", err), err)
+	}
+	if account.SSHKey == nil {
+		exitWith(i18n.Tf("error", errors.ErrSSHKeyNotFound.Error()), errors.ErrSSHKeyNotFound)
+	}
+	fmt.Printf("%s %s\n", account.SSHKey.PublicKey, account.Platform)
+}
+
+// serveAPI handles the 'serve' command. It serves the unlocked vault over
+// the local HTTP API until interrupted.
+func serveAPI(cmd *cobra.Command, args []string) {
+	addr, _ := cmd.Flags().GetString("addr")
+	socket, _ := cmd.Flags().GetString("socket")
+	listener, err := api.Listen(addr, socket)
+	if err != nil {
+		exitWith(i18n.Tf("error", err.Error()), err)
+	}
+
+	server := &http.Server{
+		Handler:           api.NewServer(store, api.NewTokens(dataDir), api.NewAuditLog(dataDir), settings.GeneratorOptions()),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		server.Close()
+	}()
+
+	tokens, err := api.NewTokens(dataDir).List()
+	if err != nil {
+		exitWith(i18n.Tf("error", err.Error()), err)
+	}
+	if len(tokens) == 0 {
+		fmt.Fprintln(os.Stderr, i18n.T("api_no_tokens"))
+	}
+	fmt.Println(i18n.Tf("api_serving", listener.Addr()))
+	if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+		exitWith(i18n.Tf("error", err.Error()), err)
+	}
+}
+
+// createAPIToken handles the 'api-token create' command. The token is only
+// shown now; the vault keeps a hash of it.
+func createAPIToken(cmd *cobra.Command, args []string) {
+	readOnly, _ := cmd.Flags().GetBool("read-only")
+	group, _ := cmd.Flags().GetString("group")
+	secret, err := api.NewTokens(dataDir).Create(args[0], readOnly, group)
+	if err != nil {
+		exitWith(i18n.Tf("error", err.Error()), err)
+	}
+	fmt.Fprintln(os.Stderr, i18n.T("api_token_created"))
+	fmt.Println(secret)
+}
+
+// listAPITokens handles the 'api-token list' command.
+func listAPITokens(cmd *cobra.Command, args []string) {
+	tokens, err := api.NewTokens(dataDir).List()
+	if err != nil {
+		exitWith(i18n.Tf("error", err.Error()), err)
+	}
+	if len(tokens) == 0 {
+		fmt.Println(i18n.T("api_no_tokens"))
+		return
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{
+		i18n.T("api_token_name_header"),
+		i18n.T("api_token_scope_header"),
+		i18n.T("created_at"),
+	})
+	table.SetAutoFormatHeaders(true)
+	table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	for _, token := range tokens {
+		scope := i18n.T("api_scope_full")
+		if token.ReadOnly {
+			scope = i18n.T("api_scope_read_only")
+		}
+		if token.Group != "" {
+			scope = i18n.Tf("api_scope_group", scope, token.Group)
+		}
+		table.Append([]string{token.Name, scope, token.CreatedAt.Local().Format("2006-01-02 15:04:05")})
+	}
+	table.Render()
+}
+
+// revokeAPIToken handles the 'api-token revoke' command.
+func revokeAPIToken(cmd *cobra.Command, args []string) {
+	if err := api.NewTokens(dataDir).Revoke(args[0]); err != nil {
+		exitWith(i18n.Tf("error", err.Error()), err)
+	}
+	fmt.Println(i18n.Tf("api_token_revoked", args[0]))
+}
+
+// serveNativeHost handles the 'native-host' command, which the browser
+// starts for an extension and talks to over stdin and stdout. Nothing else
+// may be written to stdout.
+func serveNativeHost(cmd *cobra.Command, args []string) {
+	unlock := func() error {
+		// Ask the agent every time, so locking it stops autofill at once
+		if !unlockFromAgent() {
+			return errors.ErrVaultLocked
+		}
+		return nil
+	}
+	confirm := func(extensionID, code string) (bool, error) {
+		return pinentry.Confirm(pinentry.Program(), pinentry.Prompt{
+			Title:       i18n.T("app_name"),
+			Description: i18n.Tf("native_pair_description", extensionID, dataDir, code),
+		})
+	}
+
+	host := nativehost.NewHost(nativehost.CallerID(args), nativehost.NewExtensions(dataDir), store, unlock, confirm)
+	if err := host.Serve(os.Stdin, os.Stdout); err != nil {
+		exitWith(i18n.Tf("error", err.Error()), err)
+	}
+}
+
+// installBrowserHost handles the 'browser install' command. Browsers start
+// the host without arguments of their own choosing, so a launcher script in
+// the data directory selects the root directory and vault.
+func installBrowserHost(cmd *cobra.Command, args []string) {
+	browser, id := args[0], args[1]
+	if _, err := nativehost.NewManifest(browser, id, ""); err != nil {
+		exitWith(i18n.Tf("error", err.Error()), err)
+	}
+	root, err := vaults.RootDir(rootDirFlag)
+	if err != nil {
+		exitWith(i18n.Tf("error", err.Error()), err)
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		exitWith(i18n.Tf("error", err.Error()), err)
+	}
+
+	launcherArgs := []string{"--data-dir", root}
+	if vaultName != "" {
+		launcherArgs = append(launcherArgs, "--vault", vaultName)
+	}
+	launcher := filepath.Join(agentVaultID(dataDir), "native-host")
+	if err := nativehost.WriteLauncher(launcher, exe, append(launcherArgs, "native-host")); err != nil {
+		exitWith(i18n.Tf("error", err.Error()), err)
+	}
+	if err := nativehost.NewExtensions(dataDir).Allow(id, browser); err != nil {
+		exitWith(i18n.Tf("error", err.Error()), err)
+	}
+	manifestPath, err := nativehost.Install(browser, id, launcher)
+	if err != nil {
+		exitWith(i18n.Tf("error", err.Error()), err)
+	}
+	fmt.Println(i18n.Tf("browser_installed", manifestPath))
+	fmt.Println(i18n.T("browser_install_hint"))
+}
+
+// uninstallBrowserHost handles the 'browser uninstall' command. The
+// extensions of the browser have to be paired again after reinstalling.
+func uninstallBrowserHost(cmd *cobra.Command, args []string) {
+	if err := nativehost.Uninstall(args[0]); err != nil {
+		exitWith(i18n.Tf("error", err.Error()), err)
+	}
+	removed, err := nativehost.NewExtensions(dataDir).Remove(args[0])
+	if err != nil {
+		exitWith(i18n.Tf("error", err.Error()), err)
+	}
+	fmt.Println(i18n.Tf("browser_uninstalled", args[0], removed))
+}
+
+// listBrowserExtensions handles the 'browser list' command.
+func listBrowserExtensions(cmd *cobra.Command, args []string) {
+	extensions, err := nativehost.NewExtensions(dataDir).List()
+	if err != nil {
+		exitWith(i18n.Tf("error", err.Error()), err)
+	}
+	if len(extensions) == 0 {
+		fmt.Println(i18n.T("browser_no_extensions"))
+		return
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{
+		"ID",
+		i18n.T("browser_header"),
+		i18n.T("browser_paired_header"),
+	})
+	table.SetAutoFormatHeaders(true)
+	table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	for _, extension := range extensions {
+		paired := i18n.T("browser_not_paired")
+		if extension.PairedAt != nil {
+			paired = extension.PairedAt.Local().Format("2006-01-02 15:04:05")
+		}
+		table.Append([]string{extension.ID, extension.Browser, paired})
+	}
+	table.Render()
+}
+
+// writePrivateFile replaces path with data, readable only by the owner. The
+// file is written next to path and renamed, so readers never see half of it.
+func writePrivateFile(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return errors.Wrap(err, "failed to create output file")
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "failed to restrict output file")
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "failed to write output file")
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrap(err, "failed to write output file")
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return errors.Wrap(err, "failed to replace output file")
+	}
+	return nil
+}
+
+// enableGitHistory commits every save of a local vault if the data directory
+// is a git repository.
+func enableGitHistory(backend storage.Backend) {
+	if _, local := backend.(*storage.LocalBackend); !local || !gitrepo.IsRepository(dataDir) {
+		return
+	}
+	repo, err := gitrepo.Open(dataDir, storage.VaultFileName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, i18n.Tf("error", err.Error())+"\n")
+		return
+	}
+	store.SetSaveHook(repo.Commit)
+}
+
+// openGitRepo opens the repository in the data directory, exiting on failure.
+func openGitRepo() *gitrepo.Repo {
+	repo, err := gitrepo.Open(dataDir, storage.VaultFileName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, i18n.Tf("error", err.Error())+"\n")
+		os.Exit(1)
+	}
+	return repo
+}
+
+// remoteArg returns the remote named in args or the default remote.
+func remoteArg(args []string) string {
+	if len(args) > 0 {
+		return args[0]
+	}
+	return gitrepo.DefaultRemote
+}
+
+// gitInit handles the 'git init' command.
+func gitInit(cmd *cobra.Command, args []string) {
+	remoteURL := ""
+	if len(args) > 0 {
+		remoteURL = args[0]
+	}
+	if _, err := gitrepo.Init(dataDir, storage.VaultFileName, remoteURL); err != nil {
+		fmt.Fprintf(os.Stderr, i18n.Tf("error", err.Error())+"\n")
+		return
+	}
+	fmt.Println(i18n.Tf("git_init_success", dataDir))
+}
+
+// gitLog handles the 'git log' command.
+func gitLog(cmd *cobra.Command, args []string) {
+	number, _ := cmd.Flags().GetInt("number")
+
+	commits, err := openGitRepo().Log(number)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, i18n.Tf("error", err.Error())+"\n")
+		return
+	}
+	if len(commits) == 0 {
+		fmt.Println(i18n.T("git_no_commits"))
+		return
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{
+		i18n.T("git_commit_header"),
+		i18n.T("git_date_header"),
+		i18n.T("git_author_header"),
+		i18n.T("git_message_header"),
+	})
+	table.SetAutoFormatHeaders(true)
+	table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	for _, commit := range commits {
+		table.Append([]string{commit.ShortHash(), commit.Time.Local().Format("2006-01-02 15:04"), commit.Author, commit.Message})
+	}
+	table.Render()
+}
+
+// gitPush handles the 'git push' command.
+func gitPush(cmd *cobra.Command, args []string) {
+	remote := remoteArg(args)
+	if err := openGitRepo().Push(remote); err != nil {
+		fmt.Fprintf(os.Stderr, i18n.Tf("error", err.Error())+"\n")
+		return
+	}
+	fmt.Println(i18n.Tf("git_push_success", remote))
+}
+
+// gitPull handles the 'git pull' command. Diverged vaults are merged field
+// by field after decrypting both sides, as in 'sync'.
+func gitPull(cmd *cobra.Command, args []string) {
+	remote := remoteArg(args)
+	var result *storage.SyncResult
+	pulled, err := openGitRepo().Pull(remote, func(theirs, base []byte) error {
+		unlockOrExit()
+		var err error
+		result, err = mergeWithPasswordPrompt(func(password string) (*storage.SyncResult, error) {
+			return store.MergeVaultData(theirs, base, password, "merge "+remote)
+		})
+		return err
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, i18n.Tf("error", err.Error())+"\n")
+		return
+	}
+
+	fmt.Println(i18n.T("git_pull_" + strings.ReplaceAll(string(pulled), "-", "_")))
+	if result != nil {
+		printSyncResult(result, "git_merge_success")
+	}
+}
+
+// gitRevert handles the 'git revert' command. The change made by the commit
+// is undone with a new commit; later changes are kept.
+func gitRevert(cmd *cobra.Command, args []string) {
+	repo := openGitRepo()
+	commit, err := repo.Resolve(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, i18n.Tf("error", err.Error())+"\n")
+		return
+	}
+	before, err := repo.ParentFileAt(commit.Hash)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, i18n.Tf("error", err.Error())+"\n")
+		return
+	}
+	after, err := repo.FileAt(commit.Hash)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, i18n.Tf("error", err.Error())+"\n")
+		return
+	}
+
+	fmt.Println(i18n.Tf("git_revert_commit", commit.ShortHash(), commit.Message))
+	if !readConfirmation(i18n.T("confirm_operation")) {
+		fmt.Println(i18n.T("operation_canceled"))
+		return
+	}
+
+	result, err := mergeWithPasswordPrompt(func(password string) (*storage.SyncResult, error) {
+		return store.RevertVaultData(before, after, password, fmt.Sprintf("revert %s: %s", commit.ShortHash(), commit.Message))
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, i18n.Tf("error", err.Error())+"\n")
+		return
+	}
+	printSyncResult(result, "git_revert_success")
+}
+
+// mergeWithPasswordPrompt runs merge without a password and, if the other
+// side uses a different master password, again with one read from the user.
+func mergeWithPasswordPrompt(merge func(password string) (*storage.SyncResult, error)) (*storage.SyncResult, error) {
+	result, err := merge("")
+	if !errors.Is(err, errors.ErrRemotePassword) {
+		return result, err
+	}
+	password, err := readPassword(i18n.T("enter_remote_password"))
+	if err != nil {
+		return nil, err
+	}
+	defer crypto.ClearBytes([]byte(password))
+	return merge(password)
+}
+
+// importCsv handles the 'import-csv' command.
+func importCsv(cmd *cobra.Command, args []string) {
+	format, _ := cmd.Flags().GetString("format")
+	columns, _ := cmd.Flags().GetStringToString("map")
+	groups, _ := cmd.Flags().GetStringToString("group-map")
+	defaultGroup, _ := cmd.Flags().GetString("default-group")
+	keepDuplicates, _ := cmd.Flags().GetBool("keep-duplicates")
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+	accounts, err := store.GetAccounts()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, i18n.Tf("error", err.Error())+"\n")
+		return
+	}
+
+	options := importer.Options{
+		Format:         format,
+		Columns:        columns,
+		Groups:         groups,
+		DefaultGroup:   defaultGroup,
+		KeepDuplicates: keepDuplicates,
+	}
+	preview, err := importer.Load(args[0], accounts, options)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, i18n.Tf("import_failed", err)+"\n")
+		return
+	}
+
+	// Preview what would be imported
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{
+		i18n.T("platform_header"),
+		i18n.T("username_header"),
+		"URL",
+		i18n.T("group_header"),
+		i18n.T("status_header"),
+	})
+	table.SetAutoFormatHeaders(true)
+	table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	for _, entry := range preview.Entries {
+		table.Append([]string{entry.Platform, entry.Username, entry.URL, entry.Group, i18n.T("import_status_new")})
+	}
+	for _, entry := range preview.Duplicates {
+		table.Append([]string{entry.Platform, entry.Username, entry.URL, entry.Group, i18n.T("import_status_duplicate")})
+	}
+	table.Render()
+	fmt.Println(i18n.Tf("import_summary", len(preview.Entries), len(preview.Duplicates), preview.Skipped))
+
+	if dryRun || len(preview.Entries) == 0 {
+		return
+	}
+	if !readConfirmation(i18n.T("confirm_operation")) {
+		fmt.Println(i18n.T("operation_canceled"))
+		return
+	}
+
+	added, err := importer.Commit(store, preview)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, i18n.Tf("import_failed", err)+"\n")
+		return
+	}
+	fmt.Println(i18n.Tf("import_csv_success", added))
+}
+
+// importKdbx handles the 'import-kdbx' command.
+func importKdbx(cmd *cobra.Command, args []string) {
+	keyFile, _ := cmd.Flags().GetString("key-file")
+
+	password, err := readPassword(i18n.T("enter_kdbx_password"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, i18n.Tf("error", err.Error())+"\n")
+		return
+	}
+
+	added, skipped, err := interchange.ImportKDBX(store, args[0], interchange.Credentials{Password: password, KeyFile: keyFile})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, i18n.Tf("import_failed", err)+"\n")
+		return
+	}
+	fmt.Println(i18n.Tf("import_kdbx_success", added, skipped))
+}
+
+// exportKdbx handles the 'export-kdbx' command.
+func exportKdbx(cmd *cobra.Command, args []string) {
+	exportPath := args[0]
+	keyFile, _ := cmd.Flags().GetString("key-file")
+
+	// Check if export file already exists and confirm overwrite
+	if _, err := os.Stat(exportPath); err == nil {
+		if !readConfirmation(i18n.T("export_exists")) {
+			fmt.Println(i18n.T("operation_canceled"))
+			return
+		}
+	}
+
+	// A key file alone is enough, otherwise the new file needs a password
+	minLength := 8
+	if keyFile != "" {
+		minLength = 0
+	}
+	password, err := readAndConfirmPassword(i18n.T("enter_kdbx_new_password"), i18n.T("confirm_kdbx_password"), minLength)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, i18n.Tf("error", err.Error())+"\n")
+		return
+	}
+
+	written, err := interchange.ExportKDBX(store, exportPath, interchange.Credentials{Password: password, KeyFile: keyFile})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, i18n.Tf("error", err.Error())+"\n")
+		return
+	}
+	fmt.Println(i18n.Tf("export_kdbx_success", written, exportPath))
+}
+
+// searchAccount handles the 'search' command.
+func searchAccount(cmd *cobra.Command, args []string) {
+	query := args[0]
+	asJSON := outputJSON(cmd)
+
+	results, err := store.SearchAccounts(query)
+	if err != nil {
+		exitWith(i18n.Tf("error", err.Error()), err)
+	}
+
+	if asJSON {
+		printAccountsJSON(results)
+		return
+	}
+	if len(results) == 0 {
+		fmt.Println(i18n.Tf("not_found_query", query))
+		return
+	}
+
+	fmt.Println(i18n.Tf("found_query_accounts", len(results), query))
+	fmt.Println()
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{
+		i18n.T("id_header"),
+		i18n.T("platform_header"),
+		i18n.T("username_header"),
+		i18n.T("email_header"),
+	})
+
+	table.SetAutoFormatHeaders(true)
+	table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.SetCenterSeparator("+")
+	table.SetColumnSeparator("|")
+	table.SetRowSeparator("-")
+
+	for _, account := range results {
+		table.Append([]string{
+			account.ID,
+			account.Platform,
+			account.Username,
+			account.Email,
+		})
+	}
+
+	table.Render()
+
+	fmt.Println(i18n.T("view_password_hint"))
+	fmt.Println(i18n.T("view_account_detail_hint"))
+}
+
+// exportToCsv handles the 'export-csv' command.
+func exportToCsv(cmd *cobra.Command, args []string) {
+	exportPath := args[0]
+
+	// Show security warnings
+	fmt.Println(i18n.T("csv_export_warning"))
+	fmt.Println(i18n.T("csv_post_export"))
+
+	if !readConfirmation(i18n.T("confirm_operation")) {
+		fmt.Println(i18n.T("operation_canceled"))
+		return
+	}
+
+	// Check if export file exists and confirm overwrite
+	if _, err := os.Stat(exportPath); err == nil {
+		if !readConfirmation(i18n.T("export_exists")) {
+			fmt.Println(i18n.T("operation_canceled"))
+			return
+		}
+	}
+
+	// Derived passwords are computed from the master password
+	masterPassword := ""
+	if hasDerived, err := store.HasDerivedAccounts(); err == nil && hasDerived {
+		if masterPassword, err = readMasterPassword(); err != nil {
+			exitWith(i18n.Tf("error", err.Error()), err)
 		}
+		defer crypto.ClearBytes([]byte(masterPassword))
 	}
 
 	// Perform CSV export
-	if err := store.ExportToCSV(exportPath); err != nil {
+	if err := store.ExportToCSV(exportPath, masterPassword); err != nil {
 		fmt.Fprintf(os.Stderr, i18n.Tf("error", err.Error())+"\n")
 		return
 	}
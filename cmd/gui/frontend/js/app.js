@@ -3,6 +3,24 @@ document.addEventListener('alpine:init', () => {
         // 保留原有的核心状态变量
         isUnlocked: false,
         isVaultExists: false,
+        vaults: [], // 命名密码库列表
+        currentVault: '',
+
+        // 与命令行共用的设置（config.json），加载前使用内置默认值
+        settings: {
+            language: 'en',
+            clipboard: { clear_seconds: 30, generated_clear_seconds: 60 },
+            generator: {
+                length: 16, mode: 'random', lowercase: true, uppercase: true, digits: true, symbols: true,
+                exclude_similar: false, exclude_ambiguous: false,
+            },
+            security: { master_password_min_length: 8, agent_ttl_minutes: 15 },
+        },
+        settingsDialog: {
+            show: false,
+            draft: null,
+            error: '',
+        },
         masterPassword: '',
         confirmMasterPassword: '',
         errorMessage: '',
@@ -25,6 +43,7 @@ document.addEventListener('alpine:init', () => {
             group: '',
         },
         editingPassword: '',
+        editingTags: '',
         showEditPassword: false,
         showPasswordOptions: false,
         searchQuery: '',
@@ -52,8 +71,84 @@ document.addEventListener('alpine:init', () => {
             UseSymbols: true,
             ExcludeSimilar: false,
             ExcludeAmbiguous: false,
+            MinLowercase: 0,
+            MinUppercase: 0,
+            MinDigits: 0,
+            MinSymbols: 0,
+            CustomSymbols: '',
+            Exclude: '',
+            IncludeOnly: '',
+            ExtraChars: '',
+            Alphabets: [],
+            Mode: 'random',
+            Template: 'Cvccvc-99-Cvccvc',
+            MinEntropy: 0,
+        },
+        generateEntropy: null,
+        generatedResult: null,
+
+        // 导入向导
+        importWizard: {
+            show: false,
+            step: 1,
+            formats: [],
+            format: 'bitwarden-csv',
+            path: '',
+            columns: '',
+            groups: '',
+            defaultGroup: '',
+            keepDuplicates: false,
+            preview: null,
+            busy: false,
         },
 
+        // 合并导入
+        mergeDialog: {
+            show: false,
+            step: 1,
+            path: '',
+            password: '',
+            strategy: 'mine',
+            report: null,
+            busy: false,
+        },
+
+        // 加密便携文件导入/导出
+        hasDerivedAccounts: false,
+        csvDialog: {
+            show: false,
+            masterPassword: '',
+        },
+        portableDialog: {
+            show: false,
+            mode: 'export',
+            path: '',
+            passphrase: '',
+            confirmPassphrase: '',
+            masterPassword: '',
+            groups: [],
+            tags: '',
+            busy: false,
+        },
+
+        // KeePass 数据库导入/导出
+        kdbxDialog: {
+            show: false,
+            mode: 'import',
+            path: '',
+            password: '',
+            confirmPassword: '',
+            keyFile: '',
+            busy: false,
+        },
+
+        // 用户名和邮箱别名生成
+        usernameMode: 'words',
+        emailAliases: [],
+        selectedAliasTemplate: '',
+        newAliasTemplate: '',
+        showAliasTemplateInput: false,
+
         // 排序相关变量
         sortable: null,
 
@@ -66,6 +161,8 @@ document.addEventListener('alpine:init', () => {
         async init() {
             try {
                 this.isVaultExists = await window.go.backend.App.IsVaultExists();
+                await this.loadVaults();
+                await this.loadSettings();
             } catch (error) {
                 console.error('初始化错误:', error);
             }
@@ -290,9 +387,91 @@ document.addEventListener('alpine:init', () => {
             this.showNotification('密码库已锁定');
         },
 
+        get minMasterPasswordLength() {
+            return this.settings.security.master_password_min_length;
+        },
+
+        async loadSettings() {
+            try {
+                this.settings = await window.go.backend.App.GetSettings();
+                this.applyGeneratorSettings();
+            } catch (error) {
+                console.error('加载设置失败:', error);
+                this.showNotification('设置文件无效，已使用默认设置：' + error);
+            }
+        },
+
+        // 用设置中的默认值初始化密码生成选项
+        applyGeneratorSettings() {
+            const generator = this.settings.generator;
+            Object.assign(this.generateOptions, {
+                Length: generator.length,
+                Mode: generator.mode,
+                UseLowercase: generator.lowercase,
+                UseUppercase: generator.uppercase,
+                UseDigits: generator.digits,
+                UseSymbols: generator.symbols,
+                ExcludeSimilar: generator.exclude_similar,
+                ExcludeAmbiguous: generator.exclude_ambiguous,
+            });
+        },
+
+        openSettings() {
+            this.settingsDialog.draft = JSON.parse(JSON.stringify(this.settings));
+            this.settingsDialog.error = '';
+            this.settingsDialog.show = true;
+        },
+
+        async saveSettings() {
+            const draft = this.settingsDialog.draft;
+            // 输入框返回字符串，保存前转换为数字
+            draft.clipboard.clear_seconds = Number(draft.clipboard.clear_seconds);
+            draft.clipboard.generated_clear_seconds = Number(draft.clipboard.generated_clear_seconds);
+            draft.generator.length = Number(draft.generator.length);
+            draft.security.master_password_min_length = Number(draft.security.master_password_min_length);
+            draft.security.agent_ttl_minutes = Number(draft.security.agent_ttl_minutes);
+            try {
+                await window.go.backend.App.SaveSettings(draft);
+                this.settings = draft;
+                this.applyGeneratorSettings();
+                this.settingsDialog.show = false;
+                this.showNotification('设置已保存');
+            } catch (error) {
+                this.settingsDialog.error = '保存设置失败：' + error;
+            }
+        },
+
+        async loadVaults() {
+            try {
+                this.vaults = await window.go.backend.App.ListVaults() || [];
+                this.currentVault = await window.go.backend.App.CurrentVault();
+            } catch (error) {
+                console.error('加载密码库列表失败:', error);
+            }
+        },
+
+        // 切换密码库后需要用该密码库的主密码重新解锁
+        async switchVault(name) {
+            try {
+                await window.go.backend.App.SwitchVault(name);
+                if (this.isUnlocked) {
+                    await this.lockVault();
+                }
+                this.isVaultExists = await window.go.backend.App.IsVaultExists();
+                this.errorMessage = '';
+                this.masterPassword = '';
+                this.confirmMasterPassword = '';
+                await this.loadVaults();
+                this.showNotification('已切换到密码库 ' + name);
+            } catch (error) {
+                this.errorMessage = '切换密码库失败：' + error;
+                console.error('切换密码库错误:', error);
+            }
+        },
+
         async createVault() {
-            if (this.masterPassword.length < 8) {
-                this.errorMessage = '主密码至少需要8个字符';
+            if (this.masterPassword.length < this.minMasterPasswordLength) {
+                this.errorMessage = `主密码至少需要${this.minMasterPasswordLength}个字符`;
                 return;
             }
 
@@ -383,8 +562,9 @@ document.addEventListener('alpine:init', () => {
         async copyPassword() {
             try {
                 const password = await window.go.backend.App.DecryptPassword(this.selectedAccountId);
-                await window.go.backend.App.CopyToClipboard(password, 30);
-                this.showNotification('密码已复制到剪贴板，将在30秒后清除');
+                const seconds = this.settings.clipboard.clear_seconds;
+                await window.go.backend.App.CopyToClipboard(password, seconds);
+                this.showNotification(`密码已复制到剪贴板，将在${seconds}秒后清除`);
             } catch (error) {
                 console.error('复制密码错误:', error);
                 this.showNotification('复制密码失败');
@@ -400,11 +580,15 @@ document.addEventListener('alpine:init', () => {
                 email: '',
                 url: '',
                 notes: '',
+                password_rules: '',
             };
             this.editingPassword = '';
+            this.editingTags = '';
             this.showEditPassword = false;
             this.showPasswordOptions = false;
+            this.showAliasTemplateInput = false;
             this.isEditing = true;
+            this.loadEmailAliases();
         },
 
         async editAccount() {
@@ -413,6 +597,7 @@ document.addEventListener('alpine:init', () => {
             this.isNewAccount = false;
             this.editingAccount = { ...this.selectedAccount };
             this.editingPassword = '';
+            this.editingTags = (this.selectedAccount.tags || []).join(', ');
             this.showEditPassword = false;
             this.showPasswordOptions = false;
             this.isEditing = true;
@@ -435,6 +620,7 @@ document.addEventListener('alpine:init', () => {
                 const username = this.editingAccount.username;
                 const isNew = this.isNewAccount;
                 const originalId = this.editingAccount.id;
+                this.editingAccount.tags = this.parseTags(this.editingTags);
 
                 if (isNew) {
                     await window.go.backend.App.AddAccount(this.editingAccount, this.editingPassword);
@@ -508,12 +694,23 @@ document.addEventListener('alpine:init', () => {
 
         togglePasswordOptions() {
             this.showPasswordOptions = !this.showPasswordOptions;
+            if (this.showPasswordOptions) {
+                this.updateGenerateEntropy();
+            }
         },
 
         async generatePasswordForEdit() {
             try {
-                // 确保至少选择了一种字符集
-                if (!this.generateOptions.UseLowercase &&
+                const rules = (this.editingAccount.password_rules || '').trim();
+
+                // 确保至少选择了一种字符集（设置了密码规则时由规则决定字符集）
+                const isRandomMode = this.generateOptions.Mode === 'random';
+                const hasCustomAlphabet = !isRandomMode ||
+                    this.generateOptions.IncludeOnly ||
+                    this.generateOptions.ExtraChars ||
+                    this.generateOptions.Alphabets.length > 0;
+                if (!rules && !hasCustomAlphabet &&
+                    !this.generateOptions.UseLowercase &&
                     !this.generateOptions.UseUppercase &&
                     !this.generateOptions.UseDigits &&
                     !this.generateOptions.UseSymbols) {
@@ -522,14 +719,96 @@ document.addEventListener('alpine:init', () => {
                     return;
                 }
 
-                this.editingPassword = await window.go.backend.App.GeneratePassword(this.generateOptions);
+                // 确保最少字符数之和不超过密码长度
+                const minTotal = this.generateOptions.MinLowercase + this.generateOptions.MinUppercase +
+                    this.generateOptions.MinDigits + this.generateOptions.MinSymbols;
+                if (!rules && isRandomMode && minTotal > this.generateOptions.Length) {
+                    this.showNotification('最少字符数之和不能超过密码长度');
+                    this.showPasswordOptions = true;
+                    return;
+                }
+
+                // 按账户保存的网站密码规则生成
+                this.generatedResult = await window.go.backend.App.GeneratePasswordWithRules(this.generateOptions, rules);
+                this.editingPassword = this.generatedResult.Password;
                 this.showEditPassword = true;
             } catch (error) {
                 console.error('生成密码错误:', error);
+                if (String(error).includes('entropy below required minimum')) {
+                    this.showNotification('密码熵低于设定的最低要求');
+                    this.showPasswordOptions = true;
+                    return;
+                }
                 this.showNotification('生成密码失败');
             }
         },
 
+        // 生成随机用户名
+        async generateUsername() {
+            try {
+                const options = await window.go.backend.App.DefaultUsernameOptions();
+                options.Mode = this.usernameMode;
+                this.editingAccount.username = await window.go.backend.App.GenerateUsername(options);
+            } catch (error) {
+                console.error('生成用户名错误:', error);
+                this.showNotification('生成用户名失败');
+            }
+        },
+
+        // 加载保险库中的邮箱别名模板
+        async loadEmailAliases() {
+            try {
+                this.emailAliases = await window.go.backend.App.GetEmailAliases() || [];
+                if (!this.emailAliases.includes(this.selectedAliasTemplate)) {
+                    this.selectedAliasTemplate = this.emailAliases[0] || '';
+                }
+            } catch (error) {
+                console.error('加载邮箱别名模板错误:', error);
+                this.emailAliases = [];
+            }
+        },
+
+        // 按所选模板生成邮箱别名，{tag} 使用平台名称
+        async generateEmailAlias() {
+            if (!this.selectedAliasTemplate) {
+                this.showAliasTemplateInput = true;
+                return;
+            }
+            try {
+                this.editingAccount.email = await window.go.backend.App.GenerateEmailAlias(
+                    this.selectedAliasTemplate, this.editingAccount.platform || '');
+            } catch (error) {
+                console.error('生成邮箱别名错误:', error);
+                this.showNotification('生成邮箱别名失败');
+            }
+        },
+
+        // 保存新的邮箱别名模板
+        async addEmailAliasTemplate() {
+            const template = this.newAliasTemplate.trim();
+            if (!template) return;
+            try {
+                await window.go.backend.App.SetEmailAliases([...this.emailAliases, template]);
+                await this.loadEmailAliases();
+                this.selectedAliasTemplate = template;
+                this.newAliasTemplate = '';
+                this.showAliasTemplateInput = false;
+                this.showNotification('邮箱别名模板已保存');
+            } catch (error) {
+                console.error('保存邮箱别名模板错误:', error);
+                this.showNotification('模板无效，格式例如 team+{tag}-{rand}@example.com');
+            }
+        },
+
+        // 计算当前生成选项的理论熵
+        async updateGenerateEntropy() {
+            try {
+                this.generateEntropy = await window.go.backend.App.PasswordEntropy(this.generateOptions);
+            } catch (error) {
+                this.generateEntropy = null;
+            }
+        },
+
         passwordStrength(password) {
             if (!password) return '无';
 
@@ -627,12 +906,17 @@ document.addEventListener('alpine:init', () => {
         },
 
         // 更改主密码功能
-        openChangeMasterPassword() {
+        async openChangeMasterPassword() {
             this.isChangingMasterPassword = true;
             this.currentMasterPassword = '';
             this.newMasterPassword = '';
             this.confirmNewMasterPassword = '';
             this.errorMessage = '';
+            try {
+                this.hasDerivedAccounts = await window.go.backend.App.HasDerivedAccounts();
+            } catch (error) {
+                this.hasDerivedAccounts = false;
+            }
         },
 
         cancelChangeMasterPassword() {
@@ -640,8 +924,8 @@ document.addEventListener('alpine:init', () => {
         },
 
         async saveMasterPassword() {
-            if (this.newMasterPassword.length < 8) {
-                this.errorMessage = '主密码至少需要8个字符';
+            if (this.newMasterPassword.length < this.minMasterPasswordLength) {
+                this.errorMessage = `主密码至少需要${this.minMasterPasswordLength}个字符`;
                 return;
             }
 
@@ -663,6 +947,16 @@ document.addEventListener('alpine:init', () => {
             }
         },
 
+        // 将逗号分隔的标签文本解析为去重后的数组
+        parseTags(text) {
+            const tags = [];
+            for (const tag of text.split(/[,，;]/)) {
+                const trimmed = tag.trim();
+                if (trimmed && !tags.includes(trimmed)) tags.push(trimmed);
+            }
+            return tags;
+        },
+
         // 导入导出功能
         async exportVault() {
             if (!this.isUnlocked) {
@@ -680,18 +974,294 @@ document.addEventListener('alpine:init', () => {
         },
 
         async importVault() {
-            if (!confirm('导入将覆盖当前密码库中的数据。请确认您已经备份了重要数据。是否继续？')) {
+            try {
+                const path = await window.go.backend.App.ShowImportDialog();
+                if (!path) return;
+
+                // 便携导出文件合并到当前密码库，需要输入导出口令
+                if (await window.go.backend.App.IsPortableExport(path)) {
+                    if (!this.isUnlocked) {
+                        this.showNotification('请先解锁密码库');
+                        return;
+                    }
+                    this.openPortableDialog('import', path);
+                    return;
+                }
+
+                if (!confirm('导入将覆盖当前密码库中的数据。请确认您已经备份了重要数据。是否继续？')) {
+                    return;
+                }
+                await window.go.backend.App.ImportVaultFromPath(path);
+                await this.loadGroups();
+                await this.loadAccounts();
+                this.showNotification('密码库已成功导入');
+            } catch (error) {
+                console.error('导入错误:', error);
+                this.showNotification('导入失败: ' + error);
+            }
+        },
+
+        // 打开从其他密码管理器导入的向导
+        async openImportWizard() {
+            if (!this.isUnlocked) {
+                this.showNotification('请先解锁密码库');
                 return;
             }
+            try {
+                this.importWizard.formats = await window.go.backend.App.ImportFormats();
+            } catch (error) {
+                console.error('获取导入格式错误:', error);
+            }
+            Object.assign(this.importWizard, {
+                show: true, step: 1, path: '', columns: '', groups: '',
+                defaultGroup: '', keepDuplicates: false, preview: null, busy: false,
+            });
+        },
+
+        async chooseImportFile() {
+            try {
+                const path = await window.go.backend.App.ShowImportCsvDialog();
+                if (path) this.importWizard.path = path;
+            } catch (error) {
+                console.error('选择导入文件错误:', error);
+            }
+        },
+
+        // 将 "键=值" 形式的多行文本解析为映射
+        parseMappingLines(text) {
+            const mapping = {};
+            for (const line of text.split('\n')) {
+                const index = line.indexOf('=');
+                if (index > 0) {
+                    mapping[line.slice(0, index).trim()] = line.slice(index + 1).trim();
+                }
+            }
+            return mapping;
+        },
+
+        importOptions() {
+            return {
+                Format: this.importWizard.format,
+                Columns: this.parseMappingLines(this.importWizard.columns),
+                Groups: this.parseMappingLines(this.importWizard.groups),
+                DefaultGroup: this.importWizard.defaultGroup.trim(),
+                KeepDuplicates: this.importWizard.keepDuplicates,
+            };
+        },
 
+        async previewImport() {
+            this.importWizard.busy = true;
             try {
-                await window.go.backend.App.ImportVault();
+                this.importWizard.preview = await window.go.backend.App.PreviewImportCsv(
+                    this.importWizard.path, this.importOptions());
+                this.importWizard.step = 2;
+            } catch (error) {
+                console.error('导入预览错误:', error);
+                this.showNotification('无法读取导入文件: ' + error);
+            } finally {
+                this.importWizard.busy = false;
+            }
+        },
+
+        async commitImport() {
+            this.importWizard.busy = true;
+            try {
+                const added = await window.go.backend.App.ImportCsv(this.importWizard.path, this.importOptions());
+                this.importWizard.show = false;
                 await this.loadGroups();
                 await this.loadAccounts();
-                this.showNotification('密码库已成功导入');
+                this.showNotification(`已导入 ${added} 个账户`);
             } catch (error) {
                 console.error('导入错误:', error);
                 this.showNotification('导入失败: ' + error);
+            } finally {
+                this.importWizard.busy = false;
+            }
+        },
+
+        // 打开合并导入对话框
+        openMergeDialog() {
+            if (!this.isUnlocked) {
+                this.showNotification('请先解锁密码库');
+                return;
+            }
+            Object.assign(this.mergeDialog, {
+                show: true, step: 1, path: '', password: '', strategy: 'mine', report: null, busy: false,
+            });
+        },
+
+        async chooseMergeFile() {
+            try {
+                const path = await window.go.backend.App.ShowImportDialog();
+                if (path) this.mergeDialog.path = path;
+            } catch (error) {
+                console.error('选择导入文件错误:', error);
+            }
+        },
+
+        mergeActionLabel(action) {
+            return {
+                add: '新增',
+                keep: '冲突，保留本地',
+                replace: '冲突，使用导入',
+                duplicate: '冲突，两者都保留',
+            }[action] || action;
+        },
+
+        async previewMerge() {
+            const dialog = this.mergeDialog;
+            dialog.busy = true;
+            try {
+                dialog.report = await window.go.backend.App.PreviewMerge(dialog.path, dialog.password, dialog.strategy);
+                dialog.step = 2;
+            } catch (error) {
+                console.error('合并预览错误:', error);
+                this.showNotification('无法读取导入文件: ' + error);
+            } finally {
+                dialog.busy = false;
+                dialog.password = '';
+            }
+        },
+
+        async applyMerge() {
+            this.mergeDialog.busy = true;
+            try {
+                const result = await window.go.backend.App.ApplyMerge();
+                this.mergeDialog.show = false;
+                await this.loadGroups();
+                await this.loadAccounts();
+                this.showNotification(`合并完成：新增 ${result.Added} 个账户，替换 ${result.Replaced} 个账户`);
+            } catch (error) {
+                console.error('合并错误:', error);
+                this.showNotification('合并失败: ' + error);
+            } finally {
+                this.mergeDialog.busy = false;
+            }
+        },
+
+        async cancelMerge() {
+            this.mergeDialog.show = false;
+            try {
+                await window.go.backend.App.CancelMerge();
+            } catch (error) {
+                console.error('取消合并错误:', error);
+            }
+        },
+
+        // 打开便携文件对话框，mode 为 'import' 或 'export'
+        async openPortableDialog(mode, path = '') {
+            if (!this.isUnlocked) {
+                this.showNotification('请先解锁密码库');
+                return;
+            }
+            Object.assign(this.portableDialog, {
+                show: true, mode, path, passphrase: '', confirmPassphrase: '', masterPassword: '', groups: [], tags: '', busy: false,
+            });
+            try {
+                this.hasDerivedAccounts = await window.go.backend.App.HasDerivedAccounts();
+            } catch (error) {
+                this.hasDerivedAccounts = false;
+            }
+        },
+
+        async choosePortableFile() {
+            try {
+                const path = await window.go.backend.App.ShowPortableDialog();
+                if (path) this.portableDialog.path = path;
+            } catch (error) {
+                console.error('选择文件错误:', error);
+            }
+        },
+
+        async submitPortable() {
+            const dialog = this.portableDialog;
+            if (dialog.mode === 'export' && dialog.passphrase !== dialog.confirmPassphrase) {
+                this.showNotification('两次输入的口令不一致');
+                return;
+            }
+
+            dialog.busy = true;
+            try {
+                if (dialog.mode === 'import') {
+                    const result = await window.go.backend.App.ImportPortable(dialog.path, dialog.passphrase);
+                    await this.loadGroups();
+                    await this.loadAccounts();
+                    this.showNotification(`已合并 ${result.Added} 个账户，跳过 ${result.Skipped} 个已存在的账户`);
+                } else {
+                    const count = await window.go.backend.App.ExportPortable(dialog.path, dialog.passphrase, {
+                        Groups: dialog.groups,
+                        Tags: this.parseTags(dialog.tags),
+                        IDs: [],
+                    }, dialog.masterPassword);
+                    this.showNotification(`已导出 ${count} 个账户`);
+                }
+                dialog.show = false;
+            } catch (error) {
+                console.error('便携文件操作错误:', error);
+                this.showNotification((dialog.mode === 'import' ? '导入失败: ' : '导出失败: ') + error);
+            } finally {
+                dialog.busy = false;
+                dialog.passphrase = '';
+                dialog.confirmPassphrase = '';
+                dialog.masterPassword = '';
+            }
+        },
+
+        // 打开 KeePass 导入或导出对话框，mode 为 'import' 或 'export'
+        openKdbxDialog(mode) {
+            if (!this.isUnlocked) {
+                this.showNotification('请先解锁密码库');
+                return;
+            }
+            Object.assign(this.kdbxDialog, {
+                show: true, mode, path: '', password: '', confirmPassword: '', keyFile: '', busy: false,
+            });
+        },
+
+        async chooseKdbxFile() {
+            try {
+                const path = await window.go.backend.App.ShowKdbxDialog(this.kdbxDialog.mode === 'export');
+                if (path) this.kdbxDialog.path = path;
+            } catch (error) {
+                console.error('选择文件错误:', error);
+            }
+        },
+
+        async chooseKeyFile() {
+            try {
+                const path = await window.go.backend.App.ShowKeyFileDialog();
+                if (path) this.kdbxDialog.keyFile = path;
+            } catch (error) {
+                console.error('选择密钥文件错误:', error);
+            }
+        },
+
+        async submitKdbx() {
+            const dialog = this.kdbxDialog;
+            if (dialog.mode === 'export' && dialog.password !== dialog.confirmPassword) {
+                this.showNotification('两次输入的密码不一致');
+                return;
+            }
+
+            dialog.busy = true;
+            try {
+                if (dialog.mode === 'import') {
+                    const result = await window.go.backend.App.ImportKdbx(dialog.path, dialog.password, dialog.keyFile);
+                    await this.loadGroups();
+                    await this.loadAccounts();
+                    this.showNotification(`已导入 ${result.Added} 个账户，跳过 ${result.Skipped} 个`);
+                } else {
+                    const count = await window.go.backend.App.ExportKdbx(dialog.path, dialog.password, dialog.keyFile);
+                    this.showNotification(`已导出 ${count} 个账户到 KeePass 数据库`);
+                }
+                dialog.show = false;
+            } catch (error) {
+                console.error('KeePass 数据库操作错误:', error);
+                this.showNotification((dialog.mode === 'import' ? '导入失败: ' : '导出失败: ') + error);
+            } finally {
+                dialog.busy = false;
+                dialog.password = '';
+                dialog.confirmPassword = '';
             }
         },
 
@@ -706,8 +1276,22 @@ document.addEventListener('alpine:init', () => {
                 return;
             }
 
+            // 派生密码需要主密码才能计算
+            try {
+                if (await window.go.backend.App.HasDerivedAccounts()) {
+                    this.csvDialog = { show: true, masterPassword: '' };
+                    return;
+                }
+            } catch (error) {
+                console.error('检查派生账户错误:', error);
+            }
+            await this.submitCsvExport('');
+        },
+
+        async submitCsvExport(masterPassword) {
+            this.csvDialog = { show: false, masterPassword: '' };
             try {
-                await window.go.backend.App.ExportCsv();
+                await window.go.backend.App.ExportCsv(masterPassword);
                 this.showNotification('密码库已成功导出为CSV格式');
 
                 setTimeout(() => {
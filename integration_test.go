@@ -39,10 +39,11 @@ func TestBasicFlow(t *testing.T) {
 
 	// 4. Generate password
 	passwordOpts := generator.DefaultOptions()
-	password, err := generator.GeneratePassword(passwordOpts)
+	result, err := generator.GeneratePassword(passwordOpts)
 	if err != nil {
 		t.Fatalf("Failed to generate password: %v", err)
 	}
+	password := result.Password
 
 	// 5. Encrypt password
 	encryptedPassword, err := crypto.Encrypt([]byte(password), store.GetEncryptionKey())
@@ -87,7 +88,7 @@ func TestBasicFlow(t *testing.T) {
 
 	// 9. Change master password
 	newMasterPassword := "new-master-password"
-	if err := store.ChangeMasterPassword(newMasterPassword); err != nil {
+	if err := store.ChangeMasterPassword(masterPassword, newMasterPassword); err != nil {
 		t.Fatalf("Failed to change master password: %v", err)
 	}
 
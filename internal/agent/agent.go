@@ -0,0 +1,352 @@
+// Package agent implements the unlock agent: a background process that keeps
+// the encryption keys of unlocked vaults in memory for a limited time, so that
+// CLI commands need not ask for the master password every time. Clients talk
+// to it over a Unix socket that only the owner can reach; on Linux the agent
+// also checks that every client runs as the same user.
+//
+// Each request is one JSON object on its own connection, answered by one JSON
+// object.
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/simp-lee/passwordmanager/internal/crypto"
+	"github.com/simp-lee/passwordmanager/internal/errors"
+	"github.com/simp-lee/passwordmanager/internal/socket"
+)
+
+const (
+	EnvSocket      = "PASSWORDMANAGER_AGENT_SOCK" // Overrides the socket path
+	SocketFileName = "agent.sock"                 // Socket in the root data directory
+	DefaultTTL     = 15 * time.Minute             // How long keys are kept by default
+	dialTimeout    = 2 * time.Second
+	startTimeout   = 5 * time.Second
+	requestTimeout = 5 * time.Second
+	maxRequestSize = 64 << 10
+)
+
+// Operations understood by the agent.
+const (
+	opAdd    = "add"
+	opGet    = "get"
+	opRemove = "remove"
+	opStatus = "status"
+	opStop   = "stop"
+)
+
+type request struct {
+	Op    string `json:"op"`
+	Vault string `json:"vault,omitempty"` // Data directory of the vault
+	Key   []byte `json:"key,omitempty"`
+	TTL   int64  `json:"ttl,omitempty"` // Seconds; 0 uses the agent default
+}
+
+type response struct {
+	Error  string  `json:"error,omitempty"`
+	Key    []byte  `json:"key,omitempty"`
+	Status *Status `json:"status,omitempty"`
+}
+
+// Status describes a running agent.
+type Status struct {
+	PID        int     `json:"pid"`
+	DefaultTTL int64   `json:"default_ttl"` // Seconds
+	Vaults     []Entry `json:"vaults"`
+}
+
+// Entry is an unlocked vault held by the agent.
+type Entry struct {
+	Vault   string    `json:"vault"`
+	Expires time.Time `json:"expires"`
+}
+
+// SocketPath returns the socket of the agent for the root data directory
+// root, or $PASSWORDMANAGER_AGENT_SOCK if it is set.
+func SocketPath(root string) string {
+	if path := os.Getenv(EnvSocket); path != "" {
+		return path
+	}
+	return filepath.Join(root, SocketFileName)
+}
+
+// entry is a key held by the server.
+type entry struct {
+	key     []byte
+	expires time.Time
+	timer   *time.Timer
+}
+
+// Server holds keys and answers clients.
+type Server struct {
+	listener   net.Listener
+	path       string
+	defaultTTL time.Duration
+	entries    map[string]*entry
+	mu         sync.Mutex
+	done       chan struct{}
+	closeOnce  sync.Once
+}
+
+// Listen creates the socket at path with socket.Listen and returns a server
+// for it. A stale socket left by a crashed agent is replaced; a live one is
+// an error.
+func Listen(path string, defaultTTL time.Duration) (*Server, error) {
+	if defaultTTL <= 0 {
+		defaultTTL = DefaultTTL
+	}
+	listener, err := socket.Listen(path)
+	if errors.Is(err, errors.ErrSocketInUse) {
+		return nil, errors.ErrAgentRunning
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create agent socket")
+	}
+	return &Server{
+		listener:   listener,
+		path:       path,
+		defaultTTL: defaultTTL,
+		entries:    map[string]*entry{},
+		done:       make(chan struct{}),
+	}, nil
+}
+
+// Serve answers clients until the server is closed or asked to stop.
+func (s *Server) Serve() error {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			select {
+			case <-s.done:
+				return nil
+			default:
+				return errors.Wrap(err, "agent socket failed")
+			}
+		}
+		go s.handle(conn)
+	}
+}
+
+// Close stops the server, removes the socket and wipes all keys.
+func (s *Server) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.done)
+		err = s.listener.Close()
+		os.Remove(s.path)
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for vault := range s.entries {
+			s.removeLocked(vault)
+		}
+	})
+	return err
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(requestTimeout))
+
+	if err := checkPeer(conn); err != nil {
+		json.NewEncoder(conn).Encode(response{Error: err.Error()})
+		return
+	}
+	var req request
+	if err := json.NewDecoder(io.LimitReader(conn, maxRequestSize)).Decode(&req); err != nil {
+		json.NewEncoder(conn).Encode(response{Error: "malformed request"})
+		return
+	}
+	defer crypto.ClearBytes(req.Key)
+
+	resp := s.dispatch(req)
+	json.NewEncoder(conn).Encode(resp)
+	crypto.ClearBytes(resp.Key)
+	if req.Op == opStop {
+		s.Close()
+	}
+}
+
+func (s *Server) dispatch(req request) response {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch req.Op {
+	case opAdd:
+		if req.Vault == "" || len(req.Key) == 0 {
+			return response{Error: "vault and key are required"}
+		}
+		ttl := s.defaultTTL
+		if req.TTL > 0 {
+			ttl = time.Duration(req.TTL) * time.Second
+		}
+		s.removeLocked(req.Vault)
+		vault := req.Vault
+		e := &entry{key: append([]byte(nil), req.Key...), expires: time.Now().Add(ttl)}
+		e.timer = time.AfterFunc(ttl, func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			if s.entries[vault] == e {
+				s.removeLocked(vault)
+			}
+		})
+		s.entries[vault] = e
+		return response{}
+
+	case opGet:
+		e, ok := s.entries[req.Vault]
+		if !ok || time.Now().After(e.expires) {
+			return response{Error: errors.ErrAgentLocked.Error()}
+		}
+		return response{Key: append([]byte(nil), e.key...)}
+
+	case opRemove:
+		if req.Vault == "" {
+			for vault := range s.entries {
+				s.removeLocked(vault)
+			}
+		} else {
+			s.removeLocked(req.Vault)
+		}
+		return response{}
+
+	case opStatus, opStop:
+		status := &Status{PID: os.Getpid(), DefaultTTL: int64(s.defaultTTL / time.Second), Vaults: []Entry{}}
+		for vault, e := range s.entries {
+			status.Vaults = append(status.Vaults, Entry{Vault: vault, Expires: e.expires})
+		}
+		sort.Slice(status.Vaults, func(i, j int) bool { return status.Vaults[i].Vault < status.Vaults[j].Vault })
+		return response{Status: status}
+
+	default:
+		return response{Error: fmt.Sprintf("unknown operation %q", req.Op)}
+	}
+}
+
+// removeLocked wipes and forgets the key of vault. Caller must hold the lock.
+func (s *Server) removeLocked(vault string) {
+	if e, ok := s.entries[vault]; ok {
+		e.timer.Stop()
+		crypto.ClearBytes(e.key)
+		delete(s.entries, vault)
+	}
+}
+
+// Spawn runs the current executable with args as a background process that
+// outlives the caller, and waits until it answers on path. args must make the
+// process serve an agent on path.
+func Spawn(path string, args ...string) (*Status, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to find the executable")
+	}
+	cmd := exec.Command(exe, args...)
+	cmd.SysProcAttr = detached()
+	if err := cmd.Start(); err != nil {
+		return nil, errors.Wrap(err, "failed to start agent")
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+
+	client := NewClient(path)
+	deadline := time.Now().Add(startTimeout)
+	for time.Now().Before(deadline) {
+		if status, err := client.Status(); err == nil {
+			return status, nil
+		}
+		select {
+		case err := <-exited:
+			if err == nil {
+				err = errors.ErrAgentNotRunning
+			}
+			return nil, errors.Wrap(err, "agent exited")
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+	return nil, errors.Wrap(errors.ErrAgentNotRunning, "agent did not start in time")
+}
+
+// Client talks to the agent listening on a socket.
+type Client struct {
+	path string
+}
+
+// NewClient returns a client for the agent listening on path.
+func NewClient(path string) *Client {
+	return &Client{path: path}
+}
+
+// Add hands the key of vault to the agent, which keeps it for ttl, or for its
+// default time if ttl is 0.
+func (c *Client) Add(vault string, key []byte, ttl time.Duration) error {
+	_, err := c.call(request{Op: opAdd, Vault: vault, Key: key, TTL: int64(ttl / time.Second)})
+	return err
+}
+
+// Key returns the key of vault. Returns errors.ErrAgentLocked if the agent
+// does not hold it and errors.ErrAgentNotRunning if there is no agent.
+func (c *Client) Key(vault string) ([]byte, error) {
+	resp, err := c.call(request{Op: opGet, Vault: vault})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Key, nil
+}
+
+// Remove makes the agent forget the key of vault, or all keys if vault is "".
+func (c *Client) Remove(vault string) error {
+	_, err := c.call(request{Op: opRemove, Vault: vault})
+	return err
+}
+
+// Status reports the agent's process and the vaults it holds.
+func (c *Client) Status() (*Status, error) {
+	resp, err := c.call(request{Op: opStatus})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Status, nil
+}
+
+// Stop makes the agent wipe all keys and exit. Returns its last status.
+func (c *Client) Stop() (*Status, error) {
+	resp, err := c.call(request{Op: opStop})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Status, nil
+}
+
+func (c *Client) call(req request) (*response, error) {
+	conn, err := net.DialTimeout("unix", c.path, dialTimeout)
+	if err != nil {
+		return nil, errors.ErrAgentNotRunning
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(requestTimeout))
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return nil, errors.Wrap(err, "failed to send agent request")
+	}
+	var resp response
+	if err := json.NewDecoder(io.LimitReader(conn, maxRequestSize)).Decode(&resp); err != nil {
+		return nil, errors.Wrap(err, "failed to read agent response")
+	}
+	switch resp.Error {
+	case "":
+		return &resp, nil
+	case errors.ErrAgentLocked.Error():
+		return nil, errors.ErrAgentLocked
+	default:
+		return nil, fmt.Errorf("agent: %s", resp.Error)
+	}
+}
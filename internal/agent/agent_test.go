@@ -0,0 +1,137 @@
+package agent
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/simp-lee/passwordmanager/internal/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startServer serves an agent in a private temporary directory. The channel
+// receives the result of Serve.
+func startServer(t *testing.T, ttl time.Duration) (*Server, *Client, <-chan error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "agent", SocketFileName)
+	server, err := Listen(path, ttl)
+	require.NoError(t, err)
+	done := make(chan error, 1)
+	go func() { done <- server.Serve() }()
+	t.Cleanup(func() { server.Close() })
+	return server, NewClient(path), done
+}
+
+func TestAddGetRemove(t *testing.T) {
+	_, client, _ := startServer(t, time.Minute)
+
+	_, err := client.Key("/vaults/a")
+	assert.ErrorIs(t, err, errors.ErrAgentLocked)
+
+	require.NoError(t, client.Add("/vaults/a", []byte("key-a"), 0))
+	require.NoError(t, client.Add("/vaults/b", []byte("key-b"), time.Hour))
+	key, err := client.Key("/vaults/a")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("key-a"), key)
+
+	status, err := client.Status()
+	require.NoError(t, err)
+	assert.Equal(t, os.Getpid(), status.PID)
+	assert.Equal(t, int64(60), status.DefaultTTL)
+	require.Len(t, status.Vaults, 2)
+	assert.Equal(t, "/vaults/a", status.Vaults[0].Vault)
+	assert.True(t, status.Vaults[1].Expires.After(status.Vaults[0].Expires), "An explicit TTL overrides the default")
+
+	require.NoError(t, client.Remove("/vaults/a"))
+	_, err = client.Key("/vaults/a")
+	assert.ErrorIs(t, err, errors.ErrAgentLocked)
+	_, err = client.Key("/vaults/b")
+	assert.NoError(t, err)
+
+	require.NoError(t, client.Remove(""))
+	_, err = client.Key("/vaults/b")
+	assert.ErrorIs(t, err, errors.ErrAgentLocked, "Removing without a vault forgets all keys")
+}
+
+func TestKeysExpire(t *testing.T) {
+	_, client, _ := startServer(t, time.Minute)
+
+	require.NoError(t, client.Add("/vaults/a", []byte("key-a"), time.Second))
+	_, err := client.Key("/vaults/a")
+	require.NoError(t, err)
+
+	time.Sleep(1100 * time.Millisecond)
+	_, err = client.Key("/vaults/a")
+	assert.ErrorIs(t, err, errors.ErrAgentLocked)
+	status, err := client.Status()
+	require.NoError(t, err)
+	assert.Empty(t, status.Vaults, "Expired keys are wiped")
+}
+
+func TestStop(t *testing.T) {
+	_, client, done := startServer(t, 0)
+
+	require.NoError(t, client.Add("/vaults/a", []byte("key-a"), 0))
+	status, err := client.Stop()
+	require.NoError(t, err)
+	assert.Equal(t, int64(DefaultTTL/time.Second), status.DefaultTTL)
+	assert.Len(t, status.Vaults, 1)
+
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("server did not stop")
+	}
+	_, err = client.Status()
+	assert.ErrorIs(t, err, errors.ErrAgentNotRunning)
+	_, err = os.Stat(client.path)
+	assert.True(t, os.IsNotExist(err), "The socket is removed")
+}
+
+func TestListen(t *testing.T) {
+	server, client, _ := startServer(t, 0)
+	_, err := Listen(client.path, 0)
+	assert.ErrorIs(t, err, errors.ErrAgentRunning)
+
+	// A socket left behind by a dead agent is replaced
+	server.listener.(interface{ SetUnlinkOnClose(bool) }).SetUnlinkOnClose(false)
+	server.closeOnce.Do(func() { server.listener.Close() })
+	restarted, err := Listen(client.path, 0)
+	require.NoError(t, err)
+	restarted.Close()
+
+	// A live socket is kept even if it does not answer like an agent
+	busy := filepath.Join(filepath.Dir(client.path), "busy.sock")
+	listener, err := net.Listen("unix", busy)
+	require.NoError(t, err)
+	defer listener.Close()
+	_, err = Listen(busy, 0)
+	assert.ErrorIs(t, err, errors.ErrAgentRunning)
+
+	// Other files are never removed
+	notes := filepath.Join(filepath.Dir(client.path), "notes.txt")
+	require.NoError(t, os.WriteFile(notes, []byte("notes"), 0600))
+	_, err = Listen(notes, 0)
+	assert.ErrorIs(t, err, errors.ErrNotSocket)
+	assert.FileExists(t, notes)
+
+	if runtime.GOOS == "windows" {
+		t.Skip("permission bits are not used on Windows")
+	}
+	dir := filepath.Join(t.TempDir(), "open")
+	require.NoError(t, os.Mkdir(dir, 0755))
+	_, err = Listen(filepath.Join(dir, SocketFileName), 0)
+	assert.ErrorIs(t, err, errors.ErrSocketPermissions)
+}
+
+func TestSocketPath(t *testing.T) {
+	t.Setenv(EnvSocket, "")
+	assert.Equal(t, filepath.Join("root", SocketFileName), SocketPath("root"))
+	t.Setenv(EnvSocket, "/run/agent.sock")
+	assert.Equal(t, "/run/agent.sock", SocketPath("root"))
+}
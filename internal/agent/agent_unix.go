@@ -0,0 +1,13 @@
+//go:build !windows
+
+package agent
+
+import (
+	"syscall"
+)
+
+// detached starts the agent in its own session, so it survives the terminal
+// that started it.
+func detached() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setsid: true}
+}
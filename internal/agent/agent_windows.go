@@ -0,0 +1,14 @@
+//go:build windows
+
+package agent
+
+import (
+	"syscall"
+)
+
+// detached starts the agent without a console window, so it survives the
+// terminal that started it.
+func detached() *syscall.SysProcAttr {
+	const createNoWindow = 0x08000000
+	return &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP | createNoWindow}
+}
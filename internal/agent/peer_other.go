@@ -0,0 +1,11 @@
+//go:build !linux
+
+package agent
+
+import "net"
+
+// checkPeer accepts every client; outside Linux the private socket directory
+// is what keeps other users out.
+func checkPeer(conn net.Conn) error {
+	return nil
+}
@@ -0,0 +1,464 @@
+// Package api serves the vault over a local HTTP/JSON API for automation:
+// account CRUD and search, password generation and the audit log. Every
+// request needs an API token, which may be read-only or limited to one
+// group, and every password read is recorded in the audit log. The API is
+// described by openapi.yaml, served at /v1/openapi.yaml.
+package api
+
+import (
+	_ "embed"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/simp-lee/passwordmanager/internal/crypto"
+	"github.com/simp-lee/passwordmanager/internal/errors"
+	"github.com/simp-lee/passwordmanager/internal/generator"
+	"github.com/simp-lee/passwordmanager/internal/model"
+	"github.com/simp-lee/passwordmanager/internal/socket"
+	"github.com/simp-lee/passwordmanager/internal/storage"
+)
+
+// OpenAPI is the OpenAPI 3 description of the API, in YAML.
+//
+//go:embed openapi.yaml
+var OpenAPI []byte
+
+// maxBodySize limits request bodies; accounts are small.
+const maxBodySize = 1 << 20
+
+// errEmptyBody is returned by decodeJSON for requests without a body.
+var errEmptyBody = errors.Wrap(errors.ErrInvalidRequest, "request body is empty")
+
+// Account is an account as the API returns it. Passwords are only returned
+// by the password endpoint.
+type Account struct {
+	ID            string        `json:"id"`
+	Platform      string        `json:"platform"`
+	Username      string        `json:"username,omitempty"`
+	Email         string        `json:"email,omitempty"`
+	URL           string        `json:"url,omitempty"`
+	Notes         string        `json:"notes,omitempty"`
+	Group         string        `json:"group,omitempty"`
+	Tags          []string      `json:"tags,omitempty"`
+	PasswordRules string        `json:"password_rules,omitempty"`
+	Derived       bool          `json:"derived,omitempty"` // The password is derived from the master password and cannot be read
+	SSHKey        *model.SSHKey `json:"ssh_key,omitempty"`
+	CreatedAt     time.Time     `json:"created_at"`
+	UpdatedAt     time.Time     `json:"updated_at"`
+}
+
+// AccountInput is the body of create and update requests. An update
+// replaces all fields; the password is kept if omitted.
+type AccountInput struct {
+	Platform      string   `json:"platform"`
+	Username      string   `json:"username"`
+	Email         string   `json:"email"`
+	URL           string   `json:"url"`
+	Notes         string   `json:"notes"`
+	Group         string   `json:"group"`
+	Tags          []string `json:"tags"`
+	PasswordRules string   `json:"password_rules"`
+	Password      *string  `json:"password"`
+}
+
+// GenerateInput is the body of generate requests. Omitted options take the
+// defaults from the settings.
+type GenerateInput struct {
+	Length           *int   `json:"length"`
+	Lowercase        *bool  `json:"lowercase"`
+	Uppercase        *bool  `json:"uppercase"`
+	Digits           *bool  `json:"digits"`
+	Symbols          *bool  `json:"symbols"`
+	ExcludeSimilar   *bool  `json:"exclude_similar"`
+	ExcludeAmbiguous *bool  `json:"exclude_ambiguous"`
+	Mode             string `json:"mode"`
+	Template         string `json:"template"`
+	Rules            string `json:"rules"` // Site password rules in passwordrules syntax
+}
+
+// Server is the HTTP handler of the API. It wraps an unlocked storage the
+// way the GUI backend does.
+type Server struct {
+	store    *storage.Storage
+	tokens   *Tokens
+	audit    *AuditLog
+	defaults generator.Options
+	mux      *http.ServeMux
+}
+
+// NewServer returns the API for store, which must be unlocked. defaults are
+// the password generation options used for omitted generate options.
+func NewServer(store *storage.Storage, tokens *Tokens, audit *AuditLog, defaults generator.Options) *Server {
+	s := &Server{store: store, tokens: tokens, audit: audit, defaults: defaults, mux: http.NewServeMux()}
+	s.mux.HandleFunc("GET /v1/openapi.yaml", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/yaml")
+		w.Write(OpenAPI)
+	})
+	s.handle("GET /v1/accounts", false, s.listAccounts)
+	s.handle("POST /v1/accounts", true, s.createAccount)
+	s.handle("GET /v1/accounts/{id}", false, s.getAccount)
+	s.handle("PUT /v1/accounts/{id}", true, s.updateAccount)
+	s.handle("DELETE /v1/accounts/{id}", true, s.deleteAccount)
+	s.handle("GET /v1/accounts/{id}/password", false, s.readPassword)
+	s.handle("POST /v1/generate", false, s.generate)
+	s.handle("GET /v1/audit", false, s.auditLog)
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// handlerFunc serves an authenticated request.
+type handlerFunc func(w http.ResponseWriter, r *http.Request, token *Token) error
+
+// handle registers h for pattern behind token authentication. Requests that
+// write need a token that is not read-only.
+func (s *Server) handle(pattern string, writes bool, h handlerFunc) {
+	s.mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		secret, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			writeError(w, errors.ErrUnauthorized)
+			return
+		}
+		token, err := s.tokens.Authenticate(strings.TrimSpace(secret))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if writes && token.ReadOnly {
+			writeError(w, errors.ErrForbidden)
+			return
+		}
+		if err := h(w, r, token); err != nil {
+			writeError(w, err)
+		}
+	})
+}
+
+// listAccounts returns the accounts visible to the token, optionally
+// filtered by a search query (q) and a group.
+func (s *Server) listAccounts(w http.ResponseWriter, r *http.Request, token *Token) error {
+	var accounts []*model.Account
+	var err error
+	if query := r.URL.Query().Get("q"); query != "" {
+		accounts, err = s.store.SearchAccounts(query)
+	} else {
+		accounts, err = s.store.GetAccounts()
+	}
+	if err != nil {
+		return err
+	}
+
+	group, filterGroup := r.URL.Query()["group"]
+	views := []Account{}
+	for _, account := range accounts {
+		if !token.allows(account) || filterGroup && !strings.EqualFold(account.Group, group[0]) {
+			continue
+		}
+		views = append(views, accountView(account))
+	}
+	return writeJSON(w, http.StatusOK, views)
+}
+
+func (s *Server) getAccount(w http.ResponseWriter, r *http.Request, token *Token) error {
+	account, err := s.account(r, token)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, accountView(account))
+}
+
+func (s *Server) createAccount(w http.ResponseWriter, r *http.Request, token *Token) error {
+	var input AccountInput
+	if err := decodeJSON(r, &input); err != nil {
+		return err
+	}
+	if input.Password == nil {
+		return errors.Wrap(errors.ErrInvalidRequest, "password is required")
+	}
+	account := &model.Account{}
+	if err := token.apply(&input, account); err != nil {
+		return err
+	}
+
+	// The store places the account after the last one
+	now := time.Now()
+	account.ID = crypto.GenerateID()
+	account.CreatedAt = now
+	account.UpdatedAt = now
+	var err error
+	if account.EncryptedPassword, err = crypto.Encrypt([]byte(*input.Password), s.store.GetEncryptionKey()); err != nil {
+		return errors.Wrap(err, "failed to encrypt password")
+	}
+	if err := s.store.AddAccount(account); err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusCreated, accountView(account))
+}
+
+func (s *Server) updateAccount(w http.ResponseWriter, r *http.Request, token *Token) error {
+	stored, err := s.account(r, token)
+	if err != nil {
+		return err
+	}
+	// The stored account is shared with other requests; only the store
+	// replaces it, once the change is saved
+	account := stored.Clone()
+	var input AccountInput
+	if err := decodeJSON(r, &input); err != nil {
+		return err
+	}
+	if input.Password != nil && account.SSHKey != nil {
+		return errors.Wrap(errors.ErrInvalidRequest, "the private key of an SSH key cannot be replaced by a password")
+	}
+	if err := token.apply(&input, account); err != nil {
+		return err
+	}
+
+	if input.Password != nil {
+		if account.EncryptedPassword, err = crypto.Encrypt([]byte(*input.Password), s.store.GetEncryptionKey()); err != nil {
+			return errors.Wrap(err, "failed to encrypt password")
+		}
+		account.Derived = nil // A stored password replaces any derived profile
+	}
+	account.UpdatedAt = time.Now()
+	if err := s.store.UpdateAccount(account); err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, accountView(account))
+}
+
+func (s *Server) deleteAccount(w http.ResponseWriter, r *http.Request, token *Token) error {
+	account, err := s.account(r, token)
+	if err != nil {
+		return err
+	}
+	if err := s.store.DeleteAccount(account.ID); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// readPassword returns the password of an account. The read is recorded in
+// the audit log first; if that fails, the password is not returned.
+func (s *Server) readPassword(w http.ResponseWriter, r *http.Request, token *Token) error {
+	account, err := s.account(r, token)
+	if err != nil {
+		return err
+	}
+	if account.Derived != nil {
+		return errors.ErrPasswordDerived
+	}
+	password, err := crypto.Decrypt(account.EncryptedPassword, s.store.GetEncryptionKey())
+	if err != nil {
+		return errors.Wrap(err, "failed to decrypt password")
+	}
+	defer crypto.ClearBytes(password)
+
+	if err := s.audit.Record(AuditEntry{
+		Time:      time.Now(),
+		Token:     token.Name,
+		AccountID: account.ID,
+		Platform:  account.Platform,
+		Remote:    r.RemoteAddr,
+	}); err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, map[string]string{"password": string(password)})
+}
+
+func (s *Server) generate(w http.ResponseWriter, r *http.Request, token *Token) error {
+	var input GenerateInput
+	if err := decodeJSON(r, &input); err != nil && err != errEmptyBody {
+		return err
+	}
+
+	opts := s.defaults
+	if input.Length != nil {
+		opts.Length = *input.Length
+	}
+	for _, option := range []struct {
+		value  *bool
+		target *bool
+	}{
+		{input.Lowercase, &opts.UseLowercase},
+		{input.Uppercase, &opts.UseUppercase},
+		{input.Digits, &opts.UseDigits},
+		{input.Symbols, &opts.UseSymbols},
+		{input.ExcludeSimilar, &opts.ExcludeSimilar},
+		{input.ExcludeAmbiguous, &opts.ExcludeAmbiguous},
+	} {
+		if option.value != nil {
+			*option.target = *option.value
+		}
+	}
+	if input.Mode != "" {
+		opts.Mode = input.Mode
+	}
+	opts.Template = input.Template
+
+	result, err := generator.GeneratePasswordWithRules(input.Rules, opts)
+	if err != nil {
+		return errors.Wrap(errors.ErrInvalidRequest, err.Error())
+	}
+	return writeJSON(w, http.StatusOK, map[string]any{
+		"password": result.Password,
+		"mode":     result.Mode,
+		"entropy":  result.Entropy,
+	})
+}
+
+// auditLog returns the audit log. It lists accounts of every group, so
+// tokens limited to a group cannot read it.
+func (s *Server) auditLog(w http.ResponseWriter, r *http.Request, token *Token) error {
+	if token.Group != "" {
+		return errors.ErrForbidden
+	}
+	entries, err := s.audit.Entries()
+	if err != nil {
+		return err
+	}
+	if entries == nil {
+		entries = []AuditEntry{}
+	}
+	return writeJSON(w, http.StatusOK, entries)
+}
+
+// account returns the account named by the request path. Accounts outside
+// the token's group are reported as not found.
+func (s *Server) account(r *http.Request, token *Token) (*model.Account, error) {
+	account, err := s.store.GetAccountByID(r.PathValue("id"))
+	if err != nil {
+		return nil, err
+	}
+	if account == nil || !token.allows(account) {
+		return nil, errors.ErrAccountNotFound
+	}
+	return account, nil
+}
+
+// allows reports whether the token may access account.
+func (t *Token) allows(account *model.Account) bool {
+	return t.Group == "" || strings.EqualFold(account.Group, t.Group)
+}
+
+// apply copies input to account. Accounts created or updated with a token
+// limited to a group stay in that group.
+func (t *Token) apply(input *AccountInput, account *model.Account) error {
+	if strings.TrimSpace(input.Platform) == "" {
+		return errors.Wrap(errors.ErrInvalidRequest, "platform is required")
+	}
+	if strings.TrimSpace(input.PasswordRules) != "" {
+		if _, err := generator.ParsePasswordRules(input.PasswordRules); err != nil {
+			return errors.Wrap(errors.ErrInvalidRequest, err.Error())
+		}
+	}
+	group := strings.TrimSpace(input.Group)
+	if t.Group != "" {
+		if group == "" {
+			group = t.Group
+		}
+		if !strings.EqualFold(group, t.Group) {
+			return errors.ErrForbidden
+		}
+	}
+
+	account.Platform = strings.TrimSpace(input.Platform)
+	account.Username = input.Username
+	account.Email = input.Email
+	account.URL = input.URL
+	account.Notes = input.Notes
+	account.Group = group
+	account.Tags = input.Tags
+	account.PasswordRules = input.PasswordRules
+	return nil
+}
+
+func accountView(account *model.Account) Account {
+	return Account{
+		ID:            account.ID,
+		Platform:      account.Platform,
+		Username:      account.Username,
+		Email:         account.Email,
+		URL:           account.URL,
+		Notes:         account.Notes,
+		Group:         account.Group,
+		Tags:          account.Tags,
+		PasswordRules: account.PasswordRules,
+		Derived:       account.Derived != nil,
+		SSHKey:        account.SSHKey,
+		CreatedAt:     account.CreatedAt,
+		UpdatedAt:     account.UpdatedAt,
+	}
+}
+
+// decodeJSON decodes the request body into v, rejecting unknown fields.
+func decodeJSON(r *http.Request, v any) error {
+	decoder := json.NewDecoder(io.LimitReader(r.Body, maxBodySize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		if errors.Is(err, io.EOF) {
+			return errEmptyBody
+		}
+		return errors.Wrap(errors.ErrInvalidRequest, err.Error())
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	return json.NewEncoder(w).Encode(v)
+}
+
+// writeError answers with the status matching err and {"error": message}.
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, errors.ErrUnauthorized):
+		status = http.StatusUnauthorized
+		w.Header().Set("WWW-Authenticate", "Bearer")
+	case errors.Is(err, errors.ErrForbidden):
+		status = http.StatusForbidden
+	case errors.Is(err, errors.ErrAccountNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, errors.ErrPasswordDerived):
+		status = http.StatusConflict
+	case errors.Is(err, errors.ErrInvalidRequest):
+		status = http.StatusBadRequest
+	}
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// Listen listens on socketPath, a Unix socket accessible by the current
+// user only, if it is set, and on addr otherwise. addr must be a loopback
+// address, so the API is never reachable from the network.
+func Listen(addr, socketPath string) (net.Listener, error) {
+	if socketPath != "" {
+		// Replaces a socket left behind by a server that is gone
+		listener, err := socket.Listen(socketPath)
+		if errors.Is(err, errors.ErrSocketInUse) {
+			return nil, errors.ErrAPIRunning
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create API socket")
+		}
+		return listener, nil
+	}
+
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, errors.Wrap(errors.ErrNotLoopback, err.Error())
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return nil, errors.Wrap(errors.ErrNotLoopback, addr)
+	}
+	return net.Listen("tcp", addr)
+}
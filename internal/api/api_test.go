@@ -0,0 +1,320 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/simp-lee/passwordmanager/internal/errors"
+	"github.com/simp-lee/passwordmanager/internal/generator"
+	"github.com/simp-lee/passwordmanager/internal/model"
+	"github.com/simp-lee/passwordmanager/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testAPI struct {
+	t      *testing.T
+	server *httptest.Server
+	tokens *Tokens
+	audit  *AuditLog
+	store  *storage.Storage
+	dir    string
+}
+
+func newTestAPI(t *testing.T) *testAPI {
+	t.Helper()
+	dir := t.TempDir()
+	store, err := storage.New(dir)
+	require.NoError(t, err)
+	require.NoError(t, store.CreateVault("master-password"))
+	require.NoError(t, store.UnlockVault("master-password"))
+
+	tokens := NewTokens(dir)
+	audit := NewAuditLog(dir)
+	server := httptest.NewServer(NewServer(store, tokens, audit, generator.DefaultOptions()))
+	t.Cleanup(server.Close)
+	return &testAPI{t: t, server: server, tokens: tokens, audit: audit, store: store, dir: dir}
+}
+
+func (a *testAPI) token(name string, readOnly bool, group string) string {
+	a.t.Helper()
+	secret, err := a.tokens.Create(name, readOnly, group)
+	require.NoError(a.t, err)
+	return secret
+}
+
+// do sends a request and decodes the JSON response into out, if given.
+func (a *testAPI) do(method, path, token string, body any, out any) int {
+	a.t.Helper()
+	var reader *bytes.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		require.NoError(a.t, err)
+		reader = bytes.NewReader(data)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+	req, err := http.NewRequest(method, a.server.URL+path, reader)
+	require.NoError(a.t, err)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(a.t, err)
+	defer resp.Body.Close()
+	if out != nil && resp.StatusCode < 300 {
+		require.NoError(a.t, json.NewDecoder(resp.Body).Decode(out))
+	}
+	return resp.StatusCode
+}
+
+func ptr[T any](v T) *T { return &v }
+
+func TestAuthentication(t *testing.T) {
+	api := newTestAPI(t)
+	api.token("ci", false, "")
+
+	assert.Equal(t, http.StatusUnauthorized, api.do("GET", "/v1/accounts", "", nil, nil))
+	assert.Equal(t, http.StatusUnauthorized, api.do("GET", "/v1/accounts", "pmt_wrong", nil, nil))
+
+	resp, err := http.Get(api.server.URL + "/v1/openapi.yaml")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "The API description needs no token")
+}
+
+func TestAccountCRUD(t *testing.T) {
+	api := newTestAPI(t)
+	token := api.token("ci", false, "")
+
+	var created Account
+	status := api.do("POST", "/v1/accounts", token, AccountInput{
+		Platform: "GitHub", Username: "alice", URL: "https://github.com", Password: ptr("s3cret"),
+	}, &created)
+	require.Equal(t, http.StatusCreated, status)
+	assert.NotEmpty(t, created.ID)
+	assert.Equal(t, "alice", created.Username)
+
+	var listed []Account
+	require.Equal(t, http.StatusOK, api.do("GET", "/v1/accounts?q=git", token, nil, &listed))
+	require.Len(t, listed, 1)
+	assert.Equal(t, created.ID, listed[0].ID)
+
+	var password map[string]string
+	require.Equal(t, http.StatusOK, api.do("GET", "/v1/accounts/"+created.ID+"/password", token, nil, &password))
+	assert.Equal(t, "s3cret", password["password"])
+
+	var updated Account
+	require.Equal(t, http.StatusOK, api.do("PUT", "/v1/accounts/"+created.ID, token, AccountInput{
+		Platform: "GitHub", Username: "bob",
+	}, &updated))
+	assert.Equal(t, "bob", updated.Username)
+	require.Equal(t, http.StatusOK, api.do("GET", "/v1/accounts/"+created.ID+"/password", token, nil, &password))
+	assert.Equal(t, "s3cret", password["password"], "An omitted password is kept")
+
+	assert.Equal(t, http.StatusNoContent, api.do("DELETE", "/v1/accounts/"+created.ID, token, nil, nil))
+	assert.Equal(t, http.StatusNotFound, api.do("GET", "/v1/accounts/"+created.ID, token, nil, nil))
+}
+
+// TestConcurrentWrites runs parallel requests that change accounts; run
+// with -race to check that handlers never change shared accounts.
+func TestConcurrentWrites(t *testing.T) {
+	api := newTestAPI(t)
+	token := api.token("ci", false, "")
+	var created Account
+	require.Equal(t, http.StatusCreated, api.do("POST", "/v1/accounts", token, AccountInput{Platform: "GitHub", Password: ptr("s3cret")}, &created))
+
+	send := func(method, path string, input AccountInput) int {
+		data, _ := json.Marshal(input)
+		req, err := http.NewRequest(method, api.server.URL+path, bytes.NewReader(data))
+		if err != nil {
+			return 0
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return 0
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	const writers = 8
+	statuses := make(chan int, 3*writers)
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			statuses <- send("POST", "/v1/accounts", AccountInput{Platform: fmt.Sprintf("Site %d", i), Password: ptr("x")})
+		}()
+		go func() {
+			defer wg.Done()
+			statuses <- send("PUT", "/v1/accounts/"+created.ID, AccountInput{Platform: "GitHub", Username: fmt.Sprintf("user%d", i), Password: ptr("y")})
+		}()
+		go func() {
+			defer wg.Done()
+			req, _ := http.NewRequest("GET", api.server.URL+"/v1/accounts/"+created.ID, nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			if resp, err := http.DefaultClient.Do(req); err == nil {
+				resp.Body.Close()
+				statuses <- resp.StatusCode
+			} else {
+				statuses <- 0
+			}
+		}()
+	}
+	wg.Wait()
+	close(statuses)
+	for status := range statuses {
+		assert.Contains(t, []int{http.StatusOK, http.StatusCreated}, status)
+	}
+
+	accounts, err := api.store.GetAccounts()
+	require.NoError(t, err)
+	require.Len(t, accounts, writers+1)
+	orders := map[int]bool{}
+	for _, account := range accounts {
+		assert.False(t, orders[account.SortOrder], "Sort order %d is used twice", account.SortOrder)
+		orders[account.SortOrder] = true
+	}
+}
+
+func TestFailedUpdateKeepsAccount(t *testing.T) {
+	api := newTestAPI(t)
+	token := api.token("ci", false, "")
+	var created Account
+	require.Equal(t, http.StatusCreated, api.do("POST", "/v1/accounts", token, AccountInput{Platform: "GitHub", Username: "alice", Password: ptr("s3cret")}, &created))
+
+	// Another process saves the vault, so the server's next save conflicts
+	other, err := storage.New(api.dir)
+	require.NoError(t, err)
+	require.NoError(t, other.UnlockVault("master-password"))
+	require.NoError(t, other.AddAccount(&model.Account{ID: "other", Platform: "Mail"}))
+
+	status := api.do("PUT", "/v1/accounts/"+created.ID, token, AccountInput{Platform: "GitHub", Username: "bob", Password: ptr("changed")}, nil)
+	assert.Equal(t, http.StatusInternalServerError, status)
+
+	var account Account
+	require.Equal(t, http.StatusOK, api.do("GET", "/v1/accounts/"+created.ID, token, nil, &account))
+	assert.Equal(t, "alice", account.Username, "A failed update must not change the account")
+	var password map[string]string
+	require.Equal(t, http.StatusOK, api.do("GET", "/v1/accounts/"+created.ID+"/password", token, nil, &password))
+	assert.Equal(t, "s3cret", password["password"])
+}
+
+func TestInvalidRequests(t *testing.T) {
+	api := newTestAPI(t)
+	token := api.token("ci", false, "")
+
+	assert.Equal(t, http.StatusBadRequest, api.do("POST", "/v1/accounts", token, nil, nil), "Empty body")
+	assert.Equal(t, http.StatusBadRequest, api.do("POST", "/v1/accounts", token, AccountInput{Platform: "GitHub"}, nil), "Missing password")
+	assert.Equal(t, http.StatusBadRequest, api.do("POST", "/v1/accounts", token, map[string]string{"platform": "x", "colour": "red"}, nil), "Unknown field")
+	assert.Equal(t, http.StatusBadRequest, api.do("POST", "/v1/accounts", token, AccountInput{
+		Platform: "GitHub", Password: ptr("x"), PasswordRules: "minlength: nope;",
+	}, nil), "Invalid rules")
+}
+
+func TestReadOnlyToken(t *testing.T) {
+	api := newTestAPI(t)
+	admin := api.token("admin", false, "")
+	reader := api.token("reader", true, "")
+
+	var created Account
+	require.Equal(t, http.StatusCreated, api.do("POST", "/v1/accounts", admin, AccountInput{Platform: "GitHub", Password: ptr("s3cret")}, &created))
+
+	assert.Equal(t, http.StatusForbidden, api.do("POST", "/v1/accounts", reader, AccountInput{Platform: "GitLab", Password: ptr("x")}, nil))
+	assert.Equal(t, http.StatusForbidden, api.do("PUT", "/v1/accounts/"+created.ID, reader, AccountInput{Platform: "GitHub"}, nil))
+	assert.Equal(t, http.StatusForbidden, api.do("DELETE", "/v1/accounts/"+created.ID, reader, nil, nil))
+	assert.Equal(t, http.StatusOK, api.do("GET", "/v1/accounts/"+created.ID+"/password", reader, nil, nil))
+}
+
+func TestGroupToken(t *testing.T) {
+	api := newTestAPI(t)
+	admin := api.token("admin", false, "")
+	deploy := api.token("deploy", false, "Servers")
+
+	var personal, server Account
+	require.Equal(t, http.StatusCreated, api.do("POST", "/v1/accounts", admin, AccountInput{Platform: "Bank", Group: "Personal", Password: ptr("x")}, &personal))
+	require.Equal(t, http.StatusCreated, api.do("POST", "/v1/accounts", deploy, AccountInput{Platform: "db01", Password: ptr("y")}, &server))
+	assert.Equal(t, "Servers", server.Group, "Accounts created with a group token join its group")
+
+	var listed []Account
+	require.Equal(t, http.StatusOK, api.do("GET", "/v1/accounts", deploy, nil, &listed))
+	require.Len(t, listed, 1)
+	assert.Equal(t, "db01", listed[0].Platform)
+
+	assert.Equal(t, http.StatusNotFound, api.do("GET", "/v1/accounts/"+personal.ID, deploy, nil, nil))
+	assert.Equal(t, http.StatusNotFound, api.do("GET", "/v1/accounts/"+personal.ID+"/password", deploy, nil, nil))
+	assert.Equal(t, http.StatusNotFound, api.do("DELETE", "/v1/accounts/"+personal.ID, deploy, nil, nil))
+	assert.Equal(t, http.StatusForbidden, api.do("POST", "/v1/accounts", deploy, AccountInput{Platform: "x", Group: "Personal", Password: ptr("x")}, nil))
+	assert.Equal(t, http.StatusForbidden, api.do("PUT", "/v1/accounts/"+server.ID, deploy, AccountInput{Platform: "db01", Group: "Personal"}, nil),
+		"Accounts cannot be moved out of the token's group")
+	assert.Equal(t, http.StatusForbidden, api.do("GET", "/v1/audit", deploy, nil, nil))
+}
+
+func TestPasswordReadsAreAudited(t *testing.T) {
+	api := newTestAPI(t)
+	token := api.token("ci", false, "")
+
+	var created Account
+	require.Equal(t, http.StatusCreated, api.do("POST", "/v1/accounts", token, AccountInput{Platform: "GitHub", Password: ptr("s3cret")}, &created))
+	var entries []AuditEntry
+	require.Equal(t, http.StatusOK, api.do("GET", "/v1/audit", token, nil, &entries))
+	assert.Empty(t, entries, "Only secret reads are audited")
+
+	require.Equal(t, http.StatusOK, api.do("GET", "/v1/accounts/"+created.ID+"/password", token, nil, nil))
+	require.Equal(t, http.StatusOK, api.do("GET", "/v1/audit", token, nil, &entries))
+	require.Len(t, entries, 1)
+	assert.Equal(t, "ci", entries[0].Token)
+	assert.Equal(t, created.ID, entries[0].AccountID)
+	assert.Equal(t, "GitHub", entries[0].Platform)
+	assert.NotEmpty(t, entries[0].Remote)
+}
+
+func TestGenerate(t *testing.T) {
+	api := newTestAPI(t)
+	token := api.token("reader", true, "")
+
+	var result map[string]any
+	require.Equal(t, http.StatusOK, api.do("POST", "/v1/generate", token, nil, &result), "Read-only tokens may generate")
+	assert.Len(t, result["password"], generator.DefaultOptions().Length)
+
+	require.Equal(t, http.StatusOK, api.do("POST", "/v1/generate", token, GenerateInput{Length: ptr(24), Symbols: ptr(false)}, &result))
+	password := result["password"].(string)
+	assert.Len(t, password, 24)
+	assert.False(t, strings.ContainsAny(password, "!@#$%^&*"))
+
+	assert.Equal(t, http.StatusBadRequest, api.do("POST", "/v1/generate", token, GenerateInput{Length: ptr(0)}, nil))
+}
+
+func TestListen(t *testing.T) {
+	_, err := Listen("0.0.0.0:0", "")
+	assert.ErrorIs(t, err, errors.ErrNotLoopback)
+	_, err = Listen("192.168.1.10:8377", "")
+	assert.ErrorIs(t, err, errors.ErrNotLoopback)
+
+	listener, err := Listen("127.0.0.1:0", "")
+	require.NoError(t, err)
+	listener.Close()
+
+	dir := filepath.Join(t.TempDir(), "run")
+	listener, err = Listen("", filepath.Join(dir, "api.sock"))
+	require.NoError(t, err)
+	_, err = Listen("", filepath.Join(dir, "api.sock"))
+	assert.ErrorIs(t, err, errors.ErrAPIRunning)
+	listener.Close()
+
+	notes := filepath.Join(dir, "notes.txt")
+	require.NoError(t, os.WriteFile(notes, []byte("notes"), 0600))
+	_, err = Listen("", notes)
+	assert.ErrorIs(t, err, errors.ErrNotSocket)
+	assert.FileExists(t, notes, "A mistyped socket path is never removed")
+}
@@ -0,0 +1,83 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/simp-lee/passwordmanager/internal/errors"
+)
+
+// AuditFileName is the audit log of a vault, in its data directory.
+const AuditFileName = "api_audit.log"
+
+// AuditEntry records one secret read through the API.
+type AuditEntry struct {
+	Time      time.Time `json:"time"`
+	Token     string    `json:"token"` // Name of the token used
+	AccountID string    `json:"account_id"`
+	Platform  string    `json:"platform"`
+	Remote    string    `json:"remote,omitempty"` // Address of the client, if it has one
+}
+
+// AuditLog appends entries to a file, one JSON object per line.
+type AuditLog struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewAuditLog returns the audit log kept in dir.
+func NewAuditLog(dir string) *AuditLog {
+	return &AuditLog{path: filepath.Join(dir, AuditFileName)}
+}
+
+// Record appends entry to the log.
+func (l *AuditLog) Record(entry AuditEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode audit entry")
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	file, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return errors.Wrap(err, "failed to open audit log")
+	}
+	defer file.Close()
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		return errors.Wrap(err, "failed to write audit log")
+	}
+	return nil
+}
+
+// Entries returns the entries of the log, oldest first.
+func (l *AuditLog) Entries() ([]AuditEntry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	file, err := os.Open(l.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open audit log")
+	}
+	defer file.Close()
+
+	var entries []AuditEntry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, errors.Wrap(errors.ErrDataCorrupted, AuditFileName+": "+err.Error())
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to read audit log")
+	}
+	return entries, nil
+}
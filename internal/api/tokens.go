@@ -0,0 +1,155 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/simp-lee/passwordmanager/internal/errors"
+)
+
+// TokensFileName holds the API tokens of a vault, in its data directory.
+const TokensFileName = "api_tokens.json"
+
+// tokenPrefix marks API tokens, so they are recognizable in scripts and logs.
+const tokenPrefix = "pmt_"
+
+// Token is an API token. Only a hash of its secret is stored.
+type Token struct {
+	Name      string    `json:"name"`
+	Hash      string    `json:"hash"` // Hex SHA-256 of the secret
+	ReadOnly  bool      `json:"read_only,omitempty"`
+	Group     string    `json:"group,omitempty"` // Limits the token to the accounts of this group
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Tokens is the set of API tokens kept in a data directory. The file is read
+// again on every authentication, so tokens revoked while the server runs
+// stop working at once.
+type Tokens struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewTokens returns the tokens kept in dir.
+func NewTokens(dir string) *Tokens {
+	return &Tokens{path: filepath.Join(dir, TokensFileName)}
+}
+
+// List returns the tokens in the order they were created.
+func (t *Tokens) List() ([]Token, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.load()
+}
+
+// Create adds a token and returns its secret, which is not stored and cannot
+// be shown again.
+func (t *Tokens) Create(name string, readOnly bool, group string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", errors.Wrap(errors.ErrInvalidRequest, "token name cannot be empty")
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tokens, err := t.load()
+	if err != nil {
+		return "", err
+	}
+	if slices.ContainsFunc(tokens, func(token Token) bool { return token.Name == name }) {
+		return "", errors.ErrTokenExists
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", errors.Wrap(err, "failed to generate token")
+	}
+	secret := tokenPrefix + base64.RawURLEncoding.EncodeToString(buf)
+	tokens = append(tokens, Token{
+		Name:      name,
+		Hash:      hashToken(secret),
+		ReadOnly:  readOnly,
+		Group:     strings.TrimSpace(group),
+		CreatedAt: time.Now(),
+	})
+	if err := t.save(tokens); err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+// Revoke deletes the token called name.
+func (t *Tokens) Revoke(name string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tokens, err := t.load()
+	if err != nil {
+		return err
+	}
+	i := slices.IndexFunc(tokens, func(token Token) bool { return token.Name == name })
+	if i < 0 {
+		return errors.Wrap(errors.ErrTokenNotFound, name)
+	}
+	return t.save(slices.Delete(tokens, i, i+1))
+}
+
+// Authenticate returns the token whose secret is secret.
+func (t *Tokens) Authenticate(secret string) (*Token, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tokens, err := t.load()
+	if err != nil {
+		return nil, err
+	}
+	hash := []byte(hashToken(secret))
+	for _, token := range tokens {
+		if subtle.ConstantTimeCompare([]byte(token.Hash), hash) == 1 {
+			return &token, nil
+		}
+	}
+	return nil, errors.ErrUnauthorized
+}
+
+func (t *Tokens) load() ([]Token, error) {
+	data, err := os.ReadFile(t.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read API tokens")
+	}
+	var tokens []Token
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		return nil, errors.Wrap(errors.ErrDataCorrupted, TokensFileName+": "+err.Error())
+	}
+	return tokens, nil
+}
+
+func (t *Tokens) save(tokens []Token) error {
+	if tokens == nil {
+		tokens = []Token{}
+	}
+	data, err := json.MarshalIndent(tokens, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "failed to encode API tokens")
+	}
+	if err := os.WriteFile(t.path, append(data, '\n'), 0600); err != nil {
+		return errors.Wrap(err, "failed to write API tokens")
+	}
+	return nil
+}
+
+func hashToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
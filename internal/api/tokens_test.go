@@ -0,0 +1,62 @@
+package api
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/simp-lee/passwordmanager/internal/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokens(t *testing.T) {
+	dir := t.TempDir()
+	tokens := NewTokens(dir)
+
+	secret, err := tokens.Create("ci", true, "Servers")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(secret, tokenPrefix))
+	_, err = tokens.Create("ci", false, "")
+	assert.ErrorIs(t, err, errors.ErrTokenExists)
+	_, err = tokens.Create(" ", false, "")
+	assert.ErrorIs(t, err, errors.ErrInvalidRequest)
+
+	data, err := os.ReadFile(filepath.Join(dir, TokensFileName))
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), secret, "Only a hash of the secret is stored")
+	info, err := os.Stat(filepath.Join(dir, TokensFileName))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	token, err := NewTokens(dir).Authenticate(secret)
+	require.NoError(t, err)
+	assert.Equal(t, "ci", token.Name)
+	assert.True(t, token.ReadOnly)
+	assert.Equal(t, "Servers", token.Group)
+	_, err = tokens.Authenticate(secret + "x")
+	assert.ErrorIs(t, err, errors.ErrUnauthorized)
+
+	require.NoError(t, tokens.Revoke("ci"))
+	_, err = tokens.Authenticate(secret)
+	assert.ErrorIs(t, err, errors.ErrUnauthorized, "Revoked tokens stop working at once")
+	assert.ErrorIs(t, tokens.Revoke("ci"), errors.ErrTokenNotFound)
+	list, err := tokens.List()
+	require.NoError(t, err)
+	assert.Empty(t, list)
+}
+
+func TestAuditLog(t *testing.T) {
+	log := NewAuditLog(t.TempDir())
+	entries, err := log.Entries()
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+
+	require.NoError(t, log.Record(AuditEntry{Token: "ci", AccountID: "1", Platform: "GitHub"}))
+	require.NoError(t, log.Record(AuditEntry{Token: "ci", AccountID: "2", Platform: "GitLab"}))
+	entries, err = log.Entries()
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "GitLab", entries[1].Platform)
+}
@@ -0,0 +1,266 @@
+// Package config holds the user settings shared by the CLI and the GUI. They
+// are kept as JSON in config.json in the root data directory and validated
+// against a schema, so both front ends read the same, valid values.
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/simp-lee/passwordmanager/internal/errors"
+	"github.com/simp-lee/passwordmanager/internal/generator"
+)
+
+// FileName is the settings file in the root data directory.
+const FileName = "config.json"
+
+// Config is the complete set of settings.
+type Config struct {
+	Language  string          `json:"language"` // CLI message language
+	Clipboard ClipboardConfig `json:"clipboard"`
+	Generator GeneratorConfig `json:"generator"`
+	Security  SecurityConfig  `json:"security"`
+}
+
+// ClipboardConfig sets how long copied passwords stay on the clipboard.
+type ClipboardConfig struct {
+	ClearSeconds          int `json:"clear_seconds"`           // Passwords copied from accounts
+	GeneratedClearSeconds int `json:"generated_clear_seconds"` // Newly generated passwords
+}
+
+// GeneratorConfig sets the default password generation options.
+type GeneratorConfig struct {
+	Length           int    `json:"length"`
+	Mode             string `json:"mode"`
+	Lowercase        bool   `json:"lowercase"`
+	Uppercase        bool   `json:"uppercase"`
+	Digits           bool   `json:"digits"`
+	Symbols          bool   `json:"symbols"`
+	ExcludeSimilar   bool   `json:"exclude_similar"`
+	ExcludeAmbiguous bool   `json:"exclude_ambiguous"`
+}
+
+// SecurityConfig sets password policies.
+type SecurityConfig struct {
+	MasterPasswordMinLength int `json:"master_password_min_length"`
+	AgentTTLMinutes         int `json:"agent_ttl_minutes"` // How long the unlock agent keeps keys
+}
+
+// Field describes one setting in the schema.
+type Field struct {
+	Key         string   `json:"key"`              // Dotted path, e.g. "clipboard.clear_seconds"
+	Type        string   `json:"type"`             // "int", "bool" or "string"
+	Description string   `json:"description"`      // One line, for help output
+	Min         int      `json:"min,omitempty"`    // Smallest allowed int
+	Max         int      `json:"max,omitempty"`    // Largest allowed int
+	Values      []string `json:"values,omitempty"` // Allowed strings
+
+	value func(*Config) any // Pointer to the setting in a Config
+}
+
+// schema lists every setting with its constraints.
+var schema = []Field{
+	{Key: "language", Type: "string", Description: "Language of CLI messages",
+		Values: []string{"en", "zh"}, value: func(c *Config) any { return &c.Language }},
+	{Key: "clipboard.clear_seconds", Type: "int", Description: "Seconds before a copied account password is cleared from the clipboard",
+		Min: 5, Max: 600, value: func(c *Config) any { return &c.Clipboard.ClearSeconds }},
+	{Key: "clipboard.generated_clear_seconds", Type: "int", Description: "Seconds before a copied generated password is cleared from the clipboard",
+		Min: 5, Max: 600, value: func(c *Config) any { return &c.Clipboard.GeneratedClearSeconds }},
+	{Key: "generator.length", Type: "int", Description: "Default length of generated passwords",
+		Min: 4, Max: 256, value: func(c *Config) any { return &c.Generator.Length }},
+	{Key: "generator.mode", Type: "string", Description: "Default generation mode",
+		Values: []string{generator.ModeRandom, generator.ModePronounceable}, value: func(c *Config) any { return &c.Generator.Mode }},
+	{Key: "generator.lowercase", Type: "bool", Description: "Include lowercase letters by default",
+		value: func(c *Config) any { return &c.Generator.Lowercase }},
+	{Key: "generator.uppercase", Type: "bool", Description: "Include uppercase letters by default",
+		value: func(c *Config) any { return &c.Generator.Uppercase }},
+	{Key: "generator.digits", Type: "bool", Description: "Include digits by default",
+		value: func(c *Config) any { return &c.Generator.Digits }},
+	{Key: "generator.symbols", Type: "bool", Description: "Include symbols by default",
+		value: func(c *Config) any { return &c.Generator.Symbols }},
+	{Key: "generator.exclude_similar", Type: "bool", Description: "Exclude similar characters (l, 1, I, O, 0) by default",
+		value: func(c *Config) any { return &c.Generator.ExcludeSimilar }},
+	{Key: "generator.exclude_ambiguous", Type: "bool", Description: "Exclude ambiguous symbols ({}, (), [], <>, /) by default",
+		value: func(c *Config) any { return &c.Generator.ExcludeAmbiguous }},
+	{Key: "security.master_password_min_length", Type: "int", Description: "Minimum length of new master passwords",
+		Min: 8, Max: 128, value: func(c *Config) any { return &c.Security.MasterPasswordMinLength }},
+	{Key: "security.agent_ttl_minutes", Type: "int", Description: "Minutes the unlock agent keeps a vault unlocked",
+		Min: 1, Max: 1440, value: func(c *Config) any { return &c.Security.AgentTTLMinutes }},
+}
+
+// Default returns the built-in settings.
+func Default() *Config {
+	defaults := generator.DefaultOptions()
+	return &Config{
+		Language: "en",
+		Clipboard: ClipboardConfig{
+			ClearSeconds:          30,
+			GeneratedClearSeconds: 60,
+		},
+		Generator: GeneratorConfig{
+			Length:           defaults.Length,
+			Mode:             generator.ModeRandom,
+			Lowercase:        defaults.UseLowercase,
+			Uppercase:        defaults.UseUppercase,
+			Digits:           defaults.UseDigits,
+			Symbols:          defaults.UseSymbols,
+			ExcludeSimilar:   defaults.ExcludeSimilar,
+			ExcludeAmbiguous: defaults.ExcludeAmbiguous,
+		},
+		Security: SecurityConfig{
+			MasterPasswordMinLength: 8,
+			AgentTTLMinutes:         15,
+		},
+	}
+}
+
+// Fields returns the schema of all settings.
+func Fields() []Field {
+	return slices.Clone(schema)
+}
+
+// Load reads the settings in dir. Settings missing from the file keep their
+// defaults; without a file all do. Unknown or invalid settings are errors.
+func Load(dir string) (*Config, error) {
+	c := Default()
+	data, err := os.ReadFile(filepath.Join(dir, FileName))
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read settings")
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(c); err != nil {
+		return nil, errors.Wrap(errors.ErrInvalidSetting, FileName+": "+err.Error())
+	}
+	if err := c.Validate(); err != nil {
+		return nil, errors.Wrap(err, FileName)
+	}
+	return c, nil
+}
+
+// Save validates the settings and writes them to dir.
+func (c *Config) Save(dir string) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return errors.Wrap(err, "error creating data directory")
+	}
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "failed to encode settings")
+	}
+	if err := os.WriteFile(filepath.Join(dir, FileName), append(data, '\n'), 0600); err != nil {
+		return errors.Wrap(err, "failed to write settings")
+	}
+	return nil
+}
+
+// Validate checks every setting against the schema.
+func (c *Config) Validate() error {
+	for _, field := range schema {
+		if err := field.check(field.value(c)); err != nil {
+			return err
+		}
+	}
+	if !c.Generator.Lowercase && !c.Generator.Uppercase && !c.Generator.Digits && !c.Generator.Symbols {
+		return errors.Wrap(errors.ErrInvalidSetting, "generator: at least one character set must be enabled")
+	}
+	return nil
+}
+
+// Get returns the value of the setting key as text.
+func (c *Config) Get(key string) (string, error) {
+	field, err := lookup(key)
+	if err != nil {
+		return "", err
+	}
+	switch v := field.value(c).(type) {
+	case *int:
+		return strconv.Itoa(*v), nil
+	case *bool:
+		return strconv.FormatBool(*v), nil
+	default:
+		return *v.(*string), nil
+	}
+}
+
+// Set parses value and assigns it to the setting key. The settings are left
+// unchanged if the value is invalid.
+func (c *Config) Set(key, value string) error {
+	field, err := lookup(key)
+	if err != nil {
+		return err
+	}
+
+	updated := *c
+	switch v := field.value(&updated).(type) {
+	case *int:
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return errors.Wrap(errors.ErrInvalidSetting, fmt.Sprintf("%s: %q is not a number", key, value))
+		}
+		*v = n
+	case *bool:
+		b, err := strconv.ParseBool(strings.TrimSpace(value))
+		if err != nil {
+			return errors.Wrap(errors.ErrInvalidSetting, fmt.Sprintf("%s: %q is not true or false", key, value))
+		}
+		*v = b
+	default:
+		*v.(*string) = strings.TrimSpace(value)
+	}
+	if err := updated.Validate(); err != nil {
+		return err
+	}
+	*c = updated
+	return nil
+}
+
+// GeneratorOptions returns the default password generation options.
+func (c *Config) GeneratorOptions() generator.Options {
+	opts := generator.DefaultOptions()
+	opts.Length = c.Generator.Length
+	opts.Mode = c.Generator.Mode
+	opts.UseLowercase = c.Generator.Lowercase
+	opts.UseUppercase = c.Generator.Uppercase
+	opts.UseDigits = c.Generator.Digits
+	opts.UseSymbols = c.Generator.Symbols
+	opts.ExcludeSimilar = c.Generator.ExcludeSimilar
+	opts.ExcludeAmbiguous = c.Generator.ExcludeAmbiguous
+	return opts
+}
+
+// check validates value, a pointer to the setting, against the field.
+func (f Field) check(value any) error {
+	switch v := value.(type) {
+	case *int:
+		if *v < f.Min || *v > f.Max {
+			return errors.Wrap(errors.ErrInvalidSetting, fmt.Sprintf("%s: %d is not between %d and %d", f.Key, *v, f.Min, f.Max))
+		}
+	case *string:
+		if len(f.Values) > 0 && !slices.Contains(f.Values, *v) {
+			return errors.Wrap(errors.ErrInvalidSetting, fmt.Sprintf("%s: %q is not one of %s", f.Key, *v, strings.Join(f.Values, ", ")))
+		}
+	}
+	return nil
+}
+
+func lookup(key string) (Field, error) {
+	for _, field := range schema {
+		if field.Key == key {
+			return field, nil
+		}
+	}
+	return Field{}, errors.Wrap(errors.ErrUnknownSetting, key)
+}
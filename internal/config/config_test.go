@@ -0,0 +1,93 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/simp-lee/passwordmanager/internal/errors"
+	"github.com/simp-lee/passwordmanager/internal/generator"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDefaults(t *testing.T) {
+	c, err := Load(t.TempDir())
+	require.NoError(t, err)
+	assert.Equal(t, Default(), c, "Without a file the defaults apply")
+	require.NoError(t, c.Validate())
+
+	opts := c.GeneratorOptions()
+	defaults := generator.DefaultOptions()
+	defaults.Mode = generator.ModeRandom
+	assert.Equal(t, defaults, opts)
+
+	for _, field := range Fields() {
+		_, err := c.Get(field.Key)
+		assert.NoError(t, err, field.Key)
+	}
+}
+
+func TestGetSet(t *testing.T) {
+	c := Default()
+
+	require.NoError(t, c.Set("clipboard.clear_seconds", "45"))
+	require.NoError(t, c.Set("generator.symbols", "false"))
+	require.NoError(t, c.Set("language", " zh "))
+	value, err := c.Get("clipboard.clear_seconds")
+	require.NoError(t, err)
+	assert.Equal(t, "45", value)
+	value, err = c.Get("generator.symbols")
+	require.NoError(t, err)
+	assert.Equal(t, "false", value)
+	assert.Equal(t, "zh", c.Language)
+
+	for key, value := range map[string]string{
+		"clipboard.clear_seconds":             "1",
+		"generator.length":                    "many",
+		"generator.digits":                    "maybe",
+		"generator.mode":                      "template",
+		"language":                            "fr",
+		"security.master_password_min_length": "4",
+	} {
+		assert.ErrorIs(t, c.Set(key, value), errors.ErrInvalidSetting, key)
+	}
+	assert.Equal(t, 45, c.Clipboard.ClearSeconds, "A rejected value changes nothing")
+
+	_, err = c.Get("clipboard.timeout")
+	assert.ErrorIs(t, err, errors.ErrUnknownSetting)
+	assert.ErrorIs(t, c.Set("clipboard.timeout", "5"), errors.ErrUnknownSetting)
+
+	require.NoError(t, c.Set("generator.symbols", "true"))
+	for _, key := range []string{"generator.lowercase", "generator.uppercase", "generator.digits"} {
+		require.NoError(t, c.Set(key, "false"))
+	}
+	assert.ErrorIs(t, c.Set("generator.symbols", "false"), errors.ErrInvalidSetting, "One character set must stay enabled")
+}
+
+func TestLoadSave(t *testing.T) {
+	dir := t.TempDir()
+	c := Default()
+	require.NoError(t, c.Set("generator.length", "24"))
+	require.NoError(t, c.Save(dir))
+
+	info, err := os.Stat(filepath.Join(dir, FileName))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	loaded, err := Load(dir)
+	require.NoError(t, err)
+	assert.Equal(t, c, loaded)
+
+	// A partial file keeps the other defaults
+	require.NoError(t, os.WriteFile(filepath.Join(dir, FileName), []byte(`{"clipboard": {"clear_seconds": 10}}`), 0600))
+	loaded, err = Load(dir)
+	require.NoError(t, err)
+	assert.Equal(t, 10, loaded.Clipboard.ClearSeconds)
+	assert.Equal(t, 60, loaded.Clipboard.GeneratedClearSeconds)
+
+	for _, content := range []string{`{"clipboard": {"timeout": 10}}`, `{"generator": {"length": 1}}`, `{"language": 3}`, `{`} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, FileName), []byte(content), 0600))
+		_, err = Load(dir)
+		assert.ErrorIs(t, err, errors.ErrInvalidSetting, content)
+	}
+}
@@ -6,6 +6,8 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
+	"fmt"
 	"io"
 
 	"github.com/simp-lee/passwordmanager/internal/errors"
@@ -17,6 +19,7 @@ const (
 	KeyLength  = 32     // AES-256 key length (bytes)
 	SaltLength = 16     // PBKDF2 salt length (bytes)
 	Iterations = 100000 // PBKDF2 iterations
+	IDLength   = 8      // Random bytes in an account ID (16 hex characters)
 )
 
 // GenerateSalt creates a random salt for password hashing.
@@ -29,6 +32,16 @@ func GenerateSalt() ([]byte, error) {
 	return salt, nil
 }
 
+// GenerateID creates a random account ID of IDLength bytes in hex.
+func GenerateID() string {
+	buf := make([]byte, IDLength)
+	if _, err := io.ReadFull(rand.Reader, buf); err != nil {
+		// This should not happen in production
+		panic(fmt.Sprintf("Failed to generate random ID: %v", err))
+	}
+	return hex.EncodeToString(buf)
+}
+
 // GenerateKey derives an encryption key from a master password and salt using PBKDF2.
 func GenerateKey(masterPassword string, salt []byte) []byte {
 	return pbkdf2.Key([]byte(masterPassword), salt, Iterations, KeyLength, sha256.New)
@@ -2,6 +2,7 @@ package crypto
 
 import (
 	"bytes"
+	"encoding/hex"
 	"testing"
 )
 
@@ -26,6 +27,19 @@ func TestGenerateSalt(t *testing.T) {
 	}
 }
 
+func TestGenerateID(t *testing.T) {
+	id := GenerateID()
+	if len(id) != 2*IDLength {
+		t.Errorf("Expected ID length %d, got %d", 2*IDLength, len(id))
+	}
+	if _, err := hex.DecodeString(id); err != nil {
+		t.Errorf("Expected a hex ID, got %q", id)
+	}
+	if id == GenerateID() {
+		t.Error("Generated IDs are identical, expected different")
+	}
+}
+
 func TestGenerateKey(t *testing.T) {
 	password := "test-password"
 	salt, err := GenerateSalt()
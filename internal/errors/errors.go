@@ -6,21 +6,102 @@ import (
 )
 
 var (
-	ErrVaultLocked       = errors.New("vault is locked or not initialized")
-	ErrVaultNotExists    = errors.New("vault does not exist")
-	ErrVaultExists       = errors.New("vault already exists")
-	ErrInvalidPassword   = errors.New("invalid master password")
-	ErrAccountNotFound   = errors.New("account not found")
-	ErrDataCorrupted     = errors.New("data is corrupted")
-	ErrEmptyCharset      = errors.New("at least one character set must be selected")
-	ErrInvalidLength     = errors.New("password length must be positive")
-	ErrDirectoryRequired = errors.New("data directory cannot be empty")
+	ErrVaultLocked        = errors.New("vault is locked or not initialized")
+	ErrVaultNotExists     = errors.New("vault does not exist")
+	ErrVaultExists        = errors.New("vault already exists")
+	ErrInvalidPassword    = errors.New("invalid master password")
+	ErrAccountNotFound    = errors.New("account not found")
+	ErrDataCorrupted      = errors.New("data is corrupted")
+	ErrEmptyCharset       = errors.New("at least one character set must be selected")
+	ErrInvalidLength      = errors.New("password length must be positive")
+	ErrInvalidMinimum     = errors.New("character minimums must not be negative")
+	ErrMinimumsTooLong    = errors.New("character minimums exceed password length")
+	ErrMinimumsTooStrict  = errors.New("character minimums are too rarely met at this length")
+	ErrInvalidRules       = errors.New("invalid password rules")
+	ErrInvalidCharset     = errors.New("character set contains invalid characters")
+	ErrInvalidMode        = errors.New("invalid generation mode")
+	ErrInvalidTemplate    = errors.New("invalid password template")
+	ErrRulesUnsatisfiable = errors.New("password rules cannot be satisfied")
+	ErrEntropyTooLow      = errors.New("password entropy below required minimum")
+	ErrInvalidDerivation  = errors.New("invalid password derivation input")
+	ErrPasswordDerived    = errors.New("password is derived from the master password and not stored")
+	ErrInvalidAlias       = errors.New("invalid email alias template")
+	ErrImportFormat       = errors.New("unsupported or malformed import file")
+	ErrInvalidCredentials = errors.New("invalid password or key file")
+	ErrInvalidPassphrase  = errors.New("invalid export passphrase")
+	ErrMergeStrategy      = errors.New("unknown merge strategy")
+	ErrRemotePassword     = errors.New("remote vault uses a different master password")
+	ErrDirectoryRequired  = errors.New("data directory cannot be empty")
+	ErrVersionConflict    = errors.New("vault was changed by another writer")
+	ErrBackendAuth        = errors.New("storage backend rejected the credentials")
+	ErrBackendURL         = errors.New("invalid storage backend URL")
+	ErrBackendConfig      = errors.New("invalid storage backend configuration")
+	ErrBackupNotFound     = errors.New("backup not found")
+	ErrNotGitRepository   = errors.New("data directory is not a git repository")
+	ErrGitNotFound        = errors.New("git command not found")
+	ErrNoParentCommit     = errors.New("commit has no parent to revert to")
+	ErrVaultNotFound      = errors.New("no vault with this name")
+	ErrVaultNameExists    = errors.New("a vault with this name already exists")
+	ErrInvalidVaultName   = errors.New("vault names may only contain letters, digits, '.', '_' and '-'")
+	ErrVaultInUse         = errors.New("cannot remove the default vault")
+	ErrInvalidVaultDir    = errors.New("a vault directory must not contain the root directory or another vault")
+	ErrVaultDirNotEmpty   = errors.New("vault directory is not empty")
+	ErrUnknownSetting     = errors.New("unknown setting")
+	ErrInvalidSetting     = errors.New("invalid setting value")
+	ErrAgentNotRunning    = errors.New("unlock agent is not running")
+	ErrAgentRunning       = errors.New("unlock agent is already running")
+	ErrAgentLocked        = errors.New("unlock agent does not hold this vault")
+	ErrSocketPermissions  = errors.New("socket directory is accessible by other users")
+	ErrSocketInUse        = errors.New("socket is in use by a running process")
+	ErrNotSocket          = errors.New("socket path exists and is not a socket")
+	ErrInvalidReference   = errors.New("invalid secret reference")
+	ErrAmbiguousReference = errors.New("secret reference matches several accounts")
+	ErrFieldNotFound      = errors.New("account has no such field")
+	ErrCanceled           = errors.New("canceled by the user")
+	ErrInvalidSSHKey      = errors.New("invalid SSH private key")
+	ErrSSHKeyNotFound     = errors.New("SSH key not found in the vault")
+	ErrSSHKeysReadOnly    = errors.New("SSH keys are managed in the vault, not through the agent")
+	ErrSSHAgentLocked     = errors.New("SSH agent is locked")
+	ErrSSHAgentRunning    = errors.New("SSH agent is already running")
+	ErrInvalidRequest     = errors.New("invalid request")
+	ErrUnauthorized       = errors.New("missing or invalid API token")
+	ErrForbidden          = errors.New("API token does not allow this request")
+	ErrTokenNotFound      = errors.New("API token not found")
+	ErrTokenExists        = errors.New("an API token with this name already exists")
+	ErrNotLoopback        = errors.New("the API server only listens on loopback addresses")
+	ErrAPIRunning         = errors.New("API server is already running")
+	ErrExtensionDenied    = errors.New("browser extension is not allowed to use this vault")
+	ErrExtensionNotPaired = errors.New("browser extension is not paired")
+	ErrPairingFailed      = errors.New("browser extension failed to prove its pairing")
+	ErrMessageTooLarge    = errors.New("native message is too large")
+	ErrUnsupportedOS      = errors.New("not supported on this operating system")
+	ErrUnknownBrowser     = errors.New("unknown browser")
+	ErrInvalidExtension   = errors.New("invalid browser extension ID")
 )
 
+// ConflictError reports an optimistic write that lost against another
+// writer. It matches ErrVersionConflict.
+type ConflictError struct {
+	Expected string // Version the write was based on ("" if the vault was expected not to exist)
+	Actual   string // Version found in the backend ("" if unknown or missing)
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("%v (expected version %q, found %q)", ErrVersionConflict, e.Expected, e.Actual)
+}
+
+func (e *ConflictError) Is(target error) bool {
+	return target == ErrVersionConflict
+}
+
 func Is(err, target error) bool {
 	return errors.Is(err, target)
 }
 
+func As(err error, target any) bool {
+	return errors.As(err, target)
+}
+
 func Wrap(err error, message string) error {
 	return fmt.Errorf("%s: %w", message, err)
 }
@@ -0,0 +1,111 @@
+package generator
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+
+	"github.com/simp-lee/passwordmanager/internal/errors"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// ModeDerived is reported for passwords produced by DerivePassword.
+const ModeDerived = "derived"
+
+const (
+	deriveIterations = 100000 // PBKDF2 iterations, matching the vault key derivation
+	deriveMinKeyLen  = 32     // Minimum amount of key material, in bytes
+)
+
+// DeriveInput identifies a stateless password. The same input and options
+// always produce the same password, so nothing but the counter and the
+// options needs to be remembered.
+type DeriveInput struct {
+	Secret  string // Master secret; never stored
+	Site    string // Site the password is for, e.g. "github.com"
+	Login   string // Login on that site
+	Counter int    // Incremented to rotate the password (starts at 1)
+}
+
+// DerivePassword computes a LessPass-style password: PBKDF2-SHA256 turns the
+// secret, site, login and counter into key material, which is consumed as one
+// big number to pick each character from the Options charset. Every enabled
+// character class gets at least max(1, Min*) characters, inserted at derived
+// positions. Options.Mode and Options.Template are ignored.
+// The reported entropy is that of the charset; the real strength is also
+// bounded by the strength of the secret.
+func DerivePassword(input DeriveInput, options Options) (Result, error) {
+	if input.Secret == "" {
+		return Result{}, errors.Wrap(errors.ErrInvalidDerivation, "secret is empty")
+	}
+	if strings.TrimSpace(input.Site) == "" {
+		return Result{}, errors.Wrap(errors.ErrInvalidDerivation, "site is empty")
+	}
+	if input.Counter < 1 {
+		return Result{}, errors.Wrap(errors.ErrInvalidDerivation, "counter must be at least 1")
+	}
+	if options.Length <= 0 {
+		return Result{}, errors.ErrInvalidLength
+	}
+
+	classes, charset, err := buildCharset(options)
+	if err != nil {
+		return Result{}, err
+	}
+
+	var required [][]rune
+	for _, class := range classes {
+		if len(class.chars) == 0 {
+			continue
+		}
+		for i := 0; i < max(class.min, 1); i++ {
+			required = append(required, class.chars)
+		}
+	}
+	if len(required) > options.Length {
+		return Result{}, errors.ErrMinimumsTooLong
+	}
+
+	entropy := uniformEntropy(options.Length, len(charset))
+	if err := checkEntropy(entropy, options.MinEntropy); err != nil {
+		return Result{}, err
+	}
+
+	// Request enough key material that the number never runs out
+	bits := entropy
+	for _, set := range required {
+		bits += math.Log2(float64(len(set))) + math.Log2(float64(options.Length))
+	}
+	keyLen := max(deriveMinKeyLen, int(math.Ceil(bits/8))+8)
+
+	salt := fmt.Sprintf("%s%s%x", input.Site, input.Login, input.Counter)
+	key := pbkdf2.Key([]byte(input.Secret), []byte(salt), deriveIterations, keyLen, sha256.New)
+	source := &derivedSource{n: new(big.Int).SetBytes(key)}
+	clear(key)
+
+	password := make([]rune, 0, options.Length)
+	for len(password) < options.Length-len(required) {
+		password = append(password, charset[source.next(len(charset))])
+	}
+	for _, set := range required {
+		char := set[source.next(len(set))]
+		pos := source.next(len(password) + 1)
+		password = append(password[:pos], append([]rune{char}, password[pos:]...)...)
+	}
+
+	return Result{Password: string(password), Mode: ModeDerived, CharsetSize: len(charset), Entropy: entropy}, nil
+}
+
+// derivedSource hands out numbers taken from the derived key material.
+type derivedSource struct {
+	n *big.Int
+}
+
+// next returns the remainder of the key number divided by n and keeps the quotient.
+func (s *derivedSource) next(n int) int {
+	remainder := new(big.Int)
+	s.n.DivMod(s.n, big.NewInt(int64(n)), remainder)
+	return int(remainder.Int64())
+}
@@ -0,0 +1,102 @@
+package generator
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/simp-lee/passwordmanager/internal/errors"
+)
+
+func TestDerivePassword(t *testing.T) {
+	input := DeriveInput{Secret: "password", Site: "example.org", Login: "contact@example.org", Counter: 1}
+
+	result, err := DerivePassword(input, DefaultOptions())
+	if err != nil {
+		t.Fatalf("Unexpected error deriving password: %v", err)
+	}
+	// Fixed vector: changing it breaks every stored derived password
+	if result.Password != ",RYix@zKpJEUN7WX" {
+		t.Errorf("Derived password changed, got %q", result.Password)
+	}
+	if result.Mode != ModeDerived || result.CharsetSize != 89 {
+		t.Errorf("Expected derived mode with 89 characters, got %q with %d", result.Mode, result.CharsetSize)
+	}
+
+	t.Run("Deterministic", func(t *testing.T) {
+		again, err := DerivePassword(input, DefaultOptions())
+		if err != nil {
+			t.Fatalf("Unexpected error deriving password: %v", err)
+		}
+		if again.Password != result.Password {
+			t.Errorf("Expected %q again, got %q", result.Password, again.Password)
+		}
+	})
+
+	t.Run("Every input changes the password", func(t *testing.T) {
+		variants := []DeriveInput{
+			{Secret: "Password", Site: input.Site, Login: input.Login, Counter: 1},
+			{Secret: input.Secret, Site: "example.com", Login: input.Login, Counter: 1},
+			{Secret: input.Secret, Site: input.Site, Login: "other@example.org", Counter: 1},
+			{Secret: input.Secret, Site: input.Site, Login: input.Login, Counter: 2},
+		}
+		for _, variant := range variants {
+			other, err := DerivePassword(variant, DefaultOptions())
+			if err != nil {
+				t.Fatalf("Unexpected error deriving password: %v", err)
+			}
+			if other.Password == result.Password {
+				t.Errorf("Expected a different password for %+v", variant)
+			}
+		}
+	})
+
+	t.Run("Follows charset options", func(t *testing.T) {
+		opts := Options{Length: 40, UseLowercase: true, UseDigits: true, MinDigits: 5, Exclude: "aeiou"}
+		for counter := 1; counter <= 20; counter++ {
+			input := input
+			input.Counter = counter
+			result, err := DerivePassword(input, opts)
+			if err != nil {
+				t.Fatalf("Unexpected error deriving password: %v", err)
+			}
+			password := result.Password
+			if len(password) != 40 {
+				t.Errorf("Expected 40 characters, got %d", len(password))
+			}
+			if strings.Trim(password, lowercase+digits) != "" || strings.ContainsAny(password, "aeiou") {
+				t.Errorf("Password %q contains characters outside the options", password)
+			}
+			count := 0
+			for _, char := range password {
+				if strings.ContainsRune(digits, char) {
+					count++
+				}
+			}
+			if count < 5 || !strings.ContainsAny(password, lowercase) {
+				t.Errorf("Password %q misses a required class", password)
+			}
+		}
+	})
+
+	errorTests := []struct {
+		name    string
+		input   DeriveInput
+		options Options
+		target  error
+	}{
+		{"Empty secret", DeriveInput{Site: "a", Counter: 1}, DefaultOptions(), errors.ErrInvalidDerivation},
+		{"Empty site", DeriveInput{Secret: "s", Counter: 1}, DefaultOptions(), errors.ErrInvalidDerivation},
+		{"Zero counter", DeriveInput{Secret: "s", Site: "a"}, DefaultOptions(), errors.ErrInvalidDerivation},
+		{"Too short for classes", input, Options{Length: 3, UseLowercase: true, UseUppercase: true, UseDigits: true, UseSymbols: true}, errors.ErrMinimumsTooLong},
+		{"No charset", input, Options{Length: 8}, errors.ErrEmptyCharset},
+	}
+
+	for _, test := range errorTests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := DerivePassword(test.input, test.options)
+			if !errors.Is(err, test.target) {
+				t.Errorf("Expected error %v, got %v", test.target, err)
+			}
+		})
+	}
+}
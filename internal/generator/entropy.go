@@ -0,0 +1,66 @@
+package generator
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/simp-lee/passwordmanager/internal/errors"
+)
+
+// Result is a generated password together with an estimate of its strength.
+type Result struct {
+	Password    string  // The generated password
+	Mode        string  // Generation mode that produced the password
+	CharsetSize int     // Number of distinct characters the password can contain
+	Entropy     float64 // Theoretical entropy in bits
+}
+
+// Entropy returns the theoretical entropy in bits of passwords generated with
+// options, without generating one.
+func Entropy(options Options) (float64, error) {
+	_, bits, err := measure(options)
+	return bits, err
+}
+
+// measure returns the charset size and theoretical entropy for options.
+// For the random mode the entropy is log2 of the number of passwords that
+// meet the per-class minimums, all of which are equally likely. The
+// pronounceable and template values are exact as well.
+func measure(options Options) (int, float64, error) {
+	switch options.Mode {
+	case "", ModeRandom:
+		if options.Length <= 0 {
+			return 0, 0, errors.ErrInvalidLength
+		}
+		classes, charset, err := buildCharset(options)
+		if err != nil {
+			return 0, 0, err
+		}
+		odds, err := minimumOdds(classes, charset, options.Length)
+		if err != nil {
+			return 0, 0, err
+		}
+		return len(charset), uniformEntropy(options.Length, len(charset)) + math.Log2(odds), nil
+	case ModePronounceable:
+		return pronounceableStrength(options)
+	case ModeTemplate:
+		return templateStrength(options)
+	case ModeDerived:
+		return 0, 0, errors.Wrap(errors.ErrInvalidMode, "derived passwords are created with DerivePassword")
+	default:
+		return 0, 0, errors.Wrap(errors.ErrInvalidMode, fmt.Sprintf("unknown mode %q", options.Mode))
+	}
+}
+
+// uniformEntropy is the entropy of length characters drawn uniformly from size symbols.
+func uniformEntropy(length, size int) float64 {
+	return float64(length) * math.Log2(float64(size))
+}
+
+// checkEntropy enforces the minimum entropy policy (0 disables it).
+func checkEntropy(bits, minimum float64) error {
+	if minimum > 0 && bits < minimum {
+		return errors.Wrap(errors.ErrEntropyTooLow, fmt.Sprintf("%.1f bits is below the required %.1f bits", bits, minimum))
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package generator
+
+import (
+	"math"
+	"testing"
+
+	"github.com/simp-lee/passwordmanager/internal/errors"
+)
+
+func TestEntropyRandom(t *testing.T) {
+	opts := Options{Length: 10, UseDigits: true}
+	bits, err := Entropy(opts)
+	if err != nil {
+		t.Fatalf("Unexpected error computing entropy: %v", err)
+	}
+	if math.Abs(bits-10*math.Log2(10)) > 1e-9 {
+		t.Errorf("Expected %.4f bits, got %.4f", 10*math.Log2(10), bits)
+	}
+}
+
+func TestEntropyMinimums(t *testing.T) {
+	// 36^2 two-character passwords, of which 26^2 have no digit
+	opts := Options{Length: 2, UseLowercase: true, UseDigits: true, MinDigits: 1}
+	bits, err := Entropy(opts)
+	if err != nil {
+		t.Fatalf("Unexpected error computing entropy: %v", err)
+	}
+	if want := math.Log2(36*36 - 26*26); math.Abs(bits-want) > 1e-9 {
+		t.Errorf("Expected %.4f bits, got %.4f", want, bits)
+	}
+
+	// Overlapping classes: "a" counts as a lowercase letter and a symbol
+	opts = Options{Length: 2, UseLowercase: true, UseSymbols: true, CustomSymbols: "a!", MinLowercase: 1, MinSymbols: 1}
+	bits, err = Entropy(opts)
+	if err != nil {
+		t.Fatalf("Unexpected error computing entropy: %v", err)
+	}
+	// 27^2 passwords; those without a letter are "!!", those without a symbol
+	// use only b-z
+	if want := math.Log2(27*27 - 1 - 25*25); math.Abs(bits-want) > 1e-9 {
+		t.Errorf("Expected %.4f bits, got %.4f", want, bits)
+	}
+}
+
+func TestGeneratePasswordResult(t *testing.T) {
+	tests := []struct {
+		name        string
+		options     Options
+		mode        string
+		charsetSize int
+	}{
+		{"Random", Options{Length: 12, UseLowercase: true, UseDigits: true}, ModeRandom, 36},
+		{"Pronounceable", Options{Length: 12, Mode: ModePronounceable}, ModePronounceable, 26},
+		{"Template", Options{Mode: ModeTemplate, Template: "99-99"}, ModeTemplate, 10},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := GeneratePassword(test.options)
+			if err != nil {
+				t.Fatalf("Unexpected error generating password: %v", err)
+			}
+			if result.Mode != test.mode {
+				t.Errorf("Expected mode %q, got %q", test.mode, result.Mode)
+			}
+			if result.CharsetSize != test.charsetSize {
+				t.Errorf("Expected charset size %d, got %d", test.charsetSize, result.CharsetSize)
+			}
+			bits, err := Entropy(test.options)
+			if err != nil {
+				t.Fatalf("Unexpected error computing entropy: %v", err)
+			}
+			if result.Entropy != bits {
+				t.Errorf("Expected entropy %.2f, got %.2f", bits, result.Entropy)
+			}
+		})
+	}
+
+	t.Run("Rules", func(t *testing.T) {
+		opts := Options{Length: 8}
+		result, err := GeneratePasswordWithRules("allowed: digit", opts)
+		if err != nil {
+			t.Fatalf("Unexpected error generating password: %v", err)
+		}
+		if result.CharsetSize != 10 || math.Abs(result.Entropy-8*math.Log2(10)) > 1e-9 {
+			t.Errorf("Expected 10 characters and %.2f bits, got %d and %.2f", 8*math.Log2(10), result.CharsetSize, result.Entropy)
+		}
+	})
+}
+
+func TestMinEntropy(t *testing.T) {
+	opts := Options{Length: 8, UseDigits: true, MinEntropy: 60} // About 26.6 bits
+	if _, err := GeneratePassword(opts); !errors.Is(err, errors.ErrEntropyTooLow) {
+		t.Errorf("Expected ErrEntropyTooLow, got %v", err)
+	}
+	if _, err := GeneratePasswordWithRules("allowed: digit", opts); !errors.Is(err, errors.ErrEntropyTooLow) {
+		t.Errorf("Expected ErrEntropyTooLow with rules, got %v", err)
+	}
+
+	opts.Length = 20 // About 66.4 bits
+	if _, err := GeneratePassword(opts); err != nil {
+		t.Errorf("Unexpected error above the threshold: %v", err)
+	}
+}
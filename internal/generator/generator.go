@@ -2,8 +2,12 @@ package generator
 
 import (
 	"crypto/rand"
+	"fmt"
 	"math/big"
+	"slices"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/simp-lee/passwordmanager/internal/errors"
 )
@@ -16,17 +20,47 @@ const (
 
 	similarChars   = "lI1O0"
 	ambiguousChars = "{}()[]<>/\\"
+
+	maxDrawAttempts = 100000  // Draws allowed to meet the minimums before giving up
+	minDrawOdds     = 1e-3    // Smallest share of uniform draws that must meet the minimums
+	maxOddsSteps    = 1 << 20 // Limit on states × character groups when counting odds
 )
 
+// alphabets are the optional non-ASCII alphabets selectable by name in Options.Alphabets.
+var alphabets = map[string]string{
+	"latin-extended": "àáâãäåæçèéêëìíîïðñòóôõöøùúûüýþÿÀÁÂÃÄÅÆÇÈÉÊËÌÍÎÏÐÑÒÓÔÕÖØÙÚÛÜÝÞß",
+	"greek":          "αβγδεζηθικλμνξοπρστυφχψωΑΒΓΔΕΖΗΘΙΚΛΜΝΞΟΠΡΣΤΥΦΧΨΩ",
+	"cyrillic":       "абвгдеёжзийклмнопрстуфхцчшщъыьэюяАБВГДЕЁЖЗИЙКЛМНОПРСТУФХЦЧШЩЪЫЬЭЮЯ",
+}
+
 // Options defines password generation parameters
 type Options struct {
-	Length           int  // Length of the generated password
-	UseLowercase     bool // Include lowercase letters
-	UseUppercase     bool // Include uppercase letters
-	UseDigits        bool // Include digits
-	UseSymbols       bool // Include symbols
-	ExcludeSimilar   bool // Exclude similar characters (e.g., 'l', '1', 'I', 'O', '0')
-	ExcludeAmbiguous bool // Exclude potentially ambiguous characters (e.g., '{}', '()', '[]', '<>', '/')
+	Length           int      // Length of the generated password, in characters (runes)
+	UseLowercase     bool     // Include lowercase letters
+	UseUppercase     bool     // Include uppercase letters
+	UseDigits        bool     // Include digits
+	UseSymbols       bool     // Include symbols
+	ExcludeSimilar   bool     // Exclude similar characters (e.g., 'l', '1', 'I', 'O', '0')
+	ExcludeAmbiguous bool     // Exclude potentially ambiguous characters (e.g., '{}', '()', '[]', '<>', '/')
+	MinLowercase     int      // Minimum number of lowercase letters (0 = no requirement)
+	MinUppercase     int      // Minimum number of uppercase letters (0 = no requirement)
+	MinDigits        int      // Minimum number of digits (0 = no requirement)
+	MinSymbols       int      // Minimum number of symbols (0 = no requirement)
+	CustomSymbols    string   // Symbol set to use instead of the default symbols (empty = default)
+	Exclude          string   // Additional characters that must never appear
+	IncludeOnly      string   // If set, the exact alphabet to draw from; Use*, ExtraChars and Alphabets are then ignored
+	ExtraChars       string   // Additional characters (any Unicode) added to the charset
+	Alphabets        []string // Named non-ASCII alphabets to add: "latin-extended", "greek", "cyrillic"
+	Mode             string   // Generation mode: ModeRandom (default), ModePronounceable or ModeTemplate
+	Template         string   // Pattern for ModeTemplate, e.g. "Cvccvc-99-Cvccvc" (see TemplateHelp)
+	MinEntropy       float64  // Refuse to generate below this many bits of entropy (0 = no policy)
+}
+
+// charClass is one selectable character set together with its minimum count.
+type charClass struct {
+	enabled bool
+	chars   []rune
+	min     int
 }
 
 func DefaultOptions() Options {
@@ -41,63 +75,351 @@ func DefaultOptions() Options {
 	}
 }
 
-// GeneratePassword creates a new random password based on specified options
-// It uses crypto/rand for cryptographically secure random generation
-func GeneratePassword(options Options) (string, error) {
+// AlphabetNames returns the names accepted in Options.Alphabets.
+func AlphabetNames() []string {
+	return []string{"latin-extended", "greek", "cyrillic"}
+}
+
+// GeneratePassword creates a new password based on specified options and
+// reports its strength. Options.Mode selects the random (default),
+// pronounceable or template generator. Generation is refused with
+// ErrEntropyTooLow when the options cannot reach Options.MinEntropy.
+func GeneratePassword(options Options) (Result, error) {
+	mode := options.Mode
+	if mode == "" {
+		mode = ModeRandom
+	}
+
+	charsetSize, entropy, err := measure(options)
+	if err != nil {
+		return Result{}, err
+	}
+	if err := checkEntropy(entropy, options.MinEntropy); err != nil {
+		return Result{}, err
+	}
+
+	var password string
+	switch mode {
+	case ModePronounceable:
+		password, err = generatePronounceable(options)
+	case ModeTemplate:
+		password, err = generateFromTemplate(options)
+	default:
+		password, err = generateRandom(options)
+	}
+	if err != nil {
+		return Result{}, err
+	}
+
+	return Result{Password: password, Mode: mode, CharsetSize: charsetSize, Entropy: entropy}, nil
+}
+
+// generateRandom creates a new random password based on specified options
+// It uses crypto/rand for cryptographically secure random generation.
+// Every character is drawn uniformly from the full charset, and passwords
+// that miss a per-class minimum are drawn again, so all passwords meeting
+// the minimums are equally likely.
+// Characters are handled as runes, so multibyte alphabets are supported.
+func generateRandom(options Options) (string, error) {
 	if options.Length <= 0 {
 		return "", errors.ErrInvalidLength
 	}
 
-	var charset string
+	classes, charset, err := buildCharset(options)
+	if err != nil {
+		return "", err
+	}
+	if _, err := minimumOdds(classes, charset, options.Length); err != nil {
+		return "", err
+	}
+
+	password, err := assemble(classes, charset, options.Length)
+	if err != nil {
+		return "", err
+	}
+	return string(password), nil
+}
+
+// minimumOdds returns the share of uniformly drawn passwords that meet the
+// minimums of classes, failing if the minimums cannot be met or are met too
+// rarely to draw such a password at random.
+func minimumOdds(classes []charClass, charset []rune, length int) (float64, error) {
+	required := 0
+	for _, class := range classes {
+		required += class.min
+	}
+	if required > length {
+		return 0, errors.ErrMinimumsTooLong
+	}
+
+	odds := meetOdds(classes, charset, length)
+	if odds < minDrawOdds {
+		return 0, errors.Wrap(errors.ErrMinimumsTooStrict, "lower the minimums")
+	}
+	return odds, nil
+}
+
+// buildCharset validates options and returns the character classes (already
+// filtered by the exclusion options) and the full deduplicated charset.
+func buildCharset(options Options) ([]charClass, []rune, error) {
+	symbolSet := symbols
+	if options.CustomSymbols != "" {
+		symbolSet = options.CustomSymbols
+	}
+	for _, set := range []string{symbolSet, options.Exclude, options.IncludeOnly, options.ExtraChars} {
+		if err := validateCharset(set); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// Resolve the extra characters and named alphabets
+	extra := options.ExtraChars
+	for _, name := range options.Alphabets {
+		alphabet, ok := alphabets[name]
+		if !ok {
+			return nil, nil, errors.Wrap(errors.ErrInvalidCharset, fmt.Sprintf("unknown alphabet %q", name))
+		}
+		extra += alphabet
+	}
+
+	classes := []charClass{
+		{options.UseLowercase, []rune(lowercase), options.MinLowercase},
+		{options.UseUppercase, []rune(uppercase), options.MinUppercase},
+		{options.UseDigits, []rune(digits), options.MinDigits},
+		{options.UseSymbols, []rune(symbolSet), options.MinSymbols},
+	}
+
+	// An include-only alphabet replaces the class toggles: every class is
+	// limited to the alphabet and the alphabet itself is the charset.
+	if options.IncludeOnly != "" {
+		extra = options.IncludeOnly
+		for i := range classes {
+			classes[i].enabled = classes[i].min > 0
+			classes[i].chars = intersectRunes(classes[i].chars, []rune(options.IncludeOnly))
+		}
+	}
 
 	// Build the character set based on selected options
-	if options.UseLowercase {
-		charset += lowercase
+	var charset []rune
+	for i := range classes {
+		class := &classes[i]
+		if class.min < 0 {
+			return nil, nil, errors.ErrInvalidMinimum
+		}
+		if !class.enabled {
+			if class.min > 0 {
+				return nil, nil, errors.Wrap(errors.ErrEmptyCharset, "minimum set for a disabled character set")
+			}
+			class.chars = nil
+			continue
+		}
+
+		// Filter out characters based on exclusion options
+		class.chars = filterRunes(class.chars, options)
+		if class.min > 0 && len(class.chars) == 0 {
+			return nil, nil, errors.Wrap(errors.ErrEmptyCharset, "minimum set for a character set emptied by exclusions")
+		}
+		charset = mergeRunes(charset, class.chars)
+	}
+	charset = mergeRunes(charset, filterRunes([]rune(extra), options))
+
+	if len(charset) == 0 {
+		return nil, nil, errors.ErrEmptyCharset
 	}
-	if options.UseUppercase {
-		charset += uppercase
+	return classes, charset, nil
+}
+
+// assemble draws length characters uniformly from charset, drawing again
+// until they include the minimum of every class in required.
+func assemble(required []charClass, charset []rune, length int) ([]rune, error) {
+	password := make([]rune, length)
+	for attempt := 0; attempt < maxDrawAttempts; attempt++ {
+		for i := range password {
+			c, err := randomChar(charset)
+			if err != nil {
+				return nil, err
+			}
+			password[i] = c
+		}
+		if meetsMinimums(password, required) {
+			return password, nil
+		}
 	}
-	if options.UseDigits {
-		charset += digits
+	return nil, errors.Wrap(errors.ErrMinimumsTooStrict, "no drawn password met them")
+}
+
+// meetsMinimums reports whether password has the minimum of every class.
+func meetsMinimums(password []rune, classes []charClass) bool {
+	for _, class := range classes {
+		count := 0
+		for _, char := range password {
+			if containsRune(class.chars, char) {
+				count++
+			}
+		}
+		if count < class.min {
+			return false
+		}
 	}
-	if options.UseSymbols {
-		charset += symbols
+	return true
+}
+
+// meetOdds returns the probability that length characters drawn uniformly
+// from charset meet the minimum of every class, or 0 if the minimums have
+// too many combinations to count. Classes may overlap.
+func meetOdds(classes []charClass, charset []rune, length int) float64 {
+	// A state holds the count of each class so far, capped at its minimum,
+	// as a mixed-radix number
+	var limited []charClass
+	var strides []int
+	states := 1
+	for _, class := range classes {
+		if class.min > 0 {
+			limited = append(limited, class)
+			strides = append(strides, states)
+			if states *= class.min + 1; states > maxOddsSteps {
+				return 0
+			}
+		}
 	}
 
-	// Filter out characters based on exclusion options
-	if options.ExcludeSimilar || options.ExcludeAmbiguous {
-		var filteredCharset strings.Builder
-		filteredCharset.Grow(len(charset))
+	// Group the charset by the classes a character counts toward
+	var groups []uint64
+	var shares []float64
+	for _, char := range charset {
+		var group uint64
+		for i, class := range limited {
+			if containsRune(class.chars, char) {
+				group |= 1 << i
+			}
+		}
+		index := slices.Index(groups, group)
+		if index < 0 {
+			index = len(groups)
+			groups = append(groups, group)
+			shares = append(shares, 0)
+		}
+		shares[index] += 1 / float64(len(charset))
+	}
+	if states*len(groups) > maxOddsSteps {
+		return 0
+	}
+	next := make([][]int, states)
+	for state := range next {
+		next[state] = make([]int, len(groups))
+		for g, group := range groups {
+			next[state][g] = state
+			for i, class := range limited {
+				if group&(1<<i) != 0 && state/strides[i]%(class.min+1) < class.min {
+					next[state][g] += strides[i]
+				}
+			}
+		}
+	}
 
-		for _, char := range charset {
-			if options.ExcludeSimilar && strings.ContainsRune(similarChars, char) {
+	odds := make([]float64, states)
+	odds[0] = 1
+	for n := 0; n < length; n++ {
+		drawn := make([]float64, states)
+		for state, p := range odds {
+			if p == 0 {
 				continue
 			}
-			if options.ExcludeAmbiguous && strings.ContainsRune(ambiguousChars, char) {
-				continue
+			for g, share := range shares {
+				drawn[next[state][g]] += p * share
 			}
-			filteredCharset.WriteRune(char)
 		}
+		odds = drawn
+	}
+	return odds[states-1] // Every class at its minimum
+}
+
+// validateCharset rejects user-supplied sets that are not valid UTF-8 or that
+// contain whitespace or control characters.
+func validateCharset(set string) error {
+	if !utf8.ValidString(set) {
+		return errors.Wrap(errors.ErrInvalidCharset, "not valid UTF-8")
+	}
+	for _, char := range set {
+		if unicode.IsControl(char) || unicode.IsSpace(char) {
+			return errors.Wrap(errors.ErrInvalidCharset, fmt.Sprintf("unsupported character %q", char))
+		}
+	}
+	return nil
+}
 
-		charset = filteredCharset.String()
+// filterRunes removes the characters excluded by options from charset.
+func filterRunes(charset []rune, options Options) []rune {
+	if !options.ExcludeSimilar && !options.ExcludeAmbiguous && options.Exclude == "" {
+		return charset
 	}
 
-	if len(charset) == 0 {
-		return "", errors.ErrEmptyCharset
+	filtered := make([]rune, 0, len(charset))
+	for _, char := range charset {
+		if options.ExcludeSimilar && strings.ContainsRune(similarChars, char) {
+			continue
+		}
+		if options.ExcludeAmbiguous && strings.ContainsRune(ambiguousChars, char) {
+			continue
+		}
+		if strings.ContainsRune(options.Exclude, char) {
+			continue
+		}
+		filtered = append(filtered, char)
+	}
+	return filtered
+}
+
+// mergeRunes returns the union of two character sets without duplicates,
+// keeping first-seen order. Duplicates would otherwise bias the selection.
+func mergeRunes(a, b []rune) []rune {
+	seen := make(map[rune]bool, len(a)+len(b))
+	merged := make([]rune, 0, len(a)+len(b))
+	for _, set := range [][]rune{a, b} {
+		for _, char := range set {
+			if !seen[char] {
+				seen[char] = true
+				merged = append(merged, char)
+			}
+		}
 	}
+	return merged
+}
 
-	// Generate the password using cryptographically secure random numbers
-	var password strings.Builder
-	password.Grow(options.Length) // Pre-allocate memory for performance
-	max := big.NewInt(int64(len(charset)))
+// intersectRunes returns the characters of a that also appear in b.
+func intersectRunes(a, b []rune) []rune {
+	var result []rune
+	for _, char := range a {
+		if containsRune(b, char) {
+			result = append(result, char)
+		}
+	}
+	return result
+}
 
-	for i := 0; i < options.Length; i++ {
-		n, err := rand.Int(rand.Reader, max)
-		if err != nil {
-			return "", errors.Wrap(err, "error generating random number")
+func containsRune(set []rune, char rune) bool {
+	for _, c := range set {
+		if c == char {
+			return true
 		}
-		password.WriteByte(charset[n.Int64()])
 	}
+	return false
+}
+
+// randomIndex returns a uniformly distributed random integer in [0, n).
+func randomIndex(n int) (int, error) {
+	v, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0, errors.Wrap(err, "error generating random number")
+	}
+	return int(v.Int64()), nil
+}
 
-	return password.String(), nil
+// randomChar picks a uniformly distributed random character from charset.
+func randomChar(charset []rune) (rune, error) {
+	i, err := randomIndex(len(charset))
+	if err != nil {
+		return 0, err
+	}
+	return charset[i], nil
 }
@@ -1,8 +1,12 @@
 package generator
 
 import (
+	"math"
 	"strings"
 	"testing"
+	"unicode/utf8"
+
+	"github.com/simp-lee/passwordmanager/internal/errors"
 )
 
 func TestDefaultOptions(t *testing.T) {
@@ -119,7 +123,7 @@ func TestGeneratePassword(t *testing.T) {
 
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			password, err := GeneratePassword(test.options)
+			result, err := GeneratePassword(test.options)
 
 			if test.expectErr {
 				if err == nil {
@@ -133,6 +137,7 @@ func TestGeneratePassword(t *testing.T) {
 				return
 			}
 
+			password := result.Password
 			if len(password) != test.expectedLen {
 				t.Errorf("Expected password length %d, got %d", test.expectedLen, len(password))
 			}
@@ -197,13 +202,242 @@ func TestPasswordUniqueness(t *testing.T) {
 	iterations := 100 // Number of passwords to generate for uniqueness check
 
 	for i := 0; i < iterations; i++ {
-		password, err := GeneratePassword(opts)
+		result, err := GeneratePassword(opts)
 		if err != nil {
 			t.Fatalf("Failed to generate password: %v", err)
 		}
+		password := result.Password
 		if passwords[password] {
 			t.Errorf("Generated duplicate password: %s", password)
 		}
 		passwords[password] = true
 	}
 }
+
+func TestGeneratePasswordMinimums(t *testing.T) {
+	countIn := func(password, set string) int {
+		n := 0
+		for _, char := range password {
+			if strings.ContainsRune(set, char) {
+				n++
+			}
+		}
+		return n
+	}
+
+	t.Run("Minimums satisfied", func(t *testing.T) {
+		opts := DefaultOptions()
+		opts.Length = 12
+		opts.MinLowercase = 2
+		opts.MinUppercase = 2
+		opts.MinDigits = 3
+		opts.MinSymbols = 3
+
+		for i := 0; i < 50; i++ {
+			result, err := GeneratePassword(opts)
+			if err != nil {
+				t.Fatalf("Unexpected error generating password: %v", err)
+			}
+			password := result.Password
+			if len(password) != opts.Length {
+				t.Errorf("Expected password length %d, got %d", opts.Length, len(password))
+			}
+			if n := countIn(password, lowercase); n < opts.MinLowercase {
+				t.Errorf("Expected at least %d lowercase letters in %q, got %d", opts.MinLowercase, password, n)
+			}
+			if n := countIn(password, uppercase); n < opts.MinUppercase {
+				t.Errorf("Expected at least %d uppercase letters in %q, got %d", opts.MinUppercase, password, n)
+			}
+			if n := countIn(password, digits); n < opts.MinDigits {
+				t.Errorf("Expected at least %d digits in %q, got %d", opts.MinDigits, password, n)
+			}
+			if n := countIn(password, symbols); n < opts.MinSymbols {
+				t.Errorf("Expected at least %d symbols in %q, got %d", opts.MinSymbols, password, n)
+			}
+		}
+	})
+
+	t.Run("Minimums fill whole password", func(t *testing.T) {
+		opts := Options{Length: 4, UseDigits: true, UseSymbols: true, MinDigits: 2, MinSymbols: 2}
+		result, err := GeneratePassword(opts)
+		if err != nil {
+			t.Fatalf("Unexpected error generating password: %v", err)
+		}
+		password := result.Password
+		if countIn(password, digits) != 2 || countIn(password, symbols) != 2 {
+			t.Errorf("Expected exactly 2 digits and 2 symbols, got %q", password)
+		}
+	})
+
+	t.Run("Required characters are not clustered", func(t *testing.T) {
+		// With one required digit among lowercase letters, the digit must be
+		// able to land in any position after shuffling.
+		opts := Options{Length: 4, UseLowercase: true, UseDigits: true, MinDigits: 1}
+		seenLast := false
+		for i := 0; i < 200 && !seenLast; i++ {
+			result, err := GeneratePassword(opts)
+			if err != nil {
+				t.Fatalf("Unexpected error generating password: %v", err)
+			}
+			password := result.Password
+			seenLast = strings.ContainsRune(digits, rune(password[len(password)-1]))
+		}
+		if !seenLast {
+			t.Error("Expected a required digit to appear in the last position at least once")
+		}
+	})
+
+	t.Run("Minimums do not bias the distribution", func(t *testing.T) {
+		// Among all passwords with at least one digit, 4 × (10/36) / (1 - (26/36)^4)
+		// of the 4 positions hold a digit on average, about 38%. Forcing one digit
+		// and drawing the rest freely would give about 46%.
+		opts := Options{Length: 4, UseLowercase: true, UseDigits: true, MinDigits: 1}
+		want := 4 * (10.0 / 36) / (1 - math.Pow(26.0/36, 4)) / 4
+		total := 0
+		const samples = 4000
+		for i := 0; i < samples; i++ {
+			result, err := GeneratePassword(opts)
+			if err != nil {
+				t.Fatalf("Unexpected error generating password: %v", err)
+			}
+			total += countIn(result.Password, digits)
+		}
+		if got := float64(total) / (4 * samples); math.Abs(got-want) > 0.03 {
+			t.Errorf("Expected %.3f of the characters to be digits, got %.3f", want, got)
+		}
+	})
+
+	errorTests := []struct {
+		name    string
+		options Options
+		target  error
+	}{
+		{
+			name:    "Minimums exceed length",
+			options: Options{Length: 4, UseLowercase: true, UseDigits: true, MinLowercase: 3, MinDigits: 2},
+			target:  errors.ErrMinimumsTooLong,
+		},
+		{
+			name:    "Minimums too rarely met",
+			options: Options{Length: 10, UseLowercase: true, UseDigits: true, MinDigits: 10},
+			target:  errors.ErrMinimumsTooStrict,
+		},
+		{
+			name:    "Negative minimum",
+			options: Options{Length: 8, UseLowercase: true, MinLowercase: -1},
+			target:  errors.ErrInvalidMinimum,
+		},
+		{
+			name:    "Minimum for disabled set",
+			options: Options{Length: 8, UseLowercase: true, MinDigits: 1},
+			target:  errors.ErrEmptyCharset,
+		},
+	}
+
+	for _, test := range errorTests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := GeneratePassword(test.options)
+			if !errors.Is(err, test.target) {
+				t.Errorf("Expected error %v, got %v", test.target, err)
+			}
+		})
+	}
+}
+
+func TestGeneratePasswordCustomCharsets(t *testing.T) {
+	onlyFrom := func(t *testing.T, password, allowed string) {
+		t.Helper()
+		for _, char := range password {
+			if !strings.ContainsRune(allowed, char) {
+				t.Errorf("Password %q contains character %q outside %q", password, char, allowed)
+			}
+		}
+	}
+
+	t.Run("Custom symbols", func(t *testing.T) {
+		opts := Options{Length: 20, UseSymbols: true, CustomSymbols: "!@#"}
+		result, err := GeneratePassword(opts)
+		if err != nil {
+			t.Fatalf("Unexpected error generating password: %v", err)
+		}
+		password := result.Password
+		onlyFrom(t, password, "!@#")
+	})
+
+	t.Run("Exclude list", func(t *testing.T) {
+		opts := Options{Length: 50, UseDigits: true, Exclude: "0123"}
+		result, err := GeneratePassword(opts)
+		if err != nil {
+			t.Fatalf("Unexpected error generating password: %v", err)
+		}
+		password := result.Password
+		onlyFrom(t, password, "456789")
+	})
+
+	t.Run("Include-only alphabet", func(t *testing.T) {
+		opts := DefaultOptions()
+		opts.IncludeOnly = "abc123"
+		opts.MinDigits = 2
+		result, err := GeneratePassword(opts)
+		if err != nil {
+			t.Fatalf("Unexpected error generating password: %v", err)
+		}
+		password := result.Password
+		onlyFrom(t, password, "abc123")
+		if n := strings.Count(password, "1") + strings.Count(password, "2") + strings.Count(password, "3"); n < 2 {
+			t.Errorf("Expected at least 2 digits in %q, got %d", password, n)
+		}
+	})
+
+	t.Run("Unicode alphabet is rune-safe", func(t *testing.T) {
+		opts := Options{Length: 24, Alphabets: []string{"greek"}, ExtraChars: "ж€"}
+		result, err := GeneratePassword(opts)
+		if err != nil {
+			t.Fatalf("Unexpected error generating password: %v", err)
+		}
+		password := result.Password
+		if n := utf8.RuneCountInString(password); n != opts.Length {
+			t.Errorf("Expected %d characters, got %d", opts.Length, n)
+		}
+		if !utf8.ValidString(password) {
+			t.Errorf("Password %q is not valid UTF-8", password)
+		}
+		onlyFrom(t, password, alphabets["greek"]+"ж€")
+	})
+
+	errorTests := []struct {
+		name    string
+		options Options
+		target  error
+	}{
+		{
+			name:    "Unknown alphabet",
+			options: Options{Length: 8, UseLowercase: true, Alphabets: []string{"klingon"}},
+			target:  errors.ErrInvalidCharset,
+		},
+		{
+			name:    "Control character in custom set",
+			options: Options{Length: 8, UseLowercase: true, ExtraChars: "a\x00"},
+			target:  errors.ErrInvalidCharset,
+		},
+		{
+			name:    "Invalid UTF-8",
+			options: Options{Length: 8, IncludeOnly: "\xff\xfe"},
+			target:  errors.ErrInvalidCharset,
+		},
+		{
+			name:    "Everything excluded",
+			options: Options{Length: 8, UseDigits: true, Exclude: digits},
+			target:  errors.ErrEmptyCharset,
+		},
+	}
+
+	for _, test := range errorTests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := GeneratePassword(test.options)
+			if !errors.Is(err, test.target) {
+				t.Errorf("Expected error %v, got %v", test.target, err)
+			}
+		})
+	}
+}
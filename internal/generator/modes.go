@@ -0,0 +1,259 @@
+package generator
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/simp-lee/passwordmanager/internal/errors"
+)
+
+// Generation modes selectable through Options.Mode.
+const (
+	ModeRandom        = "random"        // Uniformly random characters from the selected sets (default)
+	ModePronounceable = "pronounceable" // Alternating consonant and vowel clusters
+	ModeTemplate      = "template"      // Expands Options.Template placeholders into character classes
+)
+
+const (
+	consonantLetters = "bcdfghjklmnpqrstvwxyz"
+	vowelLetters     = "aeiou"
+)
+
+// Clusters used by the pronounceable mode. Consonant and vowel clusters never
+// share a letter, so every output splits back into clusters in exactly one way.
+var (
+	consonantClusters = append(strings.Split(consonantLetters, ""),
+		"bl", "br", "ch", "cl", "cr", "dr", "fl", "fr", "gl", "gr", "kn", "ph", "pl", "pr",
+		"sc", "sh", "sk", "sl", "sm", "sn", "sp", "st", "sw", "th", "tr", "tw", "wh", "wr")
+	vowelClusters = append(strings.Split(vowelLetters, ""),
+		"ai", "au", "ea", "ee", "ei", "ie", "io", "oa", "oo", "ou", "ue")
+)
+
+// TemplateHelp describes the placeholders understood by the template mode.
+const TemplateHelp = `c/C = lower/upper consonant, v/V = lower/upper vowel, a/A = lower/upper letter, ` +
+	`9 = digit, # = symbol, * = any selected character, \x = literal x; anything else is copied`
+
+// ModeNames returns the names accepted in Options.Mode.
+func ModeNames() []string {
+	return []string{ModeRandom, ModePronounceable, ModeTemplate}
+}
+
+// generatePronounceable alternates consonant and vowel clusters until exactly
+// options.Length characters are produced. Near the end only clusters that
+// still fit are considered, so nothing is truncated.
+func generatePronounceable(options Options) (string, error) {
+	consonants, vowels, err := pronounceableClusters(options)
+	if err != nil {
+		return "", err
+	}
+
+	clusterSets := [2][]string{consonants, vowels}
+	kind, err := randomIndex(2) // Start with a consonant or a vowel
+	if err != nil {
+		return "", err
+	}
+
+	var password strings.Builder
+	for remaining := options.Length; remaining > 0; kind = 1 - kind {
+		choices := fittingClusters(clusterSets[kind], remaining)
+		i, err := randomIndex(len(choices))
+		if err != nil {
+			return "", err
+		}
+		password.WriteString(choices[i])
+		remaining -= len(choices[i])
+	}
+
+	return password.String(), nil
+}
+
+// pronounceableStrength returns the number of distinct letters used and the
+// exact Shannon entropy of generatePronounceable. Because the split into
+// clusters is unique, the entropy of the password equals the entropy of the
+// sequence of random choices, which is computed recursively over the
+// remaining length.
+func pronounceableStrength(options Options) (int, float64, error) {
+	consonants, vowels, err := pronounceableClusters(options)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	clusterSets := [2][]string{consonants, vowels}
+	memo := make([][2]float64, options.Length+1)
+	for remaining := 1; remaining <= options.Length; remaining++ {
+		for kind := 0; kind < 2; kind++ {
+			choices := fittingClusters(clusterSets[kind], remaining)
+			sum := 0.0
+			for _, choice := range choices {
+				sum += memo[remaining-len(choice)][1-kind]
+			}
+			memo[remaining][kind] = math.Log2(float64(len(choices))) + sum/float64(len(choices))
+		}
+	}
+
+	var letters []rune
+	for _, cluster := range slices.Concat(consonants, vowels) {
+		letters = mergeRunes(letters, []rune(cluster))
+	}
+
+	// One extra bit for the choice of the starting cluster kind
+	return len(letters), 1 + (memo[options.Length][0]+memo[options.Length][1])/2, nil
+}
+
+// pronounceableClusters returns the consonant and vowel clusters left after
+// applying the exclusion options. Single letters must survive so that any
+// length can be produced.
+func pronounceableClusters(options Options) ([]string, []string, error) {
+	if options.Length <= 0 {
+		return nil, nil, errors.ErrInvalidLength
+	}
+	if err := validateCharset(options.Exclude); err != nil {
+		return nil, nil, err
+	}
+
+	keep := func(clusters []string) []string {
+		var kept []string
+		for _, cluster := range clusters {
+			if len(filterRunes([]rune(cluster), options)) == len(cluster) {
+				kept = append(kept, cluster)
+			}
+		}
+		return kept
+	}
+
+	consonants, vowels := keep(consonantClusters), keep(vowelClusters)
+	if len(fittingClusters(consonants, 1)) == 0 || len(fittingClusters(vowels, 1)) == 0 {
+		return nil, nil, errors.Wrap(errors.ErrEmptyCharset, "exclusions remove all consonants or vowels")
+	}
+	return consonants, vowels, nil
+}
+
+// fittingClusters returns the clusters no longer than remaining.
+func fittingClusters(clusters []string, remaining int) []string {
+	var fitting []string
+	for _, cluster := range clusters {
+		if len(cluster) <= remaining {
+			fitting = append(fitting, cluster)
+		}
+	}
+	return fitting
+}
+
+// templateToken is one position of an expanded template: either a literal
+// character or a set to draw from.
+type templateToken struct {
+	literal rune
+	set     []rune
+}
+
+// parseTemplate expands a template such as "Cvccvc-99-Cvccvc" into tokens.
+func parseTemplate(options Options) ([]templateToken, error) {
+	if options.Template == "" {
+		return nil, errors.Wrap(errors.ErrInvalidTemplate, "template is empty")
+	}
+	if err := validateCharset(options.Exclude); err != nil {
+		return nil, err
+	}
+
+	symbolSet := symbols
+	if options.CustomSymbols != "" {
+		if err := validateCharset(options.CustomSymbols); err != nil {
+			return nil, err
+		}
+		symbolSet = options.CustomSymbols
+	}
+
+	// The "*" placeholder draws from the same charset as the random mode
+	var anyChars []rune
+	if strings.ContainsRune(options.Template, '*') {
+		_, charset, err := buildCharset(options)
+		if err != nil {
+			return nil, err
+		}
+		anyChars = charset
+	}
+
+	sets := map[rune]string{
+		'c': consonantLetters,
+		'C': strings.ToUpper(consonantLetters),
+		'v': vowelLetters,
+		'V': strings.ToUpper(vowelLetters),
+		'a': lowercase,
+		'A': uppercase,
+		'9': digits,
+		'#': symbolSet,
+	}
+
+	var tokens []templateToken
+	escaped := false
+	for _, char := range options.Template {
+		switch {
+		case escaped:
+			tokens = append(tokens, templateToken{literal: char})
+			escaped = false
+		case char == '\\':
+			escaped = true
+		case char == '*':
+			tokens = append(tokens, templateToken{set: anyChars})
+		case sets[char] != "":
+			set := filterRunes([]rune(sets[char]), options)
+			if len(set) == 0 {
+				return nil, errors.Wrap(errors.ErrEmptyCharset, fmt.Sprintf("placeholder %q emptied by exclusions", char))
+			}
+			tokens = append(tokens, templateToken{set: set})
+		case unicode.IsControl(char):
+			return nil, errors.Wrap(errors.ErrInvalidTemplate, fmt.Sprintf("unsupported character %q", char))
+		default:
+			tokens = append(tokens, templateToken{literal: char})
+		}
+	}
+	if escaped {
+		return nil, errors.Wrap(errors.ErrInvalidTemplate, "template ends with an unfinished escape")
+	}
+
+	return tokens, nil
+}
+
+// generateFromTemplate fills every placeholder of the template with a random character.
+func generateFromTemplate(options Options) (string, error) {
+	tokens, err := parseTemplate(options)
+	if err != nil {
+		return "", err
+	}
+
+	password := make([]rune, 0, len(tokens))
+	for _, token := range tokens {
+		if token.set == nil {
+			password = append(password, token.literal)
+			continue
+		}
+		c, err := randomChar(token.set)
+		if err != nil {
+			return "", err
+		}
+		password = append(password, c)
+	}
+	return string(password), nil
+}
+
+// templateStrength returns the number of distinct characters the placeholders
+// can produce and the summed entropy of every placeholder; literals add nothing.
+func templateStrength(options Options) (int, float64, error) {
+	tokens, err := parseTemplate(options)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var chars []rune
+	bits := 0.0
+	for _, token := range tokens {
+		if token.set != nil {
+			chars = mergeRunes(chars, token.set)
+			bits += math.Log2(float64(len(token.set)))
+		}
+	}
+	return len(chars), bits, nil
+}
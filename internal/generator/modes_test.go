@@ -0,0 +1,154 @@
+package generator
+
+import (
+	"math"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/simp-lee/passwordmanager/internal/errors"
+)
+
+func TestGeneratePronounceable(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Mode = ModePronounceable
+	opts.Length = 13
+
+	isCluster := func(run string) bool {
+		for _, cluster := range slices.Concat(consonantClusters, vowelClusters) {
+			if cluster == run {
+				return true
+			}
+		}
+		return false
+	}
+
+	for i := 0; i < 50; i++ {
+		result, err := GeneratePassword(opts)
+		if err != nil {
+			t.Fatalf("Unexpected error generating password: %v", err)
+		}
+		password := result.Password
+		if len(password) != opts.Length {
+			t.Errorf("Expected password length %d, got %d", opts.Length, len(password))
+		}
+
+		// Split into maximal consonant and vowel runs; each must be a known cluster
+		start := 0
+		for j := 1; j <= len(password); j++ {
+			if j == len(password) || strings.ContainsRune(vowelLetters, rune(password[j])) != strings.ContainsRune(vowelLetters, rune(password[start])) {
+				if run := password[start:j]; !isCluster(run) {
+					t.Errorf("Password %q contains unknown cluster %q", password, run)
+				}
+				start = j
+			}
+		}
+	}
+
+	t.Run("Exclusions remove clusters", func(t *testing.T) {
+		opts := opts
+		opts.Exclude = "lt"
+		for i := 0; i < 20; i++ {
+			result, err := GeneratePassword(opts)
+			if err != nil {
+				t.Fatalf("Unexpected error generating password: %v", err)
+			}
+			password := result.Password
+			if strings.ContainsAny(password, "lt") {
+				t.Errorf("Password %q contains excluded characters", password)
+			}
+		}
+	})
+
+	t.Run("Entropy", func(t *testing.T) {
+		opts := opts
+		opts.Length = 1
+		bits, err := Entropy(opts)
+		if err != nil {
+			t.Fatalf("Unexpected error computing entropy: %v", err)
+		}
+		expected := 1 + (math.Log2(float64(len(consonantLetters)))+math.Log2(float64(len(vowelLetters))))/2
+		if math.Abs(bits-expected) > 1e-9 {
+			t.Errorf("Expected %.4f bits for a single character, got %.4f", expected, bits)
+		}
+
+		opts.Length = 16
+		bits, err = Entropy(opts)
+		if err != nil {
+			t.Fatalf("Unexpected error computing entropy: %v", err)
+		}
+		if bits <= 0 || bits >= 16*math.Log2(26) {
+			t.Errorf("Expected pronounceable entropy below random lowercase entropy, got %.2f", bits)
+		}
+	})
+}
+
+func TestGenerateFromTemplate(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Mode = ModeTemplate
+	opts.Template = `Cvccvc-99-\C#`
+
+	for i := 0; i < 50; i++ {
+		result, err := GeneratePassword(opts)
+		if err != nil {
+			t.Fatalf("Unexpected error generating password: %v", err)
+		}
+		password := result.Password
+		if len(password) != 12 {
+			t.Fatalf("Expected 12 characters, got %q", password)
+		}
+
+		expect := []string{
+			strings.ToUpper(consonantLetters), vowelLetters, consonantLetters, consonantLetters, vowelLetters, consonantLetters,
+			"-", digits, digits, "-", "C", symbols,
+		}
+		for j, set := range expect {
+			if !strings.ContainsRune(set, rune(password[j])) {
+				t.Errorf("Password %q: character %d %q not in %q", password, j, password[j], set)
+			}
+		}
+	}
+
+	t.Run("Entropy", func(t *testing.T) {
+		bits, err := Entropy(opts)
+		if err != nil {
+			t.Fatalf("Unexpected error computing entropy: %v", err)
+		}
+		expected := 4*math.Log2(21) + 2*math.Log2(5) + 2*math.Log2(10) + math.Log2(float64(len(symbols)))
+		if math.Abs(bits-expected) > 1e-9 {
+			t.Errorf("Expected %.4f bits, got %.4f", expected, bits)
+		}
+	})
+
+	t.Run("Any character placeholder uses selected sets", func(t *testing.T) {
+		opts := Options{Mode: ModeTemplate, Template: "****", UseDigits: true}
+		result, err := GeneratePassword(opts)
+		if err != nil {
+			t.Fatalf("Unexpected error generating password: %v", err)
+		}
+		password := result.Password
+		if strings.Trim(password, digits) != "" {
+			t.Errorf("Expected digits only, got %q", password)
+		}
+	})
+
+	errorTests := []struct {
+		name    string
+		options Options
+		target  error
+	}{
+		{"Empty template", Options{Mode: ModeTemplate}, errors.ErrInvalidTemplate},
+		{"Unfinished escape", Options{Mode: ModeTemplate, Template: `cv\`}, errors.ErrInvalidTemplate},
+		{"Placeholder excluded", Options{Mode: ModeTemplate, Template: "9", Exclude: digits}, errors.ErrEmptyCharset},
+		{"Unknown mode", Options{Mode: "morse", Length: 8}, errors.ErrInvalidMode},
+	}
+
+	for _, test := range errorTests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := GeneratePassword(test.options)
+			if !errors.Is(err, test.target) {
+				t.Errorf("Expected error %v, got %v", test.target, err)
+			}
+		})
+	}
+}
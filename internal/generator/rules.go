@@ -0,0 +1,337 @@
+package generator
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/simp-lee/passwordmanager/internal/errors"
+)
+
+// Character classes of the passwordrules syntax.
+// Space is left out of "special" and "ascii-printable" because many sites trim it.
+const (
+	rulesSpecial        = "-~!@#$%^&*_+=`|(){}[:;\"'<>,.?]/\\"
+	rulesASCIIPrintable = lowercase + uppercase + digits + rulesSpecial
+
+	maxRulesAttempts = 1000 // Retries allowed to satisfy max-consecutive
+)
+
+// PasswordRules is a parsed site password policy in Apple's passwordrules syntax,
+// e.g. "minlength: 8; maxlength: 12; required: lower; required: digit; allowed: [!@#]; max-consecutive: 1;".
+type PasswordRules struct {
+	Required       []string // Each entry needs at least one character from its set
+	Allowed        string   // Additional characters that may be used
+	MaxConsecutive int      // Maximum run of identical characters (0 = unlimited)
+	MinLength      int      // Minimum password length (0 = no minimum)
+	MaxLength      int      // Maximum password length (0 = no maximum)
+}
+
+// ParsePasswordRules parses a rule string in Apple's passwordrules syntax.
+// Supported properties are required, allowed, max-consecutive, minlength and maxlength.
+func ParsePasswordRules(text string) (*PasswordRules, error) {
+	rules := &PasswordRules{}
+	p := &rulesParser{input: text}
+
+	for {
+		p.skipSpace()
+		if p.done() {
+			break
+		}
+
+		name, err := p.readName()
+		if err != nil {
+			return nil, err
+		}
+		value, err := p.readValue()
+		if err != nil {
+			return nil, err
+		}
+
+		switch name {
+		case "required":
+			set, err := parseRulesClasses(value)
+			if err != nil {
+				return nil, err
+			}
+			rules.Required = append(rules.Required, set)
+		case "allowed":
+			set, err := parseRulesClasses(value)
+			if err != nil {
+				return nil, err
+			}
+			rules.Allowed = mergeCharsets(rules.Allowed, set)
+		case "max-consecutive", "minlength", "maxlength":
+			n, err := strconv.Atoi(strings.TrimSpace(value))
+			if err != nil || n <= 0 {
+				return nil, errors.Wrap(errors.ErrInvalidRules, fmt.Sprintf("%s must be a positive integer", name))
+			}
+			switch name {
+			case "max-consecutive":
+				if rules.MaxConsecutive == 0 || n < rules.MaxConsecutive {
+					rules.MaxConsecutive = n
+				}
+			case "minlength":
+				rules.MinLength = max(rules.MinLength, n)
+			case "maxlength":
+				if rules.MaxLength == 0 || n < rules.MaxLength {
+					rules.MaxLength = n
+				}
+			}
+		default:
+			return nil, errors.Wrap(errors.ErrInvalidRules, fmt.Sprintf("unknown property %q", name))
+		}
+	}
+
+	if rules.MaxLength > 0 && rules.MinLength > rules.MaxLength {
+		return nil, errors.Wrap(errors.ErrInvalidRules, "minlength is greater than maxlength")
+	}
+	if rules.MaxLength > 0 && len(rules.Required) > rules.MaxLength {
+		return nil, errors.Wrap(errors.ErrInvalidRules, "more required classes than maxlength allows")
+	}
+
+	return rules, nil
+}
+
+// Charset returns every character the rules permit.
+// Following the passwordrules spec, ascii-printable is used when nothing is listed.
+func (r *PasswordRules) Charset() string {
+	charset := r.Allowed
+	for _, set := range r.Required {
+		charset = mergeCharsets(charset, set)
+	}
+	if charset == "" {
+		charset = rulesASCIIPrintable
+	}
+	return charset
+}
+
+// ClampLength fits length into the rules' minlength/maxlength range.
+func (r *PasswordRules) ClampLength(length int) int {
+	if r.MinLength > 0 && length < r.MinLength {
+		length = r.MinLength
+	}
+	if r.MaxLength > 0 && length > r.MaxLength {
+		length = r.MaxLength
+	}
+	return length
+}
+
+// GeneratePasswordWithRules creates a password that satisfies the given rule string.
+// The character classes come from the rules; options.Length (clamped to the rules'
+// length range) and the exclusion options are still honoured.
+// An empty rule string falls back to GeneratePassword.
+func GeneratePasswordWithRules(text string, options Options) (Result, error) {
+	if strings.TrimSpace(text) == "" {
+		return GeneratePassword(options)
+	}
+
+	rules, err := ParsePasswordRules(text)
+	if err != nil {
+		return Result{}, err
+	}
+	return GenerateFromRules(rules, options)
+}
+
+// GenerateFromRules creates a password that satisfies the parsed rules.
+// The reported entropy counts the passwords that contain every required
+// class, an upper bound once max-consecutive applies.
+func GenerateFromRules(rules *PasswordRules, options Options) (Result, error) {
+	if options.Length <= 0 {
+		return Result{}, errors.ErrInvalidLength
+	}
+	if err := validateCharset(options.Exclude); err != nil {
+		return Result{}, err
+	}
+	length := rules.ClampLength(options.Length)
+
+	// Apply exclusions; a required set must keep at least one character
+	required := make([]charClass, len(rules.Required))
+	for i, set := range rules.Required {
+		required[i] = charClass{enabled: true, chars: filterRunes([]rune(set), options), min: 1}
+		if len(required[i].chars) == 0 {
+			return Result{}, errors.Wrap(errors.ErrEmptyCharset, "required character class emptied by exclusions")
+		}
+	}
+	charset := filterRunes([]rune(rules.Charset()), options)
+	if len(charset) == 0 {
+		return Result{}, errors.ErrEmptyCharset
+	}
+	if len(required) > length {
+		return Result{}, errors.Wrap(errors.ErrRulesUnsatisfiable, "more required classes than characters")
+	}
+	if rules.MaxConsecutive == 1 && len(charset) == 1 && length > 1 {
+		return Result{}, errors.Wrap(errors.ErrRulesUnsatisfiable, "max-consecutive cannot be met with a single character")
+	}
+
+	odds := meetOdds(required, charset, length)
+	if odds < minDrawOdds {
+		return Result{}, errors.Wrap(errors.ErrRulesUnsatisfiable, "required classes are too rarely met at this length")
+	}
+	entropy := uniformEntropy(length, len(charset)) + math.Log2(odds)
+	if err := checkEntropy(entropy, options.MinEntropy); err != nil {
+		return Result{}, err
+	}
+
+	for attempt := 0; attempt < maxRulesAttempts; attempt++ {
+		password, err := assemble(required, charset, length)
+		if err != nil {
+			return Result{}, err
+		}
+		if rules.MaxConsecutive == 0 || maxRun(password) <= rules.MaxConsecutive {
+			return Result{Password: string(password), Mode: ModeRandom, CharsetSize: len(charset), Entropy: entropy}, nil
+		}
+	}
+
+	return Result{}, errors.Wrap(errors.ErrRulesUnsatisfiable, "could not satisfy max-consecutive")
+}
+
+// maxRun returns the length of the longest run of identical consecutive characters.
+func maxRun(password []rune) int {
+	longest, run := 0, 0
+	for i := range password {
+		if i > 0 && password[i] == password[i-1] {
+			run++
+		} else {
+			run = 1
+		}
+		longest = max(longest, run)
+	}
+	return longest
+}
+
+// mergeCharsets returns the union of two character sets, keeping first-seen order.
+func mergeCharsets(a, b string) string {
+	return string(mergeRunes([]rune(a), []rune(b)))
+}
+
+// parseRulesClasses parses a comma-separated list of named and custom ("[...]") classes.
+func parseRulesClasses(value string) (string, error) {
+	var set string
+	p := &rulesParser{input: value}
+
+	for {
+		p.skipSpace()
+		if p.done() {
+			break
+		}
+
+		if p.peek() == '[' {
+			custom, err := p.readCustomClass()
+			if err != nil {
+				return "", err
+			}
+			for _, char := range custom {
+				if char > unicode.MaxASCII || !unicode.IsPrint(char) || char == ' ' {
+					return "", errors.Wrap(errors.ErrInvalidRules, fmt.Sprintf("unsupported character %q in custom class", char))
+				}
+			}
+			set = mergeCharsets(set, custom)
+		} else {
+			name := p.readUntil(",")
+			switch strings.ToLower(strings.TrimSpace(name)) {
+			case "lower":
+				set = mergeCharsets(set, lowercase)
+			case "upper":
+				set = mergeCharsets(set, uppercase)
+			case "digit":
+				set = mergeCharsets(set, digits)
+			case "special":
+				set = mergeCharsets(set, rulesSpecial)
+			case "ascii-printable", "unicode":
+				set = mergeCharsets(set, rulesASCIIPrintable)
+			default:
+				return "", errors.Wrap(errors.ErrInvalidRules, fmt.Sprintf("unknown character class %q", name))
+			}
+		}
+
+		p.skipSpace()
+		if p.done() {
+			break
+		}
+		if p.peek() != ',' {
+			return "", errors.Wrap(errors.ErrInvalidRules, "expected ',' between character classes")
+		}
+		p.pos++
+	}
+
+	if set == "" {
+		return "", errors.Wrap(errors.ErrInvalidRules, "empty character class list")
+	}
+	return set, nil
+}
+
+// rulesParser is a small cursor over a passwordrules string.
+type rulesParser struct {
+	input string
+	pos   int
+}
+
+func (p *rulesParser) done() bool { return p.pos >= len(p.input) }
+
+func (p *rulesParser) peek() byte { return p.input[p.pos] }
+
+func (p *rulesParser) skipSpace() {
+	for !p.done() && (p.peek() == ' ' || p.peek() == '\t' || p.peek() == '\n' || p.peek() == '\r') {
+		p.pos++
+	}
+}
+
+// readName reads a property name and the ':' that follows it.
+func (p *rulesParser) readName() (string, error) {
+	name := strings.ToLower(strings.TrimSpace(p.readUntil(":;")))
+	if p.done() || p.peek() != ':' || name == "" {
+		return "", errors.Wrap(errors.ErrInvalidRules, fmt.Sprintf("expected 'name: value' near %q", name))
+	}
+	p.pos++ // Skip ':'
+	return name, nil
+}
+
+// readValue reads a property value up to the next ';' outside of a custom class.
+func (p *rulesParser) readValue() (string, error) {
+	start := p.pos
+	for !p.done() && p.peek() != ';' {
+		if p.peek() == '[' {
+			if _, err := p.readCustomClass(); err != nil {
+				return "", err
+			}
+			continue
+		}
+		p.pos++
+	}
+	value := p.input[start:p.pos]
+	if !p.done() {
+		p.pos++ // Skip ';'
+	}
+	return value, nil
+}
+
+// readCustomClass reads "[...]" and returns the characters between the brackets.
+// A ']' directly after the opening bracket is taken literally.
+func (p *rulesParser) readCustomClass() (string, error) {
+	p.pos++ // Skip '['
+	start := p.pos
+	if !p.done() && p.peek() == ']' {
+		p.pos++
+	}
+	for !p.done() && p.peek() != ']' {
+		p.pos++
+	}
+	if p.done() {
+		return "", errors.Wrap(errors.ErrInvalidRules, "unterminated custom character class")
+	}
+	custom := p.input[start:p.pos]
+	p.pos++ // Skip ']'
+	return custom, nil
+}
+
+// readUntil reads up to (not including) any of the stop characters.
+func (p *rulesParser) readUntil(stops string) string {
+	start := p.pos
+	for !p.done() && !strings.ContainsRune(stops, rune(p.peek())) {
+		p.pos++
+	}
+	return p.input[start:p.pos]
+}
@@ -0,0 +1,119 @@
+package generator
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/simp-lee/passwordmanager/internal/errors"
+)
+
+func TestParsePasswordRules(t *testing.T) {
+	rules, err := ParsePasswordRules("minlength: 8; maxlength: 12; required: lower; required: upper, digit; allowed: [-!@#]; max-consecutive: 2")
+	if err != nil {
+		t.Fatalf("Unexpected error parsing rules: %v", err)
+	}
+
+	if rules.MinLength != 8 || rules.MaxLength != 12 {
+		t.Errorf("Expected length range 8-12, got %d-%d", rules.MinLength, rules.MaxLength)
+	}
+	if rules.MaxConsecutive != 2 {
+		t.Errorf("Expected max-consecutive 2, got %d", rules.MaxConsecutive)
+	}
+	if len(rules.Required) != 2 {
+		t.Fatalf("Expected 2 required classes, got %d", len(rules.Required))
+	}
+	if rules.Required[0] != lowercase {
+		t.Errorf("Expected first required class to be lowercase, got %q", rules.Required[0])
+	}
+	if rules.Required[1] != uppercase+digits {
+		t.Errorf("Expected second required class to be upper+digit, got %q", rules.Required[1])
+	}
+	if rules.Allowed != "-!@#" {
+		t.Errorf("Expected allowed '-!@#', got %q", rules.Allowed)
+	}
+
+	t.Run("Custom class containing separators", func(t *testing.T) {
+		rules, err := ParsePasswordRules("required: [],;]; allowed: lower")
+		if err != nil {
+			t.Fatalf("Unexpected error parsing rules: %v", err)
+		}
+		if len(rules.Required) != 1 || rules.Required[0] != "],;" {
+			t.Errorf("Expected required class '],;', got %q", rules.Required)
+		}
+	})
+
+	invalid := []string{
+		"required lower",
+		"required: lowercase",
+		"minlength: abc",
+		"maxlength: 0",
+		"colour: blue",
+		"required: [abc",
+		"minlength: 10; maxlength: 8",
+		"maxlength: 1; required: lower; required: digit",
+	}
+	for _, text := range invalid {
+		if _, err := ParsePasswordRules(text); !errors.Is(err, errors.ErrInvalidRules) {
+			t.Errorf("Expected ErrInvalidRules for %q, got %v", text, err)
+		}
+	}
+}
+
+func TestGeneratePasswordWithRules(t *testing.T) {
+	t.Run("Compliant output", func(t *testing.T) {
+		text := "maxlength: 12; required: lower; required: digit; required: [!@#]; max-consecutive: 1"
+		for i := 0; i < 50; i++ {
+			result, err := GeneratePasswordWithRules(text, DefaultOptions())
+			if err != nil {
+				t.Fatalf("Unexpected error generating password: %v", err)
+			}
+			password := result.Password
+			if len(password) != 12 {
+				t.Errorf("Expected password clamped to 12 characters, got %d", len(password))
+			}
+			if !strings.ContainsAny(password, lowercase) || !strings.ContainsAny(password, digits) || !strings.ContainsAny(password, "!@#") {
+				t.Errorf("Password %q does not contain every required class", password)
+			}
+			for _, char := range password {
+				if !strings.ContainsRune(lowercase+digits+"!@#", char) {
+					t.Errorf("Password %q contains disallowed character %q", password, char)
+				}
+			}
+			if maxRun([]rune(password)) > 1 {
+				t.Errorf("Password %q repeats a character", password)
+			}
+		}
+	})
+
+	t.Run("Minimum length raises short request", func(t *testing.T) {
+		opts := DefaultOptions()
+		opts.Length = 4
+		result, err := GeneratePasswordWithRules("minlength: 10; allowed: lower", opts)
+		if err != nil {
+			t.Fatalf("Unexpected error generating password: %v", err)
+		}
+		password := result.Password
+		if len(password) != 10 {
+			t.Errorf("Expected password length 10, got %d", len(password))
+		}
+	})
+
+	t.Run("Empty rules fall back to options", func(t *testing.T) {
+		opts := Options{Length: 8, UseDigits: true}
+		result, err := GeneratePasswordWithRules("  ", opts)
+		if err != nil {
+			t.Fatalf("Unexpected error generating password: %v", err)
+		}
+		password := result.Password
+		if strings.Trim(password, digits) != "" {
+			t.Errorf("Expected digits only, got %q", password)
+		}
+	})
+
+	t.Run("Unsatisfiable rules", func(t *testing.T) {
+		_, err := GeneratePasswordWithRules("allowed: [a]; max-consecutive: 1", DefaultOptions())
+		if !errors.Is(err, errors.ErrRulesUnsatisfiable) {
+			t.Errorf("Expected ErrRulesUnsatisfiable, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,227 @@
+package generator
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/simp-lee/passwordmanager/internal/errors"
+)
+
+// Username modes selectable through UsernameOptions.Mode.
+const (
+	UsernameWords = "words" // Random words joined by a separator, e.g. "amber_otter42"
+	UsernameChars = "chars" // Random lowercase letters and digits, starting with a letter
+)
+
+const maxAliasRandom = 32 // Longest {rand:N} accepted in an alias template
+
+// UsernameOptions defines username generation parameters
+type UsernameOptions struct {
+	Mode      string // UsernameWords (default) or UsernameChars
+	Words     int    // Number of words in the words mode
+	Separator string // Separator between words
+	Digits    int    // Random digits appended in the words mode
+	Length    int    // Length in the chars mode
+}
+
+func DefaultUsernameOptions() UsernameOptions {
+	return UsernameOptions{
+		Mode:      UsernameWords,
+		Words:     2,
+		Separator: "_",
+		Digits:    2,
+		Length:    10,
+	}
+}
+
+// UsernameModeNames returns the names accepted in UsernameOptions.Mode.
+func UsernameModeNames() []string {
+	return []string{UsernameWords, UsernameChars}
+}
+
+// GenerateUsername creates a random, non-identifying username.
+func GenerateUsername(options UsernameOptions) (string, error) {
+	switch options.Mode {
+	case "", UsernameWords:
+		if options.Words <= 0 {
+			return "", errors.ErrInvalidLength
+		}
+		if err := validateCharset(options.Separator); err != nil {
+			return "", err
+		}
+		words := make([]string, options.Words)
+		for i := range words {
+			word, err := randomWord()
+			if err != nil {
+				return "", err
+			}
+			words[i] = word
+		}
+		suffix, err := randomString([]rune(digits), options.Digits)
+		if err != nil {
+			return "", err
+		}
+		return strings.Join(words, options.Separator) + suffix, nil
+	case UsernameChars:
+		if options.Length <= 0 {
+			return "", errors.ErrInvalidLength
+		}
+		// Many sites reject usernames that start with a digit
+		first, err := randomChar([]rune(lowercase))
+		if err != nil {
+			return "", err
+		}
+		rest, err := randomString([]rune(lowercase+digits), options.Length-1)
+		if err != nil {
+			return "", err
+		}
+		return string(first) + rest, nil
+	default:
+		return "", errors.Wrap(errors.ErrInvalidMode, fmt.Sprintf("unknown username mode %q", options.Mode))
+	}
+}
+
+// GenerateEmailAlias expands an email alias template. Placeholders:
+// {tag} is the slugified tag (e.g. the vendor name) or a random word when the
+// tag is empty, {word} is a random word and {rand} or {rand:N} is N random
+// lowercase letters and digits (default 3). For example
+// "team+{tag}-{rand}@example.com" with tag "Vendor X" gives
+// "team+vendor-x-x7k@example.com", and "{tag}.{rand:4}@example.com" suits a
+// catch-all domain.
+func GenerateEmailAlias(template, tag string) (string, error) {
+	if err := ValidateEmailAliasTemplate(template); err != nil {
+		return "", err
+	}
+
+	var alias strings.Builder
+	rest := template
+	for {
+		start := strings.IndexByte(rest, '{')
+		if start < 0 {
+			alias.WriteString(rest)
+			break
+		}
+		end := strings.IndexByte(rest[start:], '}') + start
+		alias.WriteString(rest[:start])
+
+		value, err := expandAliasPlaceholder(rest[start+1:end], tag)
+		if err != nil {
+			return "", err
+		}
+		alias.WriteString(value)
+		rest = rest[end+1:]
+	}
+	return alias.String(), nil
+}
+
+// ValidateEmailAliasTemplate checks that a template has a local part, a single
+// '@' followed by a domain, and only known placeholders.
+func ValidateEmailAliasTemplate(template string) error {
+	local, domain, found := strings.Cut(template, "@")
+	if !found || strings.TrimSpace(local) == "" || domain == "" || strings.ContainsAny(domain, "@{} ") {
+		return errors.Wrap(errors.ErrInvalidAlias, "expected local-part@domain")
+	}
+	if err := validateCharset(template); err != nil {
+		return errors.Wrap(errors.ErrInvalidAlias, err.Error())
+	}
+
+	rest := local
+	for {
+		start := strings.IndexByte(rest, '{')
+		if start < 0 {
+			if strings.ContainsRune(rest, '}') {
+				return errors.Wrap(errors.ErrInvalidAlias, "unmatched '}'")
+			}
+			return nil
+		}
+		if strings.ContainsRune(rest[:start], '}') {
+			return errors.Wrap(errors.ErrInvalidAlias, "unmatched '}'")
+		}
+		end := strings.IndexByte(rest[start:], '}')
+		if end < 0 {
+			return errors.Wrap(errors.ErrInvalidAlias, "unterminated placeholder")
+		}
+		if _, err := parseAliasPlaceholder(rest[start+1 : start+end]); err != nil {
+			return err
+		}
+		rest = rest[start+end+1:]
+	}
+}
+
+// parseAliasPlaceholder returns the placeholder name and its count argument.
+func parseAliasPlaceholder(placeholder string) (string, error) {
+	name, arg, hasArg := strings.Cut(placeholder, ":")
+	switch {
+	case name == "rand" && hasArg:
+		n, err := strconv.Atoi(arg)
+		if err != nil || n <= 0 || n > maxAliasRandom {
+			return "", errors.Wrap(errors.ErrInvalidAlias, fmt.Sprintf("{rand:N} needs N between 1 and %d", maxAliasRandom))
+		}
+		return name, nil
+	case !hasArg && (name == "tag" || name == "word" || name == "rand"):
+		return name, nil
+	default:
+		return "", errors.Wrap(errors.ErrInvalidAlias, fmt.Sprintf("unknown placeholder {%s}", placeholder))
+	}
+}
+
+// expandAliasPlaceholder produces the text for one validated placeholder.
+func expandAliasPlaceholder(placeholder, tag string) (string, error) {
+	name, arg, _ := strings.Cut(placeholder, ":")
+	switch name {
+	case "tag":
+		if slug := slugify(tag); slug != "" {
+			return slug, nil
+		}
+		return randomWord()
+	case "word":
+		return randomWord()
+	default:
+		n := 3
+		if arg != "" {
+			n, _ = strconv.Atoi(arg)
+		}
+		return randomString([]rune(lowercase+digits), n)
+	}
+}
+
+// slugify lowercases text and turns every run of other characters into one '-'.
+func slugify(text string) string {
+	var slug strings.Builder
+	dash := false
+	for _, char := range strings.ToLower(text) {
+		if strings.ContainsRune(lowercase+digits, char) {
+			if dash && slug.Len() > 0 {
+				slug.WriteByte('-')
+			}
+			slug.WriteRune(char)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+	return slug.String()
+}
+
+// randomWord picks a word from the username word list.
+func randomWord() (string, error) {
+	i, err := randomIndex(len(usernameWords))
+	if err != nil {
+		return "", err
+	}
+	return usernameWords[i], nil
+}
+
+// randomString returns n characters drawn uniformly from charset.
+func randomString(charset []rune, n int) (string, error) {
+	chars := make([]rune, n)
+	for i := range chars {
+		c, err := randomChar(charset)
+		if err != nil {
+			return "", err
+		}
+		chars[i] = c
+	}
+	return string(chars), nil
+}
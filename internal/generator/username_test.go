@@ -0,0 +1,96 @@
+package generator
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/simp-lee/passwordmanager/internal/errors"
+)
+
+func TestGenerateUsername(t *testing.T) {
+	t.Run("Words", func(t *testing.T) {
+		pattern := regexp.MustCompile(`^[a-z]+_[a-z]+[0-9]{2}$`)
+		for i := 0; i < 20; i++ {
+			username, err := GenerateUsername(DefaultUsernameOptions())
+			if err != nil {
+				t.Fatalf("Unexpected error generating username: %v", err)
+			}
+			if !pattern.MatchString(username) {
+				t.Errorf("Username %q does not match %s", username, pattern)
+			}
+		}
+	})
+
+	t.Run("Chars", func(t *testing.T) {
+		opts := UsernameOptions{Mode: UsernameChars, Length: 12}
+		for i := 0; i < 20; i++ {
+			username, err := GenerateUsername(opts)
+			if err != nil {
+				t.Fatalf("Unexpected error generating username: %v", err)
+			}
+			if len(username) != 12 || !strings.ContainsRune(lowercase, rune(username[0])) || strings.Trim(username, lowercase+digits) != "" {
+				t.Errorf("Unexpected username %q", username)
+			}
+		}
+	})
+
+	errorTests := []struct {
+		name    string
+		options UsernameOptions
+		target  error
+	}{
+		{"No words", UsernameOptions{Mode: UsernameWords}, errors.ErrInvalidLength},
+		{"No length", UsernameOptions{Mode: UsernameChars}, errors.ErrInvalidLength},
+		{"Unknown mode", UsernameOptions{Mode: "emoji", Length: 8}, errors.ErrInvalidMode},
+	}
+	for _, test := range errorTests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := GenerateUsername(test.options); !errors.Is(err, test.target) {
+				t.Errorf("Expected error %v, got %v", test.target, err)
+			}
+		})
+	}
+}
+
+func TestGenerateEmailAlias(t *testing.T) {
+	tests := []struct {
+		template string
+		tag      string
+		pattern  string
+	}{
+		{"team+{tag}-{rand}@ourdomain", "Vendor X!", `^team\+vendor-x-[a-z0-9]{3}@ourdomain$`},
+		{"{tag}.{rand:6}@catchall.example", "acme", `^acme\.[a-z0-9]{6}@catchall\.example$`},
+		{"me+{tag}@example.com", "", `^me\+[a-z]+@example\.com$`},
+		{"{word}{word}@example.com", "ignored", `^[a-z]+@example\.com$`},
+	}
+	for _, test := range tests {
+		alias, err := GenerateEmailAlias(test.template, test.tag)
+		if err != nil {
+			t.Fatalf("Unexpected error expanding %q: %v", test.template, err)
+		}
+		if !regexp.MustCompile(test.pattern).MatchString(alias) {
+			t.Errorf("Alias %q from %q does not match %s", alias, test.template, test.pattern)
+		}
+	}
+
+	invalid := []string{
+		"",
+		"no-at-sign",
+		"@example.com",
+		"me@",
+		"a@b@c",
+		"me+{unknown}@example.com",
+		"me+{rand:0}@example.com",
+		"me+{rand:x}@example.com",
+		"me+{tag@example.com",
+		"me+tag}@example.com",
+		"me@{tag}.example.com",
+		"me plus@example.com",
+	}
+	for _, template := range invalid {
+		if _, err := GenerateEmailAlias(template, "x"); !errors.Is(err, errors.ErrInvalidAlias) {
+			t.Errorf("Expected ErrInvalidAlias for %q, got %v", template, err)
+		}
+	}
+}
@@ -0,0 +1,22 @@
+package generator
+
+// usernameWords are short, neutral English words used for usernames and
+// email aliases. Adjectives come first, then nouns; the order is not relied on.
+var usernameWords = []string{
+	"able", "amber", "ample", "arctic", "azure", "bold", "brave", "breezy", "bright", "brisk",
+	"calm", "candid", "clever", "cosmic", "cozy", "crisp", "curly", "dapper", "daring", "deft",
+	"eager", "early", "easy", "fancy", "fast", "fluffy", "frosty", "gentle", "giant", "glad",
+	"golden", "grand", "happy", "hardy", "hazel", "honest", "humble", "icy", "jolly", "keen",
+	"kind", "lively", "lucky", "lunar", "mellow", "merry", "mighty", "misty", "modest", "neat",
+	"nimble", "noble", "olive", "plucky", "polar", "proud", "quick", "quiet", "rapid", "rustic",
+	"sandy", "sharp", "shiny", "silent", "silver", "smooth", "snowy", "solar", "steady", "stormy",
+	"sunny", "swift", "tidy", "tiny", "vivid", "warm", "wild", "windy", "wise", "witty",
+	"acorn", "anchor", "badger", "bamboo", "beacon", "beetle", "birch", "bison", "breeze", "brook",
+	"cactus", "canyon", "cedar", "cloud", "clover", "comet", "coral", "crane", "creek", "dune",
+	"eagle", "ember", "falcon", "fern", "finch", "fjord", "forest", "fox", "galaxy", "garnet",
+	"glacier", "harbor", "hawk", "heron", "island", "jasper", "kayak", "koala", "lagoon", "lantern",
+	"lark", "lemur", "lotus", "lynx", "maple", "meadow", "meteor", "moose", "nebula", "oak",
+	"ocean", "orbit", "orca", "otter", "owl", "panda", "pebble", "pine", "planet", "pond",
+	"prairie", "puffin", "quartz", "raven", "reef", "ridge", "river", "robin", "rocket", "sparrow",
+	"spruce", "summit", "thistle", "thunder", "tiger", "trail", "tulip", "valley", "walrus", "willow",
+}
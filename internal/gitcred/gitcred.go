@@ -0,0 +1,153 @@
+// Package gitcred implements the git credential helper protocol on top of
+// the vault: git describes the remote it needs credentials for, and the
+// account whose URL matches that remote answers it.
+//
+// See git-credential(1) for the protocol: one "key=value" attribute per line,
+// ending with a blank line or the end of input.
+package gitcred
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/simp-lee/passwordmanager/internal/errors"
+	"github.com/simp-lee/passwordmanager/internal/model"
+)
+
+// Request describes the credentials git asks about, stores or erases.
+type Request struct {
+	Protocol string // e.g. "https"
+	Host     string // Host name, with the port if it is not the default
+	Path     string // Repository path; only sent with credential.useHttpPath
+	Username string
+	Password string
+}
+
+// Read parses a request. Attributes other than those in Request are ignored.
+func Read(r io.Reader) (*Request, error) {
+	req := &Request{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if line == "" {
+			break
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid credential attribute %q", line)
+		}
+		switch key {
+		case "protocol":
+			req.Protocol = value
+		case "host":
+			req.Host = value
+		case "path":
+			req.Path = value
+		case "username":
+			req.Username = value
+		case "password":
+			req.Password = value
+		case "url":
+			// Git sends url only if it was given one; split it like git does
+			u, err := url.Parse(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid credential url %q", value)
+			}
+			req.Protocol, req.Host, req.Path = u.Scheme, u.Host, strings.TrimPrefix(u.Path, "/")
+			if u.User != nil {
+				req.Username = u.User.Username()
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to read credential request")
+	}
+	if req.Host == "" {
+		return nil, fmt.Errorf("credential request has no host")
+	}
+	return req, nil
+}
+
+// Write answers git with the username and password.
+func Write(w io.Writer, username, password string) error {
+	for _, attr := range [][2]string{{"username", username}, {"password", password}} {
+		if strings.ContainsAny(attr[1], "\n\x00") {
+			return fmt.Errorf("credential %s contains a newline or NUL", attr[0])
+		}
+		if attr[1] != "" {
+			if _, err := fmt.Fprintf(w, "%s=%s\n", attr[0], attr[1]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// URL returns the remote of the request, for the URL of a new account.
+func (r *Request) URL() string {
+	u := url.URL{Scheme: r.Protocol, Host: r.Host}
+	if r.Path != "" {
+		u.Path = "/" + r.Path
+	}
+	if u.Scheme == "" {
+		u.Scheme = "https"
+	}
+	return u.String()
+}
+
+// Find returns the account that best matches the request, or nil. An
+// account matches if its URL has the request's host, the same protocol if
+// the URL names one, a path that contains the request's path if both have
+// one, and the request's username, if any, as username or email. Among
+// matches the one with the longest path wins. Accounts with derived
+// passwords never match, as their passwords are not stored, and neither do
+// SSH key accounts, whose password field holds the private key.
+func Find(accounts []*model.Account, req *Request) *model.Account {
+	var best *model.Account
+	bestScore := -1
+	for _, account := range accounts {
+		if account.Derived != nil || account.SSHKey != nil {
+			continue
+		}
+		if req.Username != "" && account.Username != req.Username && account.Email != req.Username {
+			continue
+		}
+		if score := matchURL(account.URL, req); score > bestScore {
+			best, bestScore = account, score
+		}
+	}
+	return best
+}
+
+// matchURL returns how specifically rawURL matches the request: -1 if it
+// does not, otherwise the length of its matching path.
+func matchURL(rawURL string, req *Request) int {
+	explicitScheme := strings.Contains(rawURL, "://")
+	if !explicitScheme {
+		rawURL = "https://" + rawURL
+	}
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil || u.Host == "" || !strings.EqualFold(u.Host, req.Host) {
+		return -1
+	}
+	if explicitScheme && req.Protocol != "" && !strings.EqualFold(u.Scheme, req.Protocol) {
+		return -1
+	}
+
+	accountPath, reqPath := repoPath(u.Path), repoPath(req.Path)
+	if accountPath == "" || reqPath == "" {
+		return 0
+	}
+	if reqPath != accountPath && !strings.HasPrefix(reqPath, accountPath+"/") {
+		return -1
+	}
+	return len(accountPath)
+}
+
+// repoPath normalizes a repository path for comparison.
+func repoPath(path string) string {
+	return strings.TrimSuffix(strings.Trim(path, "/"), ".git")
+}
@@ -0,0 +1,66 @@
+package gitcred
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/simp-lee/passwordmanager/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRead(t *testing.T) {
+	req, err := Read(strings.NewReader("protocol=https\nhost=github.com\npath=me/repo.git\nusername=me\ncapability[]=authtype\n\nignored=1\n"))
+	require.NoError(t, err)
+	assert.Equal(t, &Request{Protocol: "https", Host: "github.com", Path: "me/repo.git", Username: "me"}, req)
+	assert.Equal(t, "https://github.com/me/repo.git", req.URL())
+
+	req, err = Read(strings.NewReader("url=https://bob@git.example.com:8443/team/app\n"))
+	require.NoError(t, err)
+	assert.Equal(t, &Request{Protocol: "https", Host: "git.example.com:8443", Path: "team/app", Username: "bob"}, req)
+
+	_, err = Read(strings.NewReader("protocol=https\n"))
+	assert.Error(t, err, "A host is required")
+	_, err = Read(strings.NewReader("host\n"))
+	assert.Error(t, err)
+}
+
+func TestWrite(t *testing.T) {
+	var out bytes.Buffer
+	require.NoError(t, Write(&out, "me", "s3cret"))
+	assert.Equal(t, "username=me\npassword=s3cret\n", out.String())
+
+	out.Reset()
+	require.NoError(t, Write(&out, "", "token"))
+	assert.Equal(t, "password=token\n", out.String())
+	assert.Error(t, Write(&out, "me", "two\nlines"))
+}
+
+func TestFind(t *testing.T) {
+	accounts := []*model.Account{
+		{ID: "host", URL: "github.com", Username: "me"},
+		{ID: "repo", URL: "https://github.com/team/app.git", Username: "bot"},
+		{ID: "http", URL: "http://git.example.com", Username: "me"},
+		{ID: "derived", URL: "https://gitlab.com", Derived: &model.Derived{Site: "gitlab.com"}},
+		{ID: "email", URL: "https://bitbucket.org/", Email: "me@example.com"},
+		{ID: "ssh", URL: "https://git.example.org", Username: "me", EncryptedPassword: "x", SSHKey: &model.SSHKey{}},
+	}
+	find := func(req Request) string {
+		if account := Find(accounts, &req); account != nil {
+			return account.ID
+		}
+		return ""
+	}
+
+	assert.Equal(t, "host", find(Request{Protocol: "https", Host: "github.com"}))
+	assert.Equal(t, "repo", find(Request{Protocol: "https", Host: "github.com", Path: "team/app/"}), "The longest path wins")
+	assert.Equal(t, "host", find(Request{Protocol: "https", Host: "github.com", Path: "other/app"}))
+	assert.Equal(t, "host", find(Request{Protocol: "https", Host: "GitHub.com", Path: "team/app", Username: "me"}), "The username must match")
+	assert.Equal(t, "", find(Request{Protocol: "https", Host: "git.example.com"}), "The protocol must match an explicit scheme")
+	assert.Equal(t, "http", find(Request{Protocol: "http", Host: "git.example.com"}))
+	assert.Equal(t, "", find(Request{Protocol: "https", Host: "gitlab.com"}), "Derived passwords are not stored")
+	assert.Equal(t, "", find(Request{Protocol: "https", Host: "git.example.org"}), "SSH keys are not passwords")
+	assert.Equal(t, "email", find(Request{Protocol: "https", Host: "bitbucket.org", Username: "me@example.com"}))
+	assert.Equal(t, "", find(Request{Protocol: "https", Host: "example.org"}))
+}
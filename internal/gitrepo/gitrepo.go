@@ -0,0 +1,327 @@
+// Package gitrepo keeps the vault file under version control in a git
+// repository in the data directory, using the git command. Every save
+// becomes a commit, and pushing and pulling the repository syncs devices.
+// The vault file is encrypted, so git cannot merge it; diverged histories
+// are merged by a callback that decrypts both sides.
+package gitrepo
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/simp-lee/passwordmanager/internal/errors"
+)
+
+// DefaultRemote is the remote used when none is given.
+const DefaultRemote = "origin"
+
+// Fallback identity for commits when git has no user configured.
+const (
+	fallbackName  = "passwordmanager"
+	fallbackEmail = "passwordmanager@localhost"
+)
+
+// Repo is a git repository holding the vault file.
+type Repo struct {
+	dir  string // Repository root (the data directory)
+	file string // Vault file name, relative to dir
+}
+
+// Commit describes one commit that changed the vault file.
+type Commit struct {
+	Hash    string
+	Author  string
+	Time    time.Time
+	Message string
+}
+
+// ShortHash returns the abbreviated commit hash.
+func (c Commit) ShortHash() string {
+	if len(c.Hash) > 7 {
+		return c.Hash[:7]
+	}
+	return c.Hash
+}
+
+// PullResult reports how a pull was integrated.
+type PullResult string
+
+const (
+	PullUpToDate    PullResult = "up-to-date"
+	PullFastForward PullResult = "fast-forward"
+	PullMerged      PullResult = "merged"
+)
+
+// MergeFunc merges the vault file of the pulled commit (theirs) into the
+// vault file in the working tree and writes the result there. base is the
+// vault file at the merge base, or nil if the histories are unrelated.
+type MergeFunc func(theirs, base []byte) error
+
+// IsRepository reports whether dir is the root of a git repository.
+func IsRepository(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, ".git"))
+	return err == nil
+}
+
+// Open returns the repository in dir, which holds the vault file file.
+func Open(dir, file string) (*Repo, error) {
+	if !IsRepository(dir) {
+		return nil, errors.ErrNotGitRepository
+	}
+	if _, err := exec.LookPath("git"); err != nil {
+		return nil, errors.ErrGitNotFound
+	}
+	return &Repo{dir: dir, file: file}, nil
+}
+
+// Init makes dir a git repository that tracks only the vault file, commits
+// the vault file if it exists and adds remoteURL as the default remote if it
+// is not empty. Without a vault file nothing is committed, so that the first
+// pull can take the remote history as it is. An existing repository is
+// reused.
+func Init(dir, file, remoteURL string) (*Repo, error) {
+	if _, err := exec.LookPath("git"); err != nil {
+		return nil, errors.ErrGitNotFound
+	}
+	r := &Repo{dir: dir, file: file}
+	if !IsRepository(dir) {
+		if _, err := r.git("init", "--quiet"); err != nil {
+			return nil, err
+		}
+	}
+
+	// Backups, sync state and temporary files stay out of the history
+	ignore := "*\n!.gitignore\n!" + file + "\n"
+	if err := os.WriteFile(filepath.Join(dir, ".gitignore"), []byte(ignore), 0600); err != nil {
+		return nil, errors.Wrap(err, "failed to write .gitignore")
+	}
+	if _, err := r.git("add", "--", ".gitignore"); err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(filepath.Join(dir, file)); err == nil {
+		if err := r.Commit("track vault in git"); err != nil {
+			return nil, err
+		}
+	}
+
+	if remoteURL != "" {
+		if _, err := r.git("remote", "add", DefaultRemote, remoteURL); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// Commit records the current vault file with message. Does nothing if the
+// file did not change. Suitable as a storage save hook.
+func (r *Repo) Commit(message string) error {
+	if _, err := r.git("add", "--", r.file); err != nil {
+		return err
+	}
+	return r.commitStaged(message)
+}
+
+// Log returns up to limit commits that changed the vault file, newest first.
+// A limit of 0 returns all of them.
+func (r *Repo) Log(limit int) ([]Commit, error) {
+	args := []string{"log", "--format=%H%x1f%an%x1f%aI%x1f%s"}
+	if limit > 0 {
+		args = append(args, fmt.Sprintf("--max-count=%d", limit))
+	}
+	if !r.hasCommits() {
+		return nil, nil
+	}
+	out, err := r.git(append(args, "--", r.file)...)
+	if err != nil {
+		return nil, err
+	}
+
+	var commits []Commit
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		fields := strings.Split(line, "\x1f")
+		if len(fields) != 4 {
+			continue
+		}
+		when, _ := time.Parse(time.RFC3339, fields[2])
+		commits = append(commits, Commit{Hash: fields[0], Author: fields[1], Time: when, Message: fields[3]})
+	}
+	return commits, nil
+}
+
+// Resolve returns the commit named by rev, e.g. a hash or "HEAD~2".
+func (r *Repo) Resolve(rev string) (Commit, error) {
+	out, err := r.git("show", "--no-patch", "--format=%H%x1f%an%x1f%aI%x1f%s", rev+"^{commit}", "--")
+	if err != nil {
+		return Commit{}, err
+	}
+	fields := strings.Split(strings.TrimSpace(out), "\x1f")
+	if len(fields) != 4 {
+		return Commit{}, fmt.Errorf("git: unexpected output for %s", rev)
+	}
+	when, _ := time.Parse(time.RFC3339, fields[2])
+	return Commit{Hash: fields[0], Author: fields[1], Time: when, Message: fields[3]}, nil
+}
+
+// FileAt returns the vault file as of rev.
+func (r *Repo) FileAt(rev string) ([]byte, error) {
+	out, err := r.git("show", rev+":"+r.file)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(out), nil
+}
+
+// ParentFileAt returns the vault file as of the first parent of rev, the
+// state a revert of rev goes back to.
+func (r *Repo) ParentFileAt(rev string) ([]byte, error) {
+	if _, err := r.git("rev-parse", "--verify", "--quiet", rev+"^"); err != nil {
+		return nil, errors.ErrNoParentCommit
+	}
+	return r.FileAt(rev + "^")
+}
+
+// Push pushes the current branch to remote, setting it as upstream.
+func (r *Repo) Push(remote string) error {
+	branch, err := r.branch()
+	if err != nil {
+		return err
+	}
+	_, err = r.git("push", "--quiet", "--set-upstream", remote, branch)
+	return err
+}
+
+// Pull fetches the current branch from remote and integrates it. If both
+// sides have new commits, the histories are merged and merge is called to
+// merge the vault files; its result is committed as the merge commit.
+func (r *Repo) Pull(remote string, merge MergeFunc) (PullResult, error) {
+	branch, err := r.branch()
+	if err != nil {
+		return "", err
+	}
+	if _, err := r.git("fetch", "--quiet", remote, branch); err != nil {
+		return "", err
+	}
+	theirs, err := r.git("rev-parse", "FETCH_HEAD")
+	if err != nil {
+		return "", err
+	}
+	theirs = strings.TrimSpace(theirs)
+
+	if !r.hasCommits() {
+		// Nothing here yet (a new device): take the remote branch as it is
+		if _, err := r.git("reset", "--quiet", "--hard", theirs); err != nil {
+			return "", err
+		}
+		return PullFastForward, nil
+	}
+	if r.isAncestor(theirs, "HEAD") {
+		return PullUpToDate, nil
+	}
+	if r.isAncestor("HEAD", theirs) {
+		if _, err := r.git("merge", "--quiet", "--ff-only", theirs); err != nil {
+			return "", err
+		}
+		return PullFastForward, nil
+	}
+
+	// Diverged: record the merge without letting git touch the vault file,
+	// then let merge produce the merged file
+	theirData, err := r.FileAt(theirs)
+	if err != nil {
+		return "", err
+	}
+	var baseData []byte
+	if base, err := r.git("merge-base", "HEAD", theirs); err == nil {
+		baseData, _ = r.FileAt(strings.TrimSpace(base))
+	}
+	if _, err := r.gitAsCommitter("merge", "--quiet", "--no-commit", "--no-ff", "--allow-unrelated-histories", "-s", "ours", theirs); err != nil {
+		return "", err
+	}
+	if err := merge(theirData, baseData); err != nil {
+		r.git("merge", "--abort")
+		return "", err
+	}
+
+	// A save hook may already have committed the merge
+	if _, err := os.Stat(filepath.Join(r.dir, ".git", "MERGE_HEAD")); err == nil {
+		if err := r.Commit(fmt.Sprintf("merge %s/%s", remote, branch)); err != nil {
+			return "", err
+		}
+	}
+	return PullMerged, nil
+}
+
+// commitStaged commits the index if it has changes or a merge is pending.
+func (r *Repo) commitStaged(message string) error {
+	_, merging := os.Stat(filepath.Join(r.dir, ".git", "MERGE_HEAD"))
+	if _, err := r.git("diff", "--cached", "--quiet"); err == nil && merging != nil && r.hasCommits() {
+		return nil // Nothing to commit
+	}
+
+	_, err := r.gitAsCommitter("commit", "--quiet", "--message", message)
+	return err
+}
+
+// hasCommits reports whether HEAD points to a commit yet.
+func (r *Repo) hasCommits() bool {
+	_, err := r.git("rev-parse", "--verify", "--quiet", "HEAD")
+	return err == nil
+}
+
+// hasIdentity reports whether git knows who commits.
+func (r *Repo) hasIdentity() bool {
+	name, err := r.git("config", "user.name")
+	if err != nil || strings.TrimSpace(name) == "" {
+		return false
+	}
+	email, err := r.git("config", "user.email")
+	return err == nil && strings.TrimSpace(email) != ""
+}
+
+// isAncestor reports whether commit a is an ancestor of (or equal to) b.
+func (r *Repo) isAncestor(a, b string) bool {
+	_, err := r.git("merge-base", "--is-ancestor", a, b)
+	return err == nil
+}
+
+// branch returns the name of the current branch.
+func (r *Repo) branch() (string, error) {
+	out, err := r.git("symbolic-ref", "--short", "HEAD")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// gitAsCommitter runs a git command that records a committer, with a
+// fallback identity if git has none configured.
+func (r *Repo) gitAsCommitter(args ...string) (string, error) {
+	if !r.hasIdentity() {
+		args = append([]string{"-c", "user.name=" + fallbackName, "-c", "user.email=" + fallbackEmail}, args...)
+	}
+	return r.git(args...)
+}
+
+// git runs a git command in the repository and returns its output.
+func (r *Repo) git(args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = r.dir
+	// Never wait for credentials or an editor on a terminal we don't own
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0", "GIT_EDITOR=true", "LC_ALL=C")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		message := strings.TrimSpace(stderr.String())
+		if message == "" {
+			message = err.Error()
+		}
+		return stdout.String(), fmt.Errorf("git %s: %s", args[0], message)
+	}
+	return stdout.String(), nil
+}
@@ -0,0 +1,216 @@
+package gitrepo
+
+import (
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/simp-lee/passwordmanager/internal/crypto"
+	"github.com/simp-lee/passwordmanager/internal/errors"
+	"github.com/simp-lee/passwordmanager/internal/model"
+	"github.com/simp-lee/passwordmanager/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const masterPassword = "master-password"
+
+// device is one data directory with its vault and repository.
+type device struct {
+	dir   string
+	store *storage.Storage
+	repo  *Repo
+}
+
+// newBareRemote creates an empty bare repository to push to.
+func newBareRemote(t *testing.T) string {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	remote := filepath.Join(t.TempDir(), "vault.git")
+	require.NoError(t, exec.Command("git", "init", "--quiet", "--bare", remote).Run())
+	return remote
+}
+
+// newDevice makes a data directory a repository with remote as origin and
+// commits every save of its vault.
+func newDevice(t *testing.T, remote string) *device {
+	dir := t.TempDir()
+	store, err := storage.New(dir)
+	require.NoError(t, err)
+	repo, err := Init(dir, storage.VaultFileName, remote)
+	require.NoError(t, err)
+	store.SetSaveHook(repo.Commit)
+	return &device{dir: dir, store: store, repo: repo}
+}
+
+// pull pulls origin and merges diverged vaults by decrypting them.
+func (d *device) pull(t *testing.T) PullResult {
+	result, err := d.repo.Pull(DefaultRemote, func(theirs, base []byte) error {
+		_, err := d.store.MergeVaultData(theirs, base, "", "merge origin")
+		return err
+	})
+	require.NoError(t, err)
+	return result
+}
+
+func (d *device) add(t *testing.T, id, platform string) {
+	encrypted, err := crypto.Encrypt([]byte(id), d.store.GetEncryptionKey())
+	require.NoError(t, err)
+	accounts, err := d.store.GetAccounts()
+	require.NoError(t, err)
+	require.NoError(t, d.store.AddAccount(&model.Account{ID: id, Platform: platform, EncryptedPassword: encrypted,
+		SortOrder: len(accounts) + 1}))
+}
+
+func (d *device) setNotes(t *testing.T, id, notes string) {
+	account, err := d.store.GetAccountByID(id)
+	require.NoError(t, err)
+	updated := *account
+	updated.Notes = notes
+	require.NoError(t, d.store.UpdateAccount(&updated))
+}
+
+func messages(t *testing.T, repo *Repo) []string {
+	commits, err := repo.Log(0)
+	require.NoError(t, err)
+	var result []string
+	for _, commit := range commits {
+		result = append(result, commit.Message)
+	}
+	return result
+}
+
+// setupDevices returns two devices sharing a vault with accounts a and b
+// through remote.
+func setupDevices(t *testing.T) (*device, *device) {
+	remote := newBareRemote(t)
+
+	laptop := newDevice(t, remote)
+	require.NoError(t, laptop.store.CreateVault(masterPassword))
+	require.NoError(t, laptop.store.UnlockVault(masterPassword))
+	laptop.add(t, "a", "GitHub")
+	laptop.add(t, "b", "Mail")
+	require.NoError(t, laptop.repo.Push(DefaultRemote))
+
+	desktop := newDevice(t, remote)
+	assert.Equal(t, PullFastForward, desktop.pull(t), "A new device takes the remote history")
+	require.NoError(t, desktop.store.UnlockVault(masterPassword))
+	return laptop, desktop
+}
+
+func TestCommitOnSave(t *testing.T) {
+	laptop, desktop := setupDevices(t)
+
+	assert.Equal(t, []string{"add account b", "add account a", "create vault"}, messages(t, laptop.repo))
+	assert.Equal(t, messages(t, laptop.repo), messages(t, desktop.repo))
+
+	commits, err := laptop.repo.Log(1)
+	require.NoError(t, err)
+	require.Len(t, commits, 1)
+	head, err := laptop.repo.Resolve("HEAD")
+	require.NoError(t, err)
+	assert.Equal(t, commits[0], head)
+	assert.Len(t, head.ShortHash(), 7)
+
+	// Only the vault file is tracked
+	out, err := laptop.repo.git("ls-files")
+	require.NoError(t, err)
+	assert.Equal(t, ".gitignore\n"+storage.VaultFileName+"\n", out)
+}
+
+func TestPull(t *testing.T) {
+	t.Run("FastForwardAndUpToDate", func(t *testing.T) {
+		laptop, desktop := setupDevices(t)
+
+		laptop.setNotes(t, "a", "from laptop")
+		require.NoError(t, laptop.repo.Push(DefaultRemote))
+
+		assert.Equal(t, PullFastForward, desktop.pull(t))
+		assert.Equal(t, PullUpToDate, desktop.pull(t))
+		require.NoError(t, desktop.store.UnlockVault(masterPassword))
+		account, err := desktop.store.GetAccountByID("a")
+		require.NoError(t, err)
+		assert.Equal(t, "from laptop", account.Notes)
+	})
+
+	t.Run("DivergedHistoriesMergeDecrypted", func(t *testing.T) {
+		laptop, desktop := setupDevices(t)
+
+		laptop.setNotes(t, "a", "from laptop")
+		require.NoError(t, laptop.repo.Push(DefaultRemote))
+		desktop.setNotes(t, "b", "from desktop")
+		desktop.add(t, "c", "Bank")
+
+		assert.Equal(t, PullMerged, desktop.pull(t))
+		assert.Equal(t, "merge origin", messages(t, desktop.repo)[0])
+		parents, err := desktop.repo.git("rev-list", "--parents", "--max-count=1", "HEAD")
+		require.NoError(t, err)
+		assert.Len(t, strings.Fields(parents), 3, "The merge commit has both heads as parents")
+
+		require.NoError(t, desktop.repo.Push(DefaultRemote))
+		assert.Equal(t, PullFastForward, laptop.pull(t))
+		require.NoError(t, laptop.store.UnlockVault(masterPassword))
+		for _, d := range []*device{laptop, desktop} {
+			accounts, err := d.store.GetAccounts()
+			require.NoError(t, err)
+			require.Len(t, accounts, 3)
+			assert.Equal(t, "from laptop", accounts[0].Notes)
+			assert.Equal(t, "from desktop", accounts[1].Notes)
+		}
+	})
+
+	t.Run("FailedMergeIsAborted", func(t *testing.T) {
+		laptop, desktop := setupDevices(t)
+
+		laptop.setNotes(t, "a", "from laptop")
+		require.NoError(t, laptop.repo.Push(DefaultRemote))
+		desktop.setNotes(t, "b", "from desktop")
+		before, err := desktop.repo.Resolve("HEAD")
+		require.NoError(t, err)
+
+		_, err = desktop.repo.Pull(DefaultRemote, func(theirs, base []byte) error {
+			return errors.ErrRemotePassword
+		})
+		assert.ErrorIs(t, err, errors.ErrRemotePassword)
+		after, err := desktop.repo.Resolve("HEAD")
+		require.NoError(t, err)
+		assert.Equal(t, before, after)
+		assert.NoFileExists(t, filepath.Join(desktop.dir, ".git", "MERGE_HEAD"))
+	})
+}
+
+func TestRevert(t *testing.T) {
+	laptop, _ := setupDevices(t)
+	laptop.setNotes(t, "a", "mistake")
+	laptop.setNotes(t, "b", "later change")
+
+	commit, err := laptop.repo.Resolve("HEAD~1")
+	require.NoError(t, err)
+	assert.Equal(t, "update account a", commit.Message)
+	before, err := laptop.repo.ParentFileAt(commit.Hash)
+	require.NoError(t, err)
+	after, err := laptop.repo.FileAt(commit.Hash)
+	require.NoError(t, err)
+	_, err = laptop.store.RevertVaultData(before, after, "", "revert "+commit.ShortHash())
+	require.NoError(t, err)
+
+	assert.Equal(t, "revert "+commit.ShortHash(), messages(t, laptop.repo)[0])
+	a, err := laptop.store.GetAccountByID("a")
+	require.NoError(t, err)
+	assert.Empty(t, a.Notes)
+	b, err := laptop.store.GetAccountByID("b")
+	require.NoError(t, err)
+	assert.Equal(t, "later change", b.Notes)
+
+	commits, err := laptop.repo.Log(0)
+	require.NoError(t, err)
+	_, err = laptop.repo.ParentFileAt(commits[len(commits)-1].Hash)
+	assert.ErrorIs(t, err, errors.ErrNoParentCommit)
+}
+
+func TestOpen(t *testing.T) {
+	_, err := Open(t.TempDir(), storage.VaultFileName)
+	assert.ErrorIs(t, err, errors.ErrNotGitRepository)
+}
@@ -30,7 +30,7 @@ var translations = map[Lang]map[string]string{
 
 		// 主密码相关
 		"enter_master_password":         "请输入主密码: ",
-		"enter_new_master_password":     "请输入新主密码（至少8个字符）: ",
+		"enter_new_master_password":     "请输入新主密码（至少%d个字符）: ",
 		"enter_current_master_password": "请输入当前主密码: ",
 		"confirm_master_password":       "请确认主密码: ",
 		"passwords_dont_match":          "两次输入的密码不匹配",
@@ -50,6 +50,7 @@ var translations = map[Lang]map[string]string{
 		"email":             "输入邮箱",
 		"url":               "输入网址",
 		"notes":             "输入备注",
+		"password_rules":    "输入网站密码规则（可选，passwordrules 语法）",
 		"input_password":    "输入密码: ",
 		"confirm_password":  "确认密码: ",
 
@@ -61,6 +62,7 @@ var translations = map[Lang]map[string]string{
 		"password_copied":          "密码已复制到剪贴板，%d秒后自动清除",
 		"password_copy_failed":     "复制到剪贴板失败: %v",
 		"must_select_charset":      "错误: 至少需要选择一种字符类型",
+		"password_entropy":         "理论熵: %.1f 位 (字符集 %d 个字符)",
 
 		// 账户操作
 		"account_added":           "账户 '%s' 添加成功 (ID: %s)",
@@ -93,23 +95,152 @@ var translations = map[Lang]map[string]string{
 		"found_query_accounts":    "找到 %d 个匹配 '%s' 的账户:",
 
 		// 导入导出
-		"export_success":     "保险库已成功导出到: %s",
-		"export_warning":     "注意: 导出文件包含所有账户数据，请妥善保管",
-		"export_exists":      "导出文件已存在，是否覆盖?",
-		"import_warning":     "警告: 导入操作将覆盖现有保险库数据!",
-		"import_success":     "保险库导入成功。请使用其原始主密码解锁。",
-		"import_failed":      "导入保险库失败: %v",
-		"csv_export_warning": "警告: CSV导出将包含明文密码！这是一个安全风险。",
-		"csv_post_export":    "安全提示: 请在使用完毕后删除该文件",
-		"csv_export_success": "账户数据已成功导出到: %s",
+		"export_success":             "保险库已成功导出到: %s",
+		"export_warning":             "注意: 导出文件包含所有账户数据，请妥善保管",
+		"export_exists":              "导出文件已存在，是否覆盖?",
+		"import_warning":             "警告: 导入操作将覆盖现有保险库数据!",
+		"import_success":             "保险库导入成功。请使用其原始主密码解锁。",
+		"import_failed":              "导入保险库失败: %v",
+		"csv_export_warning":         "警告: CSV导出将包含明文密码！这是一个安全风险。",
+		"csv_post_export":            "安全提示: 请在使用完毕后删除该文件",
+		"csv_export_success":         "账户数据已成功导出到: %s",
+		"import_summary":             "将导入 %d 个账户，跳过 %d 个重复项和 %d 个非登录记录",
+		"import_csv_success":         "已导入 %d 个账户",
+		"import_kdbx_success":        "已导入 %d 个账户，跳过 %d 个（已存在或没有标题）",
+		"export_kdbx_success":        "已将 %d 个账户导出为 KeePass 数据库: %s",
+		"enter_kdbx_password":        "请输入 KeePass 数据库密码（仅使用密钥文件时直接回车）: ",
+		"enter_kdbx_new_password":    "请设置 KeePass 数据库密码: ",
+		"confirm_kdbx_password":      "请再次输入 KeePass 数据库密码: ",
+		"history_header":             "历史版本",
+		"tags_header":                "标签",
+		"enter_export_passphrase":    "请输入导出文件的口令: ",
+		"confirm_export_passphrase":  "请再次输入导出文件的口令: ",
+		"export_portable_success":    "已将 %d 个账户导出为加密的便携文件: %s",
+		"import_portable_success":    "已合并 %d 个账户，跳过 %d 个已存在的账户",
+		"enter_import_password":      "请输入导入文件的主密码: ",
+		"merge_fields_header":        "不同的字段",
+		"merge_action_add":           "新增",
+		"merge_action_keep":          "冲突，保留本地",
+		"merge_action_replace":       "冲突，使用导入",
+		"merge_action_duplicate":     "冲突，两者都保留",
+		"merge_summary":              "新增 %d 个，替换 %d 个，另存副本 %d 个，保留本地 %d 个，%d 个相同",
+		"merge_success":              "合并完成：新增 %d 个账户，替换 %d 个账户",
+		"cmd_sync_short":             "与另一个保险库副本（如同步盘中的文件）进行三方同步",
+		"enter_remote_password":      "远程副本使用了不同的主密码，请输入远程副本的主密码: ",
+		"sync_first":                 "已在 %s 创建远程副本",
+		"sync_field_header":          "冲突字段",
+		"sync_winner_header":         "采用版本",
+		"sync_winner_local":          "本地",
+		"sync_winner_remote":         "远程",
+		"sync_success":               "同步完成：新增 %d 个，更新 %d 个，删除 %d 个，冲突 %d 个",
+		"sync_failed":                "同步失败: %v",
+		"cmd_git_short":              "用 git 记录保险库的历史并在设备间同步",
+		"cmd_git_init":               "将数据目录设为 git 仓库，可指定远程仓库地址",
+		"cmd_git_log":                "显示保险库的修改历史",
+		"cmd_git_push":               "推送到远程仓库（默认 origin）",
+		"cmd_git_pull":               "从远程仓库拉取，分叉时解密后合并",
+		"cmd_git_revert":             "撤销某次提交所做的修改（保留之后的修改）",
+		"opt_git_log_number":         "最多显示的提交数量（0 表示全部）",
+		"git_init_success":           "已将 %s 设为 git 仓库，之后每次保存都会创建一个提交",
+		"git_no_commits":             "还没有提交",
+		"git_commit_header":          "提交",
+		"git_date_header":            "时间",
+		"git_author_header":          "作者",
+		"git_message_header":         "说明",
+		"git_push_success":           "已推送到 %s",
+		"git_pull_up_to_date":        "已是最新",
+		"git_pull_fast_forward":      "已更新到远程版本",
+		"git_pull_merged":            "本地与远程都有修改，已解密后合并",
+		"git_merge_success":          "合并完成：新增 %d 个，更新 %d 个，删除 %d 个，冲突 %d 个",
+		"git_revert_commit":          "将撤销提交 %s: %s",
+		"git_revert_success":         "撤销完成：恢复 %d 个，更新 %d 个，删除 %d 个，冲突 %d 个",
+		"cmd_backup_short":           "管理存储后端保留的密码库备份",
+		"cmd_backup_list":            "列出密码库备份（最新的在前）",
+		"cmd_backup_restore":         "用备份替换当前密码库",
+		"backup_none":                "没有备份。",
+		"backup_name_header":         "名称",
+		"backup_date_header":         "替换时间",
+		"backup_size_header":         "大小（字节）",
+		"backup_restore_warning":     "当前密码库将被备份 %s 替换（当前密码库会另存为备份）。",
+		"backup_restore_success":     "已恢复备份 %s。请使用该备份的主密码解锁。",
+		"cmd_vault_short":            "管理命名的密码库",
+		"cmd_vault_list":             "列出所有密码库",
+		"cmd_vault_create":           "创建一个命名的密码库（之后用 init 设置主密码）",
+		"cmd_vault_use":              "设置默认使用的密码库",
+		"cmd_vault_remove":           "从列表中移除密码库",
+		"opt_data_dir":               "存放密码库列表和默认密码库的目录（默认 $%s 或 ~/.passwordmanager）",
+		"opt_vault":                  "要使用的密码库名称（默认为当前默认密码库）",
+		"opt_vault_dir":              "密码库的数据目录（默认为 <data-dir>/vaults/<名称>）",
+		"opt_vault_delete_files":     "同时删除密码库的文件",
+		"vault_name_header":          "名称",
+		"vault_dir_header":           "目录",
+		"vault_default_header":       "默认",
+		"vault_create_success":       "已创建密码库 %s（%s）。运行 'passwordmanager --vault %[1]s init' 设置主密码。",
+		"vault_use_success":          "默认密码库已设为 %s。",
+		"vault_remove_success":       "已移除密码库 %s。",
+		"vault_files_kept":           "数据目录 %s 已保留。",
+		"vault_delete_files_warning": "将永久删除 %s 及其中的所有文件（包括备份）。",
+		"cmd_config_short":           "查看和修改设置（命令行与图形界面共用）",
+		"cmd_config_list":            "列出所有设置及其取值范围",
+		"cmd_config_get":             "显示一项设置的值",
+		"cmd_config_set":             "修改一项设置",
+		"config_key_header":          "设置项",
+		"config_value_header":        "值",
+		"config_allowed_header":      "允许的值",
+		"config_description_header":  "说明",
+		"config_set_success":         "已将 %s 设为 %s",
+		"cmd_agent_short":            "管理解锁代理（在内存中保存已解锁密码库的密钥）",
+		"cmd_agent_start":            "在后台启动解锁代理",
+		"cmd_agent_stop":             "停止解锁代理并清除所有密钥",
+		"cmd_agent_status":           "显示解锁代理及其保存的密码库",
+		"cmd_unlock_short":           "解锁密码库并交给解锁代理，之后的命令不再询问主密码",
+		"cmd_lock_short":             "让解锁代理忘记密码库的密钥",
+		"opt_agent_foreground":       "在前台运行代理，直到被中断",
+		"opt_agent_ttl":              "代理保存密钥的时间，如 30m 或 2h（默认使用 security.agent_ttl_minutes）",
+		"opt_lock_all":               "锁定代理保存的所有密码库",
+		"agent_started":              "解锁代理已启动（进程 %d，套接字 %s）",
+		"agent_already_running":      "解锁代理已在运行（进程 %d，套接字 %s）",
+		"agent_not_running":          "解锁代理未运行。",
+		"agent_stopped":              "解锁代理（进程 %d）已停止，已清除 %d 个密钥。",
+		"agent_status":               "解锁代理正在运行（进程 %d，套接字 %s，默认保存 %s）",
+		"agent_no_vaults":            "没有已解锁的密码库。",
+		"agent_expires_header":       "锁定时间",
+		"agent_invalid_ttl":          "--ttl 不能为负数",
+		"agent_unlocked":             "密码库 %s 已解锁，将在 %s 自动锁定",
+		"agent_locked":               "密码库 %s 已锁定",
+		"agent_locked_all":           "所有密码库已锁定",
+		"import_status_new":          "新增",
+		"import_status_duplicate":    "重复（跳过）",
+
+		// 派生密码
+		"derive_site_required":  "必须通过 --site 指定网站，或提供账户 ID",
+		"derived_profile":       "网站: %s, 登录名: %s, 计数器: %d",
+		"derived_counter_saved": "账户 '%s' 的计数器已更新为 %d",
+		"account_not_derived":   "账户 '%s' 没有派生密码配置",
+		"password_is_derived":   "账户 '%s' 的密码由主密码派生，未被存储。请使用 'passwordmanager derive %s' 计算",
+		"derived_header":        "派生密码",
+
+		// 用户名和邮箱别名
+		"generate_username":    "是否生成随机用户名?",
+		"username_mode":        "用户名样式 (%s)",
+		"generate_email_alias": "是否生成邮箱别名?",
+		"choose_email_alias":   "选择别名模板编号",
+		"invalid_choice":       "无效的选择: %s",
+		"no_email_aliases":     "尚未配置邮箱别名模板。使用 'passwordmanager email-alias add <模板>' 添加",
+		"email_alias_added":    "已添加邮箱别名模板: %s",
+		"email_alias_removed":  "已删除邮箱别名模板: %s",
+		"email_alias_example":  "示例: %s",
 
 		// 表格标题
-		"id_header":       "ID",
-		"platform_header": "平台",
-		"username_header": "用户名",
-		"email_header":    "邮箱",
-		"created_at":      "创建时间",
-		"updated_at":      "更新时间",
+		"id_header":             "ID",
+		"platform_header":       "平台",
+		"username_header":       "用户名",
+		"email_header":          "邮箱",
+		"created_at":            "创建时间",
+		"updated_at":            "更新时间",
+		"password_rules_header": "密码规则",
+		"group_header":          "分组",
+		"status_header":         "状态",
 
 		// 应用名称和描述
 		"app_name":        "密码管理器",
@@ -125,21 +256,142 @@ var translations = map[Lang]map[string]string{
 		"cmd_password_short":        "显示特定账户的密码并复制到剪贴板",
 		"cmd_change_password_short": "更改主密码",
 		"cmd_export_short":          "导出加密的保险库到文件",
-		"cmd_import_short":          "从文件导入保险库（会覆盖现有数据！）",
+		"cmd_import_short":          "从文件导入保险库（会覆盖现有数据！便携导出文件则合并）",
 		"cmd_update_short":          "更新现有账户",
 		"cmd_search_short":          "按平台、用户名或邮箱搜索账户",
 		"cmd_export_csv_short":      "导出账户到CSV文件（明文密码！）",
+		"cmd_derive_short":          "由主密码、网站、登录名和计数器派生无状态密码",
+		"cmd_import_csv_short":      "从其他密码管理器导出的 CSV/JSON 文件导入账户",
+		"cmd_import_kdbx_short":     "从 KeePass/KeePassXC 的 KDBX 4 数据库导入账户",
+		"cmd_export_kdbx_short":     "将账户导出为 KeePass/KeePassXC 的 KDBX 4 数据库",
+		"cmd_email_alias_short":     "管理用于生成邮箱别名的模板",
+		"cmd_email_alias_list":      "列出邮箱别名模板",
+		"cmd_email_alias_add":       "添加邮箱别名模板，例如 \"team+{tag}-{rand}@example.com\"",
+		"cmd_email_alias_remove":    "按编号删除邮箱别名模板",
 
 		// 选项描述
-		"opt_lang":              "语言 (zh/en)",
-		"opt_length":            "密码长度",
-		"opt_no_lowercase":      "不使用小写字母",
-		"opt_no_uppercase":      "不使用大写字母",
-		"opt_no_digits":         "不使用数字",
-		"opt_no_symbols":        "不使用特殊符号",
-		"opt_exclude_similar":   "排除相似字符 (例如 l, 1, I, O, 0)",
-		"opt_exclude_ambiguous": "排除可能混淆的字符 (例如 {}, [], (), /)",
-		"opt_copy":              "直接复制到剪贴板",
+		"opt_lang":                   "语言 (zh/en)，覆盖 language 设置",
+		"opt_length":                 "密码长度",
+		"opt_no_lowercase":           "不使用小写字母",
+		"opt_no_uppercase":           "不使用大写字母",
+		"opt_no_digits":              "不使用数字",
+		"opt_no_symbols":             "不使用特殊符号",
+		"opt_exclude_similar":        "排除相似字符 (例如 l, 1, I, O, 0)",
+		"opt_exclude_ambiguous":      "排除可能混淆的字符 (例如 {}, [], (), /)",
+		"opt_min_lowercase":          "至少包含的小写字母数量",
+		"opt_min_uppercase":          "至少包含的大写字母数量",
+		"opt_min_digits":             "至少包含的数字数量",
+		"opt_min_symbols":            "至少包含的特殊符号数量",
+		"opt_mode":                   "生成模式 (%s)",
+		"opt_template":               "template 模式的模板，例如 \"Cvccvc-99-Cvccvc\"（%s）",
+		"opt_symbols":                "使用自定义特殊符号集合代替默认符号",
+		"opt_exclude":                "额外排除的字符",
+		"opt_include_only":           "只使用这些字符（忽略字符类型选项）",
+		"opt_extra_chars":            "额外加入的字符（支持任意 Unicode 字符）",
+		"opt_alphabet":               "加入非 ASCII 字母表 (%s)",
+		"opt_min_entropy":            "拒绝生成理论熵低于此位数的密码 (0 表示不限制)",
+		"opt_rules":                  "按网站密码规则生成 (passwordrules 语法，例如 \"maxlength: 12; required: digit; allowed: [!@#]\")",
+		"opt_site":                   "派生密码所用的网站，例如 example.com",
+		"opt_login":                  "派生密码所用的登录名",
+		"opt_counter":                "派生计数器，递增以更换密码",
+		"opt_save":                   "将派生配置保存为新账户（不保存密码本身）",
+		"opt_rotate":                 "递增账户保存的计数器并派生新密码",
+		"opt_import_format":          "导入文件格式 (%s)",
+		"opt_import_map":             "列映射，字段=列名，可重复 (字段: %s)",
+		"opt_import_group_map":       "文件夹到分组的映射，原文件夹=分组，可重复；映射为空表示不分组",
+		"opt_import_default_group":   "没有文件夹的账户使用的分组",
+		"opt_import_keep_duplicates": "同时导入与现有账户重复的条目",
+		"opt_dry_run":                "只预览，不写入保险库",
+		"opt_key_file":               "KeePass 密钥文件路径",
+		"opt_portable":               "导出为使用独立口令加密的便携 JSON 文件",
+		"opt_export_group":           "只导出这些分组（含子分组）的账户，可重复；隐含 --portable",
+		"opt_export_tag":             "只导出带这些标签的账户，可重复；隐含 --portable",
+		"opt_export_id":              "只导出这些 ID 的账户，可重复；隐含 --portable",
+		"opt_merge":                  "合并到当前保险库而不是覆盖它，导入文件使用它自己的密码解锁",
+		"opt_merge_strategy":         "冲突处理策略: %s（保留本地、使用导入、两者都保留、较新的优先）",
+		"opt_copy":                   "直接复制到剪贴板",
+		"opt_password_fd":            "从此文件描述符读取主密码的第一行（也可用 $%s），用于脚本",
+		"opt_platform":               "平台名称；给出任何账户字段参数时不再交互询问",
+		"opt_username":               "用户名",
+		"opt_email":                  "邮箱",
+		"opt_url":                    "网址",
+		"opt_notes":                  "备注",
+		"opt_group":                  "分组",
+		"opt_tag":                    "标签，可重复",
+		"opt_account_rules":          "网站密码规则（passwordrules 语法），--generate 时遵循",
+		"opt_password_stdin":         "从标准输入读取账户密码",
+		"opt_generate":               "按设置中的默认选项和网站规则生成账户密码",
+		"opt_yes":                    "不询问确认",
+		"opt_yes_show":               "不询问确认，直接显示密码",
+		"opt_output":                 "输出格式：text 或 json",
+		"no_master_password":         "没有可用的主密码：请先运行 'passwordmanager unlock'，或使用 --password-fd 或 $%s",
+		"password_stdin_empty":       "标准输入中没有密码",
+		"password_flag_required":     "请用 --password-stdin 或 --generate 提供密码",
+		"password_flags_conflict":    "--password-stdin 和 --generate 不能同时使用",
+		"invalid_output":             "未知的输出格式 %q（可用 text 或 json）",
+		"cmd_run_short":              "运行命令，并把密码库中的机密作为环境变量传给它",
+		"opt_run_env":                "设置环境变量 NAME=VALUE，值可以是 %s:// 机密引用，可重复",
+		"opt_no_mask":                "不在命令输出中隐藏机密",
+		"run_invalid_env":            "%q 不是 NAME=VALUE 形式",
+		"cmd_inject_short":           "填写模板中的机密引用（\"-\" 表示从标准输入读取）",
+		"opt_inject_out":             "写入此文件（权限 0600），而不是标准输出",
+		"opt_inject_strict":          "有任何引用无法解析时失败",
+		"inject_unresolved":          "警告: 未解析的引用: %v",
+		"inject_strict_failed":       "%d 个引用无法解析，未写入任何内容",
+		"inject_written":             "已写入 %s",
+		"cmd_git_credential_short":   "作为 git 凭据助手，按远程地址匹配账户的网址",
+		"pinentry_description":       "请输入 %s 中密码库的主密码",
+		"pinentry_prompt":            "主密码:",
+		"pinentry_retry":             "主密码错误，请重试",
+		"cmd_ssh_agent_short":        "运行 SSH 代理，提供密码库中的 SSH 密钥",
+		"opt_ssh_agent_socket":       "代理套接字路径（默认在数据目录中）",
+		"ssh_agent_started":          "SSH 代理已启动（进程 %d，套接字 %s），按 Ctrl+C 停止",
+		"ssh_confirm_description":    "允许使用 SSH 密钥 %s（%s）吗？",
+		"cmd_ssh_key_short":          "管理密码库中的 SSH 密钥",
+		"cmd_ssh_key_add":            "把私钥文件存入密码库",
+		"cmd_ssh_key_list":           "列出密码库中的 SSH 密钥",
+		"cmd_ssh_key_public":         "以 authorized_keys 格式显示公钥",
+		"opt_ssh_key_name":           "密钥名称（默认为文件名）",
+		"opt_ssh_key_confirm":        "每次使用前都要求确认",
+		"opt_ssh_key_lifetime":       "SSH 代理启动后提供此密钥的时长，如 1h（默认不限）",
+		"ssh_key_passphrase":         "请输入 %s 的密码短语: ",
+		"ssh_fingerprint_header":     "SSH 密钥指纹",
+		"ssh_confirm_header":         "确认",
+		"ssh_lifetime_header":        "有效期",
+		"ssh_confirm_each_use":       "每次使用",
+		"ssh_no_keys":                "密码库中没有 SSH 密钥，请用 'passwordmanager ssh-key add' 添加",
+		"cmd_serve_short":            "在本机通过 HTTP/JSON API 提供密码库",
+		"opt_serve_addr":             "监听的回环地址",
+		"opt_serve_socket":           "改为监听此 Unix 套接字",
+		"api_serving":                "API 正在 %s 上提供服务，按 Ctrl+C 停止",
+		"cmd_api_token_short":        "管理 API 令牌",
+		"cmd_api_token_create":       "创建 API 令牌",
+		"cmd_api_token_list":         "列出 API 令牌",
+		"cmd_api_token_revoke":       "吊销 API 令牌",
+		"opt_token_read_only":        "令牌只能读取，不能修改账户",
+		"opt_token_group":            "令牌只能访问此分组的账户",
+		"api_token_created":          "API 令牌已创建，它只显示这一次:",
+		"api_token_revoked":          "API 令牌 '%s' 已吊销",
+		"api_no_tokens":              "还没有 API 令牌，请用 'passwordmanager api-token create' 创建",
+		"api_token_name_header":      "名称",
+		"api_token_scope_header":     "范围",
+		"api_scope_full":             "完全访问",
+		"api_scope_read_only":        "只读",
+		"api_scope_group":            "%s，分组 %s",
+		"cmd_native_host_short":      "通过原生消息为浏览器扩展提供服务（由浏览器启动）",
+		"cmd_browser_short":          "连接浏览器扩展以自动填充",
+		"cmd_browser_install":        "为浏览器注册原生消息主机并允许一个扩展",
+		"cmd_browser_uninstall":      "移除浏览器的原生消息主机及其扩展",
+		"cmd_browser_list":           "列出允许使用密码库的浏览器扩展",
+		"browser_installed":          "原生消息主机已安装: %s",
+		"browser_install_hint":       "请重新加载扩展并完成配对；自动填充需要先用 'passwordmanager unlock' 解锁密码库",
+		"browser_uninstalled":        "已移除 %s 的原生消息主机（忘记了 %d 个扩展）",
+		"browser_no_extensions":      "还没有浏览器扩展，请用 'passwordmanager browser install' 连接",
+		"browser_header":             "浏览器",
+		"browser_paired_header":      "配对时间",
+		"browser_not_paired":         "尚未配对",
+		"native_pair_description":    "允许浏览器扩展 %s 填充 %s 中密码库的登录信息吗？\n\n只有扩展显示的配对码为 %s 时才允许。",
+		"derived_will_be_stored":     "派生密码由主密码计算得出。更改主密码后，派生账户的当前密码将保存在密码库中，不再派生。",
 
 		// 查看账户后缀提示
 		"view_password_hint":       "\n要查看某个账户的密码，请使用命令:\npasswordmanager password <ID>",
@@ -157,7 +409,7 @@ var translations = map[Lang]map[string]string{
 
 		// 主密码相关
 		"enter_master_password":         "Enter master password: ",
-		"enter_new_master_password":     "Enter new master password (at least 8 characters): ",
+		"enter_new_master_password":     "Enter new master password (at least %d characters): ",
 		"enter_current_master_password": "Enter current master password: ",
 		"confirm_master_password":       "Confirm master password: ",
 		"passwords_dont_match":          "Passwords don't match",
@@ -177,6 +429,7 @@ var translations = map[Lang]map[string]string{
 		"email":             "Enter email",
 		"url":               "Enter URL",
 		"notes":             "Enter notes",
+		"password_rules":    "Enter site password rules (optional, passwordrules syntax)",
 		"input_password":    "Enter password: ",
 		"confirm_password":  "Confirm password: ",
 
@@ -188,6 +441,7 @@ var translations = map[Lang]map[string]string{
 		"password_copied":          "Password copied to clipboard, will be cleared in %d seconds",
 		"password_copy_failed":     "Failed to copy to clipboard: %v",
 		"must_select_charset":      "Error: You must select at least one character type",
+		"password_entropy":         "Theoretical entropy: %.1f bits (charset of %d characters)",
 
 		// 账户操作
 		"account_added":           "Account '%s' added successfully (ID: %s)",
@@ -220,23 +474,152 @@ var translations = map[Lang]map[string]string{
 		"found_query_accounts":    "Found %d accounts matching '%s':",
 
 		// 导入导出
-		"export_success":     "Vault exported successfully to: %s",
-		"export_warning":     "Note: The export file contains all account data, keep it safe",
-		"export_exists":      "Export file already exists, overwrite?",
-		"import_warning":     "Warning: Importing will overwrite your existing vault data!",
-		"import_success":     "Vault imported successfully. Use its original master password to unlock.",
-		"import_failed":      "Failed to import vault: %v",
-		"csv_export_warning": "Warning: CSV export will contain plaintext passwords! This is a security risk.",
-		"csv_post_export":    "Security tip: Delete the file after use",
-		"csv_export_success": "Account data exported successfully to: %s",
+		"export_success":             "Vault exported successfully to: %s",
+		"export_warning":             "Note: The export file contains all account data, keep it safe",
+		"export_exists":              "Export file already exists, overwrite?",
+		"import_warning":             "Warning: Importing will overwrite your existing vault data!",
+		"import_success":             "Vault imported successfully. Use its original master password to unlock.",
+		"import_failed":              "Failed to import vault: %v",
+		"csv_export_warning":         "Warning: CSV export will contain plaintext passwords! This is a security risk.",
+		"csv_post_export":            "Security tip: Delete the file after use",
+		"csv_export_success":         "Account data exported successfully to: %s",
+		"import_summary":             "%d accounts to import, %d duplicates and %d non-login records skipped",
+		"import_csv_success":         "Imported %d accounts",
+		"import_kdbx_success":        "Imported %d accounts, skipped %d (already present or untitled)",
+		"export_kdbx_success":        "Exported %d accounts to KeePass database: %s",
+		"enter_kdbx_password":        "Enter the KeePass database password (press Enter if only a key file is used): ",
+		"enter_kdbx_new_password":    "Set a password for the KeePass database: ",
+		"confirm_kdbx_password":      "Confirm the KeePass database password: ",
+		"history_header":             "History",
+		"tags_header":                "Tags",
+		"enter_export_passphrase":    "Enter the passphrase for the export file: ",
+		"confirm_export_passphrase":  "Confirm the passphrase for the export file: ",
+		"export_portable_success":    "Exported %d accounts to encrypted portable file: %s",
+		"import_portable_success":    "Merged %d accounts, skipped %d already present",
+		"enter_import_password":      "Enter the master password of the import file: ",
+		"merge_fields_header":        "Differing fields",
+		"merge_action_add":           "New",
+		"merge_action_keep":          "Conflict, keep mine",
+		"merge_action_replace":       "Conflict, take theirs",
+		"merge_action_duplicate":     "Conflict, keep both",
+		"merge_summary":              "%d to add, %d to replace, %d to keep as copies, %d local versions kept, %d identical",
+		"merge_success":              "Merge complete: %d accounts added, %d replaced",
+		"cmd_sync_short":             "Three-way sync with another replica of the vault (e.g. a file in a synced folder)",
+		"enter_remote_password":      "The remote replica uses a different master password. Enter its master password: ",
+		"sync_first":                 "Created the remote replica at %s",
+		"sync_field_header":          "Conflicting Field",
+		"sync_winner_header":         "Kept Version",
+		"sync_winner_local":          "local",
+		"sync_winner_remote":         "remote",
+		"sync_success":               "Sync complete: %d added, %d updated, %d deleted, %d conflicts",
+		"sync_failed":                "Sync failed: %v",
+		"cmd_git_short":              "Keep the vault history in git and sync it between devices",
+		"cmd_git_init":               "Make the data directory a git repository, optionally with a remote URL",
+		"cmd_git_log":                "Show the history of vault changes",
+		"cmd_git_push":               "Push to a remote (default origin)",
+		"cmd_git_pull":               "Pull from a remote, merging diverged vaults after decrypting them",
+		"cmd_git_revert":             "Undo the change made by a commit, keeping later changes",
+		"opt_git_log_number":         "Maximum number of commits to show (0 for all)",
+		"git_init_success":           "%s is now a git repository; every save creates a commit",
+		"git_no_commits":             "No commits yet",
+		"git_commit_header":          "Commit",
+		"git_date_header":            "Date",
+		"git_author_header":          "Author",
+		"git_message_header":         "Message",
+		"git_push_success":           "Pushed to %s",
+		"git_pull_up_to_date":        "Already up to date",
+		"git_pull_fast_forward":      "Updated to the remote version",
+		"git_pull_merged":            "Both sides had changes; merged after decrypting them",
+		"git_merge_success":          "Merge complete: %d added, %d updated, %d deleted, %d conflicts",
+		"git_revert_commit":          "About to revert commit %s: %s",
+		"git_revert_success":         "Revert complete: %d restored, %d updated, %d deleted, %d conflicts",
+		"cmd_backup_short":           "Manage vault backups kept by the storage backend",
+		"cmd_backup_list":            "List vault backups, newest first",
+		"cmd_backup_restore":         "Replace the current vault with a backup",
+		"backup_none":                "No backups.",
+		"backup_name_header":         "Name",
+		"backup_date_header":         "Replaced",
+		"backup_size_header":         "Size (bytes)",
+		"backup_restore_warning":     "The current vault will be replaced with backup %s (the current vault is kept as a backup).",
+		"backup_restore_success":     "Backup %s restored. Unlock with its master password.",
+		"cmd_vault_short":            "Manage named vaults",
+		"cmd_vault_list":             "List all vaults",
+		"cmd_vault_create":           "Create a named vault (then run init to set its master password)",
+		"cmd_vault_use":              "Set the vault used by default",
+		"cmd_vault_remove":           "Remove a vault from the list",
+		"opt_data_dir":               "Directory holding the vault list and the default vault (default $%s or ~/.passwordmanager)",
+		"opt_vault":                  "Name of the vault to use (default: the current default vault)",
+		"opt_vault_dir":              "Data directory of the vault (default <data-dir>/vaults/<name>)",
+		"opt_vault_delete_files":     "Also delete the vault's files",
+		"vault_name_header":          "Name",
+		"vault_dir_header":           "Directory",
+		"vault_default_header":       "Default",
+		"vault_create_success":       "Vault %s created in %s. Run 'passwordmanager --vault %[1]s init' to set its master password.",
+		"vault_use_success":          "Default vault set to %s.",
+		"vault_remove_success":       "Vault %s removed.",
+		"vault_files_kept":           "Its data directory %s was kept.",
+		"vault_delete_files_warning": "This permanently deletes %s and all files in it, including backups.",
+		"cmd_config_short":           "View and change settings (shared with the GUI)",
+		"cmd_config_list":            "List all settings with their allowed values",
+		"cmd_config_get":             "Show the value of a setting",
+		"cmd_config_set":             "Change a setting",
+		"config_key_header":          "Key",
+		"config_value_header":        "Value",
+		"config_allowed_header":      "Allowed",
+		"config_description_header":  "Description",
+		"config_set_success":         "%s set to %s",
+		"cmd_agent_short":            "Manage the unlock agent, which keeps keys of unlocked vaults in memory",
+		"cmd_agent_start":            "Start the unlock agent in the background",
+		"cmd_agent_stop":             "Stop the unlock agent and wipe all keys",
+		"cmd_agent_status":           "Show the unlock agent and the vaults it holds",
+		"cmd_unlock_short":           "Unlock the vault in the unlock agent so later commands don't ask for the master password",
+		"cmd_lock_short":             "Make the unlock agent forget the vault's key",
+		"opt_agent_foreground":       "Run the agent in the foreground until interrupted",
+		"opt_agent_ttl":              "How long the agent keeps the key, e.g. 30m or 2h (default security.agent_ttl_minutes)",
+		"opt_lock_all":               "Lock every vault held by the agent",
+		"agent_started":              "Unlock agent started (pid %d, socket %s)",
+		"agent_already_running":      "Unlock agent is already running (pid %d, socket %s)",
+		"agent_not_running":          "Unlock agent is not running.",
+		"agent_stopped":              "Unlock agent (pid %d) stopped; %d keys wiped.",
+		"agent_status":               "Unlock agent is running (pid %d, socket %s, keys kept for %s by default)",
+		"agent_no_vaults":            "No vaults are unlocked.",
+		"agent_expires_header":       "Locks at",
+		"agent_invalid_ttl":          "--ttl must not be negative",
+		"agent_unlocked":             "Vault %s unlocked until %s",
+		"agent_locked":               "Vault %s locked",
+		"agent_locked_all":           "All vaults locked",
+		"import_status_new":          "New",
+		"import_status_duplicate":    "Duplicate (skipped)",
+
+		// Derived passwords
+		"derive_site_required":  "Specify the site with --site or give an account ID",
+		"derived_profile":       "Site: %s, login: %s, counter: %d",
+		"derived_counter_saved": "Counter for account '%s' is now %d",
+		"account_not_derived":   "Account '%s' has no derived password profile",
+		"password_is_derived":   "The password of account '%s' is derived from the master password and not stored. Use 'passwordmanager derive %s' to compute it",
+		"derived_header":        "Derived password",
+
+		// Usernames and email aliases
+		"generate_username":    "Generate a random username?",
+		"username_mode":        "Username style (%s)",
+		"generate_email_alias": "Generate an email alias?",
+		"choose_email_alias":   "Choose an alias template number",
+		"invalid_choice":       "Invalid choice: %s",
+		"no_email_aliases":     "No email alias templates configured. Add one with 'passwordmanager email-alias add <template>'",
+		"email_alias_added":    "Added email alias template: %s",
+		"email_alias_removed":  "Removed email alias template: %s",
+		"email_alias_example":  "Example: %s",
 
 		// 表格标题
-		"id_header":       "ID",
-		"platform_header": "Platform",
-		"username_header": "Username",
-		"email_header":    "Email",
-		"created_at":      "Created At",
-		"updated_at":      "Updated At",
+		"id_header":             "ID",
+		"platform_header":       "Platform",
+		"username_header":       "Username",
+		"email_header":          "Email",
+		"created_at":            "Created At",
+		"updated_at":            "Updated At",
+		"password_rules_header": "Password Rules",
+		"group_header":          "Group",
+		"status_header":         "Status",
 
 		// 应用名称和描述
 		"app_name":        "Password Manager",
@@ -252,21 +635,142 @@ var translations = map[Lang]map[string]string{
 		"cmd_password_short":        "Show or copy password for a specific account",
 		"cmd_change_password_short": "Change master password",
 		"cmd_export_short":          "Export encrypted vault to a file",
-		"cmd_import_short":          "Import vault from a file (overwrites existing data!)",
+		"cmd_import_short":          "Import vault from a file (overwrites existing data! Portable exports are merged)",
 		"cmd_update_short":          "Update an existing account",
 		"cmd_search_short":          "Search accounts by platform, username, or email",
 		"cmd_export_csv_short":      "Export accounts to CSV file (plaintext passwords!)",
+		"cmd_derive_short":          "Derive a stateless password from the master password, site, login and counter",
+		"cmd_import_csv_short":      "Import accounts from another password manager's CSV/JSON export",
+		"cmd_import_kdbx_short":     "Import accounts from a KeePass/KeePassXC KDBX 4 database",
+		"cmd_export_kdbx_short":     "Export accounts to a KeePass/KeePassXC KDBX 4 database",
+		"cmd_email_alias_short":     "Manage the templates used to generate email aliases",
+		"cmd_email_alias_list":      "List email alias templates",
+		"cmd_email_alias_add":       "Add an email alias template, e.g. \"team+{tag}-{rand}@example.com\"",
+		"cmd_email_alias_remove":    "Remove an email alias template by number",
 
 		// 选项描述
-		"opt_lang":              "Language (zh/en)",
-		"opt_length":            "Password length",
-		"opt_no_lowercase":      "Don't use lowercase letters",
-		"opt_no_uppercase":      "Don't use uppercase letters",
-		"opt_no_digits":         "Don't use digits",
-		"opt_no_symbols":        "Don't use symbols",
-		"opt_exclude_similar":   "Exclude similar characters (e.g. l, 1, I, O, 0)",
-		"opt_exclude_ambiguous": "Exclude ambiguous characters (e.g. {}, [], (), /)",
-		"opt_copy":              "Copy directly to clipboard",
+		"opt_lang":                   "Language (zh/en), overrides the language setting",
+		"opt_length":                 "Password length",
+		"opt_no_lowercase":           "Don't use lowercase letters",
+		"opt_no_uppercase":           "Don't use uppercase letters",
+		"opt_no_digits":              "Don't use digits",
+		"opt_no_symbols":             "Don't use symbols",
+		"opt_exclude_similar":        "Exclude similar characters (e.g. l, 1, I, O, 0)",
+		"opt_exclude_ambiguous":      "Exclude ambiguous characters (e.g. {}, [], (), /)",
+		"opt_min_lowercase":          "Minimum number of lowercase letters",
+		"opt_min_uppercase":          "Minimum number of uppercase letters",
+		"opt_min_digits":             "Minimum number of digits",
+		"opt_min_symbols":            "Minimum number of symbols",
+		"opt_mode":                   "Generation mode (%s)",
+		"opt_template":               "Pattern for template mode, e.g. \"Cvccvc-99-Cvccvc\" (%s)",
+		"opt_symbols":                "Custom symbol set to use instead of the default symbols",
+		"opt_exclude":                "Additional characters to exclude",
+		"opt_include_only":           "Use only these characters (ignores the character type options)",
+		"opt_extra_chars":            "Additional characters to include (any Unicode characters)",
+		"opt_alphabet":               "Add non-ASCII alphabets (%s)",
+		"opt_min_entropy":            "Refuse to generate passwords below this many bits of entropy (0 = no limit)",
+		"opt_rules":                  "Generate for site password rules (passwordrules syntax, e.g. \"maxlength: 12; required: digit; allowed: [!@#]\")",
+		"opt_site":                   "Site to derive the password for, e.g. example.com",
+		"opt_login":                  "Login to derive the password for",
+		"opt_counter":                "Derivation counter; increment it to change the password",
+		"opt_save":                   "Save the derivation profile as a new account (the password itself is not stored)",
+		"opt_rotate":                 "Increment the account's stored counter and derive the new password",
+		"opt_import_format":          "Import file format (%s)",
+		"opt_import_map":             "Column mapping as field=Column, repeatable (fields: %s)",
+		"opt_import_group_map":       "Folder to group mapping as Folder=Group, repeatable; map to nothing to drop the folder",
+		"opt_import_default_group":   "Group for accounts without a folder",
+		"opt_import_keep_duplicates": "Also import entries that duplicate existing accounts",
+		"opt_dry_run":                "Only preview; do not write to the vault",
+		"opt_key_file":               "Path to a KeePass key file",
+		"opt_portable":               "Export a portable JSON file encrypted with its own passphrase",
+		"opt_export_group":           "Only export accounts in these groups and their subgroups, repeatable; implies --portable",
+		"opt_export_tag":             "Only export accounts with these tags, repeatable; implies --portable",
+		"opt_export_id":              "Only export accounts with these IDs, repeatable; implies --portable",
+		"opt_merge":                  "Merge into the current vault instead of replacing it; the file is unlocked with its own password",
+		"opt_merge_strategy":         "Conflict strategy: %s (keep mine, keep theirs, keep both, newest wins)",
+		"opt_copy":                   "Copy directly to clipboard",
+		"opt_password_fd":            "Read the master password from the first line of this file descriptor (or use $%s), for scripts",
+		"opt_platform":               "Platform name; any account field flag turns off the prompts",
+		"opt_username":               "Username",
+		"opt_email":                  "Email",
+		"opt_url":                    "URL",
+		"opt_notes":                  "Notes",
+		"opt_group":                  "Group",
+		"opt_tag":                    "Tag, repeatable",
+		"opt_account_rules":          "Site password rules (passwordrules syntax), honoured by --generate",
+		"opt_password_stdin":         "Read the account password from standard input",
+		"opt_generate":               "Generate the account password with the default settings and site rules",
+		"opt_yes":                    "Do not ask for confirmation",
+		"opt_yes_show":               "Show the password without asking for confirmation",
+		"opt_output":                 "Output format: text or json",
+		"no_master_password":         "no master password available: run 'passwordmanager unlock' first, or use --password-fd or $%s",
+		"password_stdin_empty":       "no password on standard input",
+		"password_flag_required":     "give the password with --password-stdin or --generate",
+		"password_flags_conflict":    "--password-stdin and --generate cannot be used together",
+		"invalid_output":             "unknown output format %q (use text or json)",
+		"cmd_run_short":              "Run a command with vault secrets in its environment",
+		"opt_run_env":                "Set an environment variable NAME=VALUE; the value may be a %s:// secret reference, repeatable",
+		"opt_no_mask":                "Do not mask secrets in the command's output",
+		"run_invalid_env":            "%q is not of the form NAME=VALUE",
+		"cmd_inject_short":           "Fill in the secret references of a template (\"-\" reads standard input)",
+		"opt_inject_out":             "Write to this file (with 0600 permissions) instead of standard output",
+		"opt_inject_strict":          "Fail if any reference cannot be resolved",
+		"inject_unresolved":          "Warning: unresolved reference: %v",
+		"inject_strict_failed":       "%d references could not be resolved; nothing was written",
+		"inject_written":             "Wrote %s",
+		"cmd_git_credential_short":   "Act as a git credential helper, matching remotes against account URLs",
+		"pinentry_description":       "Enter the master password of the vault in %s",
+		"pinentry_prompt":            "Master password:",
+		"pinentry_retry":             "Wrong master password, please try again",
+		"cmd_ssh_agent_short":        "Run an SSH agent serving the SSH keys in the vault",
+		"opt_ssh_agent_socket":       "Path of the agent socket (default: in the data directory)",
+		"ssh_agent_started":          "SSH agent started (pid %d, socket %s); press Ctrl+C to stop",
+		"ssh_confirm_description":    "Allow use of the SSH key %s (%s)?",
+		"cmd_ssh_key_short":          "Manage SSH keys in the vault",
+		"cmd_ssh_key_add":            "Store a private key file in the vault",
+		"cmd_ssh_key_list":           "List the SSH keys in the vault",
+		"cmd_ssh_key_public":         "Show a public key in authorized_keys format",
+		"opt_ssh_key_name":           "Name of the key (default: the file name)",
+		"opt_ssh_key_confirm":        "Ask for confirmation before every use",
+		"opt_ssh_key_lifetime":       "How long the SSH agent serves the key after it starts, e.g. 1h (default: no limit)",
+		"ssh_key_passphrase":         "Enter the passphrase of %s: ",
+		"ssh_fingerprint_header":     "SSH key fingerprint",
+		"ssh_confirm_header":         "Confirm",
+		"ssh_lifetime_header":        "Lifetime",
+		"ssh_confirm_each_use":       "each use",
+		"ssh_no_keys":                "No SSH keys in the vault. Add one with 'passwordmanager ssh-key add'",
+		"cmd_serve_short":            "Serve the vault over a local HTTP/JSON API",
+		"opt_serve_addr":             "Loopback address to listen on",
+		"opt_serve_socket":           "Listen on this Unix socket instead",
+		"api_serving":                "Serving the API on %s; press Ctrl+C to stop",
+		"cmd_api_token_short":        "Manage API tokens",
+		"cmd_api_token_create":       "Create an API token",
+		"cmd_api_token_list":         "List API tokens",
+		"cmd_api_token_revoke":       "Revoke an API token",
+		"opt_token_read_only":        "The token can read but not change accounts",
+		"opt_token_group":            "The token can only access accounts of this group",
+		"api_token_created":          "API token created. It is shown only this once:",
+		"api_token_revoked":          "API token '%s' revoked",
+		"api_no_tokens":              "No API tokens yet. Create one with 'passwordmanager api-token create'",
+		"api_token_name_header":      "Name",
+		"api_token_scope_header":     "Scope",
+		"api_scope_full":             "full access",
+		"api_scope_read_only":        "read-only",
+		"api_scope_group":            "%s, group %s",
+		"cmd_native_host_short":      "Serve a browser extension over native messaging (started by the browser)",
+		"cmd_browser_short":          "Connect browser extensions for autofill",
+		"cmd_browser_install":        "Register the native messaging host for a browser and allow an extension",
+		"cmd_browser_uninstall":      "Remove the native messaging host of a browser and its extensions",
+		"cmd_browser_list":           "List the browser extensions allowed to use the vault",
+		"browser_installed":          "Native messaging host installed: %s",
+		"browser_install_hint":       "Reload the extension and pair it. Autofill needs the vault unlocked with 'passwordmanager unlock'",
+		"browser_uninstalled":        "Native messaging host of %s removed (%d extensions forgotten)",
+		"browser_no_extensions":      "No browser extensions yet. Connect one with 'passwordmanager browser install'",
+		"browser_header":             "Browser",
+		"browser_paired_header":      "Paired",
+		"browser_not_paired":         "not yet",
+		"native_pair_description":    "Allow the browser extension %s to fill in logins from the vault in %s?\n\nOnly allow it if the extension shows the pairing code %s.",
+		"derived_will_be_stored":     "Derived passwords are computed from the master password. After the change, the current passwords of derived accounts are stored in the vault instead of derived.",
 
 		// 查看账户后缀提示
 		"view_password_hint":       "\nTo view an account's password, use command:\npasswordmanager password <ID>",
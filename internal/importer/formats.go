@@ -0,0 +1,306 @@
+package importer
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/simp-lee/passwordmanager/internal/errors"
+)
+
+// Supported export formats.
+const (
+	FormatBitwardenCSV  = "bitwarden-csv"
+	FormatBitwardenJSON = "bitwarden-json"
+	Format1PasswordCSV  = "1password-csv"
+	FormatLastPassCSV   = "lastpass-csv"
+	FormatChromeCSV     = "chrome-csv"
+	FormatFirefoxCSV    = "firefox-csv"
+	FormatNativeCSV     = "passwordmanager-csv" // Written by storage.ExportToCSV
+	FormatGenericCSV    = "csv"                 // Any CSV; columns are guessed from common names or mapped
+)
+
+// Formats returns the names accepted in Options.Format.
+func Formats() []string {
+	return []string{
+		FormatBitwardenCSV, FormatBitwardenJSON, Format1PasswordCSV, FormatLastPassCSV,
+		FormatChromeCSV, FormatFirefoxCSV, FormatNativeCSV, FormatGenericCSV,
+	}
+}
+
+// csvFormat describes the columns of a CSV export.
+type csvFormat struct {
+	columns        map[string][]string                // Field -> accepted headers, compared case-insensitively
+	required       []string                           // Fields whose column must be present
+	groupSeparator string                             // Folder separator to convert to "/"
+	firstGroup     bool                               // The group column is a list; use its first item
+	skip           func(row func(string) string) bool // Reports records that are not logins
+}
+
+var csvFormats = map[string]csvFormat{
+	FormatBitwardenCSV: {
+		columns: map[string][]string{
+			FieldPlatform: {"name"},
+			FieldUsername: {"login_username"},
+			FieldPassword: {"login_password"},
+			FieldURL:      {"login_uri"},
+			FieldNotes:    {"notes"},
+			FieldGroup:    {"folder"},
+		},
+		required: []string{FieldPlatform, FieldPassword},
+		skip: func(row func(string) string) bool {
+			kind := row("type")
+			return kind != "" && kind != "login"
+		},
+	},
+	Format1PasswordCSV: {
+		columns: map[string][]string{
+			FieldPlatform: {"title"},
+			FieldUsername: {"username"},
+			FieldPassword: {"password"},
+			FieldURL:      {"url", "website", "urls"},
+			FieldNotes:    {"notes", "notesplain"},
+			FieldGroup:    {"tags"},
+		},
+		required:   []string{FieldPlatform, FieldPassword},
+		firstGroup: true,
+	},
+	FormatLastPassCSV: {
+		columns: map[string][]string{
+			FieldPlatform: {"name"},
+			FieldUsername: {"username"},
+			FieldPassword: {"password"},
+			FieldURL:      {"url"},
+			FieldNotes:    {"extra"},
+			FieldGroup:    {"grouping"},
+		},
+		required:       []string{FieldURL, FieldPassword},
+		groupSeparator: `\`,
+		skip: func(row func(string) string) bool {
+			return row("url") == "http://sn" // Secure notes
+		},
+	},
+	FormatChromeCSV: {
+		columns: map[string][]string{
+			FieldPlatform: {"name"},
+			FieldUsername: {"username"},
+			FieldPassword: {"password"},
+			FieldURL:      {"url"},
+			FieldNotes:    {"note"},
+		},
+		required: []string{FieldURL, FieldPassword},
+	},
+	FormatFirefoxCSV: {
+		columns: map[string][]string{
+			FieldUsername: {"username"},
+			FieldPassword: {"password"},
+			FieldURL:      {"url"},
+		},
+		required: []string{FieldURL, FieldPassword},
+		skip: func(row func(string) string) bool {
+			return strings.HasPrefix(row("url"), "chrome://") // Firefox account entries
+		},
+	},
+	FormatNativeCSV: {
+		columns: map[string][]string{
+			FieldPlatform: {"platform"},
+			FieldUsername: {"username"},
+			FieldEmail:    {"email"},
+			FieldPassword: {"password"},
+			FieldURL:      {"url"},
+			FieldNotes:    {"notes"},
+			FieldGroup:    {"group"},
+		},
+		required: []string{FieldPlatform, FieldPassword},
+	},
+	FormatGenericCSV: {
+		columns: map[string][]string{
+			FieldPlatform: {"platform", "name", "title"},
+			FieldUsername: {"username", "login", "login_username", "user"},
+			FieldEmail:    {"email", "e-mail"},
+			FieldPassword: {"password", "login_password", "pass"},
+			FieldURL:      {"url", "login_uri", "website", "uri"},
+			FieldNotes:    {"notes", "note", "extra", "comments"},
+			FieldGroup:    {"group", "folder", "grouping", "category"},
+		},
+		required: []string{FieldPassword},
+	},
+}
+
+// parseCSV reads a CSV export with a header row. columns overrides the
+// format's header names per field.
+func parseCSV(r io.Reader, format csvFormat, columns map[string]string) ([]Entry, int, error) {
+	reader := csv.NewReader(skipBOM(r))
+	reader.FieldsPerRecord = -1 // Exports are not always rectangular
+	reader.LazyQuotes = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, errors.Wrap(errors.ErrImportFormat, fmt.Sprintf("failed to read CSV header: %v", err))
+	}
+	index := make(map[string]int, len(header))
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if _, ok := index[name]; !ok {
+			index[name] = i
+		}
+	}
+
+	// Resolve the column of every field
+	fieldColumn := make(map[string]int)
+	for field, names := range format.columns {
+		for _, name := range names {
+			if i, ok := index[name]; ok {
+				fieldColumn[field] = i
+				break
+			}
+		}
+	}
+	for field, name := range columns {
+		i, ok := index[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			return nil, 0, errors.Wrap(errors.ErrImportFormat, fmt.Sprintf("column %q for %s not found", name, field))
+		}
+		fieldColumn[field] = i
+	}
+	for _, field := range format.required {
+		if _, ok := fieldColumn[field]; !ok {
+			return nil, 0, errors.Wrap(errors.ErrImportFormat, fmt.Sprintf("no column for %s; check the format or map it", field))
+		}
+	}
+
+	var entries []Entry
+	skipped := 0
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, errors.Wrap(errors.ErrImportFormat, fmt.Sprintf("failed to read CSV record: %v", err))
+		}
+
+		raw := func(i int, ok bool) string {
+			if !ok || i >= len(record) {
+				return ""
+			}
+			return record[i]
+		}
+		get := func(field string) string {
+			i, ok := fieldColumn[field]
+			return strings.TrimSpace(raw(i, ok))
+		}
+		byHeader := func(name string) string {
+			i, ok := index[name]
+			return strings.TrimSpace(raw(i, ok))
+		}
+
+		if format.skip != nil && format.skip(byHeader) {
+			skipped++
+			continue
+		}
+
+		group := get(FieldGroup)
+		if format.firstGroup {
+			group, _, _ = strings.Cut(group, ",")
+		}
+		if format.groupSeparator != "" {
+			group = strings.ReplaceAll(group, format.groupSeparator, "/")
+		}
+
+		entries = append(entries, Entry{
+			Platform: get(FieldPlatform),
+			Username: get(FieldUsername),
+			Email:    get(FieldEmail),
+			Password: raw(fieldColumn[FieldPassword], true), // Spaces may be part of a password
+			URL:      get(FieldURL),
+			Notes:    get(FieldNotes),
+			Group:    strings.TrimSpace(group),
+		})
+	}
+	return entries, skipped, nil
+}
+
+// skipBOM drops a leading UTF-8 byte order mark, as written by ExportToCSV.
+func skipBOM(r io.Reader) io.Reader {
+	buffered := bufio.NewReader(r)
+	if bom, err := buffered.Peek(3); err == nil && string(bom) == "\xef\xbb\xbf" {
+		buffered.Discard(3)
+	}
+	return buffered
+}
+
+// bitwardenExport is the subset of Bitwarden's unencrypted JSON export we read.
+type bitwardenExport struct {
+	Encrypted bool `json:"encrypted"`
+	Folders   []struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"folders"`
+	Items []struct {
+		Type     int     `json:"type"` // 1 = login
+		Name     string  `json:"name"`
+		Notes    *string `json:"notes"`
+		FolderID *string `json:"folderId"`
+		Login    *struct {
+			Username *string `json:"username"`
+			Password *string `json:"password"`
+			URIs     []struct {
+				URI string `json:"uri"`
+			} `json:"uris"`
+		} `json:"login"`
+	} `json:"items"`
+}
+
+// parseBitwardenJSON reads an unencrypted Bitwarden JSON export.
+func parseBitwardenJSON(r io.Reader) ([]Entry, int, error) {
+	var export bitwardenExport
+	if err := json.NewDecoder(skipBOM(r)).Decode(&export); err != nil {
+		return nil, 0, errors.Wrap(errors.ErrImportFormat, fmt.Sprintf("failed to parse Bitwarden JSON: %v", err))
+	}
+	if export.Encrypted {
+		return nil, 0, errors.Wrap(errors.ErrImportFormat, "encrypted Bitwarden exports are not supported; export as unencrypted JSON")
+	}
+
+	folders := make(map[string]string, len(export.Folders))
+	for _, folder := range export.Folders {
+		folders[folder.ID] = folder.Name
+	}
+	value := func(s *string) string {
+		if s == nil {
+			return ""
+		}
+		return strings.TrimSpace(*s)
+	}
+
+	var entries []Entry
+	skipped := 0
+	for _, item := range export.Items {
+		if item.Type != 1 || item.Login == nil {
+			skipped++
+			continue
+		}
+		var password string
+		if item.Login.Password != nil {
+			password = *item.Login.Password // Spaces may be part of a password
+		}
+		entry := Entry{
+			Platform: strings.TrimSpace(item.Name),
+			Username: value(item.Login.Username),
+			Password: password,
+			Notes:    value(item.Notes),
+			Group:    folders[value(item.FolderID)],
+		}
+		if len(item.Login.URIs) > 0 {
+			entry.URL = strings.TrimSpace(item.Login.URIs[0].URI)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, skipped, nil
+}
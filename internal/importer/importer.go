@@ -0,0 +1,216 @@
+// Package importer reads credential exports from other password managers and
+// turns them into vault accounts.
+package importer
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/simp-lee/passwordmanager/internal/crypto"
+	"github.com/simp-lee/passwordmanager/internal/errors"
+	"github.com/simp-lee/passwordmanager/internal/model"
+	"github.com/simp-lee/passwordmanager/internal/storage"
+)
+
+// Fields that a column mapping can assign (see Options.Columns).
+const (
+	FieldPlatform = "platform"
+	FieldUsername = "username"
+	FieldEmail    = "email"
+	FieldPassword = "password"
+	FieldURL      = "url"
+	FieldNotes    = "notes"
+	FieldGroup    = "group"
+)
+
+// Entry is one credential read from an export, before it is encrypted.
+type Entry struct {
+	Platform string
+	Username string
+	Email    string
+	Password string
+	URL      string
+	Notes    string
+	Group    string
+}
+
+// Options controls how an export is read and merged.
+type Options struct {
+	Format         string            // One of Formats()
+	Columns        map[string]string // Field -> source column header, overriding the format's defaults (CSV only)
+	Groups         map[string]string // Source folder -> vault group; map a folder to "" to drop it
+	DefaultGroup   string            // Group for entries without a folder
+	KeepDuplicates bool              // Also import entries that match an existing account or an earlier entry
+}
+
+// Preview is the outcome of an import before anything is written.
+type Preview struct {
+	Entries    []Entry // Entries that will be imported
+	Duplicates []Entry // Entries skipped as duplicates
+	Skipped    int     // Records that are not logins (secure notes, cards, ...) or are empty
+}
+
+// Fields returns the field names accepted in Options.Columns.
+func Fields() []string {
+	return []string{FieldPlatform, FieldUsername, FieldEmail, FieldPassword, FieldURL, FieldNotes, FieldGroup}
+}
+
+// Parse reads an export in options.Format and returns its login entries and
+// the number of records that were skipped. Folders are already translated to
+// vault groups.
+func Parse(r io.Reader, options Options) ([]Entry, int, error) {
+	for field := range options.Columns {
+		if !slices.Contains(Fields(), field) {
+			return nil, 0, errors.Wrap(errors.ErrImportFormat, fmt.Sprintf("unknown field %q in column mapping", field))
+		}
+	}
+
+	var entries []Entry
+	var skipped int
+	var err error
+	if options.Format == FormatBitwardenJSON {
+		entries, skipped, err = parseBitwardenJSON(r)
+	} else {
+		format, ok := csvFormats[options.Format]
+		if !ok {
+			return nil, 0, errors.Wrap(errors.ErrImportFormat, fmt.Sprintf("unknown format %q", options.Format))
+		}
+		entries, skipped, err = parseCSV(r, format, options.Columns)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// Fill in missing platforms and translate folders
+	kept := entries[:0]
+	for _, entry := range entries {
+		if entry.Platform == "" {
+			entry.Platform = hostOf(entry.URL)
+		}
+		if entry.Platform == "" {
+			entry.Platform = entry.Username
+		}
+		if entry.Platform == "" {
+			skipped++
+			continue
+		}
+		entry.Group = translateGroup(entry.Group, options)
+		kept = append(kept, entry)
+	}
+	return kept, skipped, nil
+}
+
+// Plan splits entries into those to import and duplicates. An entry is a
+// duplicate of an existing account or an earlier entry when both have the same
+// site (the URL host, or the platform name when there is no URL) and username,
+// compared case-insensitively.
+func Plan(entries []Entry, existing []*model.Account, options Options) *Preview {
+	seen := make(map[string]bool, len(existing)+len(entries))
+	for _, account := range existing {
+		seen[duplicateKey(account.Platform, account.Username, account.URL)] = true
+	}
+
+	preview := &Preview{}
+	for _, entry := range entries {
+		key := duplicateKey(entry.Platform, entry.Username, entry.URL)
+		if seen[key] && !options.KeepDuplicates {
+			preview.Duplicates = append(preview.Duplicates, entry)
+			continue
+		}
+		seen[key] = true
+		preview.Entries = append(preview.Entries, entry)
+	}
+	return preview
+}
+
+// Load reads the export at path and plans it against the existing accounts.
+func Load(path string, existing []*model.Account, options Options) (*Preview, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open import file")
+	}
+	defer file.Close()
+
+	entries, skipped, err := Parse(file, options)
+	if err != nil {
+		return nil, err
+	}
+	preview := Plan(entries, existing, options)
+	preview.Skipped = skipped
+	return preview, nil
+}
+
+// Commit encrypts the previewed entries with the vault key and adds them to
+// the unlocked vault in one save. Returns the number of accounts added.
+func Commit(store *storage.Storage, preview *Preview) (int, error) {
+	key := store.GetEncryptionKey()
+	if key == nil {
+		return 0, errors.ErrVaultLocked
+	}
+
+	accounts := make([]*model.Account, 0, len(preview.Entries))
+	for _, entry := range preview.Entries {
+		encryptedPassword, err := crypto.Encrypt([]byte(entry.Password), key)
+		if err != nil {
+			return 0, errors.Wrap(err, fmt.Sprintf("failed to encrypt password for %s", entry.Platform))
+		}
+		accounts = append(accounts, &model.Account{
+			ID:                crypto.GenerateID(),
+			Platform:          entry.Platform,
+			Username:          entry.Username,
+			Email:             entry.Email,
+			EncryptedPassword: encryptedPassword,
+			URL:               entry.URL,
+			Notes:             entry.Notes,
+			Group:             entry.Group,
+		})
+	}
+
+	if err := store.AddAccounts(accounts); err != nil {
+		return 0, err
+	}
+	return len(accounts), nil
+}
+
+// translateGroup normalises a folder path to "/" separators and applies the
+// folder mapping and default group.
+func translateGroup(folder string, options Options) string {
+	folder = strings.Trim(strings.TrimSpace(folder), "/")
+	if group, ok := options.Groups[folder]; ok {
+		return group
+	}
+	if folder == "" {
+		return options.DefaultGroup
+	}
+	return folder
+}
+
+// duplicateKey identifies an account for de-duplication.
+func duplicateKey(platform, username, rawURL string) string {
+	site := hostOf(rawURL)
+	if site == "" {
+		site = strings.ToLower(strings.TrimSpace(platform))
+	}
+	return site + "\x00" + strings.ToLower(strings.TrimSpace(username))
+}
+
+// hostOf returns the lowercase host of a URL without a leading "www.", or ""
+// when rawURL has no host. A missing scheme is tolerated.
+func hostOf(rawURL string) string {
+	rawURL = strings.TrimSpace(rawURL)
+	if rawURL == "" {
+		return ""
+	}
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "https://" + rawURL
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+}
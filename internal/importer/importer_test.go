@@ -0,0 +1,190 @@
+package importer
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/simp-lee/passwordmanager/internal/crypto"
+	"github.com/simp-lee/passwordmanager/internal/errors"
+	"github.com/simp-lee/passwordmanager/internal/model"
+	"github.com/simp-lee/passwordmanager/internal/storage"
+)
+
+func TestParseFormats(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		input   string
+		want    []Entry
+		skipped int
+	}{
+		{
+			name:   "Bitwarden CSV",
+			format: FormatBitwardenCSV,
+			input: "folder,favorite,type,name,notes,fields,reprompt,login_uri,login_username,login_password,login_totp\n" +
+				"Work/Dev,,login,GitHub,my notes,,0,https://github.com/login,octo,s3cret ,\n" +
+				",,note,Secret note,text,,0,,,,\n",
+			want:    []Entry{{Platform: "GitHub", Username: "octo", Password: "s3cret ", URL: "https://github.com/login", Notes: "my notes", Group: "Work/Dev"}},
+			skipped: 1,
+		},
+		{
+			name:   "Bitwarden JSON",
+			format: FormatBitwardenJSON,
+			input: `{"encrypted": false,
+				"folders": [{"id": "f1", "name": "Personal"}],
+				"items": [
+					{"type": 1, "name": "Mail", "notes": null, "folderId": "f1",
+					 "login": {"username": "me", "password": "pw", "uris": [{"uri": "https://mail.example.com"}]}},
+					{"type": 3, "name": "Card", "card": {}}
+				]}`,
+			want:    []Entry{{Platform: "Mail", Username: "me", Password: "pw", URL: "https://mail.example.com", Group: "Personal"}},
+			skipped: 1,
+		},
+		{
+			name:   "1Password CSV",
+			format: Format1PasswordCSV,
+			input: "Title,Url,Username,Password,OTPAuth,Favorite,Archived,Tags,Notes\n" +
+				"Bank,https://bank.example,alice,pw1,,false,false,\"Finance,Important\",pin 1234\n",
+			want: []Entry{{Platform: "Bank", Username: "alice", Password: "pw1", URL: "https://bank.example", Notes: "pin 1234", Group: "Finance"}},
+		},
+		{
+			name:   "LastPass CSV",
+			format: FormatLastPassCSV,
+			input: "url,username,password,totp,extra,name,grouping,fav\n" +
+				"https://shop.example,bob,pw2,,,Shop,Home\\Shopping,0\n" +
+				"http://sn,,,,note body,Wifi,Home,0\n",
+			want:    []Entry{{Platform: "Shop", Username: "bob", Password: "pw2", URL: "https://shop.example", Group: "Home/Shopping"}},
+			skipped: 1,
+		},
+		{
+			name:   "Chrome CSV",
+			format: FormatChromeCSV,
+			input: "name,url,username,password,note\n" +
+				"example.com,https://example.com/,carol,pw3,\n",
+			want: []Entry{{Platform: "example.com", Username: "carol", Password: "pw3", URL: "https://example.com/"}},
+		},
+		{
+			name:   "Firefox CSV",
+			format: FormatFirefoxCSV,
+			input: `"url","username","password","httpRealm","formActionOrigin","guid","timeCreated","timeLastUsed","timePasswordChanged"` + "\n" +
+				`"https://www.forum.example","dave","pw4",,"https://www.forum.example","{1}","1","1","1"` + "\n" +
+				`"chrome://FirefoxAccounts","x","y",,,"{2}","1","1","1"` + "\n",
+			want:    []Entry{{Platform: "forum.example", Username: "dave", Password: "pw4", URL: "https://www.forum.example"}},
+			skipped: 1,
+		},
+		{
+			name:   "Own CSV export",
+			format: FormatNativeCSV,
+			input: "\xef\xbb\xbfID,Platform,Username,Email,Password,URL,Notes,Group,SortOrder,CreatedAt,UpdatedAt\n" +
+				"abc,Gmail,erin,erin@example.com,pw5,https://mail.google.com,,Personal,1,2024-01-01T00:00:00Z,2024-01-01T00:00:00Z\n",
+			want: []Entry{{Platform: "Gmail", Username: "erin", Email: "erin@example.com", Password: "pw5", URL: "https://mail.google.com", Group: "Personal"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			entries, skipped, err := Parse(strings.NewReader(test.input), Options{Format: test.format})
+			require.NoError(t, err, "Failed to parse export")
+			assert.Equal(t, test.want, entries, "Entries mismatch")
+			assert.Equal(t, test.skipped, skipped, "Skipped count mismatch")
+		})
+	}
+}
+
+func TestParseOptions(t *testing.T) {
+	input := "Site,Login,Secret,Folder\n" +
+		"Forum,frank,pw,Old Folder\n" +
+		"Wiki,grace,pw,\n"
+
+	t.Run("Column and group mapping", func(t *testing.T) {
+		options := Options{
+			Format:       FormatGenericCSV,
+			Columns:      map[string]string{FieldPlatform: "Site", FieldUsername: "Login", FieldPassword: "Secret"},
+			Groups:       map[string]string{"Old Folder": "Community"},
+			DefaultGroup: "Imported",
+		}
+		entries, _, err := Parse(strings.NewReader(input), options)
+		require.NoError(t, err, "Failed to parse export")
+		require.Len(t, entries, 2)
+		assert.Equal(t, Entry{Platform: "Forum", Username: "frank", Password: "pw", Group: "Community"}, entries[0])
+		assert.Equal(t, "Imported", entries[1].Group, "Default group not applied")
+	})
+
+	invalid := []Options{
+		{Format: "keepass-xml"},
+		{Format: FormatGenericCSV},
+		{Format: FormatGenericCSV, Columns: map[string]string{FieldPassword: "Missing"}},
+		{Format: FormatGenericCSV, Columns: map[string]string{"totp": "Secret"}},
+	}
+	for _, options := range invalid {
+		_, _, err := Parse(strings.NewReader(input), options)
+		assert.ErrorIs(t, err, errors.ErrImportFormat, "Expected ErrImportFormat for %+v", options)
+	}
+
+	t.Run("Encrypted Bitwarden export", func(t *testing.T) {
+		_, _, err := Parse(strings.NewReader(`{"encrypted": true}`), Options{Format: FormatBitwardenJSON})
+		assert.ErrorIs(t, err, errors.ErrImportFormat)
+	})
+}
+
+func TestPlan(t *testing.T) {
+	existing := []*model.Account{
+		{Platform: "GitHub", Username: "octo", URL: "https://github.com"},
+		{Platform: "Notes app", Username: "me"},
+	}
+	entries := []Entry{
+		{Platform: "github.com", Username: "Octo", URL: "https://www.github.com/login"}, // Same host and username
+		{Platform: "notes app", Username: "ME"},                                         // Same platform without URL
+		{Platform: "GitLab", Username: "octo", URL: "https://gitlab.com"},
+		{Platform: "GitLab copy", Username: "octo", URL: "gitlab.com"}, // Duplicate within the file
+	}
+
+	preview := Plan(entries, existing, Options{})
+	assert.Equal(t, []Entry{entries[2]}, preview.Entries, "Unexpected entries to import")
+	assert.Len(t, preview.Duplicates, 3, "Unexpected duplicate count")
+
+	preview = Plan(entries, existing, Options{KeepDuplicates: true})
+	assert.Len(t, preview.Entries, 4, "Duplicates should be kept")
+	assert.Empty(t, preview.Duplicates)
+}
+
+func TestLoadAndCommit(t *testing.T) {
+	tmpDir := t.TempDir()
+	store, err := storage.New(tmpDir)
+	require.NoError(t, err, "Failed to create storage")
+	require.NoError(t, store.CreateVault("test-password"), "Failed to create vault")
+	require.NoError(t, store.UnlockVault("test-password"), "Failed to unlock vault")
+
+	path := filepath.Join(tmpDir, "chrome.csv")
+	input := "name,url,username,password\n" +
+		"a.example,https://a.example,ann,pw-a\n" +
+		"b.example,https://b.example,ben,pw-b\n"
+	require.NoError(t, os.WriteFile(path, []byte(input), 0600))
+
+	preview, err := Load(path, nil, Options{Format: FormatChromeCSV})
+	require.NoError(t, err, "Failed to load export")
+	require.Len(t, preview.Entries, 2)
+
+	added, err := Commit(store, preview)
+	require.NoError(t, err, "Failed to commit import")
+	assert.Equal(t, 2, added)
+
+	accounts, err := store.GetAccounts()
+	require.NoError(t, err)
+	require.Len(t, accounts, 2)
+	password, err := crypto.Decrypt(accounts[1].EncryptedPassword, store.GetEncryptionKey())
+	require.NoError(t, err, "Failed to decrypt imported password")
+	assert.Equal(t, "pw-b", string(password))
+	assert.Equal(t, 2, accounts[1].SortOrder, "Imported accounts should be appended in order")
+
+	// A second import of the same file only finds duplicates
+	preview, err = Load(path, accounts, Options{Format: FormatChromeCSV})
+	require.NoError(t, err)
+	assert.Empty(t, preview.Entries)
+	assert.Len(t, preview.Duplicates, 2)
+}
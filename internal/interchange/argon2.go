@@ -0,0 +1,287 @@
+package interchange
+
+import (
+	"encoding/binary"
+	"hash"
+	"math/bits"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// golang.org/x/crypto/argon2 only exposes Argon2i and Argon2id, but KeePassXC
+// creates KDBX 4 files with Argon2d by default. This is a straightforward
+// implementation of Argon2 version 1.3 (RFC 9106) that supports both modes a
+// KDBX file can name.
+
+const (
+	argon2d  = 0
+	argon2id = 2
+
+	argon2Version    = 0x13
+	argon2SyncPoints = 4
+	argon2BlockWords = 128 // 1 KiB blocks of 64-bit words
+)
+
+type argon2Block [argon2BlockWords]uint64
+
+// argon2Key derives keyLen bytes from password and salt. secret and data are
+// the optional key and associated data inputs; memory is in KiB.
+func argon2Key(mode int, password, salt, secret, data []byte, time, memory, threads, keyLen uint32) []byte {
+	if time < 1 {
+		time = 1
+	}
+	if threads < 1 {
+		threads = 1
+	}
+
+	h0 := argon2InitHash(mode, password, salt, secret, data, time, memory, threads, keyLen)
+	memory = memory / (argon2SyncPoints * threads) * (argon2SyncPoints * threads)
+	if memory < 2*argon2SyncPoints*threads {
+		memory = 2 * argon2SyncPoints * threads
+	}
+
+	blocks := argon2InitBlocks(&h0, memory, threads)
+	argon2Fill(blocks, mode, memory, time, threads)
+	return argon2Extract(blocks, memory, threads, keyLen)
+}
+
+// argon2InitHash computes H0 with 8 spare bytes for the block and lane numbers.
+func argon2InitHash(mode int, password, salt, secret, data []byte, time, memory, threads, keyLen uint32) [blake2b.Size + 8]byte {
+	var params [24]byte
+	binary.LittleEndian.PutUint32(params[0:], threads)
+	binary.LittleEndian.PutUint32(params[4:], keyLen)
+	binary.LittleEndian.PutUint32(params[8:], memory)
+	binary.LittleEndian.PutUint32(params[12:], time)
+	binary.LittleEndian.PutUint32(params[16:], argon2Version)
+	binary.LittleEndian.PutUint32(params[20:], uint32(mode))
+
+	b2, _ := blake2b.New512(nil)
+	b2.Write(params[:])
+	for _, input := range [][]byte{password, salt, secret, data} {
+		var length [4]byte
+		binary.LittleEndian.PutUint32(length[:], uint32(len(input)))
+		b2.Write(length[:])
+		b2.Write(input)
+	}
+
+	var h0 [blake2b.Size + 8]byte
+	b2.Sum(h0[:0])
+	return h0
+}
+
+// argon2InitBlocks allocates the memory and computes the first two blocks of every lane.
+func argon2InitBlocks(h0 *[blake2b.Size + 8]byte, memory, threads uint32) []argon2Block {
+	var buf [1024]byte
+	blocks := make([]argon2Block, memory)
+	for lane := uint32(0); lane < threads; lane++ {
+		j := lane * (memory / threads)
+		binary.LittleEndian.PutUint32(h0[blake2b.Size+4:], lane)
+		for i := uint32(0); i < 2; i++ {
+			binary.LittleEndian.PutUint32(h0[blake2b.Size:], i)
+			argon2Hash(buf[:], h0[:])
+			for k := range blocks[j+i] {
+				blocks[j+i][k] = binary.LittleEndian.Uint64(buf[k*8:])
+			}
+		}
+	}
+	return blocks
+}
+
+// argon2Fill runs every pass over the memory. Lanes are processed one after
+// another; segments of the same slice never reference each other, so the
+// result equals a parallel computation.
+func argon2Fill(blocks []argon2Block, mode int, memory, time, threads uint32) {
+	laneLength := memory / threads
+	segmentLength := laneLength / argon2SyncPoints
+
+	processSegment := func(pass, slice, lane uint32) {
+		var addresses, input, zero argon2Block
+		independent := mode == argon2id && pass == 0 && slice < argon2SyncPoints/2
+		if independent {
+			input[0] = uint64(pass)
+			input[1] = uint64(lane)
+			input[2] = uint64(slice)
+			input[3] = uint64(memory)
+			input[4] = uint64(time)
+			input[5] = uint64(mode)
+		}
+
+		index := uint32(0)
+		if pass == 0 && slice == 0 {
+			index = 2 // The first two blocks are already initialised
+			if independent {
+				input[6]++
+				argon2Compress(&addresses, &input, &zero, false)
+				argon2Compress(&addresses, &addresses, &zero, false)
+			}
+		}
+
+		offset := lane*laneLength + slice*segmentLength + index
+		for ; index < segmentLength; index, offset = index+1, offset+1 {
+			prev := offset - 1
+			if index == 0 && slice == 0 {
+				prev += laneLength // Wrap to the last block of the lane
+			}
+
+			var random uint64
+			if independent {
+				if index%argon2BlockWords == 0 {
+					input[6]++
+					argon2Compress(&addresses, &input, &zero, false)
+					argon2Compress(&addresses, &addresses, &zero, false)
+				}
+				random = addresses[index%argon2BlockWords]
+			} else {
+				random = blocks[prev][0]
+			}
+
+			ref := argon2RefIndex(random, laneLength, segmentLength, threads, pass, slice, lane, index)
+			argon2Compress(&blocks[offset], &blocks[prev], &blocks[ref], true)
+		}
+	}
+
+	for pass := uint32(0); pass < time; pass++ {
+		for slice := uint32(0); slice < argon2SyncPoints; slice++ {
+			for lane := uint32(0); lane < threads; lane++ {
+				processSegment(pass, slice, lane)
+			}
+		}
+	}
+}
+
+// argon2RefIndex maps a pseudo-random value to the block referenced by the
+// current position, following the reference-set rules of the specification.
+func argon2RefIndex(random uint64, laneLength, segmentLength, threads, pass, slice, lane, index uint32) uint32 {
+	refLane := uint32(random>>32) % threads
+	if pass == 0 && slice == 0 {
+		refLane = lane
+	}
+
+	area, start := 3*segmentLength, ((slice+1)%argon2SyncPoints)*segmentLength
+	if lane == refLane {
+		area += index
+	}
+	if pass == 0 {
+		area, start = slice*segmentLength, 0
+		if slice == 0 || lane == refLane {
+			area += index
+		}
+	}
+	if index == 0 || lane == refLane {
+		area--
+	}
+
+	x := random & 0xFFFFFFFF
+	x = (x * x) >> 32
+	x = (uint64(area) * x) >> 32
+	return refLane*laneLength + uint32((uint64(start)+uint64(area)-(x+1))%uint64(laneLength))
+}
+
+// argon2Extract XORs the last block of every lane and hashes it to the key.
+func argon2Extract(blocks []argon2Block, memory, threads, keyLen uint32) []byte {
+	laneLength := memory / threads
+	final := blocks[memory-1]
+	for lane := uint32(0); lane < threads-1; lane++ {
+		for i, word := range blocks[(lane+1)*laneLength-1] {
+			final[i] ^= word
+		}
+	}
+
+	var buf [1024]byte
+	for i, word := range final {
+		binary.LittleEndian.PutUint64(buf[i*8:], word)
+	}
+	key := make([]byte, keyLen)
+	argon2Hash(key, buf[:])
+	return key
+}
+
+// argon2Compress is the compression function G. With xor set the result is
+// XORed into out, as required for passes after the first.
+func argon2Compress(out, in1, in2 *argon2Block, xor bool) {
+	var t argon2Block
+	for i := range t {
+		t[i] = in1[i] ^ in2[i]
+	}
+	for i := 0; i < argon2BlockWords; i += 16 {
+		blamka(&t[i], &t[i+1], &t[i+2], &t[i+3], &t[i+4], &t[i+5], &t[i+6], &t[i+7],
+			&t[i+8], &t[i+9], &t[i+10], &t[i+11], &t[i+12], &t[i+13], &t[i+14], &t[i+15])
+	}
+	for i := 0; i < argon2BlockWords/8; i += 2 {
+		blamka(&t[i], &t[i+1], &t[16+i], &t[16+i+1], &t[32+i], &t[32+i+1], &t[48+i], &t[48+i+1],
+			&t[64+i], &t[64+i+1], &t[80+i], &t[80+i+1], &t[96+i], &t[96+i+1], &t[112+i], &t[112+i+1])
+	}
+	for i := range t {
+		value := in1[i] ^ in2[i] ^ t[i]
+		if xor {
+			out[i] ^= value
+		} else {
+			out[i] = value
+		}
+	}
+}
+
+// blamka applies the permutation P to sixteen words.
+func blamka(t00, t01, t02, t03, t04, t05, t06, t07, t08, t09, t10, t11, t12, t13, t14, t15 *uint64) {
+	v := [16]uint64{*t00, *t01, *t02, *t03, *t04, *t05, *t06, *t07, *t08, *t09, *t10, *t11, *t12, *t13, *t14, *t15}
+
+	mix := func(a, b, c, d int) {
+		v[a] += v[b] + 2*uint64(uint32(v[a]))*uint64(uint32(v[b]))
+		v[d] = bits.RotateLeft64(v[d]^v[a], -32)
+		v[c] += v[d] + 2*uint64(uint32(v[c]))*uint64(uint32(v[d]))
+		v[b] = bits.RotateLeft64(v[b]^v[c], -24)
+		v[a] += v[b] + 2*uint64(uint32(v[a]))*uint64(uint32(v[b]))
+		v[d] = bits.RotateLeft64(v[d]^v[a], -16)
+		v[c] += v[d] + 2*uint64(uint32(v[c]))*uint64(uint32(v[d]))
+		v[b] = bits.RotateLeft64(v[b]^v[c], -63)
+	}
+	mix(0, 4, 8, 12)
+	mix(1, 5, 9, 13)
+	mix(2, 6, 10, 14)
+	mix(3, 7, 11, 15)
+	mix(0, 5, 10, 15)
+	mix(1, 6, 11, 12)
+	mix(2, 7, 8, 13)
+	mix(3, 4, 9, 14)
+
+	*t00, *t01, *t02, *t03, *t04, *t05, *t06, *t07 = v[0], v[1], v[2], v[3], v[4], v[5], v[6], v[7]
+	*t08, *t09, *t10, *t11, *t12, *t13, *t14, *t15 = v[8], v[9], v[10], v[11], v[12], v[13], v[14], v[15]
+}
+
+// argon2Hash is the variable-length hash H' built from BLAKE2b.
+func argon2Hash(out, in []byte) {
+	newHash := func(size int) hash.Hash {
+		h, _ := blake2b.New(size, nil)
+		return h
+	}
+
+	var buffer [blake2b.Size]byte
+	binary.LittleEndian.PutUint32(buffer[:4], uint32(len(out)))
+	h := newHash(min(len(out), blake2b.Size))
+	h.Write(buffer[:4])
+	h.Write(in)
+	if len(out) <= blake2b.Size {
+		h.Sum(out[:0])
+		return
+	}
+
+	outLen := len(out)
+	h.Sum(buffer[:0])
+	copy(out, buffer[:32])
+	out = out[32:]
+	for len(out) > blake2b.Size {
+		h = newHash(blake2b.Size)
+		h.Write(buffer[:])
+		h.Sum(buffer[:0])
+		copy(out, buffer[:32])
+		out = out[32:]
+	}
+
+	h = newHash(blake2b.Size)
+	if outLen%blake2b.Size > 0 {
+		r := (outLen+31)/32 - 2
+		h = newHash(outLen - 32*r)
+	}
+	h.Write(buffer[:])
+	h.Sum(out[:0])
+}
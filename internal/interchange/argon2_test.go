@@ -0,0 +1,46 @@
+package interchange
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"golang.org/x/crypto/argon2"
+)
+
+func TestArgon2Vectors(t *testing.T) {
+	// Test vectors from RFC 9106, section 5
+	password := bytes.Repeat([]byte{0x01}, 32)
+	salt := bytes.Repeat([]byte{0x02}, 16)
+	secret := bytes.Repeat([]byte{0x03}, 8)
+	data := bytes.Repeat([]byte{0x04}, 12)
+
+	tests := []struct {
+		name     string
+		mode     int
+		expected string
+	}{
+		{"Argon2d", argon2d, "512b391b6f1162975371d30919734294f868e3be3984f3c1a13a4db9fabe4acb"},
+		{"Argon2id", argon2id, "0d640df58d78766c08c037a34a8b53c9d01ef0452d75b65eb52520e96b01e659"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			key := argon2Key(test.mode, password, salt, secret, data, 3, 32, 4, 32)
+			if got := hex.EncodeToString(key); got != test.expected {
+				t.Errorf("Expected tag %s, got %s", test.expected, got)
+			}
+		})
+	}
+}
+
+func TestArgon2MatchesXCrypto(t *testing.T) {
+	// Long outputs exercise every branch of the variable-length hash
+	for _, keyLen := range []uint32{16, 32, 64, 100, 128} {
+		expected := argon2.IDKey([]byte("password"), []byte("somesalt"), 2, 256, 3, keyLen)
+		key := argon2Key(argon2id, []byte("password"), []byte("somesalt"), nil, nil, 2, 256, 3, keyLen)
+		if !bytes.Equal(key, expected) {
+			t.Errorf("Key length %d: expected %x, got %x", keyLen, expected, key)
+		}
+	}
+}
@@ -0,0 +1,838 @@
+package interchange
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/chacha20"
+
+	"github.com/simp-lee/passwordmanager/internal/errors"
+)
+
+// KDBX 4 file layout: signature and version, a TLV header, the header's
+// SHA-256 and HMAC-SHA-256, then the encrypted payload split into blocks that
+// are each authenticated with HMAC-SHA-256. The decrypted payload is an inner
+// TLV header followed by the KeePass XML document.
+
+const (
+	kdbxSignature1   = 0x9AA2D903
+	kdbxSignature2   = 0xB54BFB67
+	kdbxMajorVersion = 4
+	kdbxVersion      = kdbxMajorVersion << 16 // 4.0, which KeePass and KeePassXC both read
+
+	kdbxBlockSize = 1 << 20
+)
+
+// Outer header field IDs
+const (
+	headerEnd           = 0
+	headerCipherID      = 2
+	headerCompression   = 3
+	headerMasterSeed    = 4
+	headerEncryptionIV  = 7
+	headerKdfParameters = 11
+)
+
+// Inner header field IDs
+const (
+	innerHeaderEnd       = 0
+	innerHeaderStreamID  = 1
+	innerHeaderStreamKey = 2
+
+	innerStreamChaCha20 = 3
+)
+
+// Cipher and key derivation UUIDs
+var (
+	cipherAES256   = mustHex("31c1f2e6bf714350be5805216afc5aff")
+	cipherChaCha20 = mustHex("d6038a2b8b6f4cb5a524339a31dbb59a")
+	kdfAESKDBX3    = mustHex("c9d9f39a628a4460bf740d08c18a4fea")
+	kdfAESKDBX4    = mustHex("7c02bb8279a74ac0927d114a00648238")
+	kdfArgon2d     = mustHex("ef636ddf8c29444b91f7a9a403e30a0c")
+	kdfArgon2id    = mustHex("9e298b1956db4773b23dfc3ec6f0a1e6")
+)
+
+// exportKDF is the Argon2d cost of files written by this package: KeePassXC's
+// default memory and parallelism with a fixed number of iterations.
+var exportKDF = struct {
+	Iterations  uint64
+	Memory      uint64 // In bytes
+	Parallelism uint32
+}{Iterations: 10, Memory: 64 << 20, Parallelism: 2}
+
+// Limits on the key derivation cost of a file being read. The parameters
+// come from the header before it can be authenticated, so a crafted file
+// could otherwise make an import allocate terabytes or run for days. They
+// are far above what KeePass and KeePassXC choose for a few seconds of
+// unlocking.
+const (
+	maxArgon2Memory      = 2 << 30 // In bytes
+	maxArgon2Iterations  = 10000
+	maxArgon2Parallelism = 256
+	maxAESKDFRounds      = 1 << 31
+)
+
+// kdbxEpoch is the zero point of KDBX 4 timestamps.
+var kdbxEpoch = time.Date(1, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Credentials unlock a KDBX file: a password, a key file, or both.
+type Credentials struct {
+	Password string
+	KeyFile  string // Path to a KeePass key file (optional)
+}
+
+// keePassFile is the XML document inside a KDBX file. Only the parts that
+// map onto vault accounts are modelled; everything else is ignored on read.
+type keePassFile struct {
+	XMLName xml.Name `xml:"KeePassFile"`
+	Meta    kdbxMeta `xml:"Meta"`
+	Root    kdbxRoot `xml:"Root"`
+}
+
+type kdbxMeta struct {
+	Generator         string `xml:"Generator"`
+	DatabaseName      string `xml:"DatabaseName"`
+	RecycleBinEnabled string `xml:"RecycleBinEnabled"`
+	RecycleBinUUID    string `xml:"RecycleBinUUID"`
+}
+
+type kdbxRoot struct {
+	Group kdbxGroup `xml:"Group"`
+}
+
+type kdbxGroup struct {
+	UUID    string       `xml:"UUID"`
+	Name    string       `xml:"Name"`
+	Entries []kdbxEntry  `xml:"Entry"`
+	Groups  []*kdbxGroup `xml:"Group"`
+}
+
+type kdbxEntry struct {
+	UUID    string       `xml:"UUID"`
+	Tags    string       `xml:"Tags,omitempty"` // Separated by ";" (KeePassXC also accepts ",")
+	Times   kdbxTimes    `xml:"Times"`
+	Strings []kdbxString `xml:"String"`
+	History *kdbxHistory `xml:"History,omitempty"`
+}
+
+type kdbxHistory struct {
+	Entries []kdbxEntry `xml:"Entry"`
+}
+
+type kdbxTimes struct {
+	CreationTime         string `xml:"CreationTime"`
+	LastModificationTime string `xml:"LastModificationTime"`
+	LastAccessTime       string `xml:"LastAccessTime"`
+	ExpiryTime           string `xml:"ExpiryTime"`
+	Expires              string `xml:"Expires"`
+	UsageCount           int    `xml:"UsageCount"`
+	LocationChanged      string `xml:"LocationChanged"`
+}
+
+type kdbxString struct {
+	Key   string    `xml:"Key"`
+	Value kdbxValue `xml:"Value"`
+}
+
+type kdbxValue struct {
+	Protected string `xml:"Protected,attr,omitempty"`
+	Text      string `xml:",chardata"`
+}
+
+// get returns the value of the string field with the given key.
+func (e *kdbxEntry) get(key string) string {
+	for _, field := range e.Strings {
+		if field.Key == key {
+			return field.Value.Text
+		}
+	}
+	return ""
+}
+
+// readKDBX decrypts and parses a KDBX 4 file.
+func readKDBX(r io.Reader, credentials Credentials) (*keePassFile, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read KDBX file")
+	}
+	if len(data) < 12 ||
+		binary.LittleEndian.Uint32(data[0:]) != kdbxSignature1 ||
+		binary.LittleEndian.Uint32(data[4:]) != kdbxSignature2 {
+		return nil, errors.Wrap(errors.ErrImportFormat, "not a KeePass database")
+	}
+	if major := binary.LittleEndian.Uint32(data[8:]) >> 16; major != kdbxMajorVersion {
+		return nil, errors.Wrap(errors.ErrImportFormat, fmt.Sprintf("KDBX version %d is not supported, save the database as KDBX 4", major))
+	}
+
+	// Outer header
+	fields, headerLength, err := readTLV(data, 12, 4)
+	if err != nil {
+		return nil, err
+	}
+	header := data[:headerLength]
+	if len(data) < headerLength+64 {
+		return nil, errors.Wrap(errors.ErrImportFormat, "truncated header")
+	}
+	headerHash := sha256.Sum256(header)
+	if !hmac.Equal(headerHash[:], data[headerLength:headerLength+32]) {
+		return nil, errors.Wrap(errors.ErrDataCorrupted, "KDBX header checksum mismatch")
+	}
+
+	kdfParameters, err := readVariantDictionary(fields[headerKdfParameters])
+	if err != nil {
+		return nil, err
+	}
+	compositeKey, err := credentials.compositeKey()
+	if err != nil {
+		return nil, err
+	}
+	transformedKey, err := transformKey(compositeKey, kdfParameters)
+	if err != nil {
+		return nil, err
+	}
+
+	masterSeed := fields[headerMasterSeed]
+	if len(masterSeed) != 32 {
+		return nil, errors.Wrap(errors.ErrImportFormat, "invalid master seed")
+	}
+	hmacBase := hmacBaseKey(masterSeed, transformedKey)
+	if !hmac.Equal(headerHMAC(hmacBase, header), data[headerLength+32:headerLength+64]) {
+		return nil, errors.ErrInvalidCredentials
+	}
+
+	// Authenticated payload blocks
+	ciphertext, err := readBlocks(data[headerLength+64:], hmacBase)
+	if err != nil {
+		return nil, err
+	}
+	encryptionKey := sha256.Sum256(slices.Concat(masterSeed, transformedKey))
+	payload, err := decryptPayload(fields[headerCipherID], encryptionKey[:], fields[headerEncryptionIV], ciphertext)
+	if err != nil {
+		return nil, err
+	}
+
+	if compression := fields[headerCompression]; len(compression) == 4 && binary.LittleEndian.Uint32(compression) == 1 {
+		reader, err := gzip.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return nil, errors.Wrap(errors.ErrImportFormat, "invalid compressed payload")
+		}
+		if payload, err = io.ReadAll(reader); err != nil {
+			return nil, errors.Wrap(errors.ErrImportFormat, "invalid compressed payload")
+		}
+	}
+
+	// Inner header, then the XML document
+	innerFields, innerLength, err := readTLV(payload, 0, 4)
+	if err != nil {
+		return nil, err
+	}
+	streamID := innerFields[innerHeaderStreamID]
+	if len(streamID) != 4 || binary.LittleEndian.Uint32(streamID) != innerStreamChaCha20 {
+		return nil, errors.Wrap(errors.ErrImportFormat, "unsupported inner random stream")
+	}
+	stream, err := innerStream(innerFields[innerHeaderStreamKey])
+	if err != nil {
+		return nil, err
+	}
+
+	document, err := transformProtected(payload[innerLength:], stream, false)
+	if err != nil {
+		return nil, err
+	}
+	file := &keePassFile{}
+	if err := xml.Unmarshal(document, file); err != nil {
+		return nil, errors.Wrap(errors.ErrImportFormat, fmt.Sprintf("invalid XML: %v", err))
+	}
+	return file, nil
+}
+
+// writeKDBX encrypts file as a KDBX 4 database using AES-256 and Argon2d.
+func writeKDBX(w io.Writer, file *keePassFile, credentials Credentials) error {
+	document, err := xml.MarshalIndent(file, "", "\t")
+	if err != nil {
+		return errors.Wrap(err, "failed to encode KDBX XML")
+	}
+	salt, err := randomBytes(32)
+	if err != nil {
+		return err
+	}
+	kdfParameters := map[string]any{
+		"$UUID": kdfArgon2d,
+		"S":     salt,
+		"I":     exportKDF.Iterations,
+		"M":     exportKDF.Memory,
+		"P":     exportKDF.Parallelism,
+		"V":     uint32(argon2Version),
+	}
+	return writeDocument(w, slices.Concat([]byte(xml.Header), document), credentials, cipherAES256, kdfParameters)
+}
+
+// writeDocument encrypts an XML document with the given cipher and KDF
+// parameters, whose salt or seed must already be random.
+func writeDocument(w io.Writer, document []byte, credentials Credentials, cipherID []byte, kdfParameters map[string]any) error {
+	compositeKey, err := credentials.compositeKey()
+	if err != nil {
+		return err
+	}
+
+	ivLength := aes.BlockSize
+	if bytes.Equal(cipherID, cipherChaCha20) {
+		ivLength = chacha20.NonceSize
+	}
+	masterSeed, err := randomBytes(32)
+	if err != nil {
+		return err
+	}
+	iv, err := randomBytes(ivLength)
+	if err != nil {
+		return err
+	}
+
+	// Outer header
+	var header bytes.Buffer
+	binary.Write(&header, binary.LittleEndian, []uint32{kdbxSignature1, kdbxSignature2, kdbxVersion})
+	writeTLV(&header, headerCipherID, cipherID)
+	writeTLV(&header, headerCompression, binary.LittleEndian.AppendUint32(nil, 1))
+	writeTLV(&header, headerMasterSeed, masterSeed)
+	writeTLV(&header, headerEncryptionIV, iv)
+	writeTLV(&header, headerKdfParameters, writeVariantDictionary(kdfParameters))
+	writeTLV(&header, headerEnd, []byte("\r\n\r\n"))
+
+	transformedKey, err := transformKey(compositeKey, kdfParameters)
+	if err != nil {
+		return err
+	}
+	hmacBase := hmacBaseKey(masterSeed, transformedKey)
+	encryptionKey := sha256.Sum256(slices.Concat(masterSeed, transformedKey))
+
+	// Inner header and XML with protected values encrypted
+	streamKey, err := randomBytes(64)
+	if err != nil {
+		return err
+	}
+	stream, err := innerStream(streamKey)
+	if err != nil {
+		return err
+	}
+	document, err = transformProtected(document, stream, true)
+	if err != nil {
+		return err
+	}
+
+	var payload bytes.Buffer
+	compressor := gzip.NewWriter(&payload)
+	var inner bytes.Buffer
+	writeTLV(&inner, innerHeaderStreamID, binary.LittleEndian.AppendUint32(nil, innerStreamChaCha20))
+	writeTLV(&inner, innerHeaderStreamKey, streamKey)
+	writeTLV(&inner, innerHeaderEnd, nil)
+	compressor.Write(inner.Bytes())
+	compressor.Write(document)
+	if err := compressor.Close(); err != nil {
+		return errors.Wrap(err, "failed to compress KDBX payload")
+	}
+
+	ciphertext, err := encryptPayload(cipherID, encryptionKey[:], iv, payload.Bytes())
+	if err != nil {
+		return err
+	}
+
+	headerHash := sha256.Sum256(header.Bytes())
+	var out bytes.Buffer
+	out.Write(header.Bytes())
+	out.Write(headerHash[:])
+	out.Write(headerHMAC(hmacBase, header.Bytes()))
+	writeBlocks(&out, ciphertext, hmacBase)
+
+	if _, err := w.Write(out.Bytes()); err != nil {
+		return errors.Wrap(err, "failed to write KDBX file")
+	}
+	return nil
+}
+
+// compositeKey combines the SHA-256 of the password and the key file data.
+func (c Credentials) compositeKey() ([]byte, error) {
+	var parts []byte
+	if c.Password != "" || c.KeyFile == "" {
+		passwordHash := sha256.Sum256([]byte(c.Password))
+		parts = append(parts, passwordHash[:]...)
+	}
+	if c.KeyFile != "" {
+		keyData, err := readKeyFile(c.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, keyData...)
+	}
+	compositeKey := sha256.Sum256(parts)
+	return compositeKey[:], nil
+}
+
+// readKeyFile returns the 32-byte key of a KeePass key file: an XML key file
+// (version 1.0 or 2.0), 32 raw bytes, 64 hex digits, or any other file, which
+// is hashed.
+func readKeyFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read key file")
+	}
+
+	var keyFile struct {
+		XMLName xml.Name `xml:"KeyFile"`
+		Version string   `xml:"Meta>Version"`
+		Data    string   `xml:"Key>Data"`
+	}
+	if xml.Unmarshal(data, &keyFile) == nil && keyFile.Data != "" {
+		var key []byte
+		if strings.HasPrefix(keyFile.Version, "2.") {
+			key, err = hex.DecodeString(strings.Join(strings.Fields(keyFile.Data), ""))
+		} else {
+			key, err = base64.StdEncoding.DecodeString(strings.TrimSpace(keyFile.Data))
+		}
+		if err != nil || len(key) != 32 {
+			return nil, errors.Wrap(errors.ErrImportFormat, "invalid key data in key file")
+		}
+		return key, nil
+	}
+
+	if len(data) == 32 {
+		return data, nil
+	}
+	if len(data) == 64 {
+		if key, err := hex.DecodeString(string(data)); err == nil {
+			return key, nil
+		}
+	}
+	hash := sha256.Sum256(data)
+	return hash[:], nil
+}
+
+// transformKey runs the key derivation function named in the KDF parameters.
+func transformKey(compositeKey []byte, parameters map[string]any) ([]byte, error) {
+	uuid, _ := parameters["$UUID"].([]byte)
+	switch {
+	case bytes.Equal(uuid, kdfArgon2d), bytes.Equal(uuid, kdfArgon2id):
+		salt, _ := parameters["S"].([]byte)
+		iterations, _ := parameters["I"].(uint64)
+		memory, _ := parameters["M"].(uint64)
+		parallelism, _ := parameters["P"].(uint32)
+		version, _ := parameters["V"].(uint32)
+		secret, _ := parameters["K"].([]byte)
+		data, _ := parameters["A"].([]byte)
+		if version != argon2Version {
+			return nil, errors.Wrap(errors.ErrImportFormat, fmt.Sprintf("unsupported Argon2 version %#x", version))
+		}
+		if len(salt) == 0 || iterations == 0 || memory < 8<<10 || parallelism == 0 {
+			return nil, errors.Wrap(errors.ErrImportFormat, "invalid Argon2 parameters")
+		}
+		if memory > maxArgon2Memory || iterations > maxArgon2Iterations || parallelism > maxArgon2Parallelism {
+			return nil, errors.Wrap(errors.ErrImportFormat, fmt.Sprintf(
+				"Argon2 parameters exceed the supported limits (memory %d MiB, iterations %d, parallelism %d; at most %d MiB, %d and %d)",
+				memory>>20, iterations, parallelism, maxArgon2Memory>>20, maxArgon2Iterations, maxArgon2Parallelism))
+		}
+		mode := argon2d
+		if bytes.Equal(uuid, kdfArgon2id) {
+			mode = argon2id
+		}
+		return argon2Key(mode, compositeKey, salt, secret, data, uint32(iterations), uint32(memory/1024), parallelism, 32), nil
+
+	case bytes.Equal(uuid, kdfAESKDBX3), bytes.Equal(uuid, kdfAESKDBX4):
+		seed, _ := parameters["S"].([]byte)
+		rounds, _ := parameters["R"].(uint64)
+		if len(seed) != 32 {
+			return nil, errors.Wrap(errors.ErrImportFormat, "invalid AES-KDF seed")
+		}
+		if rounds > maxAESKDFRounds {
+			return nil, errors.Wrap(errors.ErrImportFormat, fmt.Sprintf("AES-KDF rounds %d exceed the supported limit of %d", rounds, maxAESKDFRounds))
+		}
+		block, err := aes.NewCipher(seed)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create AES-KDF cipher")
+		}
+		key := slices.Clone(compositeKey)
+		for i := uint64(0); i < rounds; i++ {
+			block.Encrypt(key[:16], key[:16])
+			block.Encrypt(key[16:], key[16:])
+		}
+		transformed := sha256.Sum256(key)
+		return transformed[:], nil
+	}
+	return nil, errors.Wrap(errors.ErrImportFormat, "unsupported key derivation function")
+}
+
+// hmacBaseKey derives the key from which every block HMAC key is computed.
+func hmacBaseKey(masterSeed, transformedKey []byte) []byte {
+	base := sha512.Sum512(slices.Concat(masterSeed, transformedKey, []byte{1}))
+	return base[:]
+}
+
+// blockHMACKey returns the HMAC key of the block with the given index.
+func blockHMACKey(hmacBase []byte, index uint64) []byte {
+	key := sha512.Sum512(slices.Concat(binary.LittleEndian.AppendUint64(nil, index), hmacBase))
+	return key[:]
+}
+
+// headerHMAC authenticates the outer header with the reserved block index.
+func headerHMAC(hmacBase, header []byte) []byte {
+	mac := hmac.New(sha256.New, blockHMACKey(hmacBase, math.MaxUint64))
+	mac.Write(header)
+	return mac.Sum(nil)
+}
+
+// readBlocks verifies and concatenates the HMAC-protected payload blocks.
+func readBlocks(data, hmacBase []byte) ([]byte, error) {
+	var ciphertext []byte
+	for index := uint64(0); ; index++ {
+		if len(data) < 36 {
+			return nil, errors.Wrap(errors.ErrDataCorrupted, "truncated KDBX block")
+		}
+		storedMAC, size := data[:32], binary.LittleEndian.Uint32(data[32:36])
+		if uint64(size) > uint64(len(data)-36) {
+			return nil, errors.Wrap(errors.ErrDataCorrupted, "truncated KDBX block")
+		}
+		block := data[36 : 36+size]
+
+		mac := hmac.New(sha256.New, blockHMACKey(hmacBase, index))
+		mac.Write(binary.LittleEndian.AppendUint64(nil, index))
+		mac.Write(data[32:36])
+		mac.Write(block)
+		if !hmac.Equal(mac.Sum(nil), storedMAC) {
+			return nil, errors.Wrap(errors.ErrDataCorrupted, fmt.Sprintf("KDBX block %d failed authentication", index))
+		}
+		if size == 0 {
+			return ciphertext, nil
+		}
+		ciphertext = append(ciphertext, block...)
+		data = data[36+size:]
+	}
+}
+
+// writeBlocks splits ciphertext into HMAC-protected blocks, ending with an empty one.
+func writeBlocks(out *bytes.Buffer, ciphertext, hmacBase []byte) {
+	for index := uint64(0); ; index++ {
+		block := ciphertext[:min(len(ciphertext), kdbxBlockSize)]
+		ciphertext = ciphertext[len(block):]
+
+		size := binary.LittleEndian.AppendUint32(nil, uint32(len(block)))
+		mac := hmac.New(sha256.New, blockHMACKey(hmacBase, index))
+		mac.Write(binary.LittleEndian.AppendUint64(nil, index))
+		mac.Write(size)
+		mac.Write(block)
+		out.Write(mac.Sum(nil))
+		out.Write(size)
+		out.Write(block)
+
+		if len(block) == 0 {
+			return
+		}
+	}
+}
+
+// decryptPayload decrypts the payload with AES-256-CBC or ChaCha20.
+func decryptPayload(cipherID, key, iv, ciphertext []byte) ([]byte, error) {
+	switch {
+	case bytes.Equal(cipherID, cipherAES256):
+		if len(iv) != aes.BlockSize || len(ciphertext) == 0 || len(ciphertext)%aes.BlockSize != 0 {
+			return nil, errors.Wrap(errors.ErrDataCorrupted, "invalid AES payload")
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create AES cipher")
+		}
+		plaintext := make([]byte, len(ciphertext))
+		cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, ciphertext)
+		padding := int(plaintext[len(plaintext)-1])
+		if padding == 0 || padding > aes.BlockSize {
+			return nil, errors.Wrap(errors.ErrDataCorrupted, "invalid AES padding")
+		}
+		return plaintext[:len(plaintext)-padding], nil
+
+	case bytes.Equal(cipherID, cipherChaCha20):
+		stream, err := chacha20.NewUnauthenticatedCipher(key, iv)
+		if err != nil {
+			return nil, errors.Wrap(errors.ErrImportFormat, "invalid ChaCha20 nonce")
+		}
+		plaintext := make([]byte, len(ciphertext))
+		stream.XORKeyStream(plaintext, ciphertext)
+		return plaintext, nil
+	}
+	return nil, errors.Wrap(errors.ErrImportFormat, "unsupported cipher, use AES-256 or ChaCha20")
+}
+
+// encryptPayload encrypts the payload with AES-256-CBC and PKCS#7 padding, or ChaCha20.
+func encryptPayload(cipherID, key, iv, plaintext []byte) ([]byte, error) {
+	if bytes.Equal(cipherID, cipherChaCha20) {
+		stream, err := chacha20.NewUnauthenticatedCipher(key, iv)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create ChaCha20 cipher")
+		}
+		ciphertext := make([]byte, len(plaintext))
+		stream.XORKeyStream(ciphertext, plaintext)
+		return ciphertext, nil
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create AES cipher")
+	}
+	padding := aes.BlockSize - len(plaintext)%aes.BlockSize
+	padded := append(slices.Clone(plaintext), bytes.Repeat([]byte{byte(padding)}, padding)...)
+	ciphertext := make([]byte, len(padded))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, padded)
+	return ciphertext, nil
+}
+
+// innerStream creates the ChaCha20 stream that protects values inside the XML.
+func innerStream(key []byte) (cipher.Stream, error) {
+	if len(key) == 0 {
+		return nil, errors.Wrap(errors.ErrImportFormat, "missing inner stream key")
+	}
+	hash := sha512.Sum512(key)
+	stream, err := chacha20.NewUnauthenticatedCipher(hash[:32], hash[32:44])
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create inner stream cipher")
+	}
+	return stream, nil
+}
+
+// transformProtected encrypts (or decrypts) the text of every <Value
+// Protected="True"> element in document order, as the inner stream requires.
+// The replacements are spliced into the original bytes so the rest of the
+// document is left untouched.
+func transformProtected(document []byte, stream cipher.Stream, encrypt bool) ([]byte, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(document))
+	var out bytes.Buffer
+	copied := int64(0)
+	protected := false
+	for {
+		start := decoder.InputOffset()
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(errors.ErrImportFormat, fmt.Sprintf("invalid XML: %v", err))
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			protected = false
+			if t.Name.Local == "Value" {
+				for _, attr := range t.Attr {
+					protected = protected || attr.Name.Local == "Protected" && strings.EqualFold(attr.Value, "true")
+				}
+			}
+		case xml.EndElement:
+			protected = false
+		case xml.CharData:
+			if !protected {
+				continue
+			}
+			out.Write(document[copied:start])
+			copied = decoder.InputOffset()
+
+			if encrypt {
+				ciphertext := make([]byte, len(t))
+				stream.XORKeyStream(ciphertext, t)
+				out.WriteString(base64.StdEncoding.EncodeToString(ciphertext))
+				continue
+			}
+			ciphertext, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(t)))
+			if err != nil {
+				return nil, errors.Wrap(errors.ErrImportFormat, "invalid protected value")
+			}
+			stream.XORKeyStream(ciphertext, ciphertext)
+			xml.EscapeText(&out, ciphertext)
+		}
+	}
+	out.Write(document[copied:])
+	return out.Bytes(), nil
+}
+
+// readTLV reads type-length-value fields starting at offset until the end
+// field. Returns the fields by type and the offset just after the end field.
+func readTLV(data []byte, offset, sizeLength int) (map[byte][]byte, int, error) {
+	fields := make(map[byte][]byte)
+	for {
+		if len(data) < offset+1+sizeLength {
+			return nil, 0, errors.Wrap(errors.ErrImportFormat, "truncated header")
+		}
+		id := data[offset]
+		size := int(binary.LittleEndian.Uint32(data[offset+1:]))
+		offset += 1 + sizeLength
+		if size < 0 || len(data)-offset < size {
+			return nil, 0, errors.Wrap(errors.ErrImportFormat, "truncated header")
+		}
+		if id == headerEnd {
+			return fields, offset + size, nil
+		}
+		fields[id] = data[offset : offset+size]
+		offset += size
+	}
+}
+
+func writeTLV(out *bytes.Buffer, id byte, value []byte) {
+	out.WriteByte(id)
+	binary.Write(out, binary.LittleEndian, uint32(len(value)))
+	out.Write(value)
+}
+
+// Variant dictionary value types
+const (
+	variantEnd       = 0x00
+	variantUint32    = 0x04
+	variantUint64    = 0x05
+	variantBool      = 0x08
+	variantInt32     = 0x0C
+	variantInt64     = 0x0D
+	variantString    = 0x18
+	variantByteArray = 0x42
+)
+
+// readVariantDictionary decodes the typed key/value map used for KDF parameters.
+func readVariantDictionary(data []byte) (map[string]any, error) {
+	invalid := errors.Wrap(errors.ErrImportFormat, "invalid KDF parameters")
+	if len(data) < 2 || data[1] > 1 {
+		return nil, invalid
+	}
+	data = data[2:]
+
+	dictionary := make(map[string]any)
+	for {
+		if len(data) < 1 {
+			return nil, invalid
+		}
+		kind := data[0]
+		if kind == variantEnd {
+			return dictionary, nil
+		}
+		if len(data) < 5 {
+			return nil, invalid
+		}
+		keyLength := int(binary.LittleEndian.Uint32(data[1:]))
+		data = data[5:]
+		if keyLength < 0 || len(data) < keyLength+4 {
+			return nil, invalid
+		}
+		key := string(data[:keyLength])
+		valueLength := int(binary.LittleEndian.Uint32(data[keyLength:]))
+		data = data[keyLength+4:]
+		if valueLength < 0 || len(data) < valueLength {
+			return nil, invalid
+		}
+		value := data[:valueLength]
+		data = data[valueLength:]
+
+		switch {
+		case kind == variantUint32 && valueLength == 4:
+			dictionary[key] = binary.LittleEndian.Uint32(value)
+		case kind == variantUint64 && valueLength == 8:
+			dictionary[key] = binary.LittleEndian.Uint64(value)
+		case kind == variantBool && valueLength == 1:
+			dictionary[key] = value[0] != 0
+		case kind == variantInt32 && valueLength == 4:
+			dictionary[key] = int32(binary.LittleEndian.Uint32(value))
+		case kind == variantInt64 && valueLength == 8:
+			dictionary[key] = int64(binary.LittleEndian.Uint64(value))
+		case kind == variantString:
+			dictionary[key] = string(value)
+		case kind == variantByteArray:
+			dictionary[key] = slices.Clone(value)
+		default:
+			return nil, invalid
+		}
+	}
+}
+
+// writeVariantDictionary encodes a variant dictionary with sorted keys.
+func writeVariantDictionary(dictionary map[string]any) []byte {
+	out := bytes.NewBuffer([]byte{0x00, 0x01})
+	keys := make([]string, 0, len(dictionary))
+	for key := range dictionary {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	for _, key := range keys {
+		var kind byte
+		var value []byte
+		switch v := dictionary[key].(type) {
+		case uint32:
+			kind, value = variantUint32, binary.LittleEndian.AppendUint32(nil, v)
+		case uint64:
+			kind, value = variantUint64, binary.LittleEndian.AppendUint64(nil, v)
+		case bool:
+			kind, value = variantBool, []byte{0}
+			if v {
+				value[0] = 1
+			}
+		case int32:
+			kind, value = variantInt32, binary.LittleEndian.AppendUint32(nil, uint32(v))
+		case int64:
+			kind, value = variantInt64, binary.LittleEndian.AppendUint64(nil, uint64(v))
+		case string:
+			kind, value = variantString, []byte(v)
+		case []byte:
+			kind, value = variantByteArray, v
+		}
+		out.WriteByte(kind)
+		binary.Write(out, binary.LittleEndian, uint32(len(key)))
+		out.WriteString(key)
+		binary.Write(out, binary.LittleEndian, uint32(len(value)))
+		out.Write(value)
+	}
+	out.WriteByte(variantEnd)
+	return out.Bytes()
+}
+
+// encodeTime formats a timestamp as KDBX 4 does: base64 of the little-endian
+// seconds since 0001-01-01.
+func encodeTime(t time.Time) string {
+	seconds := t.Unix() - kdbxEpoch.Unix()
+	return base64.StdEncoding.EncodeToString(binary.LittleEndian.AppendUint64(nil, uint64(seconds)))
+}
+
+// decodeTime parses a KDBX 4 timestamp, or an ISO 8601 one as older files use.
+func decodeTime(value string) time.Time {
+	value = strings.TrimSpace(value)
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t
+	}
+	raw, err := base64.StdEncoding.DecodeString(value)
+	if err != nil || len(raw) != 8 {
+		return time.Time{}
+	}
+	return time.Unix(int64(binary.LittleEndian.Uint64(raw))+kdbxEpoch.Unix(), 0).UTC()
+}
+
+func randomBytes(n int) ([]byte, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, errors.Wrap(err, "failed to generate random bytes")
+	}
+	return buf, nil
+}
+
+func mustHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
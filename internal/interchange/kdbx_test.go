@@ -0,0 +1,434 @@
+package interchange
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/simp-lee/passwordmanager/internal/crypto"
+	"github.com/simp-lee/passwordmanager/internal/errors"
+	"github.com/simp-lee/passwordmanager/internal/model"
+	"github.com/simp-lee/passwordmanager/internal/storage"
+)
+
+// useFastKDF lowers the Argon2 cost of exported files for the duration of a test.
+func useFastKDF(t *testing.T) {
+	saved := exportKDF
+	exportKDF.Iterations, exportKDF.Memory = 1, 1<<20
+	t.Cleanup(func() { exportKDF = saved })
+}
+
+func newStore(t *testing.T) *storage.Storage {
+	store, err := storage.New(t.TempDir())
+	require.NoError(t, err, "Failed to create storage")
+	require.NoError(t, store.CreateVault("test-password"), "Failed to create vault")
+	require.NoError(t, store.UnlockVault("test-password"), "Failed to unlock vault")
+	return store
+}
+
+func encrypt(t *testing.T, store *storage.Storage, value string) string {
+	encrypted, err := crypto.Encrypt([]byte(value), store.GetEncryptionKey())
+	require.NoError(t, err, "Failed to encrypt value")
+	return encrypted
+}
+
+func decrypt(t *testing.T, store *storage.Storage, value string) string {
+	decrypted, err := crypto.Decrypt(value, store.GetEncryptionKey())
+	require.NoError(t, err, "Failed to decrypt value")
+	return string(decrypted)
+}
+
+func TestKDBXRoundTrip(t *testing.T) {
+	useFastKDF(t)
+	source := newStore(t)
+	created := time.Date(2023, 5, 1, 8, 30, 0, 0, time.UTC)
+
+	require.NoError(t, source.AddAccounts([]*model.Account{
+		{
+			ID:                "a1b2c3d4e5f60718",
+			Platform:          "GitHub",
+			Username:          "octo",
+			Email:             "octo@example.com",
+			EncryptedPassword: encrypt(t, source, "current <&> secret"),
+			URL:               "https://github.com",
+			Notes:             "line one\nline two",
+			Group:             "Work/Dev",
+			Tags:              []string{"code", "work"},
+			CustomFields: []model.CustomField{
+				{Name: "Recovery code", Value: encrypt(t, source, "1234-5678"), Protected: true},
+				{Name: "Plan", Value: "Pro"},
+			},
+			History: []model.HistoryEntry{
+				{Username: "octo", EncryptedPassword: encrypt(t, source, "old secret"), UpdatedAt: created},
+			},
+			CreatedAt: created,
+		},
+		{
+			ID:                "b1b2c3d4e5f60718",
+			Platform:          "Mail",
+			Username:          "me",
+			EncryptedPassword: encrypt(t, source, "pw"),
+			Group:             "Personal",
+		},
+		{
+			ID:       "c1b2c3d4e5f60718",
+			Platform: "example.org",
+			Derived:  &model.Derived{Site: "example.org", Counter: 1, Length: 16, Lowercase: true},
+		},
+	}))
+
+	path := filepath.Join(t.TempDir(), "export.kdbx")
+	credentials := Credentials{Password: "kdbx-password"}
+	written, err := ExportKDBX(source, path, credentials)
+	require.NoError(t, err, "Failed to export KDBX")
+	assert.Equal(t, 3, written)
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm(), "Export should only be readable by the owner")
+
+	target := newStore(t)
+	added, skipped, err := ImportKDBX(target, path, credentials)
+	require.NoError(t, err, "Failed to import KDBX")
+	assert.Equal(t, 3, added)
+	assert.Zero(t, skipped)
+
+	accounts, err := target.GetAccounts()
+	require.NoError(t, err)
+	require.Len(t, accounts, 3)
+	byPlatform := make(map[string]*model.Account)
+	for _, account := range accounts {
+		byPlatform[account.Platform] = account
+	}
+
+	github := byPlatform["GitHub"]
+	require.NotNil(t, github)
+	assert.Equal(t, "octo", github.Username)
+	assert.Equal(t, "octo@example.com", github.Email, "Email custom string should map back to the email field")
+	assert.Equal(t, "current <&> secret", decrypt(t, target, github.EncryptedPassword))
+	assert.Equal(t, "line one\nline two", github.Notes)
+	assert.Equal(t, "Work/Dev", github.Group, "Nested groups should map to a path")
+	assert.Equal(t, []string{"code", "work"}, github.Tags)
+	assert.Equal(t, created, github.CreatedAt.UTC())
+	require.Len(t, github.CustomFields, 2)
+	assert.Equal(t, "Recovery code", github.CustomFields[0].Name)
+	assert.True(t, github.CustomFields[0].Protected)
+	assert.Equal(t, "1234-5678", decrypt(t, target, github.CustomFields[0].Value))
+	assert.Equal(t, model.CustomField{Name: "Plan", Value: "Pro"}, github.CustomFields[1])
+	require.Len(t, github.History, 1)
+	assert.Equal(t, "old secret", decrypt(t, target, github.History[0].EncryptedPassword))
+
+	assert.Equal(t, "Personal", byPlatform["Mail"].Group)
+	assert.Empty(t, decrypt(t, target, byPlatform["example.org"].EncryptedPassword), "Derived passwords are exported empty")
+
+	// Importing the same file again finds every entry already present
+	added, skipped, err = ImportKDBX(target, path, credentials)
+	require.NoError(t, err)
+	assert.Zero(t, added)
+	assert.Equal(t, 3, skipped)
+
+	// Accounts that came from KeePass keep their UUID on export
+	assert.Equal(t, entryUUID(github.ID), entryUUID("a1b2c3d4e5f60718"))
+}
+
+// keePassXCDocument follows the layout KeePassXC writes, including elements
+// this package ignores, a recycle bin and KDBX 4 binary timestamps.
+func keePassXCDocument() string {
+	stamp := encodeTime(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))
+	times := fmt.Sprintf(`<Times><CreationTime>%[1]s</CreationTime><LastModificationTime>%[1]s</LastModificationTime>`+
+		`<LastAccessTime>%[1]s</LastAccessTime><ExpiryTime>%[1]s</ExpiryTime><Expires>False</Expires>`+
+		`<UsageCount>0</UsageCount><LocationChanged>%[1]s</LocationChanged></Times>`, stamp)
+	return `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<KeePassFile>
+	<Meta>
+		<Generator>KeePassXC</Generator>
+		<DatabaseName>Team</DatabaseName>
+		<DatabaseNameChanged>` + stamp + `</DatabaseNameChanged>
+		<MemoryProtection><ProtectTitle>False</ProtectTitle><ProtectPassword>True</ProtectPassword></MemoryProtection>
+		<RecycleBinEnabled>True</RecycleBinEnabled>
+		<RecycleBinUUID>Ut5d2FhTT7mHJrF8Xpl1lA==</RecycleBinUUID>
+		<CustomData><Item><Key>KPXC_DECRYPTION_TIME_PREFERENCE</Key><Value>1000</Value></Item></CustomData>
+	</Meta>
+	<Root>
+		<Group>
+			<UUID>AAECAwQFBgcICQoLDA0ODw==</UUID>
+			<Name>Team</Name>
+			` + times + `
+			<IsExpanded>True</IsExpanded>
+			<Entry>
+				<UUID>EBESExQVFhcYGRobHB0eHw==</UUID>
+				<IconID>0</IconID>
+				<Tags>server;network, home</Tags>
+				` + times + `
+				<String><Key>Notes</Key><Value/></String>
+				<String><Key>Password</Key><Value Protected="True">root &amp; pass</Value></String>
+				<String><Key>SSH passphrase</Key><Value Protected="True">phrase</Value></String>
+				<String><Key>Title</Key><Value>Router</Value></String>
+				<String><Key>URL</Key><Value>http://192.168.1.1</Value></String>
+				<String><Key>UserName</Key><Value>admin</Value></String>
+				<Binary><Key>config.txt</Key><Value Ref="0"/></Binary>
+				<AutoType><Enabled>True</Enabled><DataTransferObfuscation>0</DataTransferObfuscation></AutoType>
+				<History>
+					<Entry>
+						<UUID>EBESExQVFhcYGRobHB0eHw==</UUID>
+						` + times + `
+						<String><Key>Password</Key><Value Protected="True">first</Value></String>
+						<String><Key>Title</Key><Value>Router</Value></String>
+						<String><Key>UserName</Key><Value>admin</Value></String>
+					</Entry>
+				</History>
+			</Entry>
+			<Group>
+				<UUID>ICEiIyQlJicoKSorLC0uLw==</UUID>
+				<Name>Servers</Name>
+				<Group>
+					<UUID>MDEyMzQ1Njc4OTo7PD0+Pw==</UUID>
+					<Name>Web</Name>
+					<Entry>
+						<UUID>QEFCQ0RFRkdISUpLTE1OTw==</UUID>
+						` + times + `
+						<String><Key>Password</Key><Value Protected="True">web-pass</Value></String>
+						<String><Key>Title</Key><Value>nginx</Value></String>
+						<String><Key>UserName</Key><Value>deploy</Value></String>
+					</Entry>
+				</Group>
+			</Group>
+			<Group>
+				<UUID>Ut5d2FhTT7mHJrF8Xpl1lA==</UUID>
+				<Name>Recycle Bin</Name>
+				<Entry>
+					<UUID>UFFSU1RVVldYWVpbXF1eXw==</UUID>
+					<String><Key>Password</Key><Value Protected="True">deleted</Value></String>
+					<String><Key>Title</Key><Value>Old</Value></String>
+				</Entry>
+			</Group>
+		</Group>
+		<DeletedObjects/>
+	</Root>
+</KeePassFile>`
+}
+
+func TestImportKeePassXCLayout(t *testing.T) {
+	kdfs := []struct {
+		name       string
+		cipherID   []byte
+		parameters map[string]any
+	}{
+		{"Argon2d with AES", cipherAES256, map[string]any{
+			"$UUID": kdfArgon2d, "S": bytes.Repeat([]byte{1}, 32), "I": uint64(2), "M": uint64(1 << 20), "P": uint32(2), "V": uint32(argon2Version),
+		}},
+		{"Argon2id with ChaCha20", cipherChaCha20, map[string]any{
+			"$UUID": kdfArgon2id, "S": bytes.Repeat([]byte{2}, 32), "I": uint64(2), "M": uint64(1 << 20), "P": uint32(1), "V": uint32(argon2Version),
+		}},
+		{"AES-KDF with ChaCha20", cipherChaCha20, map[string]any{
+			"$UUID": kdfAESKDBX4, "S": bytes.Repeat([]byte{3}, 32), "R": uint64(1000),
+		}},
+	}
+
+	for _, kdf := range kdfs {
+		t.Run(kdf.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "team.kdbx")
+			var buf bytes.Buffer
+			credentials := Credentials{Password: "team"}
+			require.NoError(t, writeDocument(&buf, []byte(keePassXCDocument()), credentials, kdf.cipherID, kdf.parameters))
+			require.NoError(t, os.WriteFile(path, buf.Bytes(), 0600))
+
+			store := newStore(t)
+			added, skipped, err := ImportKDBX(store, path, credentials)
+			require.NoError(t, err, "Failed to import KDBX")
+			assert.Equal(t, 2, added, "Recycle bin entries should be ignored")
+			assert.Zero(t, skipped)
+
+			accounts, err := store.GetAccounts()
+			require.NoError(t, err)
+			require.Len(t, accounts, 2)
+
+			router := accounts[0]
+			assert.Equal(t, "101112131415161718191a1b1c1d1e1f", router.ID)
+			assert.Equal(t, "Router", router.Platform)
+			assert.Equal(t, "admin", router.Username)
+			assert.Equal(t, "root & pass", decrypt(t, store, router.EncryptedPassword))
+			assert.Empty(t, router.Group, "Entries in the root group should be ungrouped")
+			assert.Equal(t, []string{"server", "network", "home"}, router.Tags)
+			assert.Equal(t, time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), router.UpdatedAt.UTC())
+			require.Len(t, router.CustomFields, 1)
+			assert.Equal(t, "SSH passphrase", router.CustomFields[0].Name)
+			assert.Equal(t, "phrase", decrypt(t, store, router.CustomFields[0].Value))
+			require.Len(t, router.History, 1)
+			assert.Equal(t, "first", decrypt(t, store, router.History[0].EncryptedPassword))
+
+			assert.Equal(t, "Servers/Web", accounts[1].Group)
+			assert.Equal(t, "web-pass", decrypt(t, store, accounts[1].EncryptedPassword))
+		})
+	}
+}
+
+func TestImportKDBXFiles(t *testing.T) {
+	// The files in testdata are written by testdata/make_kdbx.py, which has
+	// its own KDBX, Argon2 and ChaCha20 code, so they do not depend on this
+	// package's writer.
+	files := []struct {
+		name        string
+		credentials Credentials
+	}{
+		{"argon2d-aes.kdbx", Credentials{Password: "correct horse"}},
+		{"argon2id-chacha20.kdbx", Credentials{Password: "correct horse", KeyFile: filepath.Join("testdata", "argon2id-chacha20.keyx")}},
+	}
+
+	for _, file := range files {
+		t.Run(file.name, func(t *testing.T) {
+			path := filepath.Join("testdata", file.name)
+			store := newStore(t)
+			added, skipped, err := ImportKDBX(store, path, file.credentials)
+			require.NoError(t, err, "Failed to import KDBX")
+			assert.Equal(t, 2, added, "Recycle bin entries should be ignored")
+			assert.Zero(t, skipped)
+
+			accounts, err := store.GetAccounts()
+			require.NoError(t, err)
+			require.Len(t, accounts, 2)
+
+			router := accounts[0]
+			assert.Equal(t, "df151c772e8849bdb1ab716f875c96d4", router.ID)
+			assert.Equal(t, "Router", router.Platform)
+			assert.Equal(t, "admin", router.Username)
+			assert.Equal(t, "https://192.168.1.1", router.URL)
+			assert.Equal(t, "Rack 2, top shelf", router.Notes)
+			assert.Equal(t, "root & <pass>", decrypt(t, store, router.EncryptedPassword))
+			assert.Empty(t, router.Group)
+			assert.Equal(t, []string{"network", "home"}, router.Tags)
+			assert.Equal(t, time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC), router.UpdatedAt.UTC())
+			assert.Equal(t, time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC), router.CreatedAt.UTC())
+			require.Len(t, router.CustomFields, 1)
+			assert.Equal(t, "otp", router.CustomFields[0].Name)
+			assert.True(t, router.CustomFields[0].Protected)
+			assert.Equal(t, "otpauth://totp/Router?secret=JBSWY3DPEHPK3PXP", decrypt(t, store, router.CustomFields[0].Value))
+			require.Len(t, router.History, 1)
+			assert.Equal(t, "first-pass", decrypt(t, store, router.History[0].EncryptedPassword))
+
+			web := accounts[1]
+			assert.Equal(t, "1eadabd0755743168fa6f0b74b64dbc8", web.ID)
+			assert.Equal(t, "Servers/Web", web.Group)
+			assert.Equal(t, "deploy", web.Username)
+			assert.Equal(t, "wëb-pass ☕", decrypt(t, store, web.EncryptedPassword))
+
+			_, err = readKDBX(bytes.NewReader(mustReadFile(t, path)), Credentials{Password: "wrong"})
+			assert.ErrorIs(t, err, errors.ErrInvalidCredentials)
+		})
+	}
+}
+
+func mustReadFile(t *testing.T, path string) []byte {
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	return data
+}
+
+func TestTransformKeyLimits(t *testing.T) {
+	salt := bytes.Repeat([]byte{1}, 32)
+	tests := []struct {
+		name       string
+		parameters map[string]any
+	}{
+		{"Argon2 memory", map[string]any{"$UUID": kdfArgon2d, "S": salt, "I": uint64(2), "M": uint64(math.MaxUint32) << 10, "P": uint32(2), "V": uint32(argon2Version)}},
+		{"Argon2 iterations", map[string]any{"$UUID": kdfArgon2id, "S": salt, "I": uint64(math.MaxUint32), "M": uint64(1 << 20), "P": uint32(2), "V": uint32(argon2Version)}},
+		{"Argon2 parallelism", map[string]any{"$UUID": kdfArgon2id, "S": salt, "I": uint64(2), "M": uint64(1 << 20), "P": uint32(1 << 24), "V": uint32(argon2Version)}},
+		{"AES-KDF rounds", map[string]any{"$UUID": kdfAESKDBX4, "S": salt, "R": uint64(math.MaxUint64)}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := transformKey(make([]byte, 32), test.parameters)
+			assert.ErrorIs(t, err, errors.ErrImportFormat)
+		})
+	}
+}
+
+func TestReadKDBXErrors(t *testing.T) {
+	useFastKDF(t)
+	var buf bytes.Buffer
+	file := &keePassFile{Root: kdbxRoot{Group: kdbxGroup{UUID: groupUUID(""), Name: "Root"}}}
+	require.NoError(t, writeKDBX(&buf, file, Credentials{Password: "right"}))
+	data := buf.Bytes()
+
+	_, err := readKDBX(bytes.NewReader(data), Credentials{Password: "right"})
+	require.NoError(t, err, "Failed to read a valid file")
+
+	tampered := bytes.Clone(data)
+	tampered[len(tampered)-50] ^= 0xFF
+
+	version3 := bytes.Clone(data)
+	version3[10] = 3
+
+	tests := []struct {
+		name        string
+		data        []byte
+		credentials Credentials
+		target      error
+	}{
+		{"Wrong password", data, Credentials{Password: "wrong"}, errors.ErrInvalidCredentials},
+		{"Tampered payload", tampered, Credentials{Password: "right"}, errors.ErrDataCorrupted},
+		{"Truncated file", data[:len(data)/2], Credentials{Password: "right"}, errors.ErrDataCorrupted},
+		{"Not a KeePass file", []byte("name,url,username,password\n"), Credentials{}, errors.ErrImportFormat},
+		{"KDBX 3", version3, Credentials{Password: "right"}, errors.ErrImportFormat},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := readKDBX(bytes.NewReader(test.data), test.credentials)
+			assert.ErrorIs(t, err, test.target)
+		})
+	}
+}
+
+func TestKeyFile(t *testing.T) {
+	useFastKDF(t)
+	dir := t.TempDir()
+	key := bytes.Repeat([]byte{0xAB}, 32)
+
+	files := map[string]string{
+		"v2.keyx": `<?xml version="1.0" encoding="UTF-8"?>
+<KeyFile><Meta><Version>2.0</Version></Meta><Key><Data Hash="00000000">
+ABABABAB ABABABAB ABABABAB ABABABAB
+ABABABAB ABABABAB ABABABAB ABABABAB
+</Data></Key></KeyFile>`,
+		"v1.key":  `<KeyFile><Meta><Version>1.00</Version></Meta><Key><Data>q6urq6urq6urq6urq6urq6urq6urq6urq6urq6urq6s=</Data></Key></KeyFile>`,
+		"raw.key": string(key),
+		"hex.key": strings.Repeat("ab", 32),
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+		data, err := readKeyFile(path)
+		require.NoError(t, err, "Failed to read %s", name)
+		assert.Equal(t, key, data, "Key of %s", name)
+	}
+
+	// Any other file is hashed
+	other := filepath.Join(dir, "photo.jpg")
+	require.NoError(t, os.WriteFile(other, []byte("not a key"), 0600))
+	data, err := readKeyFile(other)
+	require.NoError(t, err)
+	assert.Len(t, data, 32)
+
+	// A file locked with a password and a key file needs both
+	var buf bytes.Buffer
+	file := &keePassFile{Root: kdbxRoot{Group: kdbxGroup{UUID: groupUUID(""), Name: "Root"}}}
+	both := Credentials{Password: "pw", KeyFile: filepath.Join(dir, "v2.keyx")}
+	require.NoError(t, writeKDBX(&buf, file, both))
+
+	_, err = readKDBX(bytes.NewReader(buf.Bytes()), both)
+	assert.NoError(t, err)
+	_, err = readKDBX(bytes.NewReader(buf.Bytes()), Credentials{Password: "pw"})
+	assert.ErrorIs(t, err, errors.ErrInvalidCredentials)
+	_, err = readKDBX(bytes.NewReader(buf.Bytes()), Credentials{KeyFile: filepath.Join(dir, "hex.key")})
+	assert.ErrorIs(t, err, errors.ErrInvalidCredentials)
+}
@@ -0,0 +1,386 @@
+// Package interchange converts vault accounts to and from the file formats of
+// other password managers that support both directions, such as KeePass.
+package interchange
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/simp-lee/passwordmanager/internal/crypto"
+	"github.com/simp-lee/passwordmanager/internal/errors"
+	"github.com/simp-lee/passwordmanager/internal/model"
+	"github.com/simp-lee/passwordmanager/internal/storage"
+)
+
+// Standard KeePass entry fields; every other string becomes a custom field.
+const (
+	keyTitle    = "Title"
+	keyUserName = "UserName"
+	keyPassword = "Password"
+	keyURL      = "URL"
+	keyNotes    = "Notes"
+	keyEmail    = "Email"
+)
+
+// ImportKDBX reads the KDBX 4 file at path and adds its entries to the
+// unlocked vault. Nested groups become "/"-separated account groups, custom
+// strings become custom fields, tags are kept and so is entry history. Entries in the
+// recycle bin are ignored, and entries whose UUID is already an account ID
+// (because they were imported before) are skipped.
+// Returns the number of accounts added and skipped.
+func ImportKDBX(store *storage.Storage, path string, credentials Credentials) (int, int, error) {
+	key := store.GetEncryptionKey()
+	if key == nil {
+		return 0, 0, errors.ErrVaultLocked
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "failed to open KDBX file")
+	}
+	defer file.Close()
+
+	database, err := readKDBX(file, credentials)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	existing, err := store.GetAccounts()
+	if err != nil {
+		return 0, 0, err
+	}
+	known := make(map[string]bool, len(existing))
+	for _, account := range existing {
+		known[account.ID] = true
+	}
+
+	recycleBin := ""
+	if strings.EqualFold(database.Meta.RecycleBinEnabled, "true") {
+		recycleBin = database.Meta.RecycleBinUUID
+	}
+
+	var accounts []*model.Account
+	skipped := 0
+	var walk func(group *kdbxGroup, path string) error
+	walk = func(group *kdbxGroup, path string) error {
+		if recycleBin != "" && group.UUID == recycleBin {
+			return nil
+		}
+		for _, entry := range group.Entries {
+			account, err := entryToAccount(entry, path, key)
+			if err != nil {
+				return err
+			}
+			if account == nil || known[account.ID] {
+				skipped++
+				continue
+			}
+			known[account.ID] = true
+			accounts = append(accounts, account)
+		}
+		for _, child := range group.Groups {
+			childPath := child.Name
+			if path != "" {
+				childPath = path + "/" + child.Name
+			}
+			if err := walk(child, childPath); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	// The root group stands for the database itself and is not a vault group
+	if err := walk(&database.Root.Group, ""); err != nil {
+		return 0, 0, err
+	}
+
+	if len(accounts) > 0 {
+		if err := store.AddAccounts(accounts); err != nil {
+			return 0, 0, err
+		}
+	}
+	return len(accounts), skipped, nil
+}
+
+// ExportKDBX writes every account of the unlocked vault to a new KDBX 4 file
+// at path, protected by credentials. Groups are nested on "/". Derived
+// passwords are not stored and are exported empty.
+// Returns the number of entries written.
+func ExportKDBX(store *storage.Storage, path string, credentials Credentials) (int, error) {
+	key := store.GetEncryptionKey()
+	if key == nil {
+		return 0, errors.ErrVaultLocked
+	}
+	accounts, err := store.GetAccounts()
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	root := &kdbxGroup{UUID: groupUUID(""), Name: "Root"}
+	for _, account := range accounts {
+		entry, err := accountToEntry(account, key)
+		if err != nil {
+			return 0, err
+		}
+		group := root
+		if account.Group != "" {
+			group = findGroup(root, account.Group)
+		}
+		group.Entries = append(group.Entries, entry)
+	}
+
+	database := &keePassFile{
+		Meta: kdbxMeta{
+			Generator:         "passwordmanager",
+			DatabaseName:      fmt.Sprintf("Passwords %s", now.Format("2006-01-02")),
+			RecycleBinEnabled: "False",
+			RecycleBinUUID:    base64.StdEncoding.EncodeToString(make([]byte, 16)),
+		},
+		Root: kdbxRoot{Group: *root},
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to create KDBX file")
+	}
+	if err := writeKDBX(file, database, credentials); err != nil {
+		file.Close()
+		return 0, err
+	}
+	if err := file.Close(); err != nil {
+		return 0, errors.Wrap(err, "failed to write KDBX file")
+	}
+	return len(accounts), nil
+}
+
+// entryToAccount converts an entry and its history. Secrets are encrypted
+// with key. Returns nil for entries without any identifying field.
+func entryToAccount(entry kdbxEntry, group string, key []byte) (*model.Account, error) {
+	uuid, err := base64.StdEncoding.DecodeString(entry.UUID)
+	if err != nil || len(uuid) != 16 {
+		return nil, errors.Wrap(errors.ErrImportFormat, "invalid entry UUID")
+	}
+
+	platform := strings.TrimSpace(entry.get(keyTitle))
+	if platform == "" {
+		platform = strings.TrimSpace(entry.get(keyURL))
+	}
+	if platform == "" {
+		platform = strings.TrimSpace(entry.get(keyUserName))
+	}
+	if platform == "" {
+		return nil, nil
+	}
+
+	encryptedPassword, err := crypto.Encrypt([]byte(entry.get(keyPassword)), key)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("failed to encrypt password for %s", platform))
+	}
+
+	account := &model.Account{
+		ID:                hex.EncodeToString(uuid),
+		Platform:          platform,
+		Username:          entry.get(keyUserName),
+		EncryptedPassword: encryptedPassword,
+		URL:               entry.get(keyURL),
+		Notes:             entry.get(keyNotes),
+		Group:             group,
+		Tags:              splitTags(entry.Tags),
+		CreatedAt:         decodeTime(entry.Times.CreationTime),
+		UpdatedAt:         decodeTime(entry.Times.LastModificationTime),
+	}
+
+	for _, field := range entry.Strings {
+		switch field.Key {
+		case keyTitle, keyUserName, keyPassword, keyURL, keyNotes:
+			continue
+		}
+		protected := strings.EqualFold(field.Value.Protected, "true")
+		if strings.EqualFold(field.Key, keyEmail) && !protected && account.Email == "" {
+			account.Email = field.Value.Text
+			continue
+		}
+
+		value := field.Value.Text
+		if protected {
+			if value, err = crypto.Encrypt([]byte(value), key); err != nil {
+				return nil, errors.Wrap(err, fmt.Sprintf("failed to encrypt field %q for %s", field.Key, platform))
+			}
+		}
+		account.CustomFields = append(account.CustomFields, model.CustomField{Name: field.Key, Value: value, Protected: protected})
+	}
+
+	if entry.History != nil {
+		for _, old := range entry.History.Entries {
+			historyEntry := model.HistoryEntry{
+				Username:  old.get(keyUserName),
+				URL:       old.get(keyURL),
+				Notes:     old.get(keyNotes),
+				UpdatedAt: decodeTime(old.Times.LastModificationTime),
+			}
+			for _, field := range old.Strings {
+				if strings.EqualFold(field.Key, keyEmail) {
+					historyEntry.Email = field.Value.Text
+				}
+			}
+			if password := old.get(keyPassword); password != "" {
+				if historyEntry.EncryptedPassword, err = crypto.Encrypt([]byte(password), key); err != nil {
+					return nil, errors.Wrap(err, fmt.Sprintf("failed to encrypt history for %s", platform))
+				}
+			}
+			account.History = append(account.History, historyEntry)
+		}
+	}
+
+	return account, nil
+}
+
+// accountToEntry converts an account and its history, decrypting secrets with key.
+func accountToEntry(account *model.Account, key []byte) (kdbxEntry, error) {
+	uuid := entryUUID(account.ID)
+	password := ""
+	if account.Derived == nil {
+		decrypted, err := crypto.Decrypt(account.EncryptedPassword, key)
+		if err != nil {
+			return kdbxEntry{}, errors.Wrap(err, fmt.Sprintf("failed to decrypt password for account ID %s", account.ID))
+		}
+		password = string(decrypted)
+		crypto.ClearBytes(decrypted)
+	}
+
+	entry := kdbxEntry{
+		UUID:  uuid,
+		Tags:  strings.Join(account.Tags, ";"),
+		Times: entryTimes(account.CreatedAt, account.UpdatedAt),
+		Strings: entryStrings(account.Platform, account.Username, password, account.URL, account.Notes,
+			account.Email),
+	}
+
+	for _, field := range account.CustomFields {
+		value := field.Value
+		if field.Protected {
+			decrypted, err := crypto.Decrypt(field.Value, key)
+			if err != nil {
+				return kdbxEntry{}, errors.Wrap(err, fmt.Sprintf("failed to decrypt field %q for account ID %s", field.Name, account.ID))
+			}
+			value = string(decrypted)
+			crypto.ClearBytes(decrypted)
+		}
+		entry.Strings = append(entry.Strings, kdbxString{Key: field.Name, Value: protectedValue(value, field.Protected)})
+	}
+
+	if len(account.History) > 0 {
+		entry.History = &kdbxHistory{}
+		for _, old := range account.History {
+			oldPassword := ""
+			if old.EncryptedPassword != "" {
+				decrypted, err := crypto.Decrypt(old.EncryptedPassword, key)
+				if err != nil {
+					return kdbxEntry{}, errors.Wrap(err, fmt.Sprintf("failed to decrypt history for account ID %s", account.ID))
+				}
+				oldPassword = string(decrypted)
+				crypto.ClearBytes(decrypted)
+			}
+			entry.History.Entries = append(entry.History.Entries, kdbxEntry{
+				UUID:    uuid,
+				Times:   entryTimes(account.CreatedAt, old.UpdatedAt),
+				Strings: entryStrings(account.Platform, old.Username, oldPassword, old.URL, old.Notes, old.Email),
+			})
+		}
+	}
+
+	return entry, nil
+}
+
+// entryStrings returns the standard fields of an entry; the password is protected.
+func entryStrings(title, username, password, url, notes, email string) []kdbxString {
+	strs := []kdbxString{
+		{Key: keyTitle, Value: protectedValue(title, false)},
+		{Key: keyUserName, Value: protectedValue(username, false)},
+		{Key: keyPassword, Value: protectedValue(password, true)},
+		{Key: keyURL, Value: protectedValue(url, false)},
+		{Key: keyNotes, Value: protectedValue(notes, false)},
+	}
+	if email != "" {
+		strs = append(strs, kdbxString{Key: keyEmail, Value: protectedValue(email, false)})
+	}
+	return strs
+}
+
+func protectedValue(text string, protected bool) kdbxValue {
+	if protected {
+		return kdbxValue{Protected: "True", Text: text}
+	}
+	return kdbxValue{Text: text}
+}
+
+func entryTimes(created, updated time.Time) kdbxTimes {
+	return kdbxTimes{
+		CreationTime:         encodeTime(created),
+		LastModificationTime: encodeTime(updated),
+		LastAccessTime:       encodeTime(updated),
+		ExpiryTime:           encodeTime(updated),
+		Expires:              "False",
+		LocationChanged:      encodeTime(updated),
+	}
+}
+
+// splitTags splits the tag list of an entry on ";" and ",".
+func splitTags(tags string) []string {
+	var result []string
+	for _, tag := range strings.FieldsFunc(tags, func(r rune) bool { return r == ';' || r == ',' }) {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			result = append(result, tag)
+		}
+	}
+	return result
+}
+
+// findGroup returns the group for a "/"-separated path below root, creating
+// the missing levels.
+func findGroup(root *kdbxGroup, path string) *kdbxGroup {
+	group := root
+	current := ""
+	for _, name := range strings.Split(path, "/") {
+		if name == "" {
+			continue
+		}
+		current += "/" + name
+
+		var next *kdbxGroup
+		for _, child := range group.Groups {
+			if child.Name == name {
+				next = child
+				break
+			}
+		}
+		if next == nil {
+			next = &kdbxGroup{UUID: groupUUID(current), Name: name}
+			group.Groups = append(group.Groups, next)
+		}
+		group = next
+	}
+	return group
+}
+
+// entryUUID maps an account ID to an entry UUID. IDs that came from a KeePass
+// UUID map back to it; others are hashed so repeated exports stay stable.
+func entryUUID(id string) string {
+	if uuid, err := hex.DecodeString(id); err == nil && len(uuid) == 16 {
+		return base64.StdEncoding.EncodeToString(uuid)
+	}
+	hash := sha256.Sum256([]byte("account:" + id))
+	return base64.StdEncoding.EncodeToString(hash[:16])
+}
+
+// groupUUID derives a stable UUID from a group path.
+func groupUUID(path string) string {
+	hash := sha256.Sum256([]byte("group:" + path))
+	return base64.StdEncoding.EncodeToString(hash[:16])
+}
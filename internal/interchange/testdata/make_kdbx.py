@@ -0,0 +1,620 @@
+#!/usr/bin/env python3
+"""Writes the KDBX 4 fixtures in this directory.
+
+The files follow the layout KeePassXC 2.7 saves: header fields in its order,
+an inner header with an attachment, a gzip-compressed document with memory
+protection, history, a recycle bin and KeePassXC custom data. They are made
+by this script rather than by the Go package, so that a mistake shared by the
+package's reader and writer cannot hide itself. Argon2 and ChaCha20 are
+implemented here from RFC 9106 and RFC 8439; AES-256-CBC comes from openssl.
+
+Run it from this directory: python3 make_kdbx.py
+"""
+
+import base64
+import gzip
+import hashlib
+import hmac
+import struct
+import subprocess
+
+MASK = (1 << 64) - 1
+
+
+# Argon2 (RFC 9106), version 0x13
+
+def blake2b_long(data, length):
+    data = struct.pack("<I", length) + data
+    if length <= 64:
+        return hashlib.blake2b(data, digest_size=length).digest()
+    out = b""
+    v = hashlib.blake2b(data).digest()
+    while length - len(out) > 64:
+        out += v[:32]
+        v = hashlib.blake2b(v).digest()
+    remaining = length - len(out)
+    if remaining < 64:
+        v = hashlib.blake2b(v, digest_size=remaining).digest()
+    return out + v
+
+
+def rotr(x, n):
+    return ((x >> n) | (x << (64 - n))) & MASK
+
+
+def permute(v, i0, i1, i2, i3, i4, i5, i6, i7, i8, i9, i10, i11, i12, i13, i14, i15):
+    def gb(a, b, c, d):
+        va, vb, vc, vd = v[a], v[b], v[c], v[d]
+        va = (va + vb + 2 * (va & 0xFFFFFFFF) * (vb & 0xFFFFFFFF)) & MASK
+        vd = rotr(vd ^ va, 32)
+        vc = (vc + vd + 2 * (vc & 0xFFFFFFFF) * (vd & 0xFFFFFFFF)) & MASK
+        vb = rotr(vb ^ vc, 24)
+        va = (va + vb + 2 * (va & 0xFFFFFFFF) * (vb & 0xFFFFFFFF)) & MASK
+        vd = rotr(vd ^ va, 16)
+        vc = (vc + vd + 2 * (vc & 0xFFFFFFFF) * (vd & 0xFFFFFFFF)) & MASK
+        vb = rotr(vb ^ vc, 63)
+        v[a], v[b], v[c], v[d] = va, vb, vc, vd
+
+    gb(i0, i4, i8, i12)
+    gb(i1, i5, i9, i13)
+    gb(i2, i6, i10, i14)
+    gb(i3, i7, i11, i15)
+    gb(i0, i5, i10, i15)
+    gb(i1, i6, i11, i12)
+    gb(i2, i7, i8, i13)
+    gb(i3, i4, i9, i14)
+
+
+def compress(x, y):
+    r = [a ^ b for a, b in zip(x, y)]
+    q = list(r)
+    for i in range(8):
+        base = 16 * i
+        permute(q, *range(base, base + 16))
+    for i in range(8):
+        idx = []
+        for j in range(8):
+            idx += [2 * i + 16 * j, 2 * i + 16 * j + 1]
+        permute(q, *idx)
+    return [a ^ b for a, b in zip(q, r)]
+
+
+def to_block(data):
+    return list(struct.unpack("<128Q", data))
+
+
+def from_block(block):
+    return struct.pack("<128Q", *block)
+
+
+ZERO = [0] * 128
+
+
+def argon2(mode, password, salt, secret, data, time_cost, memory_kib, lanes, tag_length):
+    """mode is 0 for Argon2d and 2 for Argon2id."""
+    h0 = hashlib.blake2b(
+        struct.pack("<IIIIII", lanes, tag_length, memory_kib, time_cost, 0x13, mode)
+        + struct.pack("<I", len(password)) + password
+        + struct.pack("<I", len(salt)) + salt
+        + struct.pack("<I", len(secret)) + secret
+        + struct.pack("<I", len(data)) + data
+    ).digest()
+
+    memory = 4 * lanes * (memory_kib // (4 * lanes))
+    lane_length = memory // lanes
+    segment = lane_length // 4
+    blocks = [[None] * lane_length for _ in range(lanes)]
+    for lane in range(lanes):
+        for i in range(2):
+            blocks[lane][i] = to_block(blake2b_long(h0 + struct.pack("<II", i, lane), 1024))
+
+    for t in range(time_cost):
+        for s in range(4):
+            for lane in range(lanes):
+                independent = mode == 2 and t == 0 and s < 2
+                addresses = []
+                counter = 0
+                for index in range(segment):
+                    column = s * segment + index
+                    if t == 0 and column < 2:
+                        continue
+                    previous = blocks[lane][column - 1 if column else lane_length - 1]
+                    if independent:
+                        if index % 128 == 0 or not addresses:
+                            counter += 1
+                            z = [t, lane, s, memory, time_cost, mode, counter] + [0] * 121
+                            addresses = compress(ZERO, compress(ZERO, z))
+                        pseudo = addresses[index % 128]
+                    else:
+                        pseudo = previous[0]
+                    j1 = pseudo & 0xFFFFFFFF
+                    j2 = pseudo >> 32
+
+                    ref_lane = lane if t == 0 and s == 0 else j2 % lanes
+                    if t == 0:
+                        if ref_lane == lane:
+                            area = s * segment + index - 1
+                        else:
+                            area = s * segment - (1 if index == 0 else 0)
+                    else:
+                        if ref_lane == lane:
+                            area = lane_length - segment + index - 1
+                        else:
+                            area = lane_length - segment - (1 if index == 0 else 0)
+                    x = (j1 * j1) >> 32
+                    y = (area * x) >> 32
+                    relative = area - 1 - y
+                    start = 0 if t == 0 else ((s + 1) * segment) % lane_length
+                    reference = blocks[ref_lane][(start + relative) % lane_length]
+
+                    block = compress(previous, reference)
+                    if t > 0:
+                        block = [a ^ b for a, b in zip(block, blocks[lane][column])]
+                    blocks[lane][column] = block
+
+    final = blocks[0][lane_length - 1]
+    for lane in range(1, lanes):
+        final = [a ^ b for a, b in zip(final, blocks[lane][lane_length - 1])]
+    return blake2b_long(from_block(final), tag_length)
+
+
+def check_argon2():
+    password = b"\x01" * 32
+    salt = b"\x02" * 16
+    secret = b"\x03" * 8
+    data = b"\x04" * 12
+    expected = {
+        0: "512b391b6f1162975371d30919734294f868e3be3984f3c1a13a4db9fabe4acb",
+        2: "0d640df58d78766c08c037a34a8b53c9d01ef0452d75b65eb52520e96b01e659",
+    }
+    for mode, tag in expected.items():
+        got = argon2(mode, password, salt, secret, data, 3, 32, 4, 32).hex()
+        assert got == tag, "Argon2 mode %d: %s" % (mode, got)
+
+
+# ChaCha20 (RFC 8439)
+
+def chacha20_block(key, counter, nonce):
+    def rotl(x, n):
+        return ((x << n) | (x >> (32 - n))) & 0xFFFFFFFF
+
+    def qr(s, a, b, c, d):
+        s[a] = (s[a] + s[b]) & 0xFFFFFFFF
+        s[d] = rotl(s[d] ^ s[a], 16)
+        s[c] = (s[c] + s[d]) & 0xFFFFFFFF
+        s[b] = rotl(s[b] ^ s[c], 12)
+        s[a] = (s[a] + s[b]) & 0xFFFFFFFF
+        s[d] = rotl(s[d] ^ s[a], 8)
+        s[c] = (s[c] + s[d]) & 0xFFFFFFFF
+        s[b] = rotl(s[b] ^ s[c], 7)
+
+    state = [0x61707865, 0x3320646E, 0x79622D32, 0x6B206574]
+    state += list(struct.unpack("<8I", key)) + [counter] + list(struct.unpack("<3I", nonce))
+    working = list(state)
+    for _ in range(10):
+        qr(working, 0, 4, 8, 12)
+        qr(working, 1, 5, 9, 13)
+        qr(working, 2, 6, 10, 14)
+        qr(working, 3, 7, 11, 15)
+        qr(working, 0, 5, 10, 15)
+        qr(working, 1, 6, 11, 12)
+        qr(working, 2, 7, 8, 13)
+        qr(working, 3, 4, 9, 14)
+    return struct.pack("<16I", *[(a + b) & 0xFFFFFFFF for a, b in zip(working, state)])
+
+
+class ChaCha20:
+    def __init__(self, key, nonce):
+        self.key, self.nonce, self.counter, self.buffer = key, nonce, 0, b""
+
+    def xor(self, data):
+        while len(self.buffer) < len(data):
+            self.buffer += chacha20_block(self.key, self.counter, self.nonce)
+            self.counter += 1
+        stream, self.buffer = self.buffer[:len(data)], self.buffer[len(data):]
+        return bytes(a ^ b for a, b in zip(data, stream))
+
+
+def check_chacha20():
+    # RFC 8439, section 2.4.2
+    key = bytes(range(32))
+    nonce = bytes.fromhex("000000000000004a00000000")
+    stream = ChaCha20(key, nonce)
+    stream.counter = 1
+    text = b"Ladies and Gentlemen of the class of '99: If I could offer you only one tip for the future, sunscreen would be it."
+    assert stream.xor(text)[:16].hex() == "6e2e359a2568f98041ba0728dd0d6981"
+
+
+# KDBX 4
+
+CIPHER_AES256 = bytes.fromhex("31c1f2e6bf714350be5805216afc5aff")
+CIPHER_CHACHA20 = bytes.fromhex("d6038a2b8b6f4cb5a524339a31dbb59a")
+KDF_ARGON2D = bytes.fromhex("ef636ddf8c29444b91f7a9a403e30a0c")
+KDF_ARGON2ID = bytes.fromhex("9e298b1956db4773b23dfc3ec6f0a1e6")
+
+
+def variant_dictionary(items):
+    out = struct.pack("<H", 0x0100)
+    for key, (kind, value) in items:
+        if kind == 0x04:
+            encoded = struct.pack("<I", value)
+        elif kind == 0x05:
+            encoded = struct.pack("<Q", value)
+        else:
+            encoded = value
+        name = key.encode()
+        out += bytes([kind]) + struct.pack("<i", len(name)) + name + struct.pack("<i", len(encoded)) + encoded
+    return out + b"\x00"
+
+
+def tlv(field, value):
+    return bytes([field]) + struct.pack("<I", len(value)) + value
+
+
+def kdbx_time(year, month, day, hour, minute, second):
+    import datetime
+    delta = datetime.datetime(year, month, day, hour, minute, second) - datetime.datetime(1, 1, 1)
+    return base64.b64encode(struct.pack("<q", int(delta.total_seconds()))).decode()
+
+
+def document(stream, title):
+    stamp = kdbx_time(2024, 5, 6, 7, 8, 9)
+    older = kdbx_time(2023, 1, 2, 3, 4, 5)
+
+    def protect(value):
+        return base64.b64encode(stream.xor(value.encode())).decode()
+
+    def times(modified):
+        return (
+            "<Times>"
+            "<CreationTime>%s</CreationTime>"
+            "<LastModificationTime>%s</LastModificationTime>"
+            "<LastAccessTime>%s</LastAccessTime>"
+            "<ExpiryTime>%s</ExpiryTime>"
+            "<Expires>False</Expires>"
+            "<UsageCount>0</UsageCount>"
+            "<LocationChanged>%s</LocationChanged>"
+            "</Times>" % (older, modified, modified, older, older)
+        )
+
+    # Protected values are encrypted in document order, so the pieces are
+    # built from top to bottom.
+    router_password = protect("root & <pass>")
+    router_otp = protect("otpauth://totp/Router?secret=JBSWY3DPEHPK3PXP")
+    history_password = protect("first-pass")
+    web_password = protect("wëb-pass ☕")
+    bin_password = protect("deleted")
+
+    return """<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<KeePassFile>
+\t<Meta>
+\t\t<Generator>KeePassXC</Generator>
+\t\t<DatabaseName>%(title)s</DatabaseName>
+\t\t<DatabaseNameChanged>%(stamp)s</DatabaseNameChanged>
+\t\t<DatabaseDescription/>
+\t\t<DatabaseDescriptionChanged>%(stamp)s</DatabaseDescriptionChanged>
+\t\t<DefaultUserName/>
+\t\t<DefaultUserNameChanged>%(stamp)s</DefaultUserNameChanged>
+\t\t<MaintenanceHistoryDays>365</MaintenanceHistoryDays>
+\t\t<Color/>
+\t\t<MasterKeyChanged>%(stamp)s</MasterKeyChanged>
+\t\t<MasterKeyChangeRec>-1</MasterKeyChangeRec>
+\t\t<MasterKeyChangeForce>-1</MasterKeyChangeForce>
+\t\t<MemoryProtection>
+\t\t\t<ProtectTitle>False</ProtectTitle>
+\t\t\t<ProtectUserName>False</ProtectUserName>
+\t\t\t<ProtectPassword>True</ProtectPassword>
+\t\t\t<ProtectURL>False</ProtectURL>
+\t\t\t<ProtectNotes>False</ProtectNotes>
+\t\t</MemoryProtection>
+\t\t<CustomIcons/>
+\t\t<RecycleBinEnabled>True</RecycleBinEnabled>
+\t\t<RecycleBinUUID>8uT6XRMVRE6tq1VeSkFyBg==</RecycleBinUUID>
+\t\t<RecycleBinChanged>%(stamp)s</RecycleBinChanged>
+\t\t<EntryTemplatesGroup>AAAAAAAAAAAAAAAAAAAAAA==</EntryTemplatesGroup>
+\t\t<EntryTemplatesGroupChanged>%(stamp)s</EntryTemplatesGroupChanged>
+\t\t<LastSelectedGroup>AAAAAAAAAAAAAAAAAAAAAA==</LastSelectedGroup>
+\t\t<LastTopVisibleGroup>AAAAAAAAAAAAAAAAAAAAAA==</LastTopVisibleGroup>
+\t\t<HistoryMaxItems>10</HistoryMaxItems>
+\t\t<HistoryMaxSize>6291456</HistoryMaxSize>
+\t\t<SettingsChanged>%(stamp)s</SettingsChanged>
+\t\t<CustomData>
+\t\t\t<Item>
+\t\t\t\t<Key>KPXC_DECRYPTION_TIME_PREFERENCE</Key>
+\t\t\t\t<Value>1000</Value>
+\t\t\t\t<LastModificationTime>%(stamp)s</LastModificationTime>
+\t\t\t</Item>
+\t\t</CustomData>
+\t</Meta>
+\t<Root>
+\t\t<Group>
+\t\t\t<UUID>a1Ta9G0uQ8mfW3QzmOGtcg==</UUID>
+\t\t\t<Name>%(title)s</Name>
+\t\t\t<Notes/>
+\t\t\t<IconID>48</IconID>
+\t\t\t%(group_times)s
+\t\t\t<IsExpanded>True</IsExpanded>
+\t\t\t<DefaultAutoTypeSequence/>
+\t\t\t<EnableAutoType>null</EnableAutoType>
+\t\t\t<EnableSearching>null</EnableSearching>
+\t\t\t<LastTopVisibleEntry>AAAAAAAAAAAAAAAAAAAAAA==</LastTopVisibleEntry>
+\t\t\t<Entry>
+\t\t\t\t<UUID>3xUcdy6ISb2xq3Fvh1yW1A==</UUID>
+\t\t\t\t<IconID>0</IconID>
+\t\t\t\t<ForegroundColor/>
+\t\t\t\t<BackgroundColor/>
+\t\t\t\t<OverrideURL/>
+\t\t\t\t<Tags>network;home</Tags>
+\t\t\t\t%(router_times)s
+\t\t\t\t<String>
+\t\t\t\t\t<Key>Notes</Key>
+\t\t\t\t\t<Value>Rack 2, top shelf</Value>
+\t\t\t\t</String>
+\t\t\t\t<String>
+\t\t\t\t\t<Key>Password</Key>
+\t\t\t\t\t<Value Protected="True">%(router_password)s</Value>
+\t\t\t\t</String>
+\t\t\t\t<String>
+\t\t\t\t\t<Key>Title</Key>
+\t\t\t\t\t<Value>Router</Value>
+\t\t\t\t</String>
+\t\t\t\t<String>
+\t\t\t\t\t<Key>URL</Key>
+\t\t\t\t\t<Value>https://192.168.1.1</Value>
+\t\t\t\t</String>
+\t\t\t\t<String>
+\t\t\t\t\t<Key>UserName</Key>
+\t\t\t\t\t<Value>admin</Value>
+\t\t\t\t</String>
+\t\t\t\t<String>
+\t\t\t\t\t<Key>otp</Key>
+\t\t\t\t\t<Value Protected="True">%(router_otp)s</Value>
+\t\t\t\t</String>
+\t\t\t\t<Binary>
+\t\t\t\t\t<Key>config.txt</Key>
+\t\t\t\t\t<Value Ref="0"/>
+\t\t\t\t</Binary>
+\t\t\t\t<AutoType>
+\t\t\t\t\t<Enabled>True</Enabled>
+\t\t\t\t\t<DataTransferObfuscation>0</DataTransferObfuscation>
+\t\t\t\t\t<Association>
+\t\t\t\t\t\t<Window>Router*</Window>
+\t\t\t\t\t\t<KeystrokeSequence/>
+\t\t\t\t\t</Association>
+\t\t\t\t</AutoType>
+\t\t\t\t<History>
+\t\t\t\t\t<Entry>
+\t\t\t\t\t\t<UUID>3xUcdy6ISb2xq3Fvh1yW1A==</UUID>
+\t\t\t\t\t\t<IconID>0</IconID>
+\t\t\t\t\t\t<ForegroundColor/>
+\t\t\t\t\t\t<BackgroundColor/>
+\t\t\t\t\t\t<OverrideURL/>
+\t\t\t\t\t\t<Tags/>
+\t\t\t\t\t\t%(history_times)s
+\t\t\t\t\t\t<String>
+\t\t\t\t\t\t\t<Key>Password</Key>
+\t\t\t\t\t\t\t<Value Protected="True">%(history_password)s</Value>
+\t\t\t\t\t\t</String>
+\t\t\t\t\t\t<String>
+\t\t\t\t\t\t\t<Key>Title</Key>
+\t\t\t\t\t\t\t<Value>Router</Value>
+\t\t\t\t\t\t</String>
+\t\t\t\t\t\t<String>
+\t\t\t\t\t\t\t<Key>UserName</Key>
+\t\t\t\t\t\t\t<Value>admin</Value>
+\t\t\t\t\t\t</String>
+\t\t\t\t\t\t<AutoType>
+\t\t\t\t\t\t\t<Enabled>True</Enabled>
+\t\t\t\t\t\t\t<DataTransferObfuscation>0</DataTransferObfuscation>
+\t\t\t\t\t\t</AutoType>
+\t\t\t\t\t</Entry>
+\t\t\t\t</History>
+\t\t\t</Entry>
+\t\t\t<Group>
+\t\t\t\t<UUID>q4w6DqK9R0aJVVqtg6C3Fw==</UUID>
+\t\t\t\t<Name>Servers</Name>
+\t\t\t\t<Notes/>
+\t\t\t\t<IconID>48</IconID>
+\t\t\t\t%(group_times)s
+\t\t\t\t<IsExpanded>True</IsExpanded>
+\t\t\t\t<DefaultAutoTypeSequence/>
+\t\t\t\t<EnableAutoType>null</EnableAutoType>
+\t\t\t\t<EnableSearching>null</EnableSearching>
+\t\t\t\t<LastTopVisibleEntry>AAAAAAAAAAAAAAAAAAAAAA==</LastTopVisibleEntry>
+\t\t\t\t<Group>
+\t\t\t\t\t<UUID>Wk7W0fBrSRG8c1Bk5rSTMA==</UUID>
+\t\t\t\t\t<Name>Web</Name>
+\t\t\t\t\t<Notes/>
+\t\t\t\t\t<IconID>48</IconID>
+\t\t\t\t\t%(group_times)s
+\t\t\t\t\t<IsExpanded>True</IsExpanded>
+\t\t\t\t\t<DefaultAutoTypeSequence/>
+\t\t\t\t\t<EnableAutoType>null</EnableAutoType>
+\t\t\t\t\t<EnableSearching>null</EnableSearching>
+\t\t\t\t\t<LastTopVisibleEntry>AAAAAAAAAAAAAAAAAAAAAA==</LastTopVisibleEntry>
+\t\t\t\t\t<Entry>
+\t\t\t\t\t\t<UUID>Hq2r0HVXQxaPpvC3S2TbyA==</UUID>
+\t\t\t\t\t\t<IconID>0</IconID>
+\t\t\t\t\t\t<ForegroundColor/>
+\t\t\t\t\t\t<BackgroundColor/>
+\t\t\t\t\t\t<OverrideURL/>
+\t\t\t\t\t\t<Tags/>
+\t\t\t\t\t\t%(web_times)s
+\t\t\t\t\t\t<String>
+\t\t\t\t\t\t\t<Key>Notes</Key>
+\t\t\t\t\t\t\t<Value/>
+\t\t\t\t\t\t</String>
+\t\t\t\t\t\t<String>
+\t\t\t\t\t\t\t<Key>Password</Key>
+\t\t\t\t\t\t\t<Value Protected="True">%(web_password)s</Value>
+\t\t\t\t\t\t</String>
+\t\t\t\t\t\t<String>
+\t\t\t\t\t\t\t<Key>Title</Key>
+\t\t\t\t\t\t\t<Value>nginx</Value>
+\t\t\t\t\t\t</String>
+\t\t\t\t\t\t<String>
+\t\t\t\t\t\t\t<Key>URL</Key>
+\t\t\t\t\t\t\t<Value/>
+\t\t\t\t\t\t</String>
+\t\t\t\t\t\t<String>
+\t\t\t\t\t\t\t<Key>UserName</Key>
+\t\t\t\t\t\t\t<Value>deploy</Value>
+\t\t\t\t\t\t</String>
+\t\t\t\t\t\t<AutoType>
+\t\t\t\t\t\t\t<Enabled>True</Enabled>
+\t\t\t\t\t\t\t<DataTransferObfuscation>0</DataTransferObfuscation>
+\t\t\t\t\t\t</AutoType>
+\t\t\t\t\t\t<History/>
+\t\t\t\t\t</Entry>
+\t\t\t\t</Group>
+\t\t\t</Group>
+\t\t\t<Group>
+\t\t\t\t<UUID>8uT6XRMVRE6tq1VeSkFyBg==</UUID>
+\t\t\t\t<Name>Recycle Bin</Name>
+\t\t\t\t<Notes/>
+\t\t\t\t<IconID>43</IconID>
+\t\t\t\t%(group_times)s
+\t\t\t\t<IsExpanded>True</IsExpanded>
+\t\t\t\t<DefaultAutoTypeSequence/>
+\t\t\t\t<EnableAutoType>false</EnableAutoType>
+\t\t\t\t<EnableSearching>false</EnableSearching>
+\t\t\t\t<LastTopVisibleEntry>AAAAAAAAAAAAAAAAAAAAAA==</LastTopVisibleEntry>
+\t\t\t\t<Entry>
+\t\t\t\t\t<UUID>kD1v1OqYQYi8iJdXlB2x1g==</UUID>
+\t\t\t\t\t<IconID>0</IconID>
+\t\t\t\t\t<ForegroundColor/>
+\t\t\t\t\t<BackgroundColor/>
+\t\t\t\t\t<OverrideURL/>
+\t\t\t\t\t<Tags/>
+\t\t\t\t\t%(bin_times)s
+\t\t\t\t\t<String>
+\t\t\t\t\t\t<Key>Password</Key>
+\t\t\t\t\t\t<Value Protected="True">%(bin_password)s</Value>
+\t\t\t\t\t</String>
+\t\t\t\t\t<String>
+\t\t\t\t\t\t<Key>Title</Key>
+\t\t\t\t\t\t<Value>Old</Value>
+\t\t\t\t\t</String>
+\t\t\t\t\t<AutoType>
+\t\t\t\t\t\t<Enabled>True</Enabled>
+\t\t\t\t\t\t<DataTransferObfuscation>0</DataTransferObfuscation>
+\t\t\t\t\t</AutoType>
+\t\t\t\t\t<History/>
+\t\t\t\t</Entry>
+\t\t\t</Group>
+\t\t</Group>
+\t\t<DeletedObjects/>
+\t</Root>
+</KeePassFile>
+""" % {
+        "title": title,
+        "stamp": stamp,
+        "group_times": times(older),
+        "router_times": times(stamp),
+        "history_times": times(older),
+        "web_times": times(stamp),
+        "bin_times": times(stamp),
+        "router_password": router_password,
+        "router_otp": router_otp,
+        "history_password": history_password,
+        "web_password": web_password,
+        "bin_password": bin_password,
+    }
+
+
+def composite_key(password, key_file_data):
+    parts = b""
+    if password is not None:
+        parts += hashlib.sha256(password.encode()).digest()
+    if key_file_data is not None:
+        parts += key_file_data
+    return hashlib.sha256(parts).digest()
+
+
+def aes256_cbc(key, iv, data):
+    return subprocess.run(
+        ["openssl", "enc", "-aes-256-cbc", "-K", key.hex(), "-iv", iv.hex()],
+        input=data, capture_output=True, check=True,
+    ).stdout
+
+
+def write_kdbx(path, title, password, key_file_data, cipher, kdf, seeds):
+    master_seed, iv, kdf_salt, inner_key = seeds
+    kdf_uuid, iterations, memory, parallelism = kdf
+
+    kdf_parameters = variant_dictionary([
+        ("$UUID", (0x42, kdf_uuid)),
+        ("I", (0x05, iterations)),
+        ("M", (0x05, memory)),
+        ("P", (0x04, parallelism)),
+        ("S", (0x42, kdf_salt)),
+        ("V", (0x04, 0x13)),
+    ])
+    header = struct.pack("<III", 0x9AA2D903, 0xB54BFB67, 0x00040000)
+    header += tlv(2, cipher)
+    header += tlv(3, struct.pack("<I", 1))
+    header += tlv(4, master_seed)
+    header += tlv(7, iv)
+    header += tlv(11, kdf_parameters)
+    header += tlv(0, b"\r\n\r\n")
+
+    mode = 0 if kdf_uuid == KDF_ARGON2D else 2
+    transformed = argon2(mode, composite_key(password, key_file_data), kdf_salt, b"", b"",
+                         iterations, memory // 1024, parallelism, 32)
+    hmac_base = hashlib.sha512(master_seed + transformed + b"\x01").digest()
+
+    def block_key(index):
+        return hashlib.sha512(struct.pack("<Q", index) + hmac_base).digest()
+
+    inner_hash = hashlib.sha512(inner_key).digest()
+    stream = ChaCha20(inner_hash[:32], inner_hash[32:44])
+    attachment = b"hostname router\ninterface eth0\n"
+    inner = tlv(1, struct.pack("<I", 3)) + tlv(2, inner_key) + tlv(3, b"\x01" + attachment) + tlv(0, b"")
+    payload = gzip.compress(inner + document(stream, title).encode(), mtime=0)
+
+    encryption_key = hashlib.sha256(master_seed + transformed).digest()
+    if cipher == CIPHER_AES256:
+        ciphertext = aes256_cbc(encryption_key, iv, payload)
+    else:
+        ciphertext = ChaCha20(encryption_key, iv).xor(payload)
+
+    out = header + hashlib.sha256(header).digest()
+    out += hmac.new(block_key(0xFFFFFFFFFFFFFFFF), header, hashlib.sha256).digest()
+    blocks = [ciphertext[i:i + (1 << 20)] for i in range(0, len(ciphertext), 1 << 20)] + [b""]
+    for index, block in enumerate(blocks):
+        size = struct.pack("<I", len(block))
+        mac = hmac.new(block_key(index), struct.pack("<Q", index) + size + block, hashlib.sha256).digest()
+        out += mac + size + block
+
+    with open(path, "wb") as f:
+        f.write(out)
+
+
+def main():
+    check_argon2()
+    check_chacha20()
+
+    # Fixed seeds keep the files reproducible
+    write_kdbx(
+        "argon2d-aes.kdbx", "Home", "correct horse", None, CIPHER_AES256,
+        (KDF_ARGON2D, 2, 1 << 20, 2),
+        (bytes(range(32)), bytes(range(16)), bytes(range(32, 64)), bytes(range(64, 128))),
+    )
+
+    key_data = bytes.fromhex("6b3ca4f1d2e5a8b7c6d9e0f1a2b3c4d5e6f708192a3b4c5d6e7f8091a2b3c4d5")
+    checksum = hashlib.sha256(key_data).hexdigest()[:8].upper()
+    hex_data = key_data.hex().upper()
+    with open("argon2id-chacha20.keyx", "w") as f:
+        f.write('<?xml version="1.0" encoding="UTF-8"?>\n<KeyFile>\n\t<Meta>\n\t\t<Version>2.0</Version>\n\t</Meta>\n'
+                '\t<Key>\n\t\t<Data Hash="%s">\n\t\t\t%s\n\t\t\t%s\n\t\t</Data>\n\t</Key>\n</KeyFile>\n'
+                % (checksum, " ".join(hex_data[i:i + 8] for i in range(0, 32, 8)),
+                   " ".join(hex_data[i:i + 8] for i in range(32, 64, 8))))
+    write_kdbx(
+        "argon2id-chacha20.kdbx", "Work", "correct horse", key_data, CIPHER_CHACHA20,
+        (KDF_ARGON2ID, 2, 1 << 20, 2),
+        (bytes(range(100, 132)), bytes(range(12)), bytes(range(132, 164)), bytes(range(164, 228))),
+    )
+
+
+if __name__ == "__main__":
+    main()
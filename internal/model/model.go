@@ -1,27 +1,105 @@
 package model
 
-import "time"
+import (
+	"slices"
+	"time"
+)
 
 // Account represents a password entry in the vault
 // Contains all necessary information for a stored credential
 type Account struct {
-	ID                string    `json:"id"`
-	Platform          string    `json:"platform"` // Platform/service name (e.g., "GitHub", "Gmail")
+	ID                string         `json:"id"`
+	Platform          string         `json:"platform"` // Platform/service name (e.g., "GitHub", "Gmail")
+	Username          string         `json:"username,omitempty"`
+	Email             string         `json:"email,omitempty"`
+	EncryptedPassword string         `json:"encrypted_password"` // Password encrypted with the master key
+	URL               string         `json:"url,omitempty"`
+	Notes             string         `json:"notes,omitempty"`
+	Group             string         `json:"group,omitempty"`          // Group or category for the account
+	Tags              []string       `json:"tags,omitempty"`           // Free-form labels, e.g. from KeePass tags
+	PasswordRules     string         `json:"password_rules,omitempty"` // Site password rules in passwordrules syntax, used when generating
+	Derived           *Derived       `json:"derived,omitempty"`        // Profile of a stateless password; EncryptedPassword is then empty
+	SSHKey            *SSHKey        `json:"ssh_key,omitempty"`        // Set for SSH keys; EncryptedPassword then holds the private key
+	CustomFields      []CustomField  `json:"custom_fields,omitempty"`  // Additional named values, e.g. KeePass custom strings
+	History           []HistoryEntry `json:"history,omitempty"`        // Earlier versions of the account, oldest first
+	CreatedAt         time.Time      `json:"created_at"`
+	UpdatedAt         time.Time      `json:"updated_at"`
+	SortOrder         int            `json:"sort_order"`
+	Revision          int            `json:"revision,omitempty"` // Incremented on every change; the higher revision wins sync conflicts
+}
+
+// Clone returns a copy of the account that shares no slices or pointers
+// with it, so either can be changed without affecting the other.
+func (a *Account) Clone() *Account {
+	clone := *a
+	clone.Tags = slices.Clone(a.Tags)
+	clone.CustomFields = slices.Clone(a.CustomFields)
+	clone.History = slices.Clone(a.History)
+	if a.Derived != nil {
+		derived := *a.Derived
+		clone.Derived = &derived
+	}
+	if a.SSHKey != nil {
+		sshKey := *a.SSHKey
+		clone.SSHKey = &sshKey
+	}
+	return &clone
+}
+
+// CustomField is an additional named value of an account. Protected values
+// are encrypted with the master key like passwords.
+type CustomField struct {
+	Name      string `json:"name"`
+	Value     string `json:"value"`
+	Protected bool   `json:"protected,omitempty"`
+}
+
+// HistoryEntry is an earlier version of an account's credentials.
+type HistoryEntry struct {
 	Username          string    `json:"username,omitempty"`
 	Email             string    `json:"email,omitempty"`
-	EncryptedPassword string    `json:"encrypted_password"` // Password encrypted with the master key
+	EncryptedPassword string    `json:"encrypted_password,omitempty"`
 	URL               string    `json:"url,omitempty"`
 	Notes             string    `json:"notes,omitempty"`
-	Group             string    `json:"group,omitempty"` // Group or category for the account
-	CreatedAt         time.Time `json:"created_at"`
 	UpdatedAt         time.Time `json:"updated_at"`
-	SortOrder         int       `json:"sort_order"`
+}
+
+// Derived describes a stateless password that is recomputed from the master
+// password, site, login and counter instead of being stored.
+type Derived struct {
+	Site      string `json:"site"`
+	Login     string `json:"login,omitempty"`
+	Counter   int    `json:"counter"`
+	Length    int    `json:"length"`
+	Lowercase bool   `json:"lowercase"`
+	Uppercase bool   `json:"uppercase"`
+	Digits    bool   `json:"digits"`
+	Symbols   bool   `json:"symbols"`
+}
+
+// SSHKey describes an SSH key kept in the vault and served by the SSH agent.
+// The private key, in OpenSSH format, is stored as the account's encrypted
+// password.
+type SSHKey struct {
+	PublicKey   string `json:"public_key"`         // In authorized_keys format
+	Fingerprint string `json:"fingerprint"`        // SHA256 fingerprint of the public key
+	Confirm     bool   `json:"confirm,omitempty"`  // Ask before every use of the key
+	Lifetime    int    `json:"lifetime,omitempty"` // Seconds the SSH agent serves the key after it starts; 0 for no limit
 }
 
 // Vault represents the password vault containing all accounts
 // The master password is not stored, only a hash for verification
 type Vault struct {
-	MasterKeyHash []byte     `json:"master_key_hash,omitempty"` // Hash of the master password
-	Salt          []byte     `json:"salt"`                      // Salt used for master password hashing
-	Accounts      []*Account `json:"accounts"`                  // List of stored accounts
+	MasterKeyHash []byte      `json:"master_key_hash,omitempty"` // Hash of the master password
+	Salt          []byte      `json:"salt"`                      // Salt used for master password hashing
+	Accounts      []*Account  `json:"accounts"`                  // List of stored accounts
+	EmailAliases  []string    `json:"email_aliases,omitempty"`   // Email alias templates for generated addresses
+	Tombstones    []Tombstone `json:"tombstones,omitempty"`      // Deleted account IDs, so syncing does not bring them back
+}
+
+// Tombstone records the deletion of an account.
+type Tombstone struct {
+	ID        string    `json:"id"`
+	DeletedAt time.Time `json:"deleted_at"`
+	Revision  int       `json:"revision,omitempty"` // Revision of the account when it was deleted
 }
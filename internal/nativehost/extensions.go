@@ -0,0 +1,146 @@
+package nativehost
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/simp-lee/passwordmanager/internal/errors"
+)
+
+// ExtensionsFileName lists the browser extensions allowed to use a vault and
+// their pairing keys, in its data directory.
+const ExtensionsFileName = "browser_extensions.json"
+
+// Extension is a browser extension allowed to use the vault.
+type Extension struct {
+	ID       string     `json:"id"`
+	Browser  string     `json:"browser"`
+	Key      string     `json:"key,omitempty"`       // Base64 pairing key; empty until paired
+	PairedAt *time.Time `json:"paired_at,omitempty"` // When the user confirmed the pairing
+}
+
+// Extensions is the set of browser extensions kept in a data directory.
+type Extensions struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewExtensions returns the extensions kept in dir.
+func NewExtensions(dir string) *Extensions {
+	return &Extensions{path: filepath.Join(dir, ExtensionsFileName)}
+}
+
+// List returns the allowed extensions.
+func (e *Extensions) List() ([]Extension, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.load()
+}
+
+// Get returns the extension with id. Returns errors.ErrExtensionDenied if it
+// is not allowed.
+func (e *Extensions) Get(id string) (*Extension, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	extensions, err := e.load()
+	if err != nil {
+		return nil, err
+	}
+	i := slices.IndexFunc(extensions, func(extension Extension) bool { return extension.ID == id })
+	if id == "" || i < 0 {
+		return nil, errors.ErrExtensionDenied
+	}
+	return &extensions[i], nil
+}
+
+// Allow lets the extension id of browser connect. An extension that is
+// already allowed keeps its pairing.
+func (e *Extensions) Allow(id, browser string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	extensions, err := e.load()
+	if err != nil {
+		return err
+	}
+	if slices.ContainsFunc(extensions, func(extension Extension) bool { return extension.ID == id }) {
+		return nil
+	}
+	return e.save(append(extensions, Extension{ID: id, Browser: browser}))
+}
+
+// Remove forgets the extensions of browser, with their pairings, and
+// returns how many there were.
+func (e *Extensions) Remove(browser string) (int, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	extensions, err := e.load()
+	if err != nil {
+		return 0, err
+	}
+	kept := slices.DeleteFunc(slices.Clone(extensions), func(extension Extension) bool { return extension.Browser == browser })
+	if len(kept) == len(extensions) {
+		return 0, nil
+	}
+	return len(extensions) - len(kept), e.save(kept)
+}
+
+// Pair gives the extension id a new pairing key and returns it.
+func (e *Extensions) Pair(id string) ([]byte, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	extensions, err := e.load()
+	if err != nil {
+		return nil, err
+	}
+	i := slices.IndexFunc(extensions, func(extension Extension) bool { return extension.ID == id })
+	if i < 0 {
+		return nil, errors.ErrExtensionDenied
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, errors.Wrap(err, "failed to generate pairing key")
+	}
+	now := time.Now()
+	extensions[i].Key = base64.StdEncoding.EncodeToString(key)
+	extensions[i].PairedAt = &now
+	if err := e.save(extensions); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func (e *Extensions) load() ([]Extension, error) {
+	data, err := os.ReadFile(e.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read browser extensions")
+	}
+	var extensions []Extension
+	if err := json.Unmarshal(data, &extensions); err != nil {
+		return nil, errors.Wrap(errors.ErrDataCorrupted, ExtensionsFileName+": "+err.Error())
+	}
+	return extensions, nil
+}
+
+func (e *Extensions) save(extensions []Extension) error {
+	if extensions == nil {
+		extensions = []Extension{}
+	}
+	data, err := json.MarshalIndent(extensions, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "failed to encode browser extensions")
+	}
+	if err := os.WriteFile(e.path, append(data, '\n'), 0600); err != nil {
+		return errors.Wrap(err, "failed to write browser extensions")
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package nativehost
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/simp-lee/passwordmanager/internal/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtensions(t *testing.T) {
+	dir := t.TempDir()
+	extensions := NewExtensions(dir)
+	_, err := extensions.Get(testExtensionID)
+	assert.ErrorIs(t, err, errors.ErrExtensionDenied)
+
+	require.NoError(t, extensions.Allow(testExtensionID, "chrome"))
+	require.NoError(t, extensions.Allow("autofill@example.com", "firefox"))
+	key, err := extensions.Pair(testExtensionID)
+	require.NoError(t, err)
+	require.NoError(t, extensions.Allow(testExtensionID, "chrome"))
+	extension, err := NewExtensions(dir).Get(testExtensionID)
+	require.NoError(t, err)
+	assert.NotEmpty(t, extension.Key, "Allowing again keeps the pairing")
+	assert.NotNil(t, extension.PairedAt)
+	assert.Len(t, key, 32)
+
+	info, err := os.Stat(filepath.Join(dir, ExtensionsFileName))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	removed, err := extensions.Remove("chrome")
+	require.NoError(t, err)
+	assert.Equal(t, 1, removed)
+	_, err = extensions.Get(testExtensionID)
+	assert.ErrorIs(t, err, errors.ErrExtensionDenied)
+	list, err := extensions.List()
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	assert.Equal(t, "firefox", list[0].Browser)
+}
@@ -0,0 +1,265 @@
+package nativehost
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"regexp"
+
+	"github.com/simp-lee/passwordmanager/internal/crypto"
+	"github.com/simp-lee/passwordmanager/internal/errors"
+	"github.com/simp-lee/passwordmanager/internal/model"
+)
+
+// Vault is the part of the storage the host reads credentials from.
+type Vault interface {
+	GetAccounts() ([]*model.Account, error)
+	GetEncryptionKey() []byte
+}
+
+// ConfirmFunc asks the user whether the extension may pair with the vault.
+// code is the pairing code the extension shows, for the user to compare.
+type ConfirmFunc func(extensionID, code string) (bool, error)
+
+// pairingCode is what a pairing code may look like, so the extension cannot
+// put arbitrary text into the confirmation dialog.
+var pairingCode = regexp.MustCompile(`^[A-Za-z0-9-]{4,16}$`)
+
+// Message is a request from the extension. Every request gets one reply,
+// which carries the ID of the request.
+//
+// A session starts with "hello", answered with a "challenge". An extension
+// that is not paired yet sends "pair" with a pairing code it shows to the
+// user; once the user confirms the pairing the reply carries the pairing
+// key. A paired extension sends "auth" with the HMAC-SHA256 of the challenge
+// nonce under its key. Then "credentials" returns the logins for an origin.
+type Message struct {
+	ID     json.RawMessage `json:"id,omitempty"`
+	Type   string          `json:"type"`             // "hello", "pair", "auth" or "credentials"
+	Code   string          `json:"code,omitempty"`   // Pairing code shown by the extension
+	Proof  string          `json:"proof,omitempty"`  // Base64 HMAC-SHA256 of the nonce under the pairing key
+	Origin string          `json:"origin,omitempty"` // Origin of the page to fill in, e.g. "https://github.com"
+}
+
+// Reply is the answer to a Message.
+type Reply struct {
+	ID          json.RawMessage `json:"id,omitempty"`
+	Type        string          `json:"type"`                  // "challenge", "paired", "authenticated", "credentials" or "error"
+	Nonce       string          `json:"nonce,omitempty"`       // Base64 challenge
+	Paired      bool            `json:"paired,omitempty"`      // The extension has a pairing key
+	Key         string          `json:"key,omitempty"`         // Base64 pairing key, for the extension to keep
+	Credentials []Credential    `json:"credentials,omitempty"` // Logins for the origin
+	Error       string          `json:"error,omitempty"`       // "locked", "denied", "not_paired", "unauthorized", "canceled", "bad_request" or "internal"
+	Message     string          `json:"message,omitempty"`     // Description of the error
+}
+
+// Credential is a login offered for autofill.
+type Credential struct {
+	ID       string `json:"id"`
+	Platform string `json:"platform"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password"`
+	URL      string `json:"url"`
+}
+
+// Host serves one connection from a browser extension.
+type Host struct {
+	extensionID   string
+	extensions    *Extensions
+	vault         Vault
+	unlock        func() error
+	confirm       ConfirmFunc
+	nonce         []byte
+	authenticated bool
+}
+
+// NewHost returns a host for the extension extensionID. unlock is called
+// before reading credentials and must fail with errors.ErrVaultLocked
+// unless the vault is unlocked.
+func NewHost(extensionID string, extensions *Extensions, vault Vault, unlock func() error, confirm ConfirmFunc) *Host {
+	return &Host{extensionID: extensionID, extensions: extensions, vault: vault, unlock: unlock, confirm: confirm}
+}
+
+// Serve answers messages from r on w until the browser closes r. An
+// extension that is not allowed gets one error reply.
+func (h *Host) Serve(r io.Reader, w io.Writer) error {
+	if _, err := h.extensions.Get(h.extensionID); err != nil {
+		WriteMessage(w, errorReply(nil, err))
+		return err
+	}
+
+	for {
+		data, err := ReadMessage(r)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var message Message
+		var reply Reply
+		if err := json.Unmarshal(data, &message); err != nil {
+			reply = errorReply(nil, errors.Wrap(errors.ErrInvalidRequest, err.Error()))
+		} else {
+			reply = h.handle(&message)
+		}
+		if err := WriteMessage(w, reply); err != nil {
+			return err
+		}
+	}
+}
+
+func (h *Host) handle(message *Message) Reply {
+	var reply Reply
+	var err error
+	switch message.Type {
+	case "hello":
+		reply, err = h.hello()
+	case "pair":
+		reply, err = h.pair(message.Code)
+	case "auth":
+		reply, err = h.auth(message.Proof)
+	case "credentials":
+		reply, err = h.credentials(message.Origin)
+	default:
+		err = errors.Wrap(errors.ErrInvalidRequest, "unknown message type "+message.Type)
+	}
+	if err != nil {
+		return errorReply(message.ID, err)
+	}
+	reply.ID = message.ID
+	return reply
+}
+
+// hello starts the handshake with a new challenge.
+func (h *Host) hello() (Reply, error) {
+	extension, err := h.extensions.Get(h.extensionID)
+	if err != nil {
+		return Reply{}, err
+	}
+	h.nonce = make([]byte, 32)
+	if _, err := rand.Read(h.nonce); err != nil {
+		return Reply{}, err
+	}
+	h.authenticated = false
+	return Reply{Type: "challenge", Nonce: base64.StdEncoding.EncodeToString(h.nonce), Paired: extension.Key != ""}, nil
+}
+
+// pair asks the user to confirm the pairing and hands out a new key.
+func (h *Host) pair(code string) (Reply, error) {
+	if h.nonce == nil {
+		return Reply{}, errors.Wrap(errors.ErrInvalidRequest, "expected hello first")
+	}
+	if !pairingCode.MatchString(code) {
+		return Reply{}, errors.Wrap(errors.ErrInvalidRequest, "invalid pairing code")
+	}
+	h.nonce = nil
+
+	allowed, err := h.confirm(h.extensionID, code)
+	if err != nil {
+		return Reply{}, err
+	}
+	if !allowed {
+		return Reply{}, errors.ErrCanceled
+	}
+	key, err := h.extensions.Pair(h.extensionID)
+	if err != nil {
+		return Reply{}, err
+	}
+	h.authenticated = true
+	return Reply{Type: "paired", Key: base64.StdEncoding.EncodeToString(key)}, nil
+}
+
+// auth checks the proof of a paired extension against the challenge. Each
+// challenge can be answered once.
+func (h *Host) auth(proof string) (Reply, error) {
+	if h.nonce == nil {
+		return Reply{}, errors.Wrap(errors.ErrInvalidRequest, "expected hello first")
+	}
+	nonce := h.nonce
+	h.nonce = nil
+
+	extension, err := h.extensions.Get(h.extensionID)
+	if err != nil {
+		return Reply{}, err
+	}
+	if extension.Key == "" {
+		return Reply{}, errors.ErrExtensionNotPaired
+	}
+	key, err := base64.StdEncoding.DecodeString(extension.Key)
+	if err != nil {
+		return Reply{}, errors.Wrap(errors.ErrDataCorrupted, ExtensionsFileName)
+	}
+	given, err := base64.StdEncoding.DecodeString(proof)
+	if err != nil || !hmac.Equal(given, Proof(key, nonce)) {
+		return Reply{}, errors.ErrPairingFailed
+	}
+	h.authenticated = true
+	return Reply{Type: "authenticated"}, nil
+}
+
+// credentials returns the logins matching origin.
+func (h *Host) credentials(origin string) (Reply, error) {
+	if !h.authenticated {
+		return Reply{}, errors.ErrPairingFailed
+	}
+	if err := h.unlock(); err != nil {
+		return Reply{}, err
+	}
+	accounts, err := h.vault.GetAccounts()
+	if err != nil {
+		return Reply{}, err
+	}
+
+	credentials := []Credential{}
+	for _, account := range Match(accounts, origin) {
+		password, err := crypto.Decrypt(account.EncryptedPassword, h.vault.GetEncryptionKey())
+		if err != nil {
+			return Reply{}, errors.Wrap(err, "failed to decrypt password")
+		}
+		username := account.Username
+		if username == "" {
+			username = account.Email
+		}
+		credentials = append(credentials, Credential{
+			ID:       account.ID,
+			Platform: account.Platform,
+			Username: username,
+			Password: string(password),
+			URL:      account.URL,
+		})
+		crypto.ClearBytes(password)
+	}
+	return Reply{Type: "credentials", Credentials: credentials}, nil
+}
+
+// Proof returns the answer of an extension holding key to the challenge
+// nonce: HMAC-SHA256(key, nonce).
+func Proof(key, nonce []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(nonce)
+	return mac.Sum(nil)
+}
+
+func errorReply(id json.RawMessage, err error) Reply {
+	code := "internal"
+	switch {
+	case errors.Is(err, errors.ErrVaultLocked):
+		code = "locked"
+	case errors.Is(err, errors.ErrExtensionDenied):
+		code = "denied"
+	case errors.Is(err, errors.ErrExtensionNotPaired):
+		code = "not_paired"
+	case errors.Is(err, errors.ErrPairingFailed):
+		code = "unauthorized"
+	case errors.Is(err, errors.ErrCanceled):
+		code = "canceled"
+	case errors.Is(err, errors.ErrInvalidRequest):
+		code = "bad_request"
+	}
+	return Reply{ID: id, Type: "error", Error: code, Message: err.Error()}
+}
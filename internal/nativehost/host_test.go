@@ -0,0 +1,202 @@
+package nativehost
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"testing"
+
+	"github.com/simp-lee/passwordmanager/internal/crypto"
+	"github.com/simp-lee/passwordmanager/internal/errors"
+	"github.com/simp-lee/passwordmanager/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testExtensionID = "abcdefghijklmnopabcdefghijklmnop"
+
+type fakeVault struct {
+	key      []byte
+	accounts []*model.Account
+}
+
+func (v *fakeVault) GetAccounts() ([]*model.Account, error) { return v.accounts, nil }
+func (v *fakeVault) GetEncryptionKey() []byte               { return v.key }
+
+func newVault(t *testing.T) *fakeVault {
+	t.Helper()
+	salt, err := crypto.GenerateSalt()
+	require.NoError(t, err)
+	vault := &fakeVault{key: crypto.GenerateKey("master password", salt)}
+	encrypted, err := crypto.Encrypt([]byte("hunter2"), vault.key)
+	require.NoError(t, err)
+	vault.accounts = []*model.Account{
+		{ID: "1", Platform: "GitHub", Email: "me@example.com", URL: "https://github.com", EncryptedPassword: encrypted},
+	}
+	return vault
+}
+
+// session drives a Host over pipes, like a browser would.
+type session struct {
+	t    *testing.T
+	in   *io.PipeWriter
+	out  *io.PipeReader
+	done chan error
+}
+
+func newSession(t *testing.T, host *Host) *session {
+	t.Helper()
+	inReader, inWriter := io.Pipe()
+	outReader, outWriter := io.Pipe()
+	s := &session{t: t, in: inWriter, out: outReader, done: make(chan error, 1)}
+	go func() {
+		err := host.Serve(inReader, outWriter)
+		outWriter.Close()
+		s.done <- err
+	}()
+	t.Cleanup(func() { inWriter.Close() })
+	return s
+}
+
+func (s *session) read() Reply {
+	s.t.Helper()
+	data, err := ReadMessage(s.out)
+	require.NoError(s.t, err)
+	var reply Reply
+	require.NoError(s.t, json.Unmarshal(data, &reply))
+	return reply
+}
+
+func (s *session) send(message Message) Reply {
+	s.t.Helper()
+	require.NoError(s.t, WriteMessage(s.in, message))
+	return s.read()
+}
+
+func (s *session) close() error {
+	s.in.Close()
+	return <-s.done
+}
+
+func newExtensions(t *testing.T) *Extensions {
+	t.Helper()
+	extensions := NewExtensions(t.TempDir())
+	require.NoError(t, extensions.Allow(testExtensionID, "chrome"))
+	return extensions
+}
+
+func unlocked() error { return nil }
+
+func TestHostPairingAndCredentials(t *testing.T) {
+	extensions := newExtensions(t)
+	vault := newVault(t)
+	var confirmedCode string
+	confirm := func(id, code string) (bool, error) {
+		confirmedCode = code
+		return true, nil
+	}
+
+	s := newSession(t, NewHost(testExtensionID, extensions, vault, unlocked, confirm))
+	challenge := s.send(Message{ID: json.RawMessage(`1`), Type: "hello"})
+	assert.Equal(t, "challenge", challenge.Type)
+	assert.Equal(t, json.RawMessage(`1`), challenge.ID)
+	assert.False(t, challenge.Paired)
+
+	reply := s.send(Message{Type: "credentials", Origin: "https://github.com"})
+	assert.Equal(t, "unauthorized", reply.Error, "Credentials need a paired session")
+
+	s.send(Message{Type: "hello"})
+	reply = s.send(Message{Type: "pair", Code: "<b>yes</b>"})
+	assert.Equal(t, "bad_request", reply.Error)
+	s.send(Message{Type: "hello"})
+	paired := s.send(Message{Type: "pair", Code: "K7X2-9QF4"})
+	require.Equal(t, "paired", paired.Type, paired.Message)
+	assert.Equal(t, "K7X2-9QF4", confirmedCode)
+	key, err := base64.StdEncoding.DecodeString(paired.Key)
+	require.NoError(t, err)
+
+	reply = s.send(Message{ID: json.RawMessage(`"a"`), Type: "credentials", Origin: "https://gist.github.com"})
+	require.Equal(t, "credentials", reply.Type, reply.Message)
+	assert.Equal(t, json.RawMessage(`"a"`), reply.ID)
+	require.Len(t, reply.Credentials, 1)
+	assert.Equal(t, "hunter2", reply.Credentials[0].Password)
+	assert.Equal(t, "me@example.com", reply.Credentials[0].Username, "The email stands in for a missing username")
+	require.NoError(t, s.close())
+
+	// A new connection proves the pairing with the key instead.
+	s = newSession(t, NewHost(testExtensionID, extensions, vault, unlocked, nil))
+	challenge = s.send(Message{Type: "hello"})
+	assert.True(t, challenge.Paired)
+	nonce, err := base64.StdEncoding.DecodeString(challenge.Nonce)
+	require.NoError(t, err)
+	proof := base64.StdEncoding.EncodeToString(Proof(key, nonce))
+	reply = s.send(Message{Type: "auth", Proof: proof})
+	require.Equal(t, "authenticated", reply.Type, reply.Message)
+	reply = s.send(Message{Type: "auth", Proof: proof})
+	assert.Equal(t, "bad_request", reply.Error, "A challenge can only be answered once")
+
+	reply = s.send(Message{Type: "credentials", Origin: "https://gitlab.com"})
+	assert.Equal(t, "credentials", reply.Type)
+	assert.Empty(t, reply.Credentials)
+	require.NoError(t, s.close())
+}
+
+func TestHostRejectsBadProof(t *testing.T) {
+	extensions := newExtensions(t)
+	_, err := extensions.Pair(testExtensionID)
+	require.NoError(t, err)
+
+	s := newSession(t, NewHost(testExtensionID, extensions, newVault(t), unlocked, nil))
+	challenge := s.send(Message{Type: "hello"})
+	nonce, err := base64.StdEncoding.DecodeString(challenge.Nonce)
+	require.NoError(t, err)
+	reply := s.send(Message{Type: "auth", Proof: base64.StdEncoding.EncodeToString(Proof([]byte("guess"), nonce))})
+	assert.Equal(t, "unauthorized", reply.Error)
+	reply = s.send(Message{Type: "credentials", Origin: "https://github.com"})
+	assert.Equal(t, "unauthorized", reply.Error)
+	require.NoError(t, s.close())
+}
+
+func TestHostUnpairedAuth(t *testing.T) {
+	s := newSession(t, NewHost(testExtensionID, newExtensions(t), newVault(t), unlocked, nil))
+	s.send(Message{Type: "hello"})
+	reply := s.send(Message{Type: "auth", Proof: "AAAA"})
+	assert.Equal(t, "not_paired", reply.Error)
+	require.NoError(t, s.close())
+}
+
+func TestHostPairingCanceled(t *testing.T) {
+	extensions := newExtensions(t)
+	confirm := func(id, code string) (bool, error) { return false, nil }
+
+	s := newSession(t, NewHost(testExtensionID, extensions, newVault(t), unlocked, confirm))
+	s.send(Message{Type: "hello"})
+	reply := s.send(Message{Type: "pair", Code: "1234"})
+	assert.Equal(t, "canceled", reply.Error)
+	require.NoError(t, s.close())
+
+	extension, err := extensions.Get(testExtensionID)
+	require.NoError(t, err)
+	assert.Empty(t, extension.Key)
+}
+
+func TestHostLockedVault(t *testing.T) {
+	extensions := newExtensions(t)
+	confirm := func(id, code string) (bool, error) { return true, nil }
+	locked := func() error { return errors.ErrVaultLocked }
+
+	s := newSession(t, NewHost(testExtensionID, extensions, newVault(t), locked, confirm))
+	s.send(Message{Type: "hello"})
+	require.Equal(t, "paired", s.send(Message{Type: "pair", Code: "1234"}).Type)
+	reply := s.send(Message{Type: "credentials", Origin: "https://github.com"})
+	assert.Equal(t, "locked", reply.Error)
+	assert.Empty(t, reply.Credentials)
+	require.NoError(t, s.close())
+}
+
+func TestHostDeniedExtension(t *testing.T) {
+	s := newSession(t, NewHost("ponmlkjihgfedcbaponmlkjihgfedcba", newExtensions(t), newVault(t), unlocked, nil))
+	reply := s.read()
+	assert.Equal(t, "denied", reply.Error)
+	assert.ErrorIs(t, s.close(), errors.ErrExtensionDenied)
+}
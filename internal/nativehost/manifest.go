@@ -0,0 +1,120 @@
+package nativehost
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/simp-lee/passwordmanager/internal/errors"
+)
+
+// HostName is the name extensions use to connect to the host.
+const HostName = "io.github.simp_lee.passwordmanager"
+
+// Browsers are the browsers Install knows where to register the host for.
+var Browsers = []string{"chrome", "chromium", "brave", "firefox"}
+
+// chromeExtensionID is the form of Chromium extension IDs.
+var chromeExtensionID = regexp.MustCompile(`^[a-p]{32}$`)
+
+// Manifest registers the host with a browser. Chromium-based browsers list
+// the extensions allowed to connect as origins, Firefox by their IDs.
+type Manifest struct {
+	Name              string   `json:"name"`
+	Description       string   `json:"description"`
+	Path              string   `json:"path"`
+	Type              string   `json:"type"`
+	AllowedOrigins    []string `json:"allowed_origins,omitempty"`
+	AllowedExtensions []string `json:"allowed_extensions,omitempty"`
+}
+
+// NewManifest returns the manifest letting the extension id of browser start
+// the program at path.
+func NewManifest(browser, id, path string) (*Manifest, error) {
+	if !slices.Contains(Browsers, browser) {
+		return nil, errors.Wrap(errors.ErrUnknownBrowser, browser)
+	}
+	manifest := &Manifest{
+		Name:        HostName,
+		Description: "Password Manager autofill",
+		Path:        path,
+		Type:        "stdio",
+	}
+	if browser == "firefox" {
+		if id == "" || strings.ContainsAny(id, " \t\n/") {
+			return nil, errors.Wrap(errors.ErrInvalidExtension, id)
+		}
+		manifest.AllowedExtensions = []string{id}
+	} else {
+		if !chromeExtensionID.MatchString(id) {
+			return nil, errors.Wrap(errors.ErrInvalidExtension, id)
+		}
+		manifest.AllowedOrigins = []string{"chrome-extension://" + id + "/"}
+	}
+	return manifest, nil
+}
+
+// Install registers the program at path as the host for the extension id of
+// browser and returns where the manifest was written.
+func Install(browser, id, path string) (string, error) {
+	manifest, err := NewManifest(browser, id, path)
+	if err != nil {
+		return "", err
+	}
+	dir, err := manifestDir(browser)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", errors.Wrap(err, "failed to create manifest directory")
+	}
+	manifestPath := filepath.Join(dir, HostName+".json")
+	if err := os.WriteFile(manifestPath, append(data, '\n'), 0644); err != nil {
+		return "", errors.Wrap(err, "failed to write manifest")
+	}
+	return manifestPath, nil
+}
+
+// Uninstall removes the manifest of browser. It is not an error if there is
+// none.
+func Uninstall(browser string) error {
+	if !slices.Contains(Browsers, browser) {
+		return errors.Wrap(errors.ErrUnknownBrowser, browser)
+	}
+	dir, err := manifestDir(browser)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(filepath.Join(dir, HostName+".json")); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "failed to remove manifest")
+	}
+	return nil
+}
+
+// WriteLauncher writes the shell script at path that browsers start: it runs
+// exe with args followed by the arguments of the browser. Browsers cannot
+// pass arguments of their own choosing, so the launcher is what selects the
+// data directory and vault.
+func WriteLauncher(path, exe string, args []string) error {
+	var script strings.Builder
+	script.WriteString("#!/bin/sh\nexec " + shellQuote(exe))
+	for _, arg := range args {
+		script.WriteString(" " + shellQuote(arg))
+	}
+	script.WriteString(" -- \"$@\"\n")
+	if err := os.WriteFile(path, []byte(script.String()), 0700); err != nil {
+		return errors.Wrap(err, "failed to write launcher")
+	}
+	return os.Chmod(path, 0700)
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
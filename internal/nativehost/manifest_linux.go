@@ -0,0 +1,27 @@
+//go:build linux
+
+package nativehost
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// manifestDir returns where browser looks for the manifests of the current
+// user.
+func manifestDir(browser string) (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	switch browser {
+	case "chrome":
+		return filepath.Join(home, ".config", "google-chrome", "NativeMessagingHosts"), nil
+	case "chromium":
+		return filepath.Join(home, ".config", "chromium", "NativeMessagingHosts"), nil
+	case "brave":
+		return filepath.Join(home, ".config", "BraveSoftware", "Brave-Browser", "NativeMessagingHosts"), nil
+	default:
+		return filepath.Join(home, ".mozilla", "native-messaging-hosts"), nil
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package nativehost
+
+import "github.com/simp-lee/passwordmanager/internal/errors"
+
+// manifestDir is only known on Linux; elsewhere the manifest has to be
+// registered by hand.
+func manifestDir(browser string) (string, error) {
+	return "", errors.ErrUnsupportedOS
+}
@@ -0,0 +1,43 @@
+package nativehost
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/simp-lee/passwordmanager/internal/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewManifest(t *testing.T) {
+	manifest, err := NewManifest("chrome", "abcdefghijklmnopabcdefghijklmnop", "/usr/bin/host")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"chrome-extension://abcdefghijklmnopabcdefghijklmnop/"}, manifest.AllowedOrigins)
+	assert.Empty(t, manifest.AllowedExtensions)
+
+	manifest, err = NewManifest("firefox", "autofill@example.com", "/usr/bin/host")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"autofill@example.com"}, manifest.AllowedExtensions)
+
+	_, err = NewManifest("chrome", "autofill@example.com", "/usr/bin/host")
+	assert.ErrorIs(t, err, errors.ErrInvalidExtension)
+	_, err = NewManifest("netscape", "autofill@example.com", "/usr/bin/host")
+	assert.ErrorIs(t, err, errors.ErrUnknownBrowser)
+}
+
+func TestWriteLauncher(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available")
+	}
+	path := filepath.Join(t.TempDir(), "native-host")
+	require.NoError(t, WriteLauncher(path, "printf", []string{"%s|", "it's"}))
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0700), info.Mode().Perm())
+
+	out, err := exec.Command(path, "chrome-extension://x/").Output()
+	require.NoError(t, err)
+	assert.Equal(t, "it's|--|chrome-extension://x/|", string(out))
+}
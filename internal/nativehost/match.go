@@ -0,0 +1,60 @@
+package nativehost
+
+import (
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/simp-lee/passwordmanager/internal/model"
+)
+
+// Match returns the accounts whose URL belongs to origin, the scheme and
+// host of the page asking for credentials. An account matches pages on its
+// domain and its subdomains. Pages served over plain http only match
+// accounts whose URL is explicitly http, so passwords are not filled into
+// pages an attacker on the network could have rewritten. Accounts without
+// a stored password (derived passwords and SSH keys) never match.
+func Match(accounts []*model.Account, origin string) []*model.Account {
+	originScheme, originDomain := parseURL(origin)
+	if originDomain == "" {
+		return nil
+	}
+
+	var matches []*model.Account
+	for _, account := range accounts {
+		if account.Derived != nil || account.SSHKey != nil || account.EncryptedPassword == "" {
+			continue
+		}
+		scheme, domain := parseURL(account.URL)
+		if domain == "" || originScheme != "https" && originScheme != scheme {
+			continue
+		}
+		if originDomain == domain || strings.HasSuffix(originDomain, "."+domain) {
+			matches = append(matches, account)
+		}
+	}
+	return matches
+}
+
+// parseURL returns the lowercase scheme and the normalized domain of rawURL:
+// lowercase, without port, trailing dot or leading "www.". URLs without a
+// scheme are read as https. The domain is "" if rawURL has no host.
+func parseURL(rawURL string) (scheme, domain string) {
+	rawURL = strings.TrimSpace(rawURL)
+	if rawURL == "" {
+		return "", ""
+	}
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "https://" + rawURL
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return "", ""
+	}
+
+	host := u.Hostname()
+	if ip := net.ParseIP(host); ip == nil {
+		host = strings.TrimPrefix(strings.TrimSuffix(strings.ToLower(host), "."), "www.")
+	}
+	return strings.ToLower(u.Scheme), host
+}
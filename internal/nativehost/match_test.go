@@ -0,0 +1,37 @@
+package nativehost
+
+import (
+	"testing"
+
+	"github.com/simp-lee/passwordmanager/internal/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatch(t *testing.T) {
+	accounts := []*model.Account{
+		{ID: "1", URL: "https://github.com/login", EncryptedPassword: "x"},
+		{ID: "2", URL: "www.GitLab.com", EncryptedPassword: "x"},
+		{ID: "3", URL: "http://router.local:8080", EncryptedPassword: "x"},
+		{ID: "4", URL: "https://example.com", Derived: &model.Derived{}},
+		{ID: "5", URL: "https://example.com", EncryptedPassword: "x", SSHKey: &model.SSHKey{}},
+		{ID: "6", EncryptedPassword: "x"},
+	}
+	ids := func(origin string) []string {
+		var ids []string
+		for _, account := range Match(accounts, origin) {
+			ids = append(ids, account.ID)
+		}
+		return ids
+	}
+
+	assert.Equal(t, []string{"1"}, ids("https://github.com"))
+	assert.Equal(t, []string{"1"}, ids("https://gist.github.com"), "Subdomains match")
+	assert.Empty(t, ids("https://evilgithub.com"))
+	assert.Empty(t, ids("https://github.com.evil.net"))
+	assert.Equal(t, []string{"2"}, ids("https://gitlab.com:443"), "Case, www and port are ignored")
+	assert.Empty(t, ids("http://github.com"), "Plain http only matches http accounts")
+	assert.Equal(t, []string{"3"}, ids("http://router.local"))
+	assert.Equal(t, []string{"3"}, ids("https://router.local"))
+	assert.Empty(t, ids("https://example.com"), "Accounts without a stored password never match")
+	assert.Empty(t, ids(""))
+}
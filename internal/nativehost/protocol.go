@@ -0,0 +1,72 @@
+// Package nativehost lets a browser extension autofill logins from the vault.
+// The browser starts the binary as a native messaging host and talks to it
+// over stdio. Only extensions allowed at install time may connect, and each
+// must be paired once, confirmed by the user, before it can ask for
+// credentials. The vault must already be unlocked in the unlock agent; the
+// host never asks for the master password itself.
+package nativehost
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"strings"
+
+	"github.com/simp-lee/passwordmanager/internal/errors"
+)
+
+const (
+	// MaxOutgoing is the largest message browsers accept from a host.
+	MaxOutgoing = 1 << 20
+	// MaxIncoming bounds messages from the extension, which are small.
+	MaxIncoming = 64 << 10
+)
+
+// ReadMessage reads one message: a 32-bit length in native byte order
+// followed by that many bytes of JSON. Returns io.EOF when the browser
+// closes the connection.
+func ReadMessage(r io.Reader) ([]byte, error) {
+	var length uint32
+	if err := binary.Read(r, binary.NativeEndian, &length); err != nil {
+		return nil, err
+	}
+	if length > MaxIncoming {
+		return nil, errors.ErrMessageTooLarge
+	}
+	message := make([]byte, length)
+	if _, err := io.ReadFull(r, message); err != nil {
+		return nil, errors.Wrap(err, "truncated native message")
+	}
+	return message, nil
+}
+
+// WriteMessage encodes v as JSON and writes it as one message.
+func WriteMessage(w io.Writer, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if len(data) > MaxOutgoing {
+		return errors.ErrMessageTooLarge
+	}
+	if err := binary.Write(w, binary.NativeEndian, uint32(len(data))); err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// CallerID returns the ID of the extension that started the host, from the
+// arguments the browser passes: Chrome gives the origin
+// "chrome-extension://<id>/", Firefox the manifest path and then the ID.
+func CallerID(args []string) string {
+	for _, arg := range args {
+		if id, ok := strings.CutPrefix(arg, "chrome-extension://"); ok {
+			return strings.TrimSuffix(id, "/")
+		}
+	}
+	if len(args) >= 2 && strings.HasSuffix(args[0], ".json") {
+		return args[1]
+	}
+	return ""
+}
@@ -0,0 +1,42 @@
+package nativehost
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+
+	"github.com/simp-lee/passwordmanager/internal/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessageRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, WriteMessage(&buf, Message{Type: "hello"}))
+	assert.Equal(t, uint32(buf.Len()-4), binary.NativeEndian.Uint32(buf.Bytes()))
+
+	data, err := ReadMessage(&buf)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"type":"hello"}`, string(data))
+	_, err = ReadMessage(&buf)
+	assert.ErrorIs(t, err, io.EOF, "A closed connection ends the session")
+}
+
+func TestReadMessageTooLarge(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, binary.Write(&buf, binary.NativeEndian, uint32(MaxIncoming+1)))
+	_, err := ReadMessage(&buf)
+	assert.ErrorIs(t, err, errors.ErrMessageTooLarge)
+
+	err = WriteMessage(io.Discard, string(make([]byte, MaxOutgoing)))
+	assert.ErrorIs(t, err, errors.ErrMessageTooLarge)
+}
+
+func TestCallerID(t *testing.T) {
+	id := "abcdefghijklmnopabcdefghijklmnop"
+	assert.Equal(t, id, CallerID([]string{"chrome-extension://" + id + "/"}))
+	assert.Equal(t, id, CallerID([]string{"chrome-extension://" + id + "/", "--parent-window=0"}))
+	assert.Equal(t, "autofill@example.com", CallerID([]string{"/usr/lib/mozilla/native-messaging-hosts/x.json", "autofill@example.com"}))
+	assert.Empty(t, CallerID(nil))
+}
@@ -0,0 +1,169 @@
+// Package pinentry asks for secrets through a pinentry program, the password
+// dialog used by GnuPG. Any program that speaks its Assuan protocol works,
+// such as pinentry-curses, pinentry-gnome3 or pinentry-mac, which lets
+// commands without a terminal of their own, e.g. git credential helpers,
+// still ask for the master password.
+package pinentry
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/simp-lee/passwordmanager/internal/errors"
+)
+
+const (
+	EnvProgram     = "PASSWORDMANAGER_PINENTRY" // Overrides the program
+	DefaultProgram = "pinentry"
+	errCanceled    = "83886179" // Assuan error code of a canceled dialog
+)
+
+// Prompt is the text of the dialog.
+type Prompt struct {
+	Title       string
+	Description string
+	Prompt      string // Label of the input field
+	Error       string // Shown when asking again after a wrong entry
+}
+
+// Program returns $PASSWORDMANAGER_PINENTRY, or "pinentry".
+func Program() string {
+	if program := os.Getenv(EnvProgram); program != "" {
+		return program
+	}
+	return DefaultProgram
+}
+
+// GetPin runs program and asks for a secret. Returns errors.ErrCanceled if
+// the user closes the dialog.
+func GetPin(program string, p Prompt) (string, error) {
+	return run(program, p, "GETPIN")
+}
+
+// Confirm runs program and asks the user to confirm the description.
+// Reports false if the user declines or closes the dialog.
+func Confirm(program string, p Prompt) (bool, error) {
+	_, err := run(program, p, "CONFIRM")
+	if errors.Is(err, errors.ErrCanceled) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// run starts program for one dialog and returns the data it sends back.
+func run(program string, p Prompt, command string) (string, error) {
+	cmd := exec.Command(program)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return "", err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return "", err
+	}
+	if err := cmd.Start(); err != nil {
+		return "", errors.Wrap(err, "failed to start pinentry")
+	}
+	data, err := converse(stdout, stdin, p, command)
+	stdin.Close()
+	cmd.Wait()
+	return data, err
+}
+
+// converse runs the Assuan conversation of one dialog command, GETPIN or
+// CONFIRM.
+func converse(r io.Reader, w io.Writer, p Prompt, command string) (string, error) {
+	reader := bufio.NewReader(r)
+	if _, err := response(reader); err != nil {
+		return "", err
+	}
+
+	// Terminal-based pinentries need to know where to draw
+	tty := os.Getenv("GPG_TTY")
+	if tty == "" {
+		tty = "/dev/tty"
+	}
+	var commands []string
+	commands = append(commands, "OPTION ttyname="+tty)
+	if term := os.Getenv("TERM"); term != "" {
+		commands = append(commands, "OPTION ttytype="+term)
+	}
+	for _, option := range commands {
+		if _, err := fmt.Fprintln(w, option); err != nil {
+			return "", err
+		}
+		response(reader) // Unsupported options are not fatal
+	}
+
+	for _, c := range [][2]string{{"SETTITLE", p.Title}, {"SETDESC", p.Description}, {"SETPROMPT", p.Prompt}, {"SETERROR", p.Error}} {
+		if c[1] == "" {
+			continue
+		}
+		if _, err := fmt.Fprintf(w, "%s %s\n", c[0], escape(c[1])); err != nil {
+			return "", err
+		}
+		if _, err := response(reader); err != nil {
+			return "", err
+		}
+	}
+
+	if _, err := fmt.Fprintln(w, command); err != nil {
+		return "", err
+	}
+	data, err := response(reader)
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprintln(w, "BYE")
+	return data, nil
+}
+
+// response reads lines up to the next OK or ERR and returns the data lines.
+func response(reader *bufio.Reader) (string, error) {
+	var data strings.Builder
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return "", errors.Wrap(err, "pinentry closed the connection")
+		}
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case line == "OK" || strings.HasPrefix(line, "OK "):
+			return data.String(), nil
+		case strings.HasPrefix(line, "D "):
+			data.WriteString(unescape(line[2:]))
+		case strings.HasPrefix(line, "ERR "):
+			if code, _, _ := strings.Cut(line[4:], " "); code == errCanceled {
+				return "", errors.ErrCanceled
+			}
+			return "", fmt.Errorf("pinentry: %s", line[4:])
+		}
+		// Status (S) and comment (#) lines are ignored
+	}
+}
+
+// escape percent-encodes what Assuan does not allow in a command argument.
+func escape(s string) string {
+	return strings.NewReplacer("%", "%25", "\n", "%0A", "\r", "%0D").Replace(s)
+}
+
+// unescape decodes the percent escapes of a data line.
+func unescape(s string) string {
+	var out strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '%' && i+2 < len(s) {
+			if b, err := strconv.ParseUint(s[i+1:i+3], 16, 8); err == nil {
+				out.WriteByte(byte(b))
+				i += 2
+				continue
+			}
+		}
+		out.WriteByte(s[i])
+	}
+	return out.String()
+}
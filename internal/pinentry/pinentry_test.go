@@ -0,0 +1,84 @@
+package pinentry
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/simp-lee/passwordmanager/internal/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakePinentry answers like a pinentry program: options are rejected, other
+// commands acknowledged and GETPIN or CONFIRM answered with reply. It returns the
+// commands it received.
+func fakePinentry(reply string) (io.Reader, io.Writer, <-chan []string) {
+	toClient, serverOut := io.Pipe()
+	serverIn, fromClient := io.Pipe()
+	received := make(chan []string, 1)
+	go func() {
+		defer serverOut.Close()
+		var commands []string
+		fmt.Fprintln(serverOut, "OK Pleased to meet you")
+		scanner := bufio.NewScanner(serverIn)
+		for scanner.Scan() {
+			line := scanner.Text()
+			commands = append(commands, line)
+			switch {
+			case line == "BYE":
+				received <- commands
+				return
+			case strings.HasPrefix(line, "OPTION"):
+				fmt.Fprintln(serverOut, "ERR 83886254 Unknown option")
+			case line == "GETPIN" || line == "CONFIRM":
+				fmt.Fprint(serverOut, reply)
+				if strings.HasPrefix(reply, "ERR") {
+					received <- commands
+					return
+				}
+			default:
+				fmt.Fprintln(serverOut, "OK")
+			}
+		}
+	}()
+	return toClient, fromClient, received
+}
+
+func TestConverse(t *testing.T) {
+	r, w, received := fakePinentry("S PASSWORD_FROM_CACHE\nD pa%25ss%0Aword\nOK\n")
+	pin, err := converse(r, w, Prompt{Title: "Vault", Description: "100% sure?\nReally", Prompt: "Password:"}, "GETPIN")
+	require.NoError(t, err)
+	assert.Equal(t, "pa%ss\nword", pin)
+
+	commands := <-received
+	assert.Contains(t, commands, "SETDESC 100%25 sure?%0AReally")
+	assert.Contains(t, commands, "SETPROMPT Password:")
+	assert.NotContains(t, strings.Join(commands, "\n"), "SETERROR", "Empty texts are not sent")
+}
+
+func TestConverseCanceled(t *testing.T) {
+	r, w, _ := fakePinentry("ERR 83886179 Operation cancelled <Pinentry>\n")
+	_, err := converse(r, w, Prompt{Prompt: "Password:"}, "GETPIN")
+	assert.ErrorIs(t, err, errors.ErrCanceled)
+}
+
+func TestConverseConfirm(t *testing.T) {
+	r, w, received := fakePinentry("OK\n")
+	_, err := converse(r, w, Prompt{Description: "Use key?"}, "CONFIRM")
+	require.NoError(t, err)
+	assert.Contains(t, <-received, "CONFIRM")
+
+	r, w, _ = fakePinentry("ERR 83886179 Operation cancelled\n")
+	_, err = converse(r, w, Prompt{Description: "Use key?"}, "CONFIRM")
+	assert.ErrorIs(t, err, errors.ErrCanceled)
+}
+
+func TestProgram(t *testing.T) {
+	t.Setenv(EnvProgram, "")
+	assert.Equal(t, DefaultProgram, Program())
+	t.Setenv(EnvProgram, "/usr/bin/pinentry-curses")
+	assert.Equal(t, "/usr/bin/pinentry-curses", Program())
+}
@@ -0,0 +1,88 @@
+package secretref
+
+import (
+	"bytes"
+	"io"
+	"sort"
+)
+
+// Mask replaces secrets in masked output.
+const Mask = "<concealed by passwordmanager>"
+
+// Masker is a writer that replaces every occurrence of its secrets before
+// passing output on. Output that could be the start of a secret is held back
+// until the next write shows it is not, or until Flush.
+type Masker struct {
+	w       io.Writer
+	secrets [][]byte // Longest first, so the longest overlapping secret wins
+	pending []byte
+}
+
+// NewMasker returns a masker writing to w. Empty secrets are ignored.
+func NewMasker(w io.Writer, secrets []string) *Masker {
+	m := &Masker{w: w}
+	for _, secret := range secrets {
+		if secret != "" {
+			m.secrets = append(m.secrets, []byte(secret))
+		}
+	}
+	sort.Slice(m.secrets, func(i, j int) bool { return len(m.secrets[i]) > len(m.secrets[j]) })
+	return m
+}
+
+// Write masks p and writes what can safely be written. It always reports
+// len(p) bytes written unless the underlying writer fails.
+func (m *Masker) Write(p []byte) (int, error) {
+	m.pending = append(m.pending, p...)
+	var out bytes.Buffer
+	for {
+		at, secret := m.next()
+		if secret == nil {
+			break
+		}
+		out.Write(m.pending[:at])
+		out.WriteString(Mask)
+		m.pending = m.pending[at+len(secret):]
+	}
+	keep := m.partialSuffix()
+	out.Write(m.pending[:len(m.pending)-keep])
+	m.pending = append(m.pending[:0], m.pending[len(m.pending)-keep:]...)
+
+	if _, err := m.w.Write(out.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Flush writes the output held back.
+func (m *Masker) Flush() error {
+	_, err := m.w.Write(m.pending)
+	m.pending = m.pending[:0]
+	return err
+}
+
+// next returns the earliest secret in the pending output and its position.
+func (m *Masker) next() (int, []byte) {
+	at, found := -1, []byte(nil)
+	for _, secret := range m.secrets {
+		if i := bytes.Index(m.pending, secret); i >= 0 && (at < 0 || i < at) {
+			at, found = i, secret
+		}
+	}
+	return at, found
+}
+
+// partialSuffix returns the length of the longest end of the pending output
+// that a secret starts with.
+func (m *Masker) partialSuffix() int {
+	longest := 0
+	for _, secret := range m.secrets {
+		for n := min(len(secret)-1, len(m.pending)); n > longest; n-- {
+			if bytes.HasPrefix(secret, m.pending[len(m.pending)-n:]) {
+				longest = n
+				break
+			}
+		}
+	}
+	return longest
+}
@@ -0,0 +1,199 @@
+// Package secretref resolves secret references: URIs that name one field of
+// an account in the vault, so that commands can fill in secrets without the
+// caller ever storing them.
+//
+// A reference is pm://<account>/<field>. The account is either its ID, a
+// platform name, or a group path followed by the platform name:
+//
+//	pm://4373126014574e97/password
+//	pm://GitHub/username
+//	pm://Work/Servers/Database/password
+//
+// Path segments are percent-decoded, so names containing '/' can be written
+// as %2F. Fields are the account fields (id, platform, username, email,
+// password, url, notes, group) or the name of a custom field.
+package secretref
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/simp-lee/passwordmanager/internal/crypto"
+	"github.com/simp-lee/passwordmanager/internal/errors"
+	"github.com/simp-lee/passwordmanager/internal/model"
+)
+
+// Scheme is the URI scheme of secret references.
+const Scheme = "pm"
+
+// Fields lists the account fields a reference can name besides custom fields.
+var Fields = []string{"id", "platform", "username", "email", "password", "url", "notes", "group"}
+
+// Ref is a parsed secret reference.
+type Ref struct {
+	Group string // Group path of the account; "" matches an ID or a platform in any group
+	Name  string // Platform name, or account ID if Group is ""
+	Field string // Account field or custom field name
+}
+
+// Parse parses a pm:// reference.
+func Parse(uri string) (Ref, error) {
+	rest, ok := strings.CutPrefix(uri, Scheme+"://")
+	if !ok {
+		return Ref{}, errors.Wrap(errors.ErrInvalidReference, fmt.Sprintf("%q does not start with %s://", uri, Scheme))
+	}
+	segments := strings.Split(rest, "/")
+	if len(segments) < 2 {
+		return Ref{}, errors.Wrap(errors.ErrInvalidReference, fmt.Sprintf("%q names no field", uri))
+	}
+	for i, segment := range segments {
+		decoded, err := url.PathUnescape(segment)
+		if err != nil || decoded == "" {
+			return Ref{}, errors.Wrap(errors.ErrInvalidReference, fmt.Sprintf("%q has an empty or malformed segment", uri))
+		}
+		segments[i] = decoded
+	}
+	n := len(segments)
+	return Ref{Group: strings.Join(segments[:n-2], "/"), Name: segments[n-2], Field: segments[n-1]}, nil
+}
+
+// New returns the reference to field of the account at path: an ID, a
+// platform, or a group path followed by the platform. Unlike in a URI, the
+// path is not percent-decoded.
+func New(path, field string) (Ref, error) {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	for _, segment := range segments {
+		if segment == "" {
+			return Ref{}, errors.Wrap(errors.ErrInvalidReference, fmt.Sprintf("%q has an empty segment", path))
+		}
+	}
+	if field == "" {
+		return Ref{}, errors.Wrap(errors.ErrInvalidReference, fmt.Sprintf("%q names no field", path))
+	}
+	n := len(segments)
+	return Ref{Group: strings.Join(segments[:n-1], "/"), Name: segments[n-1], Field: field}, nil
+}
+
+// IsReference reports whether s looks like a pm:// reference.
+func IsReference(s string) bool {
+	return strings.HasPrefix(s, Scheme+"://")
+}
+
+// String returns the reference as a URI.
+func (r Ref) String() string {
+	var segments []string
+	if r.Group != "" {
+		segments = strings.Split(r.Group, "/")
+	}
+	segments = append(segments, r.Name, r.Field)
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return Scheme + "://" + strings.Join(segments, "/")
+}
+
+// Vault is the part of an unlocked storage.Storage that references are
+// resolved against.
+type Vault interface {
+	GetAccounts() ([]*model.Account, error)
+	GetEncryptionKey() []byte
+}
+
+// Resolver resolves references against the accounts of an unlocked vault.
+type Resolver struct {
+	vault    Vault
+	accounts []*model.Account // Loaded on first use
+}
+
+// NewResolver returns a resolver for vault, which must be unlocked.
+func NewResolver(vault Vault) *Resolver {
+	return &Resolver{vault: vault}
+}
+
+// Resolve returns the value ref names. Passwords and protected custom fields
+// are decrypted.
+func (r *Resolver) Resolve(ref Ref) (string, error) {
+	account, err := r.find(ref)
+	if err != nil {
+		return "", err
+	}
+
+	switch strings.ToLower(ref.Field) {
+	case "id":
+		return account.ID, nil
+	case "platform":
+		return account.Platform, nil
+	case "username":
+		return account.Username, nil
+	case "email":
+		return account.Email, nil
+	case "url":
+		return account.URL, nil
+	case "notes":
+		return account.Notes, nil
+	case "group":
+		return account.Group, nil
+	case "password":
+		if account.Derived != nil {
+			return "", errors.Wrap(errors.ErrPasswordDerived, ref.String())
+		}
+		return r.decrypt(account.EncryptedPassword)
+	}
+	for _, field := range account.CustomFields {
+		if field.Name == ref.Field {
+			if field.Protected {
+				return r.decrypt(field.Value)
+			}
+			return field.Value, nil
+		}
+	}
+	return "", errors.Wrap(errors.ErrFieldNotFound, ref.String())
+}
+
+// ResolveURI parses and resolves a pm:// reference.
+func (r *Resolver) ResolveURI(uri string) (string, error) {
+	ref, err := Parse(uri)
+	if err != nil {
+		return "", err
+	}
+	return r.Resolve(ref)
+}
+
+// find returns the one account ref names.
+func (r *Resolver) find(ref Ref) (*model.Account, error) {
+	if r.accounts == nil {
+		accounts, err := r.vault.GetAccounts()
+		if err != nil {
+			return nil, err
+		}
+		r.accounts = accounts
+	}
+
+	var matches []*model.Account
+	for _, account := range r.accounts {
+		if ref.Group == "" && account.ID == ref.Name {
+			return account, nil
+		}
+		if strings.EqualFold(account.Platform, ref.Name) && (ref.Group == "" || strings.EqualFold(account.Group, ref.Group)) {
+			matches = append(matches, account)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return nil, errors.Wrap(errors.ErrAccountNotFound, ref.String())
+	case 1:
+		return matches[0], nil
+	default:
+		return nil, errors.Wrap(errors.ErrAmbiguousReference, fmt.Sprintf("%s matches %d accounts; use the group path or ID", ref, len(matches)))
+	}
+}
+
+func (r *Resolver) decrypt(ciphertext string) (string, error) {
+	plaintext, err := crypto.Decrypt(ciphertext, r.vault.GetEncryptionKey())
+	if err != nil {
+		return "", err
+	}
+	defer crypto.ClearBytes(plaintext)
+	return string(plaintext), nil
+}
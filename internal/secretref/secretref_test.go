@@ -0,0 +1,159 @@
+package secretref
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/simp-lee/passwordmanager/internal/crypto"
+	"github.com/simp-lee/passwordmanager/internal/errors"
+	"github.com/simp-lee/passwordmanager/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testVault struct {
+	accounts []*model.Account
+	key      []byte
+}
+
+func (v *testVault) GetAccounts() ([]*model.Account, error) { return v.accounts, nil }
+func (v *testVault) GetEncryptionKey() []byte               { return v.key }
+
+func newTestVault(t *testing.T) *testVault {
+	key := bytes.Repeat([]byte{7}, crypto.KeyLength)
+	encrypt := func(s string) string {
+		ciphertext, err := crypto.Encrypt([]byte(s), key)
+		require.NoError(t, err)
+		return ciphertext
+	}
+	return &testVault{key: key, accounts: []*model.Account{
+		{ID: "a1", Platform: "GitHub", Group: "Work", Username: "me", EncryptedPassword: encrypt("work-pass"),
+			CustomFields: []model.CustomField{{Name: "token", Value: encrypt("ghp_123"), Protected: true}, {Name: "org", Value: "acme"}}},
+		{ID: "a2", Platform: "GitHub", Group: "Personal", EncryptedPassword: encrypt("home-pass")},
+		{ID: "a3", Platform: "Database", Group: "Work/Servers", EncryptedPassword: encrypt("db-pass")},
+		{ID: "a4", Platform: "Site", Derived: &model.Derived{Site: "example.com"}},
+	}}
+}
+
+func TestParse(t *testing.T) {
+	ref, err := Parse("pm://Work/Servers/Database/password")
+	require.NoError(t, err)
+	assert.Equal(t, Ref{Group: "Work/Servers", Name: "Database", Field: "password"}, ref)
+	assert.Equal(t, "pm://Work/Servers/Database/password", ref.String())
+
+	ref, err = Parse("pm://a1/username")
+	require.NoError(t, err)
+	assert.Equal(t, Ref{Name: "a1", Field: "username"}, ref)
+
+	ref, err = Parse("pm://Home/A%2FB%20C/password")
+	require.NoError(t, err)
+	assert.Equal(t, "A/B C", ref.Name)
+	assert.Equal(t, "pm://Home/A%2FB%20C/password", ref.String(), "Escaping round-trips")
+
+	for _, uri := range []string{"op://a/b", "pm://onlyone", "pm://a//password", "pm://a/%zz", "pm://"} {
+		_, err := Parse(uri)
+		assert.ErrorIs(t, err, errors.ErrInvalidReference, uri)
+	}
+
+	ref, err = New("Work/GitHub", "password")
+	require.NoError(t, err)
+	assert.Equal(t, Ref{Group: "Work", Name: "GitHub", Field: "password"}, ref)
+	_, err = New("Work//GitHub", "password")
+	assert.ErrorIs(t, err, errors.ErrInvalidReference)
+	_, err = New("GitHub", "")
+	assert.ErrorIs(t, err, errors.ErrInvalidReference)
+}
+
+func TestResolve(t *testing.T) {
+	r := NewResolver(newTestVault(t))
+
+	for uri, want := range map[string]string{
+		"pm://Work/GitHub/password":           "work-pass",
+		"pm://personal/github/password":       "home-pass",
+		"pm://a1/username":                    "me",
+		"pm://a1/token":                       "ghp_123",
+		"pm://a1/org":                         "acme",
+		"pm://Database/password":              "db-pass",
+		"pm://Work/Servers/Database/password": "db-pass",
+		"pm://a3/group":                       "Work/Servers",
+	} {
+		value, err := r.ResolveURI(uri)
+		require.NoError(t, err, uri)
+		assert.Equal(t, want, value, uri)
+	}
+
+	_, err := r.ResolveURI("pm://GitHub/password")
+	assert.ErrorIs(t, err, errors.ErrAmbiguousReference)
+	_, err = r.ResolveURI("pm://Work/Gitlab/password")
+	assert.ErrorIs(t, err, errors.ErrAccountNotFound)
+	_, err = r.ResolveURI("pm://a1/pin")
+	assert.ErrorIs(t, err, errors.ErrFieldNotFound)
+	_, err = r.ResolveURI("pm://a4/password")
+	assert.ErrorIs(t, err, errors.ErrPasswordDerived)
+}
+
+func TestMasker(t *testing.T) {
+	var out bytes.Buffer
+	m := NewMasker(&out, []string{"secret", "secretive", ""})
+
+	// Secrets split across writes are still caught
+	for _, chunk := range []string{"a sec", "ret and a secr", "etive end, sec"} {
+		n, err := m.Write([]byte(chunk))
+		require.NoError(t, err)
+		assert.Equal(t, len(chunk), n)
+	}
+	assert.Equal(t, "a "+Mask+" and a "+Mask+" end, ", out.String(), "A possible secret prefix is held back")
+	require.NoError(t, m.Flush())
+	assert.Equal(t, "a "+Mask+" and a "+Mask+" end, sec", out.String())
+
+	out.Reset()
+	m = NewMasker(&out, nil)
+	m.Write([]byte("nothing to hide"))
+	assert.Equal(t, "nothing to hide", out.String())
+}
+
+func TestRender(t *testing.T) {
+	r := NewResolver(newTestVault(t))
+
+	output, unresolved, err := Render("user={{ pm \"Work/GitHub\" \"username\" }}\n"+
+		"pass={{ pm \"pm://Work/Servers/Database/password\" }}\n"+
+		"token={{ pm \"a1\" \"token\" }}\n", r)
+	require.NoError(t, err)
+	assert.Empty(t, unresolved)
+	assert.Equal(t, "user=me\npass=db-pass\ntoken=ghp_123\n", output)
+
+	output, unresolved, err = Render(`a={{ pm "GitHub" "password" }} b={{ pm "Nope" "password" }} c={{ pm "a1" "username" }}`, r)
+	require.NoError(t, err)
+	require.Len(t, unresolved, 2)
+	assert.ErrorIs(t, unresolved[0], errors.ErrAmbiguousReference)
+	assert.ErrorIs(t, unresolved[1], errors.ErrAccountNotFound)
+	assert.Equal(t, `a={{ pm "GitHub" "password" }} b={{ pm "Nope" "password" }} c=me`, output, "Unresolved references are kept")
+
+	output, unresolved, err = Render(`x={{- pm "a1" "username" -}} y={{pm `+"`a1`"+` "token"}}`, r)
+	require.NoError(t, err)
+	assert.Empty(t, unresolved)
+	assert.Equal(t, "x=me y=ghp_123", output)
+
+	for _, text := range []string{`{{ pm "a1" }}`, `{{ pm "a1" "password" "x" }}`, `{{ pm "a1" `, `{{ pm a1 "password" }}`, `{{ pm "a1""password" }}`, `{{pm}}`} {
+		_, _, err := Render(text, r)
+		assert.Error(t, err, text)
+	}
+}
+
+func TestRenderKeepsOtherActions(t *testing.T) {
+	r := NewResolver(newTestVault(t))
+
+	text := "env:\n" +
+		"  TOKEN: ${{ secrets.GITHUB_TOKEN }}\n" +
+		"  USER: {{ pm \"a1\" \"username\" }}\n" +
+		"hostname: \"{{.Node.Hostname}}-{{ .Task.Slot }}\"\n" +
+		"{{ pmx }} {{ \"pm\" }} {{ range .Items }}{{ end }}\n"
+	output, unresolved, err := Render(text, r)
+	require.NoError(t, err)
+	assert.Empty(t, unresolved)
+	assert.Equal(t, "env:\n"+
+		"  TOKEN: ${{ secrets.GITHUB_TOKEN }}\n"+
+		"  USER: me\n"+
+		"hostname: \"{{.Node.Hostname}}-{{ .Task.Slot }}\"\n"+
+		"{{ pmx }} {{ \"pm\" }} {{ range .Items }}{{ end }}\n", output)
+}
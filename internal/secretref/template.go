@@ -0,0 +1,109 @@
+package secretref
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/simp-lee/passwordmanager/internal/errors"
+)
+
+// actionStart matches the opening of a pm action. Only pm actions are
+// template syntax; any other {{ }} belongs to the file being rendered, such
+// as a GitHub Actions expression or a docker-compose placeholder.
+var actionStart = regexp.MustCompile(`\{\{-?\s*pm(\s|\}\})`)
+
+// Render fills in the secret references of a template. References are
+// written as pm actions, either with an account path and a field or with
+// one pm:// URI:
+//
+//	DB_PASSWORD={{ pm "Work/Database" "password" }}
+//	TOKEN={{ pm "pm://4373126014574e97/api-token" }}
+//
+// All other text, including other {{ }} expressions, is copied unchanged.
+// A reference that names a missing account or field, or several accounts,
+// is left in the output as written and reported in unresolved. A malformed
+// pm action or reference fails the whole rendering.
+func Render(text string, r *Resolver) (output string, unresolved []error, err error) {
+	var out strings.Builder
+	pos := 0
+	for {
+		loc := actionStart.FindStringIndex(text[pos:])
+		if loc == nil {
+			out.WriteString(text[pos:])
+			return out.String(), unresolved, nil
+		}
+		start := pos + loc[0]
+		out.WriteString(text[pos:start])
+
+		args, length, err := parseAction(text[start:])
+		if err != nil {
+			return "", nil, errors.Wrap(err, fmt.Sprintf("line %d", strings.Count(text[:start], "\n")+1))
+		}
+		pos = start + length
+
+		value, err := resolveAction(args, r)
+		if errors.Is(err, errors.ErrAccountNotFound) || errors.Is(err, errors.ErrFieldNotFound) ||
+			errors.Is(err, errors.ErrAmbiguousReference) || errors.Is(err, errors.ErrPasswordDerived) {
+			unresolved = append(unresolved, err)
+			out.WriteString(text[start:pos])
+			continue
+		}
+		if err != nil {
+			return "", nil, err
+		}
+		out.WriteString(value)
+	}
+}
+
+// parseAction reads the pm action at the start of text and returns its
+// arguments and its length. Arguments are Go string literals.
+func parseAction(text string) (args []string, length int, err error) {
+	rest := strings.TrimPrefix(text[2:], "-")
+	rest = strings.TrimLeft(rest, " \t\r\n")
+	rest = strings.TrimPrefix(rest, "pm")
+	for {
+		trimmed := strings.TrimLeft(rest, " \t\r\n")
+		if strings.HasPrefix(trimmed, "-}}") && len(trimmed) < len(rest) {
+			return args, len(text) - len(trimmed) + 3, nil
+		}
+		if strings.HasPrefix(trimmed, "}}") {
+			return args, len(text) - len(trimmed) + 2, nil
+		}
+		if trimmed == "" {
+			return nil, 0, errors.Wrap(errors.ErrInvalidReference, "unclosed pm action")
+		}
+		if len(trimmed) == len(rest) && args != nil {
+			return nil, 0, errors.Wrap(errors.ErrInvalidReference, "pm arguments must be separated by spaces")
+		}
+		quoted, err := strconv.QuotedPrefix(trimmed)
+		if err != nil {
+			return nil, 0, errors.Wrap(errors.ErrInvalidReference, "pm arguments must be quoted strings")
+		}
+		arg, err := strconv.Unquote(quoted)
+		if err != nil {
+			return nil, 0, errors.Wrap(errors.ErrInvalidReference, "pm arguments must be quoted strings")
+		}
+		args = append(args, arg)
+		rest = trimmed[len(quoted):]
+	}
+}
+
+// resolveAction looks up the secret a pm action refers to.
+func resolveAction(args []string, r *Resolver) (string, error) {
+	var ref Ref
+	var err error
+	switch len(args) {
+	case 1:
+		ref, err = Parse(args[0])
+	case 2:
+		ref, err = New(args[0], args[1])
+	default:
+		err = errors.Wrap(errors.ErrInvalidReference, fmt.Sprintf("pm takes a path and a field or one URI, got %d arguments", len(args)))
+	}
+	if err != nil {
+		return "", err
+	}
+	return r.Resolve(ref)
+}
@@ -0,0 +1,67 @@
+// Package socket creates the Unix sockets that the unlock agent, the SSH
+// agent and the API server listen on. A socket is only reachable by the
+// current user: it lives in a directory that belongs to the user and is
+// closed to everyone else, so its own permissions never matter, not even in
+// the moment before they are restricted.
+package socket
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/simp-lee/passwordmanager/internal/errors"
+)
+
+// Listen creates the socket at path, creating its directory if it is
+// missing. A socket left behind by a process that is gone, which refuses
+// connections, is replaced. Fails with errors.ErrSocketInUse if a process
+// listens on path, and with errors.ErrNotSocket if path is another kind of
+// file; nothing but a stale socket is ever removed.
+func Listen(path string) (net.Listener, error) {
+	if err := CheckDir(filepath.Dir(path)); err != nil {
+		return nil, err
+	}
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, errors.Wrap(errors.ErrNotSocket, path)
+		}
+		conn, err := net.Dial("unix", path)
+		if err == nil {
+			conn.Close()
+			return nil, errors.Wrap(errors.ErrSocketInUse, path)
+		}
+		if !isRefused(err) {
+			return nil, errors.Wrap(err, "failed to check socket")
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, errors.Wrap(err, "failed to remove stale socket")
+		}
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create socket")
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return nil, errors.Wrap(err, "failed to restrict socket")
+	}
+	return listener, nil
+}
+
+// CheckDir makes sure only the current user can reach sockets in dir,
+// creating it if it is missing.
+func CheckDir(dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return errors.Wrap(err, "failed to create socket directory")
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return errors.Wrap(err, "failed to check socket directory")
+	}
+	if !isPrivate(info) {
+		return errors.Wrap(errors.ErrSocketPermissions, dir)
+	}
+	return nil
+}
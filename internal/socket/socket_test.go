@@ -0,0 +1,59 @@
+package socket
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/simp-lee/passwordmanager/internal/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListen(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "run", "test.sock")
+
+	listener, err := Listen(path)
+	require.NoError(t, err, "The directory is created")
+	if runtime.GOOS != "windows" {
+		info, err := os.Stat(filepath.Dir(path))
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0700), info.Mode().Perm())
+		info, err = os.Lstat(path)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	}
+
+	_, err = Listen(path)
+	assert.ErrorIs(t, err, errors.ErrSocketInUse, "A live socket is kept")
+
+	// A socket left behind by a process that is gone is replaced
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+	listener.Close()
+	listener, err = Listen(path)
+	require.NoError(t, err)
+	listener.Close()
+
+	// Other files are never removed
+	notes := filepath.Join(dir, "run", "notes.txt")
+	require.NoError(t, os.WriteFile(notes, []byte("notes"), 0600))
+	_, err = Listen(notes)
+	assert.ErrorIs(t, err, errors.ErrNotSocket)
+	assert.FileExists(t, notes)
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "run", "sub"), 0700))
+	_, err = Listen(filepath.Join(dir, "run", "sub"))
+	assert.ErrorIs(t, err, errors.ErrNotSocket)
+	assert.DirExists(t, filepath.Join(dir, "run", "sub"))
+
+	if runtime.GOOS == "windows" {
+		t.Skip("permission bits are not used on Windows")
+	}
+	open := filepath.Join(dir, "open")
+	require.NoError(t, os.Mkdir(open, 0755))
+	_, err = Listen(filepath.Join(open, "test.sock"))
+	assert.ErrorIs(t, err, errors.ErrSocketPermissions)
+	assert.NoFileExists(t, filepath.Join(open, "test.sock"))
+}
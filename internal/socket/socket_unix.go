@@ -0,0 +1,22 @@
+//go:build !windows
+
+package socket
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// isPrivate reports whether the directory belongs to the current user and is
+// closed to everyone else.
+func isPrivate(info os.FileInfo) bool {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	return ok && int(stat.Uid) == os.Getuid() && info.Mode().Perm()&0077 == 0
+}
+
+// isRefused reports whether dialling a socket failed because nothing
+// listens on it anymore.
+func isRefused(err error) bool {
+	return errors.Is(err, syscall.ECONNREFUSED)
+}
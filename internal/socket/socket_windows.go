@@ -0,0 +1,25 @@
+//go:build windows
+
+package socket
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// wsaeConnRefused is the Winsock error for a socket nothing listens on.
+const wsaeConnRefused = syscall.Errno(10061)
+
+// isPrivate reports whether the directory is closed to other users. Windows
+// has no permission bits; the data directory in the user profile is protected
+// by its ACLs instead.
+func isPrivate(info os.FileInfo) bool {
+	return info.IsDir()
+}
+
+// isRefused reports whether dialling a socket failed because nothing
+// listens on it anymore.
+func isRefused(err error) bool {
+	return errors.Is(err, wsaeConnRefused) || errors.Is(err, syscall.ECONNREFUSED)
+}
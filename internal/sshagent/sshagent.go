@@ -0,0 +1,310 @@
+// Package sshagent serves the SSH keys kept in the vault over the SSH agent
+// protocol, so ssh and git can use them without private keys on disk. Keys
+// are stored in the vault with 'passwordmanager ssh-key add'; the agent only
+// serves them and refuses keys added through the protocol.
+package sshagent
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/pem"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/simp-lee/passwordmanager/internal/crypto"
+	"github.com/simp-lee/passwordmanager/internal/errors"
+	"github.com/simp-lee/passwordmanager/internal/model"
+	"github.com/simp-lee/passwordmanager/internal/socket"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// Vault is the part of the storage the keyring reads keys from.
+type Vault interface {
+	GetAccounts() ([]*model.Account, error)
+	GetEncryptionKey() []byte
+}
+
+// ConfirmFunc asks the user whether the key of account may be used. It is
+// called before every signature with a key that requires confirmation.
+type ConfirmFunc func(account *model.Account) (bool, error)
+
+// Keyring is an agent.ExtendedAgent serving the SSH keys of an unlocked
+// vault. Keys are read from the vault on every request, so keys added or
+// deleted while the agent runs are picked up.
+type Keyring struct {
+	vault   Vault
+	confirm ConfirmFunc
+	started time.Time
+	now     func() time.Time
+
+	mu         sync.Mutex
+	passphrase []byte // Set while locked with 'ssh-add -x'
+}
+
+// NewKeyring returns a keyring serving the SSH keys of vault. Key lifetimes
+// count from now; confirm may be nil, in which case keys requiring
+// confirmation are refused.
+func NewKeyring(vault Vault, confirm ConfirmFunc) *Keyring {
+	return &Keyring{vault: vault, confirm: confirm, started: time.Now(), now: time.Now}
+}
+
+// List returns the SSH keys that may be used, named after their accounts.
+// A locked keyring lists none.
+func (k *Keyring) List() ([]*agent.Key, error) {
+	if k.locked() {
+		return nil, nil
+	}
+	accounts, err := k.accounts()
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []*agent.Key
+	for _, account := range accounts {
+		publicKey, err := parsePublicKey(account)
+		if err != nil {
+			continue
+		}
+		keys = append(keys, &agent.Key{
+			Format:  publicKey.Type(),
+			Blob:    publicKey.Marshal(),
+			Comment: account.Platform,
+		})
+	}
+	return keys, nil
+}
+
+// Sign signs data with the private key of key.
+func (k *Keyring) Sign(key ssh.PublicKey, data []byte) (*ssh.Signature, error) {
+	return k.SignWithFlags(key, data, 0)
+}
+
+// SignWithFlags signs data with the private key of key, asking first if the
+// key requires confirmation. The flags select SHA-2 signatures for RSA keys.
+func (k *Keyring) SignWithFlags(key ssh.PublicKey, data []byte, flags agent.SignatureFlags) (*ssh.Signature, error) {
+	if k.locked() {
+		return nil, errors.ErrSSHAgentLocked
+	}
+	account, err := k.find(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if account.SSHKey.Confirm {
+		if k.confirm == nil {
+			return nil, errors.ErrCanceled
+		}
+		allowed, err := k.confirm(account)
+		if err != nil {
+			return nil, err
+		}
+		if !allowed {
+			return nil, errors.ErrCanceled
+		}
+	}
+
+	signer, err := k.signer(account)
+	if err != nil {
+		return nil, err
+	}
+	var algorithm string
+	switch {
+	case flags&agent.SignatureFlagRsaSha512 != 0:
+		algorithm = ssh.KeyAlgoRSASHA512
+	case flags&agent.SignatureFlagRsaSha256 != 0:
+		algorithm = ssh.KeyAlgoRSASHA256
+	}
+	if algorithm != "" && key.Type() == ssh.KeyAlgoRSA {
+		if algorithmSigner, ok := signer.(ssh.AlgorithmSigner); ok {
+			return algorithmSigner.SignWithAlgorithm(rand.Reader, data, algorithm)
+		}
+	}
+	return signer.Sign(rand.Reader, data)
+}
+
+// Add refuses keys; they are added to the vault instead.
+func (k *Keyring) Add(key agent.AddedKey) error {
+	return errors.ErrSSHKeysReadOnly
+}
+
+// Remove refuses to remove keys; they are deleted from the vault instead.
+func (k *Keyring) Remove(key ssh.PublicKey) error {
+	return errors.ErrSSHKeysReadOnly
+}
+
+// RemoveAll refuses to remove keys; they are deleted from the vault instead.
+func (k *Keyring) RemoveAll() error {
+	return errors.ErrSSHKeysReadOnly
+}
+
+// Lock stops serving keys until Unlock is called with the same passphrase.
+func (k *Keyring) Lock(passphrase []byte) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.passphrase != nil {
+		return errors.ErrSSHAgentLocked
+	}
+	k.passphrase = slices.Clone(passphrase)
+	if k.passphrase == nil {
+		k.passphrase = []byte{}
+	}
+	return nil
+}
+
+// Unlock serves keys again after Lock.
+func (k *Keyring) Unlock(passphrase []byte) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.passphrase == nil {
+		return errors.Wrap(errors.ErrSSHAgentLocked, "agent is not locked")
+	}
+	if subtle.ConstantTimeCompare(k.passphrase, passphrase) != 1 {
+		return errors.Wrap(errors.ErrSSHAgentLocked, "wrong passphrase")
+	}
+	crypto.ClearBytes(k.passphrase)
+	k.passphrase = nil
+	return nil
+}
+
+// Signers is not supported: private keys only leave the vault to sign.
+func (k *Keyring) Signers() ([]ssh.Signer, error) {
+	return nil, errors.ErrSSHKeysReadOnly
+}
+
+// Extension reports that no extensions are supported.
+func (k *Keyring) Extension(extensionType string, contents []byte) ([]byte, error) {
+	return nil, agent.ErrExtensionUnsupported
+}
+
+func (k *Keyring) locked() bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.passphrase != nil
+}
+
+// accounts returns the accounts holding SSH keys whose lifetime has not
+// expired.
+func (k *Keyring) accounts() ([]*model.Account, error) {
+	accounts, err := k.vault.GetAccounts()
+	if err != nil {
+		return nil, err
+	}
+	elapsed := k.now().Sub(k.started)
+	var keys []*model.Account
+	for _, account := range accounts {
+		if account.SSHKey == nil {
+			continue
+		}
+		if lifetime := account.SSHKey.Lifetime; lifetime > 0 && elapsed >= time.Duration(lifetime)*time.Second {
+			continue
+		}
+		keys = append(keys, account)
+	}
+	return keys, nil
+}
+
+// find returns the account holding key.
+func (k *Keyring) find(key ssh.PublicKey) (*model.Account, error) {
+	accounts, err := k.accounts()
+	if err != nil {
+		return nil, err
+	}
+	blob := key.Marshal()
+	for _, account := range accounts {
+		publicKey, err := parsePublicKey(account)
+		if err == nil && bytes.Equal(publicKey.Marshal(), blob) {
+			return account, nil
+		}
+	}
+	return nil, errors.ErrSSHKeyNotFound
+}
+
+// signer decrypts the private key of account.
+func (k *Keyring) signer(account *model.Account) (ssh.Signer, error) {
+	privateKey, err := crypto.Decrypt(account.EncryptedPassword, k.vault.GetEncryptionKey())
+	if err != nil {
+		return nil, err
+	}
+	defer crypto.ClearBytes(privateKey)
+	signer, err := ssh.ParsePrivateKey(privateKey)
+	if err != nil {
+		return nil, errors.Wrap(errors.ErrInvalidSSHKey, err.Error())
+	}
+	return signer, nil
+}
+
+func parsePublicKey(account *model.Account) (ssh.PublicKey, error) {
+	publicKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(account.SSHKey.PublicKey))
+	return publicKey, err
+}
+
+// ParsePrivateKey reads a private key file, decrypting it with passphrase if
+// it is protected. It returns the key in OpenSSH format without a passphrase,
+// ready to be encrypted into the vault, and its description. Returns an
+// *ssh.PassphraseMissingError if the file needs a passphrase and none was
+// given.
+func ParsePrivateKey(data, passphrase []byte) ([]byte, *model.SSHKey, error) {
+	var privateKey any
+	var err error
+	if len(passphrase) > 0 {
+		privateKey, err = ssh.ParseRawPrivateKeyWithPassphrase(data, passphrase)
+	} else {
+		privateKey, err = ssh.ParseRawPrivateKey(data)
+	}
+	if err != nil {
+		var missing *ssh.PassphraseMissingError
+		if errors.As(err, &missing) {
+			return nil, nil, err
+		}
+		return nil, nil, errors.Wrap(errors.ErrInvalidSSHKey, err.Error())
+	}
+
+	signer, err := ssh.NewSignerFromKey(privateKey)
+	if err != nil {
+		return nil, nil, errors.Wrap(errors.ErrInvalidSSHKey, err.Error())
+	}
+	block, err := ssh.MarshalPrivateKey(privateKey, "")
+	if err != nil {
+		return nil, nil, errors.Wrap(errors.ErrInvalidSSHKey, err.Error())
+	}
+	publicKey := signer.PublicKey()
+	return pem.EncodeToMemory(block), &model.SSHKey{
+		PublicKey:   string(bytes.TrimSpace(ssh.MarshalAuthorizedKey(publicKey))),
+		Fingerprint: ssh.FingerprintSHA256(publicKey),
+	}, nil
+}
+
+// Listen creates the agent socket at path with socket.Listen, so its
+// directory must be private to the current user. A socket left behind by an
+// agent that is gone is replaced.
+func Listen(path string) (net.Listener, error) {
+	listener, err := socket.Listen(path)
+	if errors.Is(err, errors.ErrSocketInUse) {
+		return nil, errors.ErrSSHAgentRunning
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create SSH agent socket")
+	}
+	return listener, nil
+}
+
+// Serve answers SSH agent clients on listener until it is closed.
+func Serve(listener net.Listener, keyring agent.Agent) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return errors.Wrap(err, "SSH agent socket failed")
+		}
+		go func() {
+			defer conn.Close()
+			agent.ServeAgent(keyring, conn)
+		}()
+	}
+}
@@ -0,0 +1,234 @@
+package sshagent
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/pem"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/simp-lee/passwordmanager/internal/crypto"
+	"github.com/simp-lee/passwordmanager/internal/errors"
+	"github.com/simp-lee/passwordmanager/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+type fakeVault struct {
+	key      []byte
+	accounts []*model.Account
+}
+
+func (v *fakeVault) GetAccounts() ([]*model.Account, error) { return v.accounts, nil }
+func (v *fakeVault) GetEncryptionKey() []byte               { return v.key }
+
+func newVault(t *testing.T) *fakeVault {
+	t.Helper()
+	salt, err := crypto.GenerateSalt()
+	require.NoError(t, err)
+	return &fakeVault{key: crypto.GenerateKey("master password", salt)}
+}
+
+// addKey stores privateKey in the vault as an SSH key named name.
+func (v *fakeVault) addKey(t *testing.T, name string, privateKey any) *model.Account {
+	t.Helper()
+	block, err := ssh.MarshalPrivateKey(privateKey, "")
+	require.NoError(t, err)
+	openSSHKey, sshKey, err := ParsePrivateKey(pem.EncodeToMemory(block), nil)
+	require.NoError(t, err)
+	encrypted, err := crypto.Encrypt(openSSHKey, v.key)
+	require.NoError(t, err)
+	account := &model.Account{ID: name, Platform: name, EncryptedPassword: encrypted, SSHKey: sshKey}
+	v.accounts = append(v.accounts, account)
+	return account
+}
+
+func newEd25519Key(t *testing.T) ed25519.PrivateKey {
+	t.Helper()
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	return privateKey
+}
+
+// serve runs keyring on a socket and returns a client for it.
+func serve(t *testing.T, keyring *Keyring) (agent.ExtendedAgent, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "ssh", "ssh-agent.sock")
+	listener, err := Listen(path)
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go Serve(listener, keyring)
+
+	conn, err := net.Dial("unix", path)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return agent.NewClient(conn), path
+}
+
+func TestListAndSign(t *testing.T) {
+	vault := newVault(t)
+	account := vault.addKey(t, "work-laptop", newEd25519Key(t))
+	vault.accounts = append(vault.accounts, &model.Account{ID: "login", Platform: "GitHub"})
+	client, _ := serve(t, NewKeyring(vault, nil))
+
+	keys, err := client.List()
+	require.NoError(t, err)
+	require.Len(t, keys, 1, "Only SSH keys are listed")
+	assert.Equal(t, "work-laptop", keys[0].Comment)
+	assert.Equal(t, account.SSHKey.Fingerprint, ssh.FingerprintSHA256(keys[0]))
+
+	data := []byte("session data")
+	signature, err := client.Sign(keys[0], data)
+	require.NoError(t, err)
+	assert.NoError(t, keys[0].Verify(data, signature))
+}
+
+func TestSignRSAWithSHA2(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	vault := newVault(t)
+	vault.addKey(t, "rsa", privateKey)
+	client, _ := serve(t, NewKeyring(vault, nil))
+
+	keys, err := client.List()
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	data := []byte("session data")
+	signature, err := client.SignWithFlags(keys[0], data, agent.SignatureFlagRsaSha512)
+	require.NoError(t, err)
+	assert.Equal(t, ssh.KeyAlgoRSASHA512, signature.Format)
+	assert.NoError(t, keys[0].Verify(data, signature))
+}
+
+func TestConfirm(t *testing.T) {
+	vault := newVault(t)
+	account := vault.addKey(t, "deploy", newEd25519Key(t))
+	account.SSHKey.Confirm = true
+
+	var asked []string
+	allow := false
+	client, _ := serve(t, NewKeyring(vault, func(account *model.Account) (bool, error) {
+		asked = append(asked, account.Platform)
+		return allow, nil
+	}))
+	keys, err := client.List()
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+
+	_, err = client.Sign(keys[0], []byte("data"))
+	assert.Error(t, err, "Declined use is refused")
+	allow = true
+	_, err = client.Sign(keys[0], []byte("data"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"deploy", "deploy"}, asked, "Every use is confirmed")
+
+	_, err = NewKeyring(vault, nil).Sign(keys[0], []byte("data"))
+	assert.ErrorIs(t, err, errors.ErrCanceled, "Without a way to ask, keys requiring confirmation are refused")
+}
+
+func TestLifetime(t *testing.T) {
+	vault := newVault(t)
+	vault.addKey(t, "forever", newEd25519Key(t))
+	temporary := vault.addKey(t, "temporary", newEd25519Key(t))
+	temporary.SSHKey.Lifetime = 3600
+
+	keyring := NewKeyring(vault, nil)
+	keys, err := keyring.List()
+	require.NoError(t, err)
+	assert.Len(t, keys, 2)
+
+	keyring.now = func() time.Time { return keyring.started.Add(time.Hour) }
+	keys, err = keyring.List()
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	assert.Equal(t, "forever", keys[0].Comment)
+
+	publicKey, err := parsePublicKey(temporary)
+	require.NoError(t, err)
+	_, err = keyring.Sign(publicKey, []byte("data"))
+	assert.ErrorIs(t, err, errors.ErrSSHKeyNotFound)
+}
+
+func TestKeysAreReadOnly(t *testing.T) {
+	vault := newVault(t)
+	vault.addKey(t, "work-laptop", newEd25519Key(t))
+	client, _ := serve(t, NewKeyring(vault, nil))
+
+	assert.Error(t, client.Add(agent.AddedKey{PrivateKey: newEd25519Key(t)}))
+	assert.Error(t, client.RemoveAll())
+	keys, err := client.List()
+	require.NoError(t, err)
+	assert.Len(t, keys, 1)
+}
+
+func TestLock(t *testing.T) {
+	vault := newVault(t)
+	vault.addKey(t, "work-laptop", newEd25519Key(t))
+	client, _ := serve(t, NewKeyring(vault, nil))
+
+	require.NoError(t, client.Lock([]byte("secret")))
+	keys, err := client.List()
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+	assert.Error(t, client.Unlock([]byte("wrong")))
+
+	require.NoError(t, client.Unlock([]byte("secret")))
+	keys, err = client.List()
+	require.NoError(t, err)
+	assert.Len(t, keys, 1)
+}
+
+func TestParsePrivateKeyWithPassphrase(t *testing.T) {
+	privateKey := newEd25519Key(t)
+	block, err := ssh.MarshalPrivateKeyWithPassphrase(privateKey, "laptop", []byte("passphrase"))
+	require.NoError(t, err)
+	data := pem.EncodeToMemory(block)
+
+	_, _, err = ParsePrivateKey(data, nil)
+	var missing *ssh.PassphraseMissingError
+	assert.ErrorAs(t, err, &missing)
+	_, _, err = ParsePrivateKey(data, []byte("wrong"))
+	assert.Error(t, err)
+
+	openSSHKey, sshKey, err := ParsePrivateKey(data, []byte("passphrase"))
+	require.NoError(t, err)
+	signer, err := ssh.ParsePrivateKey(openSSHKey)
+	require.NoError(t, err, "Stored keys have no passphrase")
+	assert.Equal(t, ssh.FingerprintSHA256(signer.PublicKey()), sshKey.Fingerprint)
+	assert.True(t, strings.HasPrefix(sshKey.PublicKey, "ssh-ed25519 "))
+
+	_, _, err = ParsePrivateKey([]byte("not a key"), nil)
+	assert.ErrorIs(t, err, errors.ErrInvalidSSHKey)
+}
+
+func TestListenRefusesRunningAgent(t *testing.T) {
+	vault := newVault(t)
+	_, path := serve(t, NewKeyring(vault, nil))
+	_, err := Listen(path)
+	assert.ErrorIs(t, err, errors.ErrSSHAgentRunning)
+}
+
+func TestSSHAdd(t *testing.T) {
+	sshAdd, err := exec.LookPath("ssh-add")
+	if err != nil {
+		t.Skip("ssh-add not installed")
+	}
+	vault := newVault(t)
+	account := vault.addKey(t, "work-laptop", newEd25519Key(t))
+	_, path := serve(t, NewKeyring(vault, nil))
+
+	cmd := exec.Command(sshAdd, "-l")
+	cmd.Env = append(os.Environ(), "SSH_AUTH_SOCK="+path)
+	output, err := cmd.CombinedOutput()
+	require.NoError(t, err, string(output))
+	assert.Contains(t, string(output), account.SSHKey.Fingerprint)
+	assert.Contains(t, string(output), "work-laptop")
+}
@@ -0,0 +1,371 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/simp-lee/passwordmanager/internal/errors"
+)
+
+const (
+	backupDirName   = "backups"                    // Directory (or collection) holding backups next to the vault
+	backupTimeStamp = "20060102T150405.000000000Z" // Sortable timestamp embedded in backup names
+	keptBackupTag   = "-kept"                      // Follows the timestamp in names of backups made by KeepBackup
+	maxBackups      = 10                           // Backups kept before the oldest are removed, not counting kept ones
+)
+
+// Environment variables selecting a WebDAV backend instead of the local file.
+const (
+	EnvWebDAVURL      = "PASSWORDMANAGER_WEBDAV_URL"
+	EnvWebDAVUser     = "PASSWORDMANAGER_WEBDAV_USER"
+	EnvWebDAVPassword = "PASSWORDMANAGER_WEBDAV_PASSWORD"
+)
+
+// Backend stores the encrypted vault file. Storage does all encryption; a
+// backend only moves opaque bytes.
+//
+// Every stored state of the vault has a version (an ETag for HTTP backends).
+// Writes are optimistic: Write only succeeds if the stored version is still
+// the one the caller last read, so concurrent writers cannot silently
+// overwrite each other.
+type Backend interface {
+	// Read returns the vault file and its version.
+	// Returns errors.ErrVaultNotExists if there is no vault yet.
+	Read() ([]byte, string, error)
+
+	// Version returns the version of the stored vault without reading it.
+	// Returns errors.ErrVaultNotExists if there is no vault yet.
+	Version() (string, error)
+
+	// Write replaces the vault file if its version is still version, or
+	// creates it if version is "" and no vault exists. The replaced file is
+	// kept as a backup; only the newest maxBackups of those are kept.
+	// Returns the new version, or an *errors.ConflictError if the vault was
+	// changed in the meantime.
+	Write(data []byte, version string) (string, error)
+
+	// KeepBackup stores data as a backup that is never removed to make room
+	// for newer ones, for a vault replaced on purpose, e.g. by an import.
+	KeepBackup(data []byte) error
+
+	// ListBackups returns the kept backups, newest first.
+	ListBackups() ([]Backup, error)
+
+	// ReadBackup returns the contents of the backup with the given name.
+	// Returns errors.ErrBackupNotFound if it does not exist.
+	ReadBackup(name string) ([]byte, error)
+}
+
+// Backup describes an earlier version of the vault file kept by a backend.
+type Backup struct {
+	Name string    // Identifies the backup within its backend
+	Time time.Time // When the version was replaced
+	Size int64     // Size in bytes
+	Kept bool      // Made by KeepBackup, so not removed for newer backups
+}
+
+// BackendConfigFileName is the file in the data directory that selects the
+// backend.
+const BackendConfigFileName = "backend.json"
+
+// Backend types in BackendConfig.
+const (
+	BackendLocal  = "local"
+	BackendWebDAV = "webdav"
+	BackendS3     = "s3"
+)
+
+// Environment variables with S3 credentials not kept in the backend
+// configuration.
+const (
+	EnvS3AccessKey = "AWS_ACCESS_KEY_ID"
+	EnvS3SecretKey = "AWS_SECRET_ACCESS_KEY"
+)
+
+// BackendConfig selects where the vault file is kept. It is read from
+// backend.json in the data directory, e.g.
+//
+//	{"type": "s3", "s3": {"endpoint": "http://minio:9000", "bucket": "team"}}
+type BackendConfig struct {
+	Type   string        `json:"type"` // local (default), webdav or s3
+	WebDAV *WebDAVConfig `json:"webdav,omitempty"`
+	S3     *S3Config     `json:"s3,omitempty"`
+}
+
+// WebDAVConfig configures a WebDAV backend.
+type WebDAVConfig struct {
+	URL      string `json:"url"` // URL of the vault file
+	User     string `json:"user"`
+	Password string `json:"password"`
+}
+
+// LoadBackendConfig reads backend.json from dataDir. Without the file, the
+// local backend is selected.
+func LoadBackendConfig(dataDir string) (*BackendConfig, error) {
+	data, err := os.ReadFile(filepath.Join(dataDir, BackendConfigFileName))
+	if os.IsNotExist(err) {
+		return &BackendConfig{Type: BackendLocal}, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read backend configuration")
+	}
+
+	config := &BackendConfig{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(config); err != nil {
+		return nil, errors.Wrap(errors.ErrBackendConfig, err.Error())
+	}
+	if config.Type == "" {
+		config.Type = BackendLocal
+	}
+	return config, nil
+}
+
+// OpenBackend returns the backend for dataDir: WebDAV if
+// PASSWORDMANAGER_WEBDAV_URL is set, otherwise the one selected by
+// backend.json, by default the vault file in dataDir.
+func OpenBackend(dataDir string) (Backend, error) {
+	if url := os.Getenv(EnvWebDAVURL); url != "" {
+		return NewWebDAVBackend(url, os.Getenv(EnvWebDAVUser), os.Getenv(EnvWebDAVPassword))
+	}
+
+	config, err := LoadBackendConfig(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	switch config.Type {
+	case BackendLocal:
+		return NewLocalBackend(filepath.Join(dataDir, VaultFileName)), nil
+	case BackendWebDAV:
+		if config.WebDAV == nil {
+			return nil, errors.Wrap(errors.ErrBackendConfig, "missing webdav section")
+		}
+		return NewWebDAVBackend(config.WebDAV.URL, config.WebDAV.User, config.WebDAV.Password)
+	case BackendS3:
+		if config.S3 == nil {
+			return nil, errors.Wrap(errors.ErrBackendConfig, "missing s3 section")
+		}
+		s3 := *config.S3
+		if s3.AccessKey == "" {
+			s3.AccessKey = os.Getenv(EnvS3AccessKey)
+		}
+		if s3.SecretKey == "" {
+			s3.SecretKey = os.Getenv(EnvS3SecretKey)
+		}
+		return NewS3Backend(s3)
+	default:
+		return nil, errors.Wrap(errors.ErrBackendConfig, fmt.Sprintf("unknown backend type %q", config.Type))
+	}
+}
+
+// LocalBackend keeps the vault in a file on the local filesystem, with
+// backups in a directory next to it. Versions are content hashes.
+type LocalBackend struct {
+	path string
+	mu   sync.Mutex // Serializes compare-and-write within this process
+}
+
+// NewLocalBackend returns a backend for the vault file at path.
+func NewLocalBackend(path string) *LocalBackend {
+	return &LocalBackend{path: path}
+}
+
+// Read returns the vault file and its version.
+func (b *LocalBackend) Read() ([]byte, string, error) {
+	data, err := os.ReadFile(b.path)
+	if os.IsNotExist(err) {
+		return nil, "", errors.ErrVaultNotExists
+	}
+	if err != nil {
+		return nil, "", errors.Wrap(err, "error reading vault file")
+	}
+	return data, contentVersion(data), nil
+}
+
+// Version returns the version of the vault file.
+func (b *LocalBackend) Version() (string, error) {
+	_, version, err := b.Read()
+	return version, err
+}
+
+// Write replaces the vault file atomically if it is still at version.
+func (b *LocalBackend) Write(data []byte, version string) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	current, currentVersion, err := b.Read()
+	if err != nil && !errors.Is(err, errors.ErrVaultNotExists) {
+		return "", err
+	}
+	if currentVersion != version {
+		return "", &errors.ConflictError{Expected: version, Actual: currentVersion}
+	}
+
+	// Keep the version being replaced
+	backup := ""
+	if current != nil {
+		if backup, err = b.backup(current, false); err != nil {
+			return "", err
+		}
+	}
+
+	if err := writeFileAtomic(b.path, data); err != nil {
+		if backup != "" {
+			os.Remove(backup)
+		}
+		return "", errors.Wrap(err, "failed to write vault file")
+	}
+	if backup != "" {
+		b.pruneBackups()
+	}
+	return contentVersion(data), nil
+}
+
+// KeepBackup writes data to the backup directory as a kept backup.
+func (b *LocalBackend) KeepBackup(data []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	_, err := b.backup(data, true)
+	return err
+}
+
+// ListBackups returns the backups in the backup directory, newest first.
+func (b *LocalBackend) ListBackups() ([]Backup, error) {
+	entries, err := os.ReadDir(b.backupDir())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list backups")
+	}
+
+	var backups []Backup
+	for _, entry := range entries {
+		backup, ok := parseBackupName(filepath.Base(b.path), entry.Name())
+		if !ok || entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		backup.Size = info.Size()
+		backups = append(backups, backup)
+	}
+	sortBackups(backups)
+	return backups, nil
+}
+
+// ReadBackup returns the contents of a backup.
+func (b *LocalBackend) ReadBackup(name string) ([]byte, error) {
+	if _, ok := parseBackupName(filepath.Base(b.path), name); !ok {
+		return nil, errors.ErrBackupNotFound
+	}
+	data, err := os.ReadFile(filepath.Join(b.backupDir(), name))
+	if os.IsNotExist(err) {
+		return nil, errors.ErrBackupNotFound
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read backup")
+	}
+	return data, nil
+}
+
+// backup writes data as the newest backup and returns its path.
+// Caller must hold the lock.
+func (b *LocalBackend) backup(data []byte, kept bool) (string, error) {
+	if err := os.MkdirAll(b.backupDir(), 0700); err != nil {
+		return "", errors.Wrap(err, "failed to create backup directory")
+	}
+	backupPath := filepath.Join(b.backupDir(), backupName(filepath.Base(b.path), time.Now(), kept))
+	if err := os.WriteFile(backupPath, data, 0600); err != nil {
+		return "", errors.Wrap(err, "failed to write backup")
+	}
+	return backupPath, nil
+}
+
+// pruneBackups removes the oldest backups beyond maxBackups. Caller must
+// hold the lock.
+func (b *LocalBackend) pruneBackups() {
+	backups, err := b.ListBackups()
+	if err != nil {
+		return
+	}
+	for _, old := range prunedBackups(backups) {
+		os.Remove(filepath.Join(b.backupDir(), old.Name))
+	}
+}
+
+func (b *LocalBackend) backupDir() string {
+	return filepath.Join(filepath.Dir(b.path), backupDirName)
+}
+
+// contentVersion derives a version from the file contents.
+func contentVersion(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:16])
+}
+
+// backupName names a backup of the vault file base taken at t,
+// e.g. "vault-20240102T150405.000000000Z.encrypted", or
+// "vault-20240102T150405.000000000Z-kept.encrypted" for a kept backup.
+func backupName(base string, t time.Time, kept bool) string {
+	ext := path.Ext(base)
+	name := strings.TrimSuffix(base, ext) + "-" + t.UTC().Format(backupTimeStamp)
+	if kept {
+		name += keptBackupTag
+	}
+	return name + ext
+}
+
+// parseBackupName returns the backup of the vault file base that name
+// stands for, without its size, or false if name is not such a backup.
+func parseBackupName(base, name string) (Backup, bool) {
+	ext := path.Ext(base)
+	prefix := strings.TrimSuffix(base, ext) + "-"
+	if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ext) || len(name) < len(prefix)+len(ext) {
+		return Backup{}, false
+	}
+	stamp := name[len(prefix) : len(name)-len(ext)]
+	stamp, kept := strings.CutSuffix(stamp, keptBackupTag)
+	t, err := time.Parse(backupTimeStamp, stamp)
+	if err != nil {
+		return Backup{}, false
+	}
+	return Backup{Name: name, Time: t, Kept: kept}, true
+}
+
+// sortBackups orders backups newest first.
+func sortBackups(backups []Backup) {
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].Time.After(backups[j].Time)
+	})
+}
+
+// prunedBackups returns the backups beyond maxBackups in a list sorted
+// newest first. Kept backups are neither counted nor returned.
+func prunedBackups(backups []Backup) []Backup {
+	var pruned []Backup
+	count := 0
+	for _, backup := range backups {
+		if backup.Kept {
+			continue
+		}
+		if count++; count > maxBackups {
+			pruned = append(pruned, backup)
+		}
+	}
+	return pruned
+}
@@ -0,0 +1,240 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/simp-lee/passwordmanager/internal/errors"
+	"github.com/simp-lee/passwordmanager/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/webdav"
+)
+
+// newWebDAVServer starts an in-process WebDAV server with the vault directory
+// /dav/files/alice. x/net/webdav ignores If-Match and If-None-Match on PUT, so
+// they are checked here the way Nextcloud does.
+func newWebDAVServer(t *testing.T) *httptest.Server {
+	fs := webdav.NewMemFS()
+	require.NoError(t, fs.Mkdir(context.Background(), "/dav", 0700))
+	require.NoError(t, fs.Mkdir(context.Background(), "/dav/files", 0700))
+	require.NoError(t, fs.Mkdir(context.Background(), "/dav/files/alice", 0700))
+	handler := &webdav.Handler{FileSystem: fs, LockSystem: webdav.NewMemLS()}
+
+	var mu sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, ok := r.BasicAuth(); !ok || user != "alice" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+
+		if r.Method == http.MethodPut {
+			head := httptest.NewRecorder()
+			handler.ServeHTTP(head, httptest.NewRequest(http.MethodHead, r.URL.Path, nil))
+			etag := ""
+			if head.Code == http.StatusOK {
+				etag = head.Header().Get("ETag")
+			}
+			if match := r.Header.Get("If-Match"); match != "" && match != etag {
+				w.WriteHeader(http.StatusPreconditionFailed)
+				return
+			}
+			if r.Header.Get("If-None-Match") == "*" && etag != "" {
+				w.WriteHeader(http.StatusPreconditionFailed)
+				return
+			}
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newTestWebDAVBackend(t *testing.T, server *httptest.Server) *WebDAVBackend {
+	backend, err := NewWebDAVBackend(server.URL+"/dav/files/alice/vault.encrypted", "alice", "secret")
+	require.NoError(t, err)
+	return backend
+}
+
+// testBackend checks the behaviour every backend must share.
+func testBackend(t *testing.T, backend Backend) {
+	_, _, err := backend.Read()
+	assert.ErrorIs(t, err, errors.ErrVaultNotExists)
+	_, err = backend.Version()
+	assert.ErrorIs(t, err, errors.ErrVaultNotExists)
+
+	v1, err := backend.Write([]byte("first"), "")
+	require.NoError(t, err, "Writing without a version creates the vault")
+	data, version, err := backend.Read()
+	require.NoError(t, err)
+	assert.Equal(t, "first", string(data))
+	assert.Equal(t, v1, version)
+
+	_, err = backend.Write([]byte("again"), "")
+	var conflict *errors.ConflictError
+	require.ErrorAs(t, err, &conflict, "Creating an existing vault conflicts")
+	assert.ErrorIs(t, err, errors.ErrVersionConflict)
+	assert.Equal(t, v1, conflict.Actual)
+
+	v2, err := backend.Write([]byte("second"), v1)
+	require.NoError(t, err)
+	assert.NotEqual(t, v1, v2)
+	current, err := backend.Version()
+	require.NoError(t, err)
+	assert.Equal(t, v2, current)
+
+	_, err = backend.Write([]byte("stale"), v1)
+	assert.ErrorIs(t, err, errors.ErrVersionConflict, "Writing from a stale version conflicts")
+	data, _, err = backend.Read()
+	require.NoError(t, err)
+	assert.Equal(t, "second", string(data), "A rejected write changes nothing")
+
+	backups, err := backend.ListBackups()
+	require.NoError(t, err)
+	require.Len(t, backups, 1, "A rejected write leaves no backup")
+	data, err = backend.ReadBackup(backups[len(backups)-1].Name)
+	require.NoError(t, err)
+	assert.Equal(t, "first", string(data), "The replaced version is kept")
+	assert.Equal(t, int64(len("first")), backups[len(backups)-1].Size)
+
+	_, err = backend.ReadBackup("../vault.encrypted")
+	assert.ErrorIs(t, err, errors.ErrBackupNotFound)
+
+	require.NoError(t, backend.KeepBackup([]byte("kept")))
+	version = v2
+	for i := 0; i < maxBackups+2; i++ {
+		version, err = backend.Write([]byte(fmt.Sprintf("write %d", i)), version)
+		require.NoError(t, err)
+	}
+	backups, err = backend.ListBackups()
+	require.NoError(t, err)
+	require.Len(t, backups, maxBackups+1, "Old backups are removed, kept ones stay")
+	assert.True(t, backups[0].Time.After(backups[1].Time), "Backups are listed newest first")
+	data, err = backend.ReadBackup(backups[0].Name)
+	require.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("write %d", maxBackups), string(data))
+
+	kept := slices.IndexFunc(backups, func(backup Backup) bool { return backup.Kept })
+	require.NotEqual(t, -1, kept)
+	data, err = backend.ReadBackup(backups[kept].Name)
+	require.NoError(t, err)
+	assert.Equal(t, "kept", string(data))
+	assert.Equal(t, int64(len("kept")), backups[kept].Size)
+}
+
+func TestLocalBackend(t *testing.T) {
+	testBackend(t, NewLocalBackend(filepath.Join(t.TempDir(), VaultFileName)))
+}
+
+func TestWebDAVBackend(t *testing.T) {
+	server := newWebDAVServer(t)
+
+	t.Run("Backend", func(t *testing.T) {
+		testBackend(t, newTestWebDAVBackend(t, server))
+	})
+
+	t.Run("CredentialsInURL", func(t *testing.T) {
+		backend, err := NewWebDAVBackend("http://alice:secret@"+server.Listener.Addr().String()+"/dav/files/alice/other.encrypted", "", "")
+		require.NoError(t, err)
+		_, err = backend.Version()
+		assert.ErrorIs(t, err, errors.ErrVaultNotExists)
+	})
+
+	t.Run("WrongCredentials", func(t *testing.T) {
+		backend, err := NewWebDAVBackend(server.URL+"/dav/files/alice/vault.encrypted", "alice", "wrong")
+		require.NoError(t, err)
+		_, _, err = backend.Read()
+		assert.ErrorIs(t, err, errors.ErrBackendAuth)
+	})
+
+	t.Run("InvalidURL", func(t *testing.T) {
+		for _, url := range []string{"ftp://example.com/vault", "https://example.com/dir/", "not a url", "https:///vault"} {
+			_, err := NewWebDAVBackend(url, "", "")
+			assert.ErrorIs(t, err, errors.ErrBackendURL, url)
+		}
+	})
+}
+
+func TestStorageWithBackend(t *testing.T) {
+	server := newWebDAVServer(t)
+
+	laptop, err := NewWithBackend(t.TempDir(), newTestWebDAVBackend(t, server))
+	require.NoError(t, err)
+	require.NoError(t, laptop.CreateVault("master-password"))
+	require.NoError(t, laptop.UnlockVault("master-password"))
+
+	desktop, err := NewWithBackend(t.TempDir(), newTestWebDAVBackend(t, server))
+	require.NoError(t, err)
+	assert.True(t, desktop.IsVaultExists())
+	assert.ErrorIs(t, desktop.CreateVault("other-password"), errors.ErrVaultExists)
+	require.NoError(t, desktop.UnlockVault("master-password"))
+
+	require.NoError(t, laptop.AddAccount(&model.Account{ID: "laptop", Platform: "GitHub", EncryptedPassword: encryptFor(t, laptop, "x")}))
+
+	err = desktop.AddAccount(&model.Account{ID: "desktop", Platform: "Mail", EncryptedPassword: encryptFor(t, desktop, "y")})
+	assert.ErrorIs(t, err, errors.ErrVersionConflict, "A save based on a stale vault must not overwrite the other one")
+	accounts, err := desktop.GetAccounts()
+	require.NoError(t, err)
+	assert.Empty(t, accounts, "A failed save leaves the vault unchanged")
+
+	require.NoError(t, desktop.UnlockVault("master-password"), "Reloading picks up the other writer's changes")
+	require.NoError(t, desktop.AddAccount(&model.Account{ID: "desktop", Platform: "Mail", EncryptedPassword: encryptFor(t, desktop, "y")}))
+	accounts, err = desktop.GetAccounts()
+	require.NoError(t, err)
+	assert.Len(t, accounts, 2)
+
+	backups, err := desktop.backend.ListBackups()
+	require.NoError(t, err)
+	assert.NotEmpty(t, backups, "Saves keep the versions they replaced")
+}
+
+func TestOpenBackend(t *testing.T) {
+	writeConfig := func(t *testing.T, config string) string {
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, BackendConfigFileName), []byte(config), 0600))
+		return dir
+	}
+
+	t.Run("LocalByDefault", func(t *testing.T) {
+		dir := t.TempDir()
+		backend, err := OpenBackend(dir)
+		require.NoError(t, err)
+		assert.Equal(t, filepath.Join(dir, VaultFileName), backend.(*LocalBackend).path)
+	})
+
+	t.Run("S3WithCredentialsFromEnv", func(t *testing.T) {
+		server, _ := newS3Server(t)
+		t.Setenv(EnvS3AccessKey, testS3AccessKey)
+		t.Setenv(EnvS3SecretKey, testS3SecretKey)
+		dir := writeConfig(t, `{"type": "s3", "s3": {"endpoint": "`+server.URL+`", "bucket": "team", "key": "vaults/ops.encrypted"}}`)
+
+		backend, err := OpenBackend(dir)
+		require.NoError(t, err)
+		require.IsType(t, &S3Backend{}, backend)
+		_, err = backend.Write([]byte("vault"), "")
+		require.NoError(t, err)
+	})
+
+	t.Run("EnvWebDAVWins", func(t *testing.T) {
+		t.Setenv(EnvWebDAVURL, "https://cloud.example.com/dav/vault.encrypted")
+		backend, err := OpenBackend(writeConfig(t, `{"type": "s3"}`))
+		require.NoError(t, err)
+		assert.IsType(t, &WebDAVBackend{}, backend)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		for _, config := range []string{`{"type": "ftp"}`, `{"type": "s3"}`, `{"type": "webdav"}`, `{"typo": "s3"}`, `not json`} {
+			_, err := OpenBackend(writeConfig(t, config))
+			assert.ErrorIs(t, err, errors.ErrBackendConfig, config)
+		}
+	})
+}
@@ -0,0 +1,383 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/simp-lee/passwordmanager/internal/crypto"
+	"github.com/simp-lee/passwordmanager/internal/errors"
+	"github.com/simp-lee/passwordmanager/internal/generator"
+	"github.com/simp-lee/passwordmanager/internal/model"
+)
+
+// MergeStrategy decides which version wins when an imported account matches
+// an existing one but differs from it.
+type MergeStrategy string
+
+const (
+	MergeKeepMine   MergeStrategy = "mine"   // Keep the existing account
+	MergeKeepTheirs MergeStrategy = "theirs" // Replace it with the imported one
+	MergeKeepBoth   MergeStrategy = "both"   // Add the imported one next to it
+	MergeNewest     MergeStrategy = "newest" // Keep whichever was updated last
+)
+
+// MergeStrategies returns the names of the supported strategies.
+func MergeStrategies() []string {
+	return []string{string(MergeKeepMine), string(MergeKeepTheirs), string(MergeKeepBoth), string(MergeNewest)}
+}
+
+// MergeAction is what a merge does with one imported account.
+type MergeAction string
+
+const (
+	MergeAdd       MergeAction = "add"       // No matching account; added
+	MergeUnchanged MergeAction = "unchanged" // Matches an identical account; nothing to do
+	MergeKeep      MergeAction = "keep"      // Conflict resolved in favour of the existing account
+	MergeReplace   MergeAction = "replace"   // Conflict resolved in favour of the imported account
+	MergeDuplicate MergeAction = "duplicate" // Conflict resolved by keeping both
+)
+
+// MergeChange describes the planned action for one imported account.
+type MergeChange struct {
+	Action   MergeAction
+	Local    *model.Account // Matching existing account; nil for MergeAdd
+	Incoming *model.Account // Imported account, secrets encrypted with the vault key
+	Fields   []string       // Names of the fields that differ from Local
+}
+
+// MergePlan is the result of PlanMerge. Nothing is written until it is
+// passed to ApplyMerge, so it doubles as the diff report shown beforehand.
+type MergePlan struct {
+	Strategy MergeStrategy
+	Changes  []MergeChange
+	salt     []byte // Salt of the vault the plan was made for
+}
+
+// Count returns the number of changes with the given action.
+func (p *MergePlan) Count(action MergeAction) int {
+	count := 0
+	for _, change := range p.Changes {
+		if change.Action == action {
+			count++
+		}
+	}
+	return count
+}
+
+// PlanMerge reads the vault file or portable export at path, unlocking it
+// with its own password, and plans how to merge its accounts into the
+// unlocked vault. Imported accounts match existing ones by ID, or else by
+// platform and username (case-insensitive); conflicts are resolved with
+// strategy. Derived passwords of a vault file are computed with its master
+// password and stored, since they cannot be derived from ours.
+// Requires read lock.
+func (s *Storage) PlanMerge(path, password string, strategy MergeStrategy) (*MergePlan, error) {
+	if !slices.Contains(MergeStrategies(), string(strategy)) {
+		return nil, errors.Wrap(errors.ErrMergeStrategy, string(strategy))
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read import file")
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if err := s.checkVaultUnlocked(); err != nil {
+		return nil, err
+	}
+
+	var incoming []*model.Account
+	if isPortableData(data) {
+		incoming, err = s.loadPortableAccounts(path, password)
+	} else {
+		incoming, err = s.loadVaultAccounts(data, password)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &MergePlan{Strategy: strategy, salt: bytes.Clone(s.vault.Salt)}
+	byID := make(map[string]*model.Account, len(s.vault.Accounts))
+	byLogin := make(map[string]*model.Account, len(s.vault.Accounts))
+	for _, account := range s.vault.Accounts {
+		byID[account.ID] = account
+		if _, ok := byLogin[loginKey(account)]; !ok {
+			byLogin[loginKey(account)] = account
+		}
+	}
+
+	matched := make(map[string]bool)
+	for _, account := range incoming {
+		local := byID[account.ID]
+		if local == nil {
+			local = byLogin[loginKey(account)]
+		}
+		if local == nil || matched[local.ID] {
+			plan.Changes = append(plan.Changes, MergeChange{Action: MergeAdd, Incoming: account})
+			continue
+		}
+		matched[local.ID] = true
+
+		change := MergeChange{Local: local, Incoming: account, Fields: diffAccounts(local, account, s.key)}
+		switch {
+		case len(change.Fields) == 0:
+			change.Action = MergeUnchanged
+		case strategy == MergeKeepTheirs, strategy == MergeNewest && account.UpdatedAt.After(local.UpdatedAt):
+			change.Action = MergeReplace
+		case strategy == MergeKeepBoth:
+			change.Action = MergeDuplicate
+		default:
+			change.Action = MergeKeep
+		}
+		plan.Changes = append(plan.Changes, change)
+	}
+
+	return plan, nil
+}
+
+// ApplyMerge writes a plan made by PlanMerge with a single save. Replaced
+// accounts keep their ID, position and creation time, and their previous
+// version is added to their history. Imported accounts whose ID is taken
+// get a new one.
+// Returns the number of accounts added and replaced. Requires exclusive lock.
+func (s *Storage) ApplyMerge(plan *MergePlan) (int, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkVaultUnlocked(); err != nil {
+		return 0, 0, err
+	}
+	if !bytes.Equal(plan.salt, s.vault.Salt) {
+		return 0, 0, errors.Wrap(errors.ErrVaultLocked, "vault was re-keyed after the merge was planned")
+	}
+
+	accounts := slices.Clone(s.vault.Accounts)
+	index := make(map[string]int, len(accounts))
+	maxOrder := 0
+	for i, account := range accounts {
+		index[account.ID] = i
+		maxOrder = max(maxOrder, account.SortOrder)
+	}
+
+	added, replaced := 0, 0
+	for _, change := range plan.Changes {
+		switch change.Action {
+		case MergeReplace:
+			if i, ok := index[change.Local.ID]; ok {
+				accounts[i] = replaceAccount(accounts[i], change.Incoming)
+				replaced++
+				continue
+			}
+			// The existing account was deleted in the meantime; add instead
+			fallthrough
+		case MergeAdd, MergeDuplicate:
+			account := change.Incoming.Clone()
+			if _, taken := index[account.ID]; taken || account.ID == "" {
+				account.ID = crypto.GenerateID()
+			}
+			maxOrder++
+			account.SortOrder = maxOrder
+			index[account.ID] = len(accounts)
+			accounts = append(accounts, account)
+			added++
+		}
+	}
+	if added == 0 && replaced == 0 {
+		return 0, 0, nil
+	}
+
+	hash, err := s.getMasterKeyHashForSave()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer crypto.ClearBytes(hash)
+
+	previous := s.vault.Accounts
+	s.vault.Accounts = accounts
+	if err := s.saveVault(hash, fmt.Sprintf("merge import: %d added, %d replaced", added, replaced)); err != nil {
+		s.vault.Accounts = previous
+		return 0, 0, err
+	}
+	return added, replaced, nil
+}
+
+// loadPortableAccounts decrypts a portable export and re-encrypts its
+// accounts with the vault key. Caller must hold the lock.
+func (s *Storage) loadPortableAccounts(path, passphrase string) ([]*model.Account, error) {
+	envelope, err := readPortableEnvelope(path)
+	if err != nil {
+		return nil, err
+	}
+	exportKey := crypto.GenerateKey(passphrase, envelope.Salt)
+	defer crypto.ClearBytes(exportKey)
+
+	payload, err := decryptPortablePayload(envelope, exportKey)
+	if err != nil {
+		return nil, err
+	}
+
+	accounts := make([]*model.Account, 0, len(payload.Accounts))
+	for _, account := range payload.Accounts {
+		if account == nil {
+			continue
+		}
+		if account.Derived != nil {
+			// Only the master password of the exporting vault gives its password
+			return nil, errors.Wrap(errors.ErrImportFormat, fmt.Sprintf("derived account %s has no stored password", account.ID))
+		}
+		rekeyed, err := rekeyAccount(account, exportKey, s.key)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, rekeyed)
+	}
+	return accounts, nil
+}
+
+// loadVaultAccounts unlocks the contents of another vault file with its master
+// password and re-encrypts its accounts with the vault key. Derived passwords
+// are computed and stored. Caller must hold the lock.
+func (s *Storage) loadVaultAccounts(fileData []byte, masterPassword string) ([]*model.Account, error) {
+	vault, key, err := decryptVaultFile(fileData, masterPassword)
+	if err != nil {
+		return nil, err
+	}
+	defer crypto.ClearBytes(key)
+
+	accounts := make([]*model.Account, 0, len(vault.Accounts))
+	for _, account := range vault.Accounts {
+		if account.Derived != nil {
+			if account, err = materializeDerived(account, masterPassword, key); err != nil {
+				return nil, err
+			}
+		}
+		rekeyed, err := rekeyAccount(account, key, s.key)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, rekeyed)
+	}
+	return accounts, nil
+}
+
+// materializeDerived returns a copy of a derived account with its password
+// computed from secret and stored encrypted with key.
+func materializeDerived(account *model.Account, secret string, key []byte) (*model.Account, error) {
+	password, err := derivePassword(account, secret)
+	if err != nil {
+		return nil, err
+	}
+	defer crypto.ClearBytes(password)
+
+	stored := *account
+	stored.Derived = nil
+	if stored.EncryptedPassword, err = crypto.Encrypt(password, key); err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("failed to encrypt password for account ID %s", account.ID))
+	}
+	return &stored, nil
+}
+
+// derivePassword computes the password of a derived account from secret.
+func derivePassword(account *model.Account, secret string) ([]byte, error) {
+	profile := account.Derived
+	result, err := generator.DerivePassword(generator.DeriveInput{
+		Secret:  secret,
+		Site:    profile.Site,
+		Login:   profile.Login,
+		Counter: profile.Counter,
+	}, generator.Options{
+		Length:       profile.Length,
+		UseLowercase: profile.Lowercase,
+		UseUppercase: profile.Uppercase,
+		UseDigits:    profile.Digits,
+		UseSymbols:   profile.Symbols,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("failed to derive password for account ID %s", account.ID))
+	}
+	return []byte(result.Password), nil
+}
+
+// replaceAccount returns incoming in place of local, keeping the identity and
+// position of local and recording it in the history.
+func replaceAccount(local, incoming *model.Account) *model.Account {
+	merged := incoming.Clone()
+	merged.ID = local.ID
+	merged.SortOrder = local.SortOrder
+	merged.CreatedAt = local.CreatedAt
+	merged.Revision = local.Revision + 1
+	merged.History = append(slices.Clone(local.History), model.HistoryEntry{
+		Username:          local.Username,
+		Email:             local.Email,
+		EncryptedPassword: local.EncryptedPassword,
+		URL:               local.URL,
+		Notes:             local.Notes,
+		UpdatedAt:         local.UpdatedAt,
+	})
+	return merged
+}
+
+// diffAccounts returns the names of the fields in which two accounts differ.
+// Secrets are compared after decrypting them with key.
+func diffAccounts(local, incoming *model.Account, key []byte) []string {
+	var fields []string
+	compare := func(name string, equal bool) {
+		if !equal {
+			fields = append(fields, name)
+		}
+	}
+
+	compare("platform", local.Platform == incoming.Platform)
+	compare("username", local.Username == incoming.Username)
+	compare("email", local.Email == incoming.Email)
+	compare("password", (local.Derived != nil) == (incoming.Derived != nil) &&
+		(local.Derived != nil || secretEqual(local.EncryptedPassword, incoming.EncryptedPassword, key)))
+	compare("url", local.URL == incoming.URL)
+	compare("notes", local.Notes == incoming.Notes)
+	compare("group", local.Group == incoming.Group)
+	compare("tags", slices.Equal(local.Tags, incoming.Tags))
+	compare("password_rules", local.PasswordRules == incoming.PasswordRules)
+	compare("derived", local.Derived == nil && incoming.Derived == nil ||
+		local.Derived != nil && incoming.Derived != nil && *local.Derived == *incoming.Derived)
+	compare("ssh_key", local.SSHKey == nil && incoming.SSHKey == nil ||
+		local.SSHKey != nil && incoming.SSHKey != nil && *local.SSHKey == *incoming.SSHKey)
+	compare("custom_fields", slices.EqualFunc(local.CustomFields, incoming.CustomFields, func(a, b model.CustomField) bool {
+		if a.Name != b.Name || a.Protected != b.Protected {
+			return false
+		}
+		if a.Protected {
+			return secretEqual(a.Value, b.Value, key)
+		}
+		return a.Value == b.Value
+	}))
+
+	return fields
+}
+
+// secretEqual reports whether two ciphertexts encrypted with key hold the same plaintext.
+func secretEqual(a, b string, key []byte) bool {
+	if a == b {
+		return true
+	}
+	plainA, err := crypto.Decrypt(a, key)
+	if err != nil {
+		return false
+	}
+	defer crypto.ClearBytes(plainA)
+	plainB, err := crypto.Decrypt(b, key)
+	if err != nil {
+		return false
+	}
+	defer crypto.ClearBytes(plainB)
+	return bytes.Equal(plainA, plainB)
+}
+
+// loginKey identifies an account by platform and username for matching.
+func loginKey(account *model.Account) string {
+	return strings.ToLower(strings.TrimSpace(account.Platform)) + "\x00" + strings.ToLower(strings.TrimSpace(account.Username))
+}
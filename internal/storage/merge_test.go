@@ -0,0 +1,223 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/simp-lee/passwordmanager/internal/crypto"
+	"github.com/simp-lee/passwordmanager/internal/errors"
+	"github.com/simp-lee/passwordmanager/internal/generator"
+	"github.com/simp-lee/passwordmanager/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	mergeOlder = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	mergeNewer = time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+)
+
+// newMergeVault creates an unlocked vault holding accounts whose passwords
+// are encrypted from the plaintext in EncryptedPassword.
+func newMergeVault(t *testing.T, masterPassword string, accounts ...*model.Account) *Storage {
+	s, err := New(t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, s.CreateVault(masterPassword))
+	require.NoError(t, s.UnlockVault(masterPassword))
+
+	for _, account := range accounts {
+		if account.Derived == nil {
+			encrypted, err := crypto.Encrypt([]byte(account.EncryptedPassword), s.GetEncryptionKey())
+			require.NoError(t, err)
+			account.EncryptedPassword = encrypted
+		}
+	}
+	require.NoError(t, s.AddAccounts(accounts))
+	return s
+}
+
+// setupMerge returns our vault and the path of a colleague's vault file.
+func setupMerge(t *testing.T) (*Storage, string) {
+	mine := newMergeVault(t, "my-password",
+		&model.Account{ID: "shared", Platform: "GitHub", Username: "octo", EncryptedPassword: "mine",
+			CreatedAt: mergeOlder, UpdatedAt: mergeOlder},
+		&model.Account{ID: "my-mail", Platform: "Mail", Username: "me", EncryptedPassword: "mail", Notes: "mine",
+			CreatedAt: mergeNewer, UpdatedAt: mergeNewer},
+		&model.Account{ID: "only-mine", Platform: "Bank", EncryptedPassword: "bank"},
+	)
+
+	theirs := newMergeVault(t, "their-password",
+		&model.Account{ID: "shared", Platform: "GitHub", Username: "octo", EncryptedPassword: "theirs",
+			CreatedAt: mergeOlder, UpdatedAt: mergeNewer},
+		&model.Account{ID: "their-mail", Platform: "mail", Username: "Me", EncryptedPassword: "mail", Notes: "theirs",
+			CreatedAt: mergeOlder, UpdatedAt: mergeOlder},
+		&model.Account{ID: "only-theirs", Platform: "Forum", EncryptedPassword: "forum"},
+		&model.Account{ID: "derived", Platform: "example.org",
+			Derived: &model.Derived{Site: "example.org", Counter: 1, Length: 16, Lowercase: true, Digits: true}},
+	)
+	path := filepath.Join(t.TempDir(), "theirs.encrypted")
+	require.NoError(t, theirs.ExportVault(path))
+	return mine, path
+}
+
+func encryptFor(t *testing.T, s *Storage, plaintext string) string {
+	encrypted, err := crypto.Encrypt([]byte(plaintext), s.GetEncryptionKey())
+	require.NoError(t, err)
+	return encrypted
+}
+
+func decryptWith(t *testing.T, s *Storage, ciphertext string) string {
+	plaintext, err := crypto.Decrypt(ciphertext, s.GetEncryptionKey())
+	require.NoError(t, err)
+	return string(plaintext)
+}
+
+func TestPlanMerge(t *testing.T) {
+	tests := []struct {
+		strategy MergeStrategy
+		shared   MergeAction
+		mail     MergeAction
+	}{
+		{MergeKeepMine, MergeKeep, MergeKeep},
+		{MergeKeepTheirs, MergeReplace, MergeReplace},
+		{MergeKeepBoth, MergeDuplicate, MergeDuplicate},
+		{MergeNewest, MergeReplace, MergeKeep},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.strategy), func(t *testing.T) {
+			mine, path := setupMerge(t)
+
+			plan, err := mine.PlanMerge(path, "their-password", tt.strategy)
+			require.NoError(t, err)
+			require.Len(t, plan.Changes, 4)
+
+			actions := make(map[string]MergeChange)
+			for _, change := range plan.Changes {
+				actions[change.Incoming.Platform] = change
+			}
+			assert.Equal(t, tt.shared, actions["GitHub"].Action, "Accounts should match by ID")
+			assert.Equal(t, []string{"password"}, actions["GitHub"].Fields)
+			assert.Equal(t, tt.mail, actions["mail"].Action, "Accounts should match by platform and username")
+			assert.Equal(t, "my-mail", actions["mail"].Local.ID)
+			assert.Equal(t, []string{"platform", "username", "notes"}, actions["mail"].Fields)
+			assert.Equal(t, MergeAdd, actions["Forum"].Action)
+			assert.Equal(t, MergeAdd, actions["example.org"].Action)
+
+			// Planning does not change the vault
+			accounts, err := mine.GetAccounts()
+			require.NoError(t, err)
+			assert.Len(t, accounts, 3)
+		})
+	}
+}
+
+func TestApplyMerge(t *testing.T) {
+	t.Run("KeepTheirs", func(t *testing.T) {
+		mine, path := setupMerge(t)
+		plan, err := mine.PlanMerge(path, "their-password", MergeKeepTheirs)
+		require.NoError(t, err)
+
+		added, replaced, err := mine.ApplyMerge(plan)
+		require.NoError(t, err)
+		assert.Equal(t, 2, added)
+		assert.Equal(t, 2, replaced)
+
+		shared, err := mine.GetAccountByID("shared")
+		require.NoError(t, err)
+		assert.Equal(t, "theirs", decryptWith(t, mine, shared.EncryptedPassword), "Secrets are re-encrypted with our key")
+		require.Len(t, shared.History, 1, "The replaced version is kept in the history")
+		assert.Equal(t, "mine", decryptWith(t, mine, shared.History[0].EncryptedPassword))
+		assert.Equal(t, 1, shared.SortOrder, "Replaced accounts keep their position")
+
+		mail, err := mine.GetAccountByID("my-mail")
+		require.NoError(t, err, "Replaced accounts keep their ID")
+		assert.Equal(t, "theirs", mail.Notes)
+
+		derived, err := mine.GetAccountByID("derived")
+		require.NoError(t, err)
+		assert.Nil(t, derived.Derived, "Derived passwords are stored since our master password differs")
+		want, err := generator.DerivePassword(generator.DeriveInput{Secret: "their-password", Site: "example.org", Counter: 1},
+			generator.Options{Length: 16, UseLowercase: true, UseDigits: true})
+		require.NoError(t, err)
+		assert.Equal(t, want.Password, decryptWith(t, mine, derived.EncryptedPassword))
+
+		// Merging the same file again changes nothing
+		plan, err = mine.PlanMerge(path, "their-password", MergeKeepTheirs)
+		require.NoError(t, err)
+		assert.Equal(t, 4, plan.Count(MergeUnchanged))
+		added, replaced, err = mine.ApplyMerge(plan)
+		require.NoError(t, err)
+		assert.Zero(t, added)
+		assert.Zero(t, replaced)
+	})
+
+	t.Run("KeepBoth", func(t *testing.T) {
+		mine, path := setupMerge(t)
+		plan, err := mine.PlanMerge(path, "their-password", MergeKeepBoth)
+		require.NoError(t, err)
+
+		added, replaced, err := mine.ApplyMerge(plan)
+		require.NoError(t, err)
+		assert.Equal(t, 4, added)
+		assert.Zero(t, replaced)
+
+		accounts, err := mine.GetAccounts()
+		require.NoError(t, err)
+		require.Len(t, accounts, 7)
+		ids := make(map[string]bool)
+		for _, account := range accounts {
+			assert.False(t, ids[account.ID], "IDs must stay unique")
+			ids[account.ID] = true
+		}
+		shared, err := mine.GetAccountByID("shared")
+		require.NoError(t, err)
+		assert.Equal(t, "mine", decryptWith(t, mine, shared.EncryptedPassword))
+	})
+
+	t.Run("Portable", func(t *testing.T) {
+		mine, _ := setupMerge(t)
+		other := newMergeVault(t, "other-password",
+			&model.Account{ID: "shared", Platform: "GitHub", Username: "octo", EncryptedPassword: "portable"})
+		path := filepath.Join(t.TempDir(), "export.json")
+		_, err := other.ExportPortable(path, "export-passphrase", ExportSelection{}, "")
+		require.NoError(t, err)
+
+		plan, err := mine.PlanMerge(path, "export-passphrase", MergeKeepTheirs)
+		require.NoError(t, err)
+		require.Len(t, plan.Changes, 1)
+		assert.Equal(t, MergeReplace, plan.Changes[0].Action)
+
+		_, _, err = mine.ApplyMerge(plan)
+		require.NoError(t, err)
+		shared, err := mine.GetAccountByID("shared")
+		require.NoError(t, err)
+		assert.Equal(t, "portable", decryptWith(t, mine, shared.EncryptedPassword))
+	})
+
+	t.Run("StalePlan", func(t *testing.T) {
+		mine, path := setupMerge(t)
+		plan, err := mine.PlanMerge(path, "their-password", MergeKeepTheirs)
+		require.NoError(t, err)
+
+		require.NoError(t, mine.ChangeMasterPassword("my-password", "new-password"))
+		_, _, err = mine.ApplyMerge(plan)
+		assert.ErrorIs(t, err, errors.ErrVaultLocked, "A plan holds secrets encrypted with the old key")
+	})
+}
+
+func TestPlanMergeErrors(t *testing.T) {
+	mine, path := setupMerge(t)
+
+	_, err := mine.PlanMerge(path, "wrong-password", MergeKeepMine)
+	assert.ErrorIs(t, err, errors.ErrInvalidPassword)
+
+	_, err = mine.PlanMerge(path, "their-password", MergeStrategy("ours"))
+	assert.ErrorIs(t, err, errors.ErrMergeStrategy)
+
+	locked, tmpDir := setupTestStorage(t)
+	defer cleanupTestStorage(tmpDir)
+	_, err = locked.PlanMerge(path, "their-password", MergeKeepMine)
+	assert.ErrorIs(t, err, errors.ErrVaultLocked)
+}
@@ -0,0 +1,264 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/simp-lee/passwordmanager/internal/crypto"
+	"github.com/simp-lee/passwordmanager/internal/errors"
+	"github.com/simp-lee/passwordmanager/internal/model"
+)
+
+const (
+	portableFormat  = "passwordmanager-portable" // Format marker of portable exports
+	portableVersion = 1
+	portableKDF     = "pbkdf2-sha256"
+)
+
+// ExportSelection chooses the accounts written to a portable export. An
+// account is selected when it matches any of the lists; a group also matches
+// its subgroups. Empty lists select every account.
+type ExportSelection struct {
+	Groups []string
+	Tags   []string
+	IDs    []string
+}
+
+// portableEnvelope is the unencrypted outer document of a portable export.
+// Data holds the encrypted portablePayload.
+type portableEnvelope struct {
+	Format     string    `json:"format"`
+	Version    int       `json:"version"`
+	KDF        string    `json:"kdf"`
+	Iterations int       `json:"iterations"`
+	Salt       []byte    `json:"salt"`
+	CreatedAt  time.Time `json:"created_at"`
+	Count      int       `json:"count"`
+	Data       string    `json:"data"`
+}
+
+type portablePayload struct {
+	Accounts []*model.Account `json:"accounts"`
+}
+
+// matches reports whether account is selected.
+func (sel ExportSelection) matches(account *model.Account) bool {
+	if len(sel.Groups) == 0 && len(sel.Tags) == 0 && len(sel.IDs) == 0 {
+		return true
+	}
+	if slices.Contains(sel.IDs, account.ID) {
+		return true
+	}
+	for _, group := range sel.Groups {
+		group = strings.Trim(group, "/")
+		if account.Group == group || strings.HasPrefix(account.Group, group+"/") {
+			return true
+		}
+	}
+	for _, tag := range sel.Tags {
+		if slices.ContainsFunc(account.Tags, func(t string) bool { return strings.EqualFold(t, tag) }) {
+			return true
+		}
+	}
+	return false
+}
+
+// ExportPortable writes the selected accounts to a self-contained JSON file
+// at path. Secrets are re-encrypted with a key derived from passphrase, so
+// the file opens independently of the master password. Derived passwords
+// are computed from masterPassword and stored, since the importing vault
+// would derive different ones; masterPassword is only needed, and checked,
+// if a derived account is selected.
+// Returns the number of accounts written. Requires read lock.
+func (s *Storage) ExportPortable(path, passphrase string, selection ExportSelection, masterPassword string) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if err := s.checkVaultUnlocked(); err != nil {
+		return 0, err
+	}
+	if slices.ContainsFunc(s.vault.Accounts, func(account *model.Account) bool {
+		return account.Derived != nil && selection.matches(account)
+	}) {
+		if err := s.checkMasterPassword(masterPassword); err != nil {
+			return 0, err
+		}
+	}
+
+	salt, err := crypto.GenerateSalt()
+	if err != nil {
+		return 0, err
+	}
+	exportKey := crypto.GenerateKey(passphrase, salt)
+	defer crypto.ClearBytes(exportKey)
+
+	payload := portablePayload{Accounts: []*model.Account{}}
+	for _, account := range s.vault.Accounts {
+		if !selection.matches(account) {
+			continue
+		}
+		if account.Derived != nil {
+			if account, err = materializeDerived(account, masterPassword, s.key); err != nil {
+				return 0, err
+			}
+		}
+		rekeyed, err := rekeyAccount(account, s.key, exportKey)
+		if err != nil {
+			return 0, err
+		}
+		payload.Accounts = append(payload.Accounts, rekeyed)
+	}
+
+	payloadData, err := json.Marshal(payload)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to marshal export data")
+	}
+	defer crypto.ClearBytes(payloadData)
+	encrypted, err := crypto.Encrypt(payloadData, exportKey)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to encrypt export data")
+	}
+
+	envelope, err := json.MarshalIndent(portableEnvelope{
+		Format:     portableFormat,
+		Version:    portableVersion,
+		KDF:        portableKDF,
+		Iterations: crypto.Iterations,
+		Salt:       salt,
+		CreatedAt:  time.Now().UTC(),
+		Count:      len(payload.Accounts),
+		Data:       encrypted,
+	}, "", "  ")
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to marshal export file")
+	}
+
+	if err := os.WriteFile(path, envelope, 0600); err != nil {
+		return 0, errors.Wrap(err, "failed to write export file")
+	}
+	return len(payload.Accounts), nil
+}
+
+// IsPortableExport reports whether the file at path is a portable export
+// rather than a raw vault file.
+func IsPortableExport(path string) bool {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	return isPortableData(data)
+}
+
+func isPortableData(data []byte) bool {
+	var envelope struct {
+		Format string `json:"format"`
+	}
+	return json.Unmarshal(data, &envelope) == nil && envelope.Format == portableFormat
+}
+
+// ImportPortable merges the accounts of the portable export at path into the
+// unlocked vault. Secrets are re-encrypted with the vault key. Accounts whose
+// ID already exists are skipped; the rest are appended after the current sort
+// order with their timestamps kept.
+// Returns the number of accounts added and skipped. Requires exclusive lock.
+func (s *Storage) ImportPortable(path, passphrase string) (int, int, error) {
+	envelope, err := readPortableEnvelope(path)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkVaultUnlocked(); err != nil {
+		return 0, 0, err
+	}
+
+	exportKey := crypto.GenerateKey(passphrase, envelope.Salt)
+	defer crypto.ClearBytes(exportKey)
+
+	payload, err := decryptPortablePayload(envelope, exportKey)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	known := make(map[string]bool, len(s.vault.Accounts))
+	maxOrder := 0
+	for _, account := range s.vault.Accounts {
+		known[account.ID] = true
+		maxOrder = max(maxOrder, account.SortOrder)
+	}
+
+	var accounts []*model.Account
+	skipped := 0
+	for _, account := range payload.Accounts {
+		if account == nil || account.ID == "" || known[account.ID] {
+			skipped++
+			continue
+		}
+		rekeyed, err := rekeyAccount(account, exportKey, s.key)
+		if err != nil {
+			return 0, 0, err
+		}
+		known[account.ID] = true
+		rekeyed.SortOrder = maxOrder + len(accounts) + 1
+		accounts = append(accounts, rekeyed)
+	}
+	if len(accounts) == 0 {
+		return 0, skipped, nil
+	}
+
+	hash, err := s.getMasterKeyHashForSave()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer crypto.ClearBytes(hash)
+
+	previous := s.vault.Accounts
+	s.vault.Accounts = append(slices.Clip(previous), accounts...)
+	if err := s.saveVault(hash, fmt.Sprintf("import %d accounts from portable export", len(accounts))); err != nil {
+		s.vault.Accounts = previous
+		return 0, 0, err
+	}
+	return len(accounts), skipped, nil
+}
+
+// decryptPortablePayload decrypts the accounts of a portable export.
+func decryptPortablePayload(envelope *portableEnvelope, exportKey []byte) (*portablePayload, error) {
+	payloadData, err := crypto.Decrypt(envelope.Data, exportKey)
+	if err != nil {
+		return nil, errors.ErrInvalidPassphrase
+	}
+	defer crypto.ClearBytes(payloadData)
+
+	var payload portablePayload
+	if err := json.Unmarshal(payloadData, &payload); err != nil {
+		return nil, errors.Wrap(errors.ErrDataCorrupted, "invalid export data")
+	}
+	return &payload, nil
+}
+
+// readPortableEnvelope reads and validates the outer document of a portable export.
+func readPortableEnvelope(path string) (*portableEnvelope, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read import file")
+	}
+
+	var envelope portableEnvelope
+	if err := json.Unmarshal(data, &envelope); err != nil || envelope.Format != portableFormat {
+		return nil, errors.Wrap(errors.ErrImportFormat, "not a portable export")
+	}
+	if envelope.Version != portableVersion || envelope.KDF != portableKDF || envelope.Iterations != crypto.Iterations {
+		return nil, errors.Wrap(errors.ErrImportFormat,
+			fmt.Sprintf("unsupported portable export version %d (%s, %d iterations)", envelope.Version, envelope.KDF, envelope.Iterations))
+	}
+	if len(envelope.Salt) != crypto.SaltLength || envelope.Data == "" {
+		return nil, errors.Wrap(errors.ErrDataCorrupted, "invalid portable export header")
+	}
+	return &envelope, nil
+}
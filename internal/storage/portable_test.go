@@ -0,0 +1,181 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/simp-lee/passwordmanager/internal/crypto"
+	"github.com/simp-lee/passwordmanager/internal/errors"
+	"github.com/simp-lee/passwordmanager/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupPortableSource creates a vault with accounts in different groups and tags.
+func setupPortableSource(t *testing.T) *Storage {
+	s, err := New(t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, s.CreateVault("source-password"))
+	require.NoError(t, s.UnlockVault("source-password"))
+
+	encrypt := func(value string) string {
+		encrypted, err := crypto.Encrypt([]byte(value), s.GetEncryptionKey())
+		require.NoError(t, err)
+		return encrypted
+	}
+	require.NoError(t, s.AddAccounts([]*model.Account{
+		{ID: "work-1", Platform: "GitHub", EncryptedPassword: encrypt("gh-secret"), Group: "Work",
+			CustomFields: []model.CustomField{{Name: "PIN", Value: encrypt("1234"), Protected: true}},
+			History:      []model.HistoryEntry{{EncryptedPassword: encrypt("gh-old")}}},
+		{ID: "work-2", Platform: "Jira", EncryptedPassword: encrypt("jira-secret"), Group: "Work/Tools"},
+		{ID: "home-1", Platform: "Bank", EncryptedPassword: encrypt("bank-secret"), Group: "Home", Tags: []string{"finance"}},
+		{ID: "home-2", Platform: "Forum", EncryptedPassword: encrypt("forum-secret"), Group: "Home"},
+	}))
+	return s
+}
+
+func TestExportSelection(t *testing.T) {
+	account := &model.Account{ID: "a", Group: "Work/Tools", Tags: []string{"Finance"}}
+
+	assert.True(t, ExportSelection{}.matches(account), "Empty selection selects everything")
+	assert.True(t, ExportSelection{Groups: []string{"Work"}}.matches(account), "Parent group should match subgroups")
+	assert.True(t, ExportSelection{Groups: []string{"Work/Tools/"}}.matches(account))
+	assert.False(t, ExportSelection{Groups: []string{"Wor"}}.matches(account), "Group prefix must end at a separator")
+	assert.True(t, ExportSelection{Tags: []string{"finance"}}.matches(account), "Tags match case-insensitively")
+	assert.True(t, ExportSelection{IDs: []string{"a"}}.matches(account))
+	assert.False(t, ExportSelection{IDs: []string{"b"}, Tags: []string{"travel"}}.matches(account))
+}
+
+func TestPortableExport(t *testing.T) {
+	t.Run("RoundTripWithDifferentPassword", func(t *testing.T) {
+		source := setupPortableSource(t)
+		path := filepath.Join(t.TempDir(), "export.json")
+
+		count, err := source.ExportPortable(path, "export-passphrase", ExportSelection{Groups: []string{"Work"}, Tags: []string{"finance"}}, "")
+		require.NoError(t, err, "Failed to export")
+		assert.Equal(t, 3, count)
+
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+		assert.True(t, IsPortableExport(path))
+
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.NotContains(t, string(data), "GitHub", "Account data must be encrypted")
+
+		target, tmpDir := setupTestStorage(t)
+		defer cleanupTestStorage(tmpDir)
+		setupVaultWithAccounts(t, target, "target-password", 1)
+
+		added, skipped, err := target.ImportPortable(path, "export-passphrase")
+		require.NoError(t, err, "Failed to import")
+		assert.Equal(t, 3, added)
+		assert.Zero(t, skipped)
+
+		accounts, err := target.GetAccounts()
+		require.NoError(t, err)
+		require.Len(t, accounts, 4, "Import should merge into the existing vault")
+		assert.Equal(t, "test-id-0", accounts[0].ID, "Existing accounts stay first")
+
+		github, err := target.GetAccountByID("work-1")
+		require.NoError(t, err)
+		key := target.GetEncryptionKey()
+		for want, ciphertext := range map[string]string{
+			"gh-secret": github.EncryptedPassword,
+			"1234":      github.CustomFields[0].Value,
+			"gh-old":    github.History[0].EncryptedPassword,
+		} {
+			plaintext, err := crypto.Decrypt(ciphertext, key)
+			require.NoError(t, err, "Secrets should be re-encrypted with the target vault key")
+			assert.Equal(t, want, string(plaintext))
+		}
+
+		// A second import finds every account already present
+		added, skipped, err = target.ImportPortable(path, "export-passphrase")
+		require.NoError(t, err)
+		assert.Zero(t, added)
+		assert.Equal(t, 3, skipped)
+
+		// The source vault is unchanged
+		original, err := source.GetAccountByID("work-1")
+		require.NoError(t, err)
+		plaintext, err := crypto.Decrypt(original.EncryptedPassword, source.GetEncryptionKey())
+		require.NoError(t, err)
+		assert.Equal(t, "gh-secret", string(plaintext))
+	})
+
+	t.Run("DerivedPasswordsMaterialized", func(t *testing.T) {
+		source := setupPortableSource(t)
+		derived := &model.Account{ID: "derived", Platform: "example.org",
+			Derived: &model.Derived{Site: "example.org", Counter: 1, Length: 16, Lowercase: true, Digits: true}}
+		require.NoError(t, source.AddAccount(derived))
+		want, err := derivePassword(derived, "source-password")
+		require.NoError(t, err)
+
+		path := filepath.Join(t.TempDir(), "export.json")
+		_, err = source.ExportPortable(path, "export-passphrase", ExportSelection{IDs: []string{"derived"}}, "wrong-password")
+		assert.ErrorIs(t, err, errors.ErrInvalidPassword)
+		_, err = source.ExportPortable(path, "export-passphrase", ExportSelection{IDs: []string{"home-1"}}, "")
+		assert.NoError(t, err, "The master password is only needed for derived accounts")
+		_, err = source.ExportPortable(path, "export-passphrase", ExportSelection{IDs: []string{"derived"}}, "source-password")
+		require.NoError(t, err)
+
+		// The target vault has another master password, which would derive another password
+		target, tmpDir := setupTestStorage(t)
+		defer cleanupTestStorage(tmpDir)
+		setupVaultWithAccounts(t, target, "target-password", 0)
+		_, _, err = target.ImportPortable(path, "export-passphrase")
+		require.NoError(t, err)
+		imported, err := target.GetAccountByID("derived")
+		require.NoError(t, err)
+		assert.Nil(t, imported.Derived)
+		plaintext, err := crypto.Decrypt(imported.EncryptedPassword, target.GetEncryptionKey())
+		require.NoError(t, err)
+		assert.Equal(t, string(want), string(plaintext))
+	})
+
+	t.Run("WrongPassphrase", func(t *testing.T) {
+		source := setupPortableSource(t)
+		path := filepath.Join(t.TempDir(), "export.json")
+		_, err := source.ExportPortable(path, "export-passphrase", ExportSelection{}, "")
+		require.NoError(t, err)
+
+		_, _, err = source.ImportPortable(path, "wrong-passphrase")
+		assert.ErrorIs(t, err, errors.ErrInvalidPassphrase)
+	})
+
+	t.Run("Locked", func(t *testing.T) {
+		s, tmpDir := setupTestStorage(t)
+		defer cleanupTestStorage(tmpDir)
+
+		_, err := s.ExportPortable(filepath.Join(tmpDir, "export.json"), "passphrase", ExportSelection{}, "")
+		assert.ErrorIs(t, err, errors.ErrVaultLocked)
+	})
+
+	t.Run("RawImportRejected", func(t *testing.T) {
+		source := setupPortableSource(t)
+		path := filepath.Join(t.TempDir(), "export.json")
+		_, err := source.ExportPortable(path, "export-passphrase", ExportSelection{IDs: []string{"home-2"}}, "")
+		require.NoError(t, err)
+
+		target, tmpDir := setupTestStorage(t)
+		defer cleanupTestStorage(tmpDir)
+		setupVaultWithAccounts(t, target, "target-password", 1)
+
+		err = target.ImportVault(path)
+		assert.ErrorIs(t, err, errors.ErrImportFormat, "A portable export must not replace the vault file")
+		require.NoError(t, target.UnlockVault("target-password"), "The existing vault should be restored")
+	})
+
+	t.Run("NotPortable", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "other.json")
+		require.NoError(t, os.WriteFile(path, []byte(`{"format":"something-else"}`), 0600))
+		assert.False(t, IsPortableExport(path))
+
+		s := setupPortableSource(t)
+		_, _, err := s.ImportPortable(path, "passphrase")
+		assert.ErrorIs(t, err, errors.ErrImportFormat)
+	})
+}
@@ -0,0 +1,459 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/simp-lee/passwordmanager/internal/errors"
+)
+
+const (
+	s3Timeout       = 30 * time.Second
+	s3DefaultKey    = VaultFileName
+	s3DateFormat    = "20060102T150405Z"
+	s3SignAlgo      = "AWS4-HMAC-SHA256"
+	s3Service       = "s3"
+	s3DefaultRegion = "us-east-1"
+)
+
+// S3Config configures an S3-compatible bucket (AWS S3, MinIO, ...).
+type S3Config struct {
+	Endpoint  string `json:"endpoint"`   // e.g. https://s3.eu-central-1.amazonaws.com or http://localhost:9000
+	Region    string `json:"region"`     // Default us-east-1
+	Bucket    string `json:"bucket"`     // Bucket name; versioning should be enabled for backups
+	Key       string `json:"key"`        // Object key of the vault, default vault.encrypted
+	AccessKey string `json:"access_key"` // Default $AWS_ACCESS_KEY_ID
+	SecretKey string `json:"secret_key"` // Default $AWS_SECRET_ACCESS_KEY
+}
+
+// S3Backend keeps the vault as an object in an S3-compatible bucket,
+// addressed path-style. Versions are the object's ETags; writes use
+// If-Match / If-None-Match, so the server rejects a write based on a stale
+// version. Backups are the noncurrent versions of the object, so the bucket
+// needs versioning enabled to keep any. Kept backups are separate objects
+// next to the vault object.
+type S3Backend struct {
+	config S3Config
+	base   *url.URL // Endpoint
+	client *http.Client
+	now    func() time.Time // Signing clock
+}
+
+// NewS3Backend returns a backend for the object described by config.
+func NewS3Backend(config S3Config) (*S3Backend, error) {
+	base, err := url.Parse(config.Endpoint)
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		return nil, errors.Wrap(errors.ErrBackendURL, config.Endpoint)
+	}
+	if config.Bucket == "" || config.AccessKey == "" || config.SecretKey == "" {
+		return nil, errors.Wrap(errors.ErrBackendConfig, "S3 bucket and credentials are required")
+	}
+	if config.Key == "" {
+		config.Key = s3DefaultKey
+	}
+	if config.Region == "" {
+		config.Region = s3DefaultRegion
+	}
+	base.Path = strings.TrimSuffix(base.Path, "/")
+
+	return &S3Backend{
+		config: config,
+		base:   base,
+		client: &http.Client{Timeout: s3Timeout},
+		now:    time.Now,
+	}, nil
+}
+
+// Read downloads the current version of the vault object.
+func (b *S3Backend) Read() ([]byte, string, error) {
+	resp, err := b.do(http.MethodGet, nil, nil, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, "", errors.ErrVaultNotExists
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", s3Error(resp)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "error reading vault file")
+	}
+	return data, resp.Header.Get("ETag"), nil
+}
+
+// Version returns the ETag of the vault object.
+func (b *S3Backend) Version() (string, error) {
+	resp, err := b.do(http.MethodHead, nil, nil, nil)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Header.Get("ETag"), nil
+	case http.StatusNotFound:
+		return "", errors.ErrVaultNotExists
+	default:
+		return "", s3Error(resp)
+	}
+}
+
+// Write uploads the vault object if the bucket still has version. With
+// versioning enabled, the replaced version stays available as a backup.
+func (b *S3Backend) Write(data []byte, version string) (string, error) {
+	header := http.Header{"Content-Type": {"application/octet-stream"}}
+	if version == "" {
+		header.Set("If-None-Match", "*")
+	} else {
+		header.Set("If-Match", version)
+	}
+
+	resp, err := b.do(http.MethodPut, nil, header, data)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusPreconditionFailed, http.StatusConflict:
+		// 409 means a concurrent conditional write is still in progress
+		actual, _ := b.Version()
+		return "", &errors.ConflictError{Expected: version, Actual: actual}
+	case http.StatusNotFound:
+		if version != "" {
+			return "", &errors.ConflictError{Expected: version}
+		}
+		return "", s3Error(resp)
+	default:
+		return "", s3Error(resp)
+	}
+
+	if version != "" {
+		b.pruneVersions()
+	}
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		return etag, nil
+	}
+	return b.Version()
+}
+
+// KeepBackup uploads data as an object named like a backup of the vault
+// object, which versioning does not expire.
+func (b *S3Backend) KeepBackup(data []byte) error {
+	name := backupName(path.Base(b.config.Key), b.now(), true)
+	header := http.Header{"Content-Type": {"application/octet-stream"}, "If-None-Match": {"*"}}
+	resp, err := b.send(http.MethodPut, b.keptPath(name), nil, header, data)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return s3Error(resp)
+	}
+	return nil
+}
+
+// ListBackups returns the noncurrent versions of the vault object and the
+// kept backups, newest first. Each version is dated when the next version
+// replaced it.
+func (b *S3Backend) ListBackups() ([]Backup, error) {
+	versions, err := b.listVersions(b.config.Key)
+	if err != nil {
+		return nil, err
+	}
+	versions = slices.DeleteFunc(versions, func(version s3Version) bool { return version.Key != b.config.Key })
+	var backups []Backup
+	for i, version := range versions {
+		if i == 0 {
+			continue // The current version
+		}
+		backups = append(backups, Backup{Name: version.VersionID, Time: versions[i-1].LastModified, Size: version.Size})
+	}
+
+	base := path.Base(b.config.Key)
+	kept, err := b.listVersions(strings.TrimSuffix(b.config.Key, path.Ext(base)) + "-")
+	if err != nil {
+		return nil, err
+	}
+	for _, version := range kept {
+		backup, ok := parseBackupName(base, path.Base(version.Key))
+		if !ok || !backup.Kept || !version.IsLatest || path.Dir(version.Key) != path.Dir(b.config.Key) {
+			continue
+		}
+		// Dated by the server like the versions, not by the name
+		backup.Time, backup.Size = version.LastModified, version.Size
+		backups = append(backups, backup)
+	}
+	sortBackups(backups)
+	return backups, nil
+}
+
+// ReadBackup downloads a noncurrent version of the vault object, or a kept
+// backup.
+func (b *S3Backend) ReadBackup(name string) ([]byte, error) {
+	if name == "" || name == "null" {
+		return nil, errors.ErrBackupNotFound
+	}
+	var resp *http.Response
+	var err error
+	if backup, ok := parseBackupName(path.Base(b.config.Key), name); ok && backup.Kept {
+		resp, err = b.send(http.MethodGet, b.keptPath(name), nil, nil, nil)
+	} else {
+		resp, err = b.do(http.MethodGet, url.Values{"versionId": {name}}, nil, nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// An unknown or malformed version ID is 404 NoSuchVersion or 400 InvalidArgument
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusBadRequest {
+		return nil, errors.ErrBackupNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, s3Error(resp)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read backup")
+	}
+	return data, nil
+}
+
+// s3Version is one version of an object.
+type s3Version struct {
+	Key          string    `xml:"Key"`
+	VersionID    string    `xml:"VersionId"`
+	IsLatest     bool      `xml:"IsLatest"`
+	LastModified time.Time `xml:"LastModified"`
+	Size         int64     `xml:"Size"`
+}
+
+// listVersions returns the versions of the objects whose keys start with
+// prefix, the current ones first and then newest first. Delete markers are
+// skipped.
+func (b *S3Backend) listVersions(prefix string) ([]s3Version, error) {
+	var versions []s3Version
+	query := url.Values{"versions": {""}, "prefix": {prefix}}
+	for {
+		resp, err := b.doBucket(http.MethodGet, query)
+		if err != nil {
+			return nil, err
+		}
+		var result struct {
+			Versions            []s3Version `xml:"Version"`
+			IsTruncated         bool        `xml:"IsTruncated"`
+			NextKeyMarker       string      `xml:"NextKeyMarker"`
+			NextVersionIDMarker string      `xml:"NextVersionIdMarker"`
+		}
+		if resp.StatusCode != http.StatusOK {
+			err = s3Error(resp)
+		} else if decodeErr := xml.NewDecoder(resp.Body).Decode(&result); decodeErr != nil {
+			err = errors.Wrap(decodeErr, "failed to parse version list")
+		}
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		versions = append(versions, result.Versions...)
+		if !result.IsTruncated {
+			break
+		}
+		query.Set("key-marker", result.NextKeyMarker)
+		query.Set("version-id-marker", result.NextVersionIDMarker)
+	}
+
+	sort.SliceStable(versions, func(i, j int) bool {
+		if versions[i].IsLatest != versions[j].IsLatest {
+			return versions[i].IsLatest
+		}
+		return versions[i].LastModified.After(versions[j].LastModified)
+	})
+	return versions, nil
+}
+
+// pruneVersions deletes the noncurrent versions beyond maxBackups, leaving
+// kept backups alone. Failures are ignored; a lifecycle rule may be in
+// charge instead.
+func (b *S3Backend) pruneVersions() {
+	backups, err := b.ListBackups()
+	if err != nil {
+		return
+	}
+	for _, old := range prunedBackups(backups) {
+		if resp, err := b.do(http.MethodDelete, url.Values{"versionId": {old.Name}}, nil, nil); err == nil {
+			resp.Body.Close()
+		}
+	}
+}
+
+// do sends a signed request for the vault object.
+func (b *S3Backend) do(method string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	return b.send(method, "/"+b.config.Bucket+"/"+b.config.Key, query, header, body)
+}
+
+// keptPath returns the request path of the kept backup with the given name.
+func (b *S3Backend) keptPath(name string) string {
+	return "/" + b.config.Bucket + "/" + path.Join(path.Dir(b.config.Key), name)
+}
+
+// doBucket sends a signed request for the bucket.
+func (b *S3Backend) doBucket(method string, query url.Values) (*http.Response, error) {
+	return b.send(method, "/"+b.config.Bucket, query, nil, nil)
+}
+
+func (b *S3Backend) send(method, objectPath string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	target := *b.base
+	target.Path = b.base.Path + objectPath
+	target.RawPath = ""
+	target.RawQuery = s3CanonicalQuery(query)
+
+	req, err := http.NewRequest(method, target.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create S3 request")
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	signV4(req, body, b.config.AccessKey, b.config.SecretKey, b.config.Region, b.now())
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "S3 request failed")
+	}
+	if resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusUnauthorized {
+		resp.Body.Close()
+		return nil, errors.ErrBackendAuth
+	}
+	return resp, nil
+}
+
+// signV4 signs req with AWS Signature Version 4, covering the host and all
+// headers set on req.
+func signV4(req *http.Request, body []byte, accessKey, secretKey, region string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format(s3DateFormat)
+	date := amzDate[:8]
+	payloadHash := sha256Hex(body)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	// Canonical headers: lowercase names, sorted, with the host
+	headers := map[string]string{"host": req.URL.Host}
+	for key, values := range req.Header {
+		headers[strings.ToLower(key)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		s3URIEncode(req.URL.Path, false),
+		s3CanonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + region + "/" + s3Service + "/aws4_request"
+	stringToSign := strings.Join([]string{s3SignAlgo, amzDate, scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, s3Service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s3SignAlgo, accessKey, scope, signedHeaders, signature))
+}
+
+// s3CanonicalQuery encodes query sorted by key, as both the request and its
+// signature need it.
+func s3CanonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, key := range keys {
+		values := append([]string(nil), query[key]...)
+		sort.Strings(values)
+		for _, value := range values {
+			parts = append(parts, s3URIEncode(key, true)+"="+s3URIEncode(value, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// s3URIEncode percent-encodes everything but unreserved characters, and
+// slashes unless encodeSlash is set.
+func s3URIEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3Error describes an unexpected response using the S3 error code, if any.
+func s3Error(resp *http.Response) error {
+	var body struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	if resp.Request.Method != http.MethodHead {
+		xml.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&body)
+	}
+	if body.Code != "" {
+		return fmt.Errorf("S3 %s %s: %s: %s", resp.Request.Method, resp.Request.URL.Path, body.Code, body.Message)
+	}
+	return fmt.Errorf("S3 %s %s: unexpected status %s", resp.Request.Method, resp.Request.URL.Path, resp.Status)
+}
@@ -2,6 +2,7 @@ package storage
 
 import (
 	"bytes"
+	"crypto/subtle"
 	"encoding/csv"
 	"encoding/json"
 	"fmt"
@@ -19,21 +20,36 @@ import (
 )
 
 const (
-	vaultFileName = "vault.encrypted" // Encrypted vault filename
+	VaultFileName = "vault.encrypted" // Encrypted vault filename
 	hashLength    = 32                // SHA-256 hash length
 )
 
+// SaveHook is called after the vault file was written, with a short
+// description of the change such as "update account <id>".
+type SaveHook func(operation string) error
+
 // Storage manages vault file operations (read, write, encrypt).
 type Storage struct {
-	vaultPath string       // Path to vault file
-	vault     *model.Vault // In-memory vault data
-	key       []byte       // Encryption key (derived from master password)
-	mu        sync.RWMutex // Mutex for concurrent access
+	dataDir  string       // Local data directory (sync state)
+	backend  Backend      // Where the vault file is kept
+	version  string       // Backend version the in-memory vault is based on
+	vault    *model.Vault // In-memory vault data
+	key      []byte       // Encryption key (derived from master password)
+	saveHook SaveHook     // Optional, e.g. commits the vault file to git
+	mu       sync.RWMutex // Mutex for concurrent access
 }
 
-// New creates a storage instance for the given data directory.
+// New creates a storage instance for the given data directory, with the
+// vault file in that directory.
 // Creates the directory if it doesn't exist.
 func New(dataDir string) (*Storage, error) {
+	return NewWithBackend(dataDir, NewLocalBackend(filepath.Join(dataDir, VaultFileName)))
+}
+
+// NewWithBackend creates a storage instance that keeps the vault file in
+// backend. dataDir holds local state such as sync ancestors.
+// Creates the directory if it doesn't exist.
+func NewWithBackend(dataDir string, backend Backend) (*Storage, error) {
 	if dataDir == "" {
 		return nil, errors.ErrDirectoryRequired
 	}
@@ -41,16 +57,23 @@ func New(dataDir string) (*Storage, error) {
 	if err := os.MkdirAll(dataDir, 0700); err != nil {
 		return nil, errors.Wrap(err, "error creating data directory")
 	}
-	vaultPath := filepath.Join(dataDir, vaultFileName)
 	return &Storage{
-		vaultPath: vaultPath,
-		vault:     &model.Vault{},
+		dataDir: dataDir,
+		backend: backend,
+		vault:   &model.Vault{},
 	}, nil
 }
 
+// SetSaveHook sets a function to call after every write of the vault file.
+func (s *Storage) SetSaveHook(hook SaveHook) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.saveHook = hook
+}
+
 // IsVaultExists checks if the vault file exists.
 func (s *Storage) IsVaultExists() bool {
-	_, err := os.Stat(s.vaultPath)
+	_, err := s.backend.Version()
 	return err == nil
 }
 
@@ -83,10 +106,11 @@ func (s *Storage) CreateVault(masterPassword string) error {
 		Salt:     salt,
 		Accounts: []*model.Account{},
 	}
-	s.key = key // Store key in memory
+	s.key = key    // Store key in memory
+	s.version = "" // The vault must not exist yet
 
 	// Save the new vault to disk
-	return s.saveVault(masterKeyHash)
+	return s.saveVault(masterKeyHash, "create vault")
 }
 
 // UnlockVault unlocks the vault using the master password.
@@ -96,76 +120,124 @@ func (s *Storage) UnlockVault(masterPassword string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if !s.IsVaultExists() {
-		return errors.ErrVaultNotExists
+	// Read vault file
+	fileData, version, err := s.backend.Read()
+	if err != nil {
+		return err
 	}
 
-	// Read vault file
-	fileData, err := os.ReadFile(s.vaultPath)
+	vault, key, err := decryptVaultFile(fileData, masterPassword)
 	if err != nil {
-		return errors.Wrap(err, "error reading vault file")
+		return err
 	}
 
+	// Update in-memory state
+	s.vault = vault
+	s.key = key
+	s.version = version
+
+	// Ensure all accounts have valid sort orders
+	if err := s.ensureAccountSortOrders(); err != nil {
+		return err
+	}
+
+	return nil // Unlocked successfully
+}
+
+// UnlockWithKey unlocks the vault with an encryption key obtained from an
+// earlier unlock, e.g. one cached by the unlock agent, without deriving it
+// from the master password again. Returns errors.ErrInvalidPassword if the
+// key no longer fits the vault, e.g. after a master password change.
+// Requires exclusive lock.
+func (s *Storage) UnlockWithKey(key []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fileData, version, err := s.backend.Read()
+	if err != nil {
+		return err
+	}
+	vault, err := decryptVaultData(fileData, key)
+	if err != nil {
+		return errors.ErrInvalidPassword
+	}
+
+	s.vault = vault
+	s.key = bytes.Clone(key)
+	s.version = version
+	return s.ensureAccountSortOrders()
+}
+
+// decryptVaultFile verifies masterPassword against the contents of a vault
+// file and decrypts it. Returns the vault with its salt set and the key.
+func decryptVaultFile(fileData []byte, masterPassword string) (*model.Vault, []byte, error) {
 	// Basic format check
 	if len(fileData) < hashLength+crypto.SaltLength {
-		return errors.ErrDataCorrupted
+		return nil, nil, errors.ErrDataCorrupted
 	}
 
-	// Extract hash, salt, encrypted data
+	// Extract hash and salt
 	storedHash := fileData[:hashLength]
 	salt := fileData[hashLength : hashLength+crypto.SaltLength]
-	encryptedVaultData := fileData[hashLength+crypto.SaltLength:]
 
 	// Verify master password hash
 	currentHash := crypto.HashPassword(masterPassword, salt)
 	defer crypto.ClearBytes(currentHash)
 	if !bytes.Equal(storedHash, currentHash) {
-		return errors.ErrInvalidPassword
+		return nil, nil, errors.ErrInvalidPassword
 	}
 
 	// Derive decryption key
 	key := crypto.GenerateKey(masterPassword, salt)
 
+	vault, err := decryptVaultData(fileData, key)
+	if err != nil {
+		crypto.ClearBytes(key)
+		return nil, nil, err
+	}
+	return vault, key, nil
+}
+
+// decryptVaultData decrypts the contents of a vault file with key, without
+// checking the password hash. Returns the vault with its salt set.
+func decryptVaultData(fileData []byte, key []byte) (*model.Vault, error) {
+	if len(fileData) < hashLength+crypto.SaltLength {
+		return nil, errors.ErrDataCorrupted
+	}
+	salt := fileData[hashLength : hashLength+crypto.SaltLength]
+	encryptedVaultData := fileData[hashLength+crypto.SaltLength:]
+
 	// Decrypt vault data
 	decryptedData, err := crypto.Decrypt(string(encryptedVaultData), key)
 	if err != nil {
-		crypto.ClearBytes(key)
-		return errors.Wrap(err, "failed to decrypt vault data")
+		return nil, errors.Wrap(err, "failed to decrypt vault data")
 	}
+	defer crypto.ClearBytes(decryptedData)
 
 	// Parse JSON data into Vault struct
 	vault := &model.Vault{}
 	if err := json.Unmarshal(decryptedData, vault); err != nil {
-		crypto.ClearBytes(key)
-		crypto.ClearBytes(decryptedData)
-		return errors.Wrap(err, "failed to unmarshal vault data")
+		return nil, errors.Wrap(err, "failed to unmarshal vault data")
 	}
-	crypto.ClearBytes(decryptedData)
+	vault.Salt = bytes.Clone(salt)
 
-	// Update in-memory state
-	s.vault = vault
-	s.vault.Salt = salt
-	s.key = key
-
-	// Ensure all accounts have valid sort orders
-	if err := s.ensureAccountSortOrders(); err != nil {
-		return err
-	}
-
-	return nil // Unlocked successfully
+	return vault, nil
 }
 
 // saveVault persists the in-memory vault to disk (encrypted).
 // Serializes accounts, encrypts, prepends hash/salt, and writes atomically.
+// operation describes the change for the save hook.
 // Caller must hold the lock. Requires masterKeyHash.
-func (s *Storage) saveVault(masterKeyHash []byte) error {
+func (s *Storage) saveVault(masterKeyHash []byte, operation string) error {
 	// Pre-conditions
 	if s.vault == nil || s.key == nil || len(s.vault.Salt) == 0 || len(masterKeyHash) == 0 {
 		return errors.Wrap(errors.ErrVaultLocked, "cannot save vault, missing state")
 	}
 
-	// Marshal only accounts to JSON
-	tempVault := &model.Vault{Accounts: s.vault.Accounts}
+	s.vault.Tombstones = pruneTombstones(s.vault.Tombstones, time.Now())
+
+	// Marshal only accounts and settings to JSON
+	tempVault := &model.Vault{Accounts: s.vault.Accounts, EmailAliases: s.vault.EmailAliases, Tombstones: s.vault.Tombstones}
 	vaultData, err := json.Marshal(tempVault)
 	if err != nil {
 		return errors.Wrap(err, "failed to marshal vault data")
@@ -181,20 +253,32 @@ func (s *Storage) saveVault(masterKeyHash []byte) error {
 	// Prepare file data: hash | salt | encrypted_data
 	fileData := bytes.Join([][]byte{masterKeyHash, s.vault.Salt, encryptedDataBytes}, nil)
 
-	// Atomic write (write to temp file, then rename)
-	tempFile := s.vaultPath + ".tmp"
-	if err := os.WriteFile(tempFile, fileData, 0600); err != nil {
-		return errors.Wrap(err, "failed to write temporary file")
-	}
-	if err := os.Rename(tempFile, s.vaultPath); err != nil {
-		os.Remove(tempFile) // Clean up temp file on failure
-		return errors.Wrap(err, "failed to rename temporary file")
+	// Optimistic write: fails with a conflict if another writer saved since
+	// the vault was read
+	version, err := s.backend.Write(fileData, s.version)
+	if err != nil {
+		return err
 	}
+	s.version = version
+	s.runSaveHook(operation)
 
 	return nil // Saved successfully
 }
 
-// AddAccount adds a new account to the vault and saves it.
+// runSaveHook runs the save hook, if any. The vault is already saved, so a
+// failing hook is only reported. Caller must hold the lock.
+func (s *Storage) runSaveHook(operation string) {
+	if s.saveHook == nil {
+		return
+	}
+	if err := s.saveHook(operation); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: Failed to record vault change %q: %v\n", operation, err)
+	}
+}
+
+// AddAccount adds a new account to the vault and saves it. An account
+// without a sort order is placed after the last one. If the save fails, the
+// vault is left unchanged.
 // Requires exclusive lock.
 func (s *Storage) AddAccount(account *model.Account) error {
 	s.mu.Lock()
@@ -208,19 +292,66 @@ func (s *Storage) AddAccount(account *model.Account) error {
 	now := time.Now()
 	account.CreatedAt = now
 	account.UpdatedAt = now
+	account.Revision = 1
+	if account.SortOrder == 0 {
+		for _, existing := range s.vault.Accounts {
+			account.SortOrder = max(account.SortOrder, existing.SortOrder)
+		}
+		account.SortOrder++
+	}
 
 	// Append account
 	s.vault.Accounts = append(s.vault.Accounts, account)
 
 	// Get current hash for saving
+	hash, err := s.getMasterKeyHashForSave()
+	if err == nil {
+		defer crypto.ClearBytes(hash)
+		err = s.saveVault(hash, "add account "+account.ID)
+	}
+	if err != nil {
+		s.vault.Accounts = s.vault.Accounts[:len(s.vault.Accounts)-1]
+	}
+	return err
+}
+
+// AddAccounts adds several accounts with a single save, appending them after
+// the current sort order. Zero timestamps are set to now. Used by bulk imports.
+// Requires exclusive lock.
+func (s *Storage) AddAccounts(accounts []*model.Account) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkVaultUnlocked(); err != nil {
+		return err
+	}
+
+	maxOrder := 0
+	for _, account := range s.vault.Accounts {
+		maxOrder = max(maxOrder, account.SortOrder)
+	}
+
+	// Keep timestamps carried over from the source, if any
+	now := time.Now()
+	for i, account := range accounts {
+		if account.CreatedAt.IsZero() {
+			account.CreatedAt = now
+		}
+		if account.UpdatedAt.IsZero() {
+			account.UpdatedAt = account.CreatedAt
+		}
+		account.Revision = max(account.Revision, 1)
+		account.SortOrder = maxOrder + i + 1
+	}
+	s.vault.Accounts = append(s.vault.Accounts, accounts...)
+
 	hash, err := s.getMasterKeyHashForSave()
 	if err != nil {
 		return err
 	}
 	defer crypto.ClearBytes(hash)
 
-	// Save updated vault
-	return s.saveVault(hash)
+	return s.saveVault(hash, fmt.Sprintf("add %d accounts", len(accounts)))
 }
 
 // GetAccounts retrieves all accounts from the unlocked vault.
@@ -268,6 +399,8 @@ func (s *Storage) GetAccountByID(id string) (*model.Account, error) {
 }
 
 // UpdateAccount updates an existing account in the vault and saves it.
+// account replaces the stored one; if the save fails, the stored one is put
+// back.
 // Requires exclusive lock.
 func (s *Storage) UpdateAccount(account *model.Account) error {
 	s.mu.Lock()
@@ -277,31 +410,27 @@ func (s *Storage) UpdateAccount(account *model.Account) error {
 		return err
 	}
 
-	found := false
 	// Find and replace account
-	for i, acc := range s.vault.Accounts {
-		if acc.ID == account.ID {
-			account.CreatedAt = acc.CreatedAt // Preserve original creation time
-			account.UpdatedAt = time.Now()    // Update modification time
-			s.vault.Accounts[i] = account
-			found = true
-			break
-		}
-	}
-
-	if !found {
+	index := slices.IndexFunc(s.vault.Accounts, func(acc *model.Account) bool { return acc.ID == account.ID })
+	if index < 0 {
 		return errors.Wrap(errors.ErrAccountNotFound, fmt.Sprintf("account ID %s not found for update", account.ID))
 	}
+	previous := s.vault.Accounts[index]
+	account.CreatedAt = previous.CreatedAt // Preserve original creation time
+	account.UpdatedAt = time.Now()         // Update modification time
+	account.Revision = previous.Revision + 1
+	s.vault.Accounts[index] = account
 
 	// Get current hash for saving
 	hash, err := s.getMasterKeyHashForSave()
+	if err == nil {
+		defer crypto.ClearBytes(hash)
+		err = s.saveVault(hash, "update account "+account.ID)
+	}
 	if err != nil {
-		return err
+		s.vault.Accounts[index] = previous
 	}
-	defer crypto.ClearBytes(hash)
-
-	// Save updated vault
-	return s.saveVault(hash)
+	return err
 }
 
 // DeleteAccount removes an account by ID from the vault and saves it.
@@ -319,6 +448,7 @@ func (s *Storage) DeleteAccount(id string) error {
 	for i, account := range s.vault.Accounts {
 		if account.ID == id {
 			s.vault.Accounts = slices.Delete(s.vault.Accounts, i, i+1)
+			s.vault.Tombstones = append(s.vault.Tombstones, model.Tombstone{ID: id, DeletedAt: time.Now(), Revision: account.Revision})
 			found = true
 			break
 		}
@@ -336,7 +466,7 @@ func (s *Storage) DeleteAccount(id string) error {
 	defer crypto.ClearBytes(hash)
 
 	// Save updated vault
-	return s.saveVault(hash)
+	return s.saveVault(hash, "delete account "+id)
 }
 
 // GetEncryptionKey retrieves the current encryption key (nil if locked).
@@ -353,14 +483,10 @@ func (s *Storage) ExportVault(exportPath string) error {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	if !s.IsVaultExists() {
-		return errors.ErrVaultNotExists
-	}
-
 	// Read current vault file
-	data, err := os.ReadFile(s.vaultPath)
+	data, _, err := s.backend.Read()
 	if err != nil {
-		return errors.Wrap(err, "failed to read vault file for export")
+		return err
 	}
 
 	// Write data to export path (0600 permissions)
@@ -368,84 +494,125 @@ func (s *Storage) ExportVault(exportPath string) error {
 }
 
 // ImportVault replaces the current vault with one from importPath.
-// Backs up the existing vault first. Resets in-memory state (locks vault).
+// The existing vault is kept as a backup that newer backups do not push
+// out. Resets in-memory state
+// (locks vault). Portable exports are rejected; they are merged with
+// ImportPortable.
 // Requires exclusive lock.
 func (s *Storage) ImportVault(importPath string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	// Backup existing vault
-	backupPath := s.vaultPath + ".bak"
-	if s.IsVaultExists() {
-		if err := os.Rename(s.vaultPath, backupPath); err != nil {
-			fmt.Fprintf(os.Stderr, "Warning: Failed to backup existing vault: %v\n", err)
-		} else {
-			fmt.Printf("Existing vault backed up to %s\n", backupPath)
-		}
-	}
-
 	// Read import file
 	data, err := os.ReadFile(importPath)
 	if err != nil {
-		// Try to restore backup on read failure
-		if _, backupErr := os.Stat(backupPath); backupErr == nil {
-			os.Rename(backupPath, s.vaultPath)
-		}
 		return errors.Wrap(err, "failed to read import file")
 	}
 
+	// Portable exports are merged with ImportPortable, never copied over the vault
+	if isPortableData(data) {
+		return errors.Wrap(errors.ErrImportFormat, "file is a portable export, use ImportPortable to merge it")
+	}
+
 	// Basic format validation (check minimum size)
 	// This is a simplified check; a full validation would require attempting decryption.
 	if len(data) <= hashLength+crypto.SaltLength {
-		if _, backupErr := os.Stat(backupPath); backupErr == nil {
-			os.Rename(backupPath, s.vaultPath)
-		}
 		return errors.Wrap(errors.ErrDataCorrupted, "import file format invalid or too short")
 	}
 
-	// Atomic write for import
-	tempFile := s.vaultPath + ".tmp"
-	if err := os.WriteFile(tempFile, data, 0600); err != nil {
-		if _, backupErr := os.Stat(backupPath); backupErr == nil {
-			os.Rename(backupPath, s.vaultPath)
-		}
-		return errors.Wrap(err, "failed to write imported data to temporary file")
-	}
-	if err := os.Rename(tempFile, s.vaultPath); err != nil {
-		os.Remove(tempFile)
-		if _, backupErr := os.Stat(backupPath); backupErr == nil {
-			os.Rename(backupPath, s.vaultPath)
-		}
+	// Replace whatever version is stored now
+	version, err := s.replaceVault(data)
+	if err != nil {
 		return errors.Wrap(err, "failed to finalize import operation")
 	}
+	if version != "" {
+		fmt.Println("Existing vault kept as a backup.")
+	}
+	s.runSaveHook("import vault")
 
-	// Remove the call to s.ensureAccountSortOrders() from the ImportVault function.
-	// The sort order migration for the imported accounts will naturally occur the next time
-	// the user successfully unlocks this imported vault.
-
-	// // Ensure all imported accounts have valid sort orders
-	// if err := s.ensureAccountSortOrders(); err != nil {
-	// 	return errors.Wrap(err, "failed to ensure account sort orders")
-	// }
+	// The sort order migration for the imported accounts will naturally occur
+	// the next time the user successfully unlocks this imported vault.
 
 	// Reset in-memory state (lock)
 	s.vault = nil
 	s.key = nil
+	s.version = ""
 
 	fmt.Println("Vault imported successfully. Unlock with its original master password.")
 	return nil
 }
 
+// ListBackups returns the earlier versions of the vault file kept by the
+// backend, newest first. Works while the vault is locked.
+func (s *Storage) ListBackups() ([]Backup, error) {
+	return s.backend.ListBackups()
+}
+
+// RestoreBackup replaces the current vault with the backup name. The
+// replaced vault is kept as a backup that newer backups do not push out, so
+// a restore can be undone.
+// Resets in-memory state (locks vault); the restored vault is unlocked with
+// the master password it was saved with.
+// Requires exclusive lock.
+func (s *Storage) RestoreBackup(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := s.backend.ReadBackup(name)
+	if err != nil {
+		return err
+	}
+	if len(data) <= hashLength+crypto.SaltLength {
+		return errors.Wrap(errors.ErrDataCorrupted, "backup format invalid or too short")
+	}
+
+	if _, err := s.replaceVault(data); err != nil {
+		return errors.Wrap(err, "failed to restore backup")
+	}
+	s.runSaveHook("restore backup " + name)
+
+	// Reset in-memory state (lock)
+	s.vault = nil
+	s.key = nil
+	s.version = ""
+	return nil
+}
+
+// replaceVault writes data over the stored vault, keeping the stored vault
+// as a kept backup first. Returns the replaced version, "" if there was no
+// vault. Caller must hold the lock.
+func (s *Storage) replaceVault(data []byte) (string, error) {
+	current, version, err := s.backend.Read()
+	if err != nil && !errors.Is(err, errors.ErrVaultNotExists) {
+		return "", err
+	}
+	if current != nil {
+		if err := s.backend.KeepBackup(current); err != nil {
+			return "", err
+		}
+	}
+	if _, err := s.backend.Write(data, version); err != nil {
+		return "", err
+	}
+	return version, nil
+}
+
 // ChangeMasterPassword changes the master password for the unlocked vault.
 // Generates new salt/hash/key, re-encrypts all account passwords, and saves.
+// Derived passwords are computed from currentPassword and stored, since the
+// new master password would derive different ones. Returns
+// errors.ErrInvalidPassword if currentPassword is wrong.
 // Requires exclusive lock.
-func (s *Storage) ChangeMasterPassword(newPassword string) error {
+func (s *Storage) ChangeMasterPassword(currentPassword, newPassword string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	if s.vault == nil || s.key == nil {
 		return errors.ErrVaultLocked
 	}
+	if err := s.checkMasterPassword(currentPassword); err != nil {
+		return err
+	}
 
 	// Backup current vault data
 	oldKey := make([]byte, len(s.key))
@@ -465,34 +632,28 @@ func (s *Storage) ChangeMasterPassword(newPassword string) error {
 	// Derive new key
 	newKey := crypto.GenerateKey(newPassword, newSalt)
 
-	// Re-encrypt all account passwords with the new key
-	for _, account := range s.vault.Accounts {
-		// Decrypt old password
-		decryptedPassword, err := crypto.Decrypt(account.EncryptedPassword, oldKey)
-		if err != nil {
-			crypto.ClearBytes(newKey)
-			return errors.Wrap(err, fmt.Sprintf("failed to decrypt password for account ID %s", account.ID))
+	// Re-encrypt all account passwords, protected fields and history with the new key
+	accounts := make([]*model.Account, len(s.vault.Accounts))
+	for i, account := range s.vault.Accounts {
+		if account.Derived != nil {
+			if account, err = materializeDerived(account, currentPassword, oldKey); err != nil {
+				crypto.ClearBytes(newKey)
+				return err
+			}
 		}
-
-		// Encrypt with new key
-		encryptedPassword, err := crypto.Encrypt(decryptedPassword, newKey)
-		if err != nil {
-			crypto.ClearBytes(decryptedPassword)
+		if accounts[i], err = rekeyAccount(account, oldKey, newKey); err != nil {
 			crypto.ClearBytes(newKey)
-			return errors.Wrap(err, fmt.Sprintf("failed to re-encrypt password for account ID %s", account.ID))
+			return err
 		}
-		crypto.ClearBytes(decryptedPassword)
-
-		// Update account with new encrypted password
-		account.EncryptedPassword = encryptedPassword
 	}
 
-	// Update in-memory state with new salt and key
+	// Update in-memory state with new accounts, salt and key
+	s.vault.Accounts = accounts
 	s.vault.Salt = newSalt
 	s.key = newKey
 
 	// Save vault (will use new key for encryption and write new hash/salt)
-	err = s.saveVault(newMasterKeyHash)
+	err = s.saveVault(newMasterKeyHash, "change master password")
 	if err != nil {
 		crypto.ClearBytes(newKey)
 		// Note: In-memory state might be inconsistent if save fails.
@@ -502,15 +663,98 @@ func (s *Storage) ChangeMasterPassword(newPassword string) error {
 	return nil // Password changed successfully
 }
 
-// ExportToCSV exports decrypted vault data to a CSV file.
+// checkMasterPassword returns errors.ErrInvalidPassword unless password is
+// the master password of the unlocked vault. Caller must hold the lock.
+func (s *Storage) checkMasterPassword(password string) error {
+	key := crypto.GenerateKey(password, s.vault.Salt)
+	defer crypto.ClearBytes(key)
+	if subtle.ConstantTimeCompare(key, s.key) != 1 {
+		return errors.ErrInvalidPassword
+	}
+	return nil
+}
+
+// HasDerivedAccounts reports whether the unlocked vault has derived accounts,
+// whose passwords can only be computed with the master password.
+// Requires read lock.
+func (s *Storage) HasDerivedAccounts() (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if err := s.checkVaultUnlocked(); err != nil {
+		return false, err
+	}
+	return slices.ContainsFunc(s.vault.Accounts, func(account *model.Account) bool { return account.Derived != nil }), nil
+}
+
+// rekeyAccount returns a copy of account with its password, protected fields
+// and history re-encrypted from oldKey to newKey. The original is not modified.
+func rekeyAccount(account *model.Account, oldKey, newKey []byte) (*model.Account, error) {
+	rekeyed := *account
+	rekeyed.Tags = slices.Clone(account.Tags)
+	rekeyed.CustomFields = slices.Clone(account.CustomFields)
+	rekeyed.History = slices.Clone(account.History)
+
+	// Derived passwords are not stored
+	if account.Derived == nil {
+		encryptedPassword, err := reencrypt(account.EncryptedPassword, oldKey, newKey)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("failed to re-encrypt password for account ID %s", account.ID))
+		}
+		rekeyed.EncryptedPassword = encryptedPassword
+	}
+
+	for i, field := range rekeyed.CustomFields {
+		if !field.Protected {
+			continue
+		}
+		value, err := reencrypt(field.Value, oldKey, newKey)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("failed to re-encrypt field %q for account ID %s", field.Name, account.ID))
+		}
+		rekeyed.CustomFields[i].Value = value
+	}
+
+	for i, entry := range rekeyed.History {
+		if entry.EncryptedPassword == "" {
+			continue
+		}
+		encryptedPassword, err := reencrypt(entry.EncryptedPassword, oldKey, newKey)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("failed to re-encrypt history for account ID %s", account.ID))
+		}
+		rekeyed.History[i].EncryptedPassword = encryptedPassword
+	}
+
+	return &rekeyed, nil
+}
+
+// reencrypt decrypts ciphertext with oldKey and encrypts it again with newKey.
+func reencrypt(ciphertext string, oldKey, newKey []byte) (string, error) {
+	plaintext, err := crypto.Decrypt(ciphertext, oldKey)
+	if err != nil {
+		return "", err
+	}
+	defer crypto.ClearBytes(plaintext)
+	return crypto.Encrypt(plaintext, newKey)
+}
+
+// ExportToCSV exports decrypted vault data to a CSV file. Derived passwords
+// are computed from masterPassword, which is only needed, and checked, if
+// the vault has derived accounts.
 // WARNING: Insecure. Requires read lock.
-func (s *Storage) ExportToCSV(exportPath string) error {
+func (s *Storage) ExportToCSV(exportPath, masterPassword string) error {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
 	if s.vault == nil || s.key == nil {
 		return errors.ErrVaultLocked
 	}
+	if slices.ContainsFunc(s.vault.Accounts, func(account *model.Account) bool { return account.Derived != nil }) {
+		if err := s.checkMasterPassword(masterPassword); err != nil {
+			return err
+		}
+	}
 
 	fmt.Println("WARNING: Exporting passwords to CSV is insecure.")
 
@@ -538,9 +782,13 @@ func (s *Storage) ExportToCSV(exportPath string) error {
 
 	// Write account records
 	for _, account := range s.vault.Accounts {
-		// Decrypt password
-		decryptedPassword, err := crypto.Decrypt(account.EncryptedPassword, s.key)
-		if err != nil {
+		// Decrypt password, or compute it for derived accounts
+		var decryptedPassword []byte
+		if account.Derived != nil {
+			if decryptedPassword, err = derivePassword(account, masterPassword); err != nil {
+				return err
+			}
+		} else if decryptedPassword, err = crypto.Decrypt(account.EncryptedPassword, s.key); err != nil {
 			return errors.Wrap(err, fmt.Sprintf("failed to decrypt password for account ID %s", account.ID))
 		}
 
@@ -563,6 +811,40 @@ func (s *Storage) ExportToCSV(exportPath string) error {
 	return nil // Export successful
 }
 
+// GetEmailAliases returns the email alias templates stored in the vault.
+// Requires read lock.
+func (s *Storage) GetEmailAliases() ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if err := s.checkVaultUnlocked(); err != nil {
+		return nil, err
+	}
+	return slices.Clone(s.vault.EmailAliases), nil
+}
+
+// SetEmailAliases replaces the email alias templates and saves the vault.
+// Templates are not validated here; see generator.ValidateEmailAliasTemplate.
+// Requires exclusive lock.
+func (s *Storage) SetEmailAliases(templates []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkVaultUnlocked(); err != nil {
+		return err
+	}
+
+	s.vault.EmailAliases = slices.Clone(templates)
+
+	hash, err := s.getMasterKeyHashForSave()
+	if err != nil {
+		return err
+	}
+	defer crypto.ClearBytes(hash)
+
+	return s.saveVault(hash, "update email aliases")
+}
+
 // SearchAccounts searches unlocked accounts by query (case-insensitive).
 // Checks Platform, Username, Email, URL, Notes. Returns all if query is empty.
 // Requires read lock.
@@ -588,7 +870,8 @@ func (s *Storage) SearchAccounts(query string) ([]*model.Account, error) {
 			strings.Contains(strings.ToLower(account.Username), query) ||
 			strings.Contains(strings.ToLower(account.Email), query) ||
 			strings.Contains(strings.ToLower(account.URL), query) ||
-			strings.Contains(strings.ToLower(account.Notes), query) {
+			strings.Contains(strings.ToLower(account.Notes), query) ||
+			slices.ContainsFunc(account.Tags, func(tag string) bool { return strings.Contains(strings.ToLower(tag), query) }) {
 			results = append(results, account)
 		}
 	}
@@ -610,7 +893,7 @@ func (s *Storage) getMasterKeyHashForSave() ([]byte, error) {
 	}
 
 	// Read raw vault file
-	fileData, err := os.ReadFile(s.vaultPath)
+	fileData, _, err := s.backend.Read()
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to read vault file for hash")
 	}
@@ -666,7 +949,7 @@ func (s *Storage) ensureAccountSortOrders() error {
 		defer crypto.ClearBytes(hash)
 
 		// Save updated vault with new sort orders
-		return s.saveVault(hash)
+		return s.saveVault(hash, "assign sort orders")
 	}
 
 	return nil
@@ -75,8 +75,8 @@ func TestNew(t *testing.T) {
 		// Verify storage instance was created correctly
 		assert.NotNil(t, s, "New should not return a nil Storage instance")
 
-		expectedPath := filepath.Join(tmpDir, vaultFileName)
-		assert.Equal(t, expectedPath, s.vaultPath, "Incorrect vault path")
+		expectedPath := filepath.Join(tmpDir, VaultFileName)
+		assert.Equal(t, expectedPath, s.backend.(*LocalBackend).path, "Incorrect vault path")
 
 		// Verify directory was actually created
 		_, err := os.Stat(tmpDir)
@@ -376,7 +376,7 @@ func TestChangeMasterPassword(t *testing.T) {
 		setupVaultWithAccounts(t, s, oldPassword, 3)
 
 		// Change master password
-		err := s.ChangeMasterPassword(newPassword)
+		err := s.ChangeMasterPassword(oldPassword, newPassword)
 		assert.NoError(t, err, "Failed to change master password")
 
 		// Lock vault and reset storage state
@@ -405,12 +405,86 @@ func TestChangeMasterPassword(t *testing.T) {
 		}
 	})
 
+	t.Run("WithDerivedAccount", func(t *testing.T) {
+		s, tmpDir := setupTestStorage(t)
+		defer cleanupTestStorage(tmpDir)
+
+		setupVaultWithAccounts(t, s, "old-password", 1)
+
+		derived := &model.Account{
+			ID:       "derived-account",
+			Platform: "example.org",
+			Derived:  &model.Derived{Site: "example.org", Counter: 1, Length: 16, Lowercase: true},
+		}
+		assert.NoError(t, s.AddAccount(derived), "Failed to add derived account")
+		before, err := derivePassword(derived, "old-password")
+		require.NoError(t, err)
+
+		err = s.ChangeMasterPassword("old-password", "new-password")
+		assert.NoError(t, err, "Failed to change master password with a derived account")
+
+		// The new master password would derive another password, so the current one is stored
+		account, err := s.GetAccountByID("derived-account")
+		assert.NoError(t, err, "Failed to get derived account")
+		assert.Nil(t, account.Derived, "Derived account should become an ordinary account")
+		decrypted, err := crypto.Decrypt(account.EncryptedPassword, s.GetEncryptionKey())
+		require.NoError(t, err)
+		assert.Equal(t, string(before), string(decrypted), "The password must not change with the master password")
+	})
+
+	t.Run("WrongCurrentPassword", func(t *testing.T) {
+		s, tmpDir := setupTestStorage(t)
+		defer cleanupTestStorage(tmpDir)
+
+		setupVaultWithAccounts(t, s, "old-password", 1)
+		err := s.ChangeMasterPassword("not-the-password", "new-password")
+		assert.ErrorIs(t, err, errors.ErrInvalidPassword)
+		assert.NoError(t, s.UnlockVault("old-password"), "The master password is unchanged")
+	})
+
+	t.Run("WithFieldsAndHistory", func(t *testing.T) {
+		s, tmpDir := setupTestStorage(t)
+		defer cleanupTestStorage(tmpDir)
+
+		setupVaultWithAccounts(t, s, "old-password", 0)
+		encrypt := func(value string) string {
+			encrypted, err := crypto.Encrypt([]byte(value), s.GetEncryptionKey())
+			assert.NoError(t, err, "Failed to encrypt value")
+			return encrypted
+		}
+
+		account := &model.Account{
+			ID:                "with-history",
+			Platform:          "example.org",
+			EncryptedPassword: encrypt("current"),
+			CustomFields: []model.CustomField{
+				{Name: "PIN", Value: encrypt("1234"), Protected: true},
+				{Name: "Plan", Value: "family"},
+			},
+			History: []model.HistoryEntry{{Username: "old", EncryptedPassword: encrypt("previous")}},
+		}
+		assert.NoError(t, s.AddAccount(account), "Failed to add account")
+		assert.NoError(t, s.ChangeMasterPassword("old-password", "new-password"), "Failed to change master password")
+
+		decrypt := func(value string) string {
+			decrypted, err := crypto.Decrypt(value, s.GetEncryptionKey())
+			assert.NoError(t, err, "Failed to decrypt value with the new key")
+			return string(decrypted)
+		}
+		stored, err := s.GetAccountByID("with-history")
+		assert.NoError(t, err, "Failed to get account")
+		assert.Equal(t, "current", decrypt(stored.EncryptedPassword))
+		assert.Equal(t, "1234", decrypt(stored.CustomFields[0].Value), "Protected field should be re-encrypted")
+		assert.Equal(t, "family", stored.CustomFields[1].Value, "Plain field should be unchanged")
+		assert.Equal(t, "previous", decrypt(stored.History[0].EncryptedPassword), "History should be re-encrypted")
+	})
+
 	t.Run("WithLockedVault", func(t *testing.T) {
 		s, tmpDir := setupTestStorage(t)
 		defer cleanupTestStorage(tmpDir)
 
 		// Without unlocking vault, change password should fail
-		err := s.ChangeMasterPassword("new-password")
+		err := s.ChangeMasterPassword("old-password", "new-password")
 		assert.ErrorIs(t, err, errors.ErrVaultLocked, "Should return ErrVaultLocked when vault is not unlocked")
 	})
 }
@@ -504,8 +578,8 @@ func TestExportToCSV(t *testing.T) {
 
 		// Export to CSV
 		csvPath := filepath.Join(tmpDir, "export.csv")
-		err := s.ExportToCSV(csvPath)
-		assert.NoError(t, err, "CSV export failed")
+		err := s.ExportToCSV(csvPath, "")
+		assert.NoError(t, err, "CSV export failed without derived accounts")
 
 		// Verify file exists and is not empty
 		fileInfo, err := os.Stat(csvPath)
@@ -518,13 +592,38 @@ func TestExportToCSV(t *testing.T) {
 		assert.Contains(t, string(content), "ID,Platform,Username,Email,Password", "CSV header missing")
 	})
 
+	t.Run("ExportDerived", func(t *testing.T) {
+		s, tmpDir := setupTestStorage(t)
+		defer cleanupTestStorage(tmpDir)
+
+		setupVaultWithAccounts(t, s, "test-password", 0)
+		derived := &model.Account{
+			ID:       "derived-account",
+			Platform: "example.org",
+			Derived:  &model.Derived{Site: "example.org", Counter: 1, Length: 16, Lowercase: true, Digits: true},
+		}
+		require.NoError(t, s.AddAccount(derived))
+		password, err := derivePassword(derived, "test-password")
+		require.NoError(t, err)
+
+		csvPath := filepath.Join(tmpDir, "export.csv")
+		assert.ErrorIs(t, s.ExportToCSV(csvPath, "wrong-password"), errors.ErrInvalidPassword)
+		_, err = os.Stat(csvPath)
+		assert.True(t, os.IsNotExist(err), "Nothing is written with a wrong master password")
+
+		require.NoError(t, s.ExportToCSV(csvPath, "test-password"))
+		content, err := os.ReadFile(csvPath)
+		require.NoError(t, err)
+		assert.Contains(t, string(content), ","+string(password)+",", "Derived passwords are exported, not left empty")
+	})
+
 	t.Run("ExportWithLockedVault", func(t *testing.T) {
 		s, tmpDir := setupTestStorage(t)
 		defer cleanupTestStorage(tmpDir)
 
 		// Try to export without unlocking vault
 		csvPath := filepath.Join(tmpDir, "locked-export.csv")
-		err := s.ExportToCSV(csvPath)
+		err := s.ExportToCSV(csvPath, "")
 		assert.ErrorIs(t, err, errors.ErrVaultLocked, "Should fail when vault is locked")
 	})
 }
@@ -549,3 +648,49 @@ func TestGetEncryptionKey(t *testing.T) {
 	assert.NotNil(t, key, "Key should be available after unlock")
 	assert.Len(t, key, crypto.KeyLength, "Key should have correct length")
 }
+
+func TestUnlockWithKey(t *testing.T) {
+	s, tmpDir := setupTestStorage(t)
+	defer cleanupTestStorage(tmpDir)
+	setupVaultWithAccounts(t, s, "test-password", 2)
+	key := append([]byte(nil), s.GetEncryptionKey()...)
+
+	// A second instance, as another process would be, unlocks with the key alone
+	other, err := New(tmpDir)
+	require.NoError(t, err)
+	require.NoError(t, other.UnlockWithKey(key))
+	accounts, err := other.GetAccounts()
+	require.NoError(t, err)
+	assert.Len(t, accounts, 2)
+	require.NoError(t, other.DeleteAccount("test-id-0"), "Saving works without the master password")
+
+	require.NoError(t, s.UnlockVault("test-password"))
+	require.NoError(t, s.ChangeMasterPassword("test-password", "new-password"))
+	assert.ErrorIs(t, other.UnlockWithKey(key), errors.ErrInvalidPassword, "The old key no longer fits")
+}
+
+func TestEmailAliases(t *testing.T) {
+	s, tmpDir := setupTestStorage(t)
+	defer cleanupTestStorage(tmpDir)
+
+	_, err := s.GetEmailAliases()
+	assert.ErrorIs(t, err, errors.ErrVaultLocked, "Should fail when vault is locked")
+
+	setupVaultWithAccounts(t, s, "test-password", 1)
+
+	templates := []string{"team+{tag}-{rand}@example.com", "{tag}@catchall.example.com"}
+	require.NoError(t, s.SetEmailAliases(templates), "Failed to set email aliases")
+
+	// Aliases must survive a reload from disk
+	s.vault = nil
+	s.key = nil
+	require.NoError(t, s.UnlockVault("test-password"), "Failed to unlock vault")
+
+	aliases, err := s.GetEmailAliases()
+	assert.NoError(t, err, "Failed to get email aliases")
+	assert.Equal(t, templates, aliases, "Email aliases mismatch after reload")
+
+	accounts, err := s.GetAccounts()
+	assert.NoError(t, err, "Failed to get accounts")
+	assert.Len(t, accounts, 1, "Accounts should be preserved")
+}
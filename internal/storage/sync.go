@@ -0,0 +1,641 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/simp-lee/passwordmanager/internal/crypto"
+	"github.com/simp-lee/passwordmanager/internal/errors"
+	"github.com/simp-lee/passwordmanager/internal/model"
+)
+
+const syncDirName = "sync" // Holds the last synced state per remote, inside the data directory
+
+// tombstoneLifetime is how long the deletion of an account is remembered.
+// A replica that was not synced for longer can bring the account back.
+const tombstoneLifetime = 180 * 24 * time.Hour
+
+// maxSyncAttempts bounds how often Sync merges again when the remote replica
+// changes while it is being merged.
+const maxSyncAttempts = 3
+
+// Replica names used in SyncConflict.Winner.
+const (
+	SyncLocal  = "local"
+	SyncRemote = "remote"
+)
+
+// SyncConflict is a field that was changed differently on both replicas
+// since their last sync. The replica whose account has the higher revision
+// wins; on equal revisions the one updated last does.
+type SyncConflict struct {
+	AccountID string
+	Platform  string
+	Field     string // Field name as in diffAccounts, or "deleted"
+	Winner    string // SyncLocal or SyncRemote
+}
+
+// SyncResult summarises the changes a sync brought into the local vault.
+type SyncResult struct {
+	Added     int  // Accounts added from the remote replica
+	Updated   int  // Local accounts changed by the remote replica
+	Deleted   int  // Local accounts deleted on the remote replica
+	FirstSync bool // No common ancestor was available
+	Conflicts []SyncConflict
+}
+
+// Sync merges the vault file at remotePath, a replica of this vault, with the
+// unlocked vault and writes the result to both. Changes are merged per field
+// against the state of their last sync, which is kept in the data directory;
+// fields changed on both sides go to the replica with the higher account
+// revision. Deletions are carried by tombstones, which expire after
+// tombstoneLifetime. If remotePath does not exist, it is created. If the
+// remote replica changes while it is merged, Sync merges again, up to
+// maxSyncAttempts times before it gives up with errors.ErrVersionConflict.
+// Returns errors.ErrRemotePassword if the remote uses a different master
+// password; see SyncWithPassword. Requires exclusive lock.
+func (s *Storage) Sync(remotePath string) (*SyncResult, error) {
+	return s.sync(remotePath, "")
+}
+
+// SyncWithPassword is Sync for a remote replica whose master password was
+// changed. The merged vault is written with the local master password.
+func (s *Storage) SyncWithPassword(remotePath, remotePassword string) (*SyncResult, error) {
+	return s.sync(remotePath, remotePassword)
+}
+
+func (s *Storage) sync(remotePath, remotePassword string) (*SyncResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkVaultUnlocked(); err != nil {
+		return nil, err
+	}
+
+	total := &SyncResult{}
+	for attempt := 1; ; attempt++ {
+		remoteData, err := os.ReadFile(remotePath)
+		if os.IsNotExist(err) {
+			// Nothing to merge yet; publish this vault as the first replica
+			total.FirstSync = true
+			err = s.publishSync(remotePath, nil)
+		} else {
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to read remote vault")
+			}
+			var result *SyncResult
+			if result, err = s.mergeRemote(remotePath, remoteData, remotePassword); err != nil {
+				return nil, err
+			}
+			total.add(result, attempt == 1)
+			err = s.publishSync(remotePath, remoteData)
+		}
+		if errors.Is(err, errors.ErrVersionConflict) && attempt < maxSyncAttempts {
+			// Another replica synced meanwhile; merge its changes too
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return total, nil
+	}
+}
+
+// mergeRemote merges the replica remoteData read from remotePath into the
+// unlocked vault and saves it. Caller must hold the lock.
+func (s *Storage) mergeRemote(remotePath string, remoteData []byte, remotePassword string) (*SyncResult, error) {
+	remote, err := s.openRemote(remoteData, remotePassword)
+	if err != nil {
+		return nil, err
+	}
+
+	// A missing or unreadable ancestor (e.g. after a password change) only
+	// makes the merge less precise
+	var ancestor *model.Vault
+	if ancestorData, err := os.ReadFile(s.ancestorPath(remotePath)); err == nil && s.sameKey(ancestorData) {
+		ancestor, _ = decryptVaultData(ancestorData, s.key)
+	}
+	return s.mergeAndSave(remote, ancestor, "sync with "+remotePath)
+}
+
+// add counts the changes of another merge into r. first tells whether it was
+// the first merge of the sync, which decides FirstSync.
+func (r *SyncResult) add(other *SyncResult, first bool) {
+	r.Added += other.Added
+	r.Updated += other.Updated
+	r.Deleted += other.Deleted
+	r.Conflicts = append(r.Conflicts, other.Conflicts...)
+	if first {
+		r.FirstSync = other.FirstSync
+	}
+}
+
+// MergeVaultData merges the contents of another replica's vault file with
+// the unlocked vault and saves the result, as Sync does. ancestorData is the
+// state both replicas started from, or nil if unknown. remotePassword is only
+// needed if the replica uses a different master password. operation
+// describes the change for the save hook. Requires exclusive lock.
+func (s *Storage) MergeVaultData(remoteData, ancestorData []byte, remotePassword, operation string) (*SyncResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkVaultUnlocked(); err != nil {
+		return nil, err
+	}
+
+	remote, err := s.openRemote(remoteData, remotePassword)
+	if err != nil {
+		return nil, err
+	}
+	var ancestor *model.Vault
+	if ancestorData != nil {
+		if ancestor, err = s.openRemote(ancestorData, remotePassword); err != nil {
+			ancestor = nil // Written with yet another password; merge without it
+		}
+	}
+	return s.mergeAndSave(remote, ancestor, operation)
+}
+
+// RevertVaultData undoes the change between two earlier states of the vault
+// file, before and after, while keeping later changes: accounts added by
+// the change are deleted, deleted ones come back and changed fields get
+// their earlier value unless they were changed again since. password is only
+// needed if the states use a different master password. Requires exclusive
+// lock.
+func (s *Storage) RevertVaultData(before, after []byte, password, operation string) (*SyncResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkVaultUnlocked(); err != nil {
+		return nil, err
+	}
+
+	// Merging "before" into the vault with "after" as the ancestor applies
+	// the reverse of the change
+	target, err := s.openRemote(before, password)
+	if err != nil {
+		return nil, err
+	}
+	changed, err := s.openRemote(after, password)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the difference between the two states counts: tombstones of
+	// accounts deleted by the change must not keep them deleted, and older
+	// tombstones must not delete accounts restored since
+	restored := indexAccounts(target.Accounts)
+	changedByID := indexAccounts(changed.Accounts)
+	current := *s.vault
+	current.Tombstones = slices.DeleteFunc(slices.Clone(s.vault.Tombstones), func(t model.Tombstone) bool {
+		return restored[t.ID] != nil && changedByID[t.ID] == nil
+	})
+	target.Tombstones = nil
+
+	merged, result := mergeReplicas(&current, target, changed, s.key)
+
+	now := time.Now()
+	existing := indexAccounts(s.vault.Accounts)
+	deleted := indexTombstones(s.vault.Tombstones)
+	kept := indexAccounts(merged.Accounts)
+	for _, account := range merged.Accounts {
+		if existing[account.ID] == nil {
+			// Restored accounts are newer than their deletion on other replicas
+			account.UpdatedAt = now
+			account.Revision = max(account.Revision, deleted[account.ID].Revision) + 1
+		}
+	}
+	for _, account := range s.vault.Accounts {
+		if kept[account.ID] == nil {
+			// Accounts added by the change stay deleted on other replicas too
+			merged.Tombstones = append(merged.Tombstones, model.Tombstone{ID: account.ID, DeletedAt: now, Revision: account.Revision})
+		}
+	}
+	return result, s.saveMerged(merged, operation)
+}
+
+// mergeAndSave merges remote into the unlocked vault and saves the result.
+// Caller must hold the lock.
+func (s *Storage) mergeAndSave(remote, ancestor *model.Vault, operation string) (*SyncResult, error) {
+	merged, result := mergeReplicas(s.vault, remote, ancestor, s.key)
+	return result, s.saveMerged(merged, operation)
+}
+
+// saveMerged replaces the accounts, aliases and tombstones of the vault with
+// merged and saves it. Caller must hold the lock.
+func (s *Storage) saveMerged(merged *model.Vault, operation string) error {
+	hash, err := s.getMasterKeyHashForSave()
+	if err != nil {
+		return err
+	}
+	defer crypto.ClearBytes(hash)
+
+	previous := *s.vault
+	s.vault.Accounts = merged.Accounts
+	s.vault.EmailAliases = merged.EmailAliases
+	s.vault.Tombstones = merged.Tombstones
+	if err := s.saveVault(hash, operation); err != nil {
+		*s.vault = previous
+		return err
+	}
+	return nil
+}
+
+// openRemote decrypts a remote replica and re-encrypts its secrets with the
+// vault key if it uses a different one. Caller must hold the lock.
+func (s *Storage) openRemote(fileData []byte, remotePassword string) (*model.Vault, error) {
+	if remotePassword == "" {
+		if !s.sameKey(fileData) {
+			return nil, errors.ErrRemotePassword
+		}
+		return decryptVaultData(fileData, s.key)
+	}
+
+	remote, key, err := decryptVaultFile(fileData, remotePassword)
+	if err != nil {
+		return nil, err
+	}
+	defer crypto.ClearBytes(key)
+	for i, account := range remote.Accounts {
+		if remote.Accounts[i], err = rekeyAccount(account, key, s.key); err != nil {
+			return nil, err
+		}
+	}
+	return remote, nil
+}
+
+// sameKey reports whether a vault file was written with the current salt and
+// therefore the current key. Caller must hold the lock.
+func (s *Storage) sameKey(fileData []byte) bool {
+	return len(fileData) > hashLength+crypto.SaltLength &&
+		bytes.Equal(fileData[hashLength:hashLength+crypto.SaltLength], s.vault.Salt)
+}
+
+// publishSync copies the saved vault file to the remote replica and keeps it
+// as the common ancestor of the next sync. read is the remote file the vault
+// was merged with, or nil if there was none; if the remote no longer matches
+// it, another replica synced meanwhile and publishSync fails with an
+// *errors.ConflictError instead of overwriting that change. Caller must hold
+// the lock.
+func (s *Storage) publishSync(remotePath string, read []byte) error {
+	data, _, err := s.backend.Read()
+	if err != nil {
+		return errors.Wrap(err, "failed to read vault file for sync")
+	}
+
+	ancestorPath := s.ancestorPath(remotePath)
+	if err := os.MkdirAll(filepath.Dir(ancestorPath), 0700); err != nil {
+		return errors.Wrap(err, "failed to create sync directory")
+	}
+	if err := writeRemote(remotePath, data, read); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(ancestorPath, data); err != nil {
+		return errors.Wrap(err, "failed to record sync state")
+	}
+	return nil
+}
+
+// writeRemote replaces the remote replica at path with data if it still
+// holds read, or creates it if read is nil and it does not exist. The file
+// is compared right before it is renamed into place; a plain file offers no
+// way to make both one step. The temporary file has a unique name, as other
+// replicas write next to path too.
+func writeRemote(path string, data, read []byte) error {
+	tempFile, err := writeTempFile(path, data)
+	if err != nil {
+		return errors.Wrap(err, "failed to write remote vault")
+	}
+	defer os.Remove(tempFile)
+
+	if read == nil {
+		// Link fails if another replica created the file meanwhile; file
+		// systems without hard links fall back to the check below
+		err := os.Link(tempFile, path)
+		if err == nil {
+			return nil
+		}
+		if os.IsExist(err) {
+			return &errors.ConflictError{}
+		}
+	}
+
+	expected, actual := "", ""
+	if read != nil {
+		expected = contentVersion(read)
+	}
+	current, err := os.ReadFile(path)
+	if err == nil {
+		actual = contentVersion(current)
+	} else if !os.IsNotExist(err) {
+		return errors.Wrap(err, "failed to read remote vault")
+	}
+	if actual != expected {
+		return &errors.ConflictError{Expected: expected, Actual: actual}
+	}
+	if err := os.Rename(tempFile, path); err != nil {
+		return errors.Wrap(err, "failed to write remote vault")
+	}
+	return nil
+}
+
+// ancestorPath returns where the last synced state for remotePath is kept.
+func (s *Storage) ancestorPath(remotePath string) string {
+	if abs, err := filepath.Abs(remotePath); err == nil {
+		remotePath = abs
+	}
+	sum := sha256.Sum256([]byte(remotePath))
+	return filepath.Join(s.dataDir, syncDirName, hex.EncodeToString(sum[:8])+".encrypted")
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames it
+// into place, so readers never see a partial file.
+func writeFileAtomic(path string, data []byte) error {
+	tempFile, err := writeTempFile(path, data)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tempFile, path); err != nil {
+		os.Remove(tempFile)
+		return err
+	}
+	return nil
+}
+
+// writeTempFile writes data to a new file with a unique name next to path,
+// readable by the owner only, and returns its name.
+func writeTempFile(path string, data []byte) (string, error) {
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return "", err
+	}
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+	return file.Name(), nil
+}
+
+// mergeReplicas performs a three-way merge of two replicas against their
+// common ancestor, which may be nil. All secrets must be encrypted with key.
+// Local accounts keep their order; accounts new from the remote are appended.
+func mergeReplicas(local, remote, ancestor *model.Vault, key []byte) (*model.Vault, *SyncResult) {
+	result := &SyncResult{FirstSync: ancestor == nil}
+	if ancestor == nil {
+		ancestor = &model.Vault{}
+	}
+
+	remoteByID := indexAccounts(remote.Accounts)
+	ancestorByID := indexAccounts(ancestor.Accounts)
+	localDeleted := indexTombstones(local.Tombstones)
+	remoteDeleted := indexTombstones(remote.Tombstones)
+
+	merged := &model.Vault{}
+	maxOrder := 0
+	for _, l := range local.Accounts {
+		maxOrder = max(maxOrder, l.SortOrder)
+		a := ancestorByID[l.ID]
+		r, ok := remoteByID[l.ID]
+		if !ok {
+			// Deleted on the remote since the last sync, or deleted there before we
+			// ever synced: keep the account only if it was changed here afterwards
+			deletion, tombstone := remoteDeleted[l.ID]
+			switch {
+			case a != nil && len(diffAccounts(a, l, key)) == 0,
+				a == nil && tombstone && !changedSince(l, deletion):
+				result.Deleted++
+				continue
+			case a != nil || tombstone:
+				result.Conflicts = append(result.Conflicts, SyncConflict{AccountID: l.ID, Platform: l.Platform, Field: "deleted", Winner: SyncLocal})
+			}
+			merged.Accounts = append(merged.Accounts, l)
+			continue
+		}
+
+		account, conflicts, changed := mergeAccount(l, r, a, key)
+		result.Conflicts = append(result.Conflicts, conflicts...)
+		if changed {
+			result.Updated++
+		}
+		merged.Accounts = append(merged.Accounts, account)
+	}
+
+	localByID := indexAccounts(local.Accounts)
+	for _, r := range remote.Accounts {
+		if localByID[r.ID] != nil {
+			continue
+		}
+		a := ancestorByID[r.ID]
+		deletion, tombstone := localDeleted[r.ID]
+		switch {
+		case a != nil && len(diffAccounts(a, r, key)) == 0,
+			a == nil && tombstone && !changedSince(r, deletion):
+			continue // Deleted here and not changed there
+		case a != nil || tombstone:
+			result.Conflicts = append(result.Conflicts, SyncConflict{AccountID: r.ID, Platform: r.Platform, Field: "deleted", Winner: SyncRemote})
+		}
+		account := r.Clone()
+		maxOrder++
+		account.SortOrder = maxOrder
+		merged.Accounts = append(merged.Accounts, account)
+		result.Added++
+	}
+
+	// Keep the latest tombstones of accounts that stayed deleted
+	present := indexAccounts(merged.Accounts)
+	for _, tombstones := range [][]model.Tombstone{local.Tombstones, remote.Tombstones} {
+		for _, tombstone := range tombstones {
+			if present[tombstone.ID] != nil {
+				continue
+			}
+			i := slices.IndexFunc(merged.Tombstones, func(t model.Tombstone) bool { return t.ID == tombstone.ID })
+			if i < 0 {
+				merged.Tombstones = append(merged.Tombstones, tombstone)
+			} else if newerTombstone(tombstone, merged.Tombstones[i]) {
+				merged.Tombstones[i] = tombstone
+			}
+		}
+	}
+
+	merged.EmailAliases = mergeLists(local.EmailAliases, remote.EmailAliases, ancestor.EmailAliases)
+	return merged, result
+}
+
+// mergeAccount merges two versions of an account field by field. a is their
+// common ancestor or nil. A field changed on one side only takes that change;
+// a field changed on both sides takes the version with the higher revision,
+// or on equal revisions the one updated last, since clocks of replicas may
+// disagree. Reports whether anything was taken from r.
+func mergeAccount(l, r, a *model.Account, key []byte) (*model.Account, []SyncConflict, bool) {
+	differing := diffAccounts(l, r, key)
+	history := mergeHistory(l.History, r.History)
+	if len(differing) == 0 && len(history) == len(l.History) {
+		return l, nil, false
+	}
+
+	var localChanged, remoteChanged []string
+	if a != nil {
+		localChanged = diffAccounts(a, l, key)
+		remoteChanged = diffAccounts(a, r, key)
+	}
+
+	merged := l.Clone()
+	merged.History = history
+	winner := SyncLocal
+	if r.Revision > l.Revision || r.Revision == l.Revision && r.UpdatedAt.After(l.UpdatedAt) {
+		winner = SyncRemote
+	}
+
+	var conflicts []SyncConflict
+	tookRemote, keptLocal := false, false
+	for _, field := range differing {
+		takeRemote := false
+		switch {
+		case a != nil && !slices.Contains(localChanged, field):
+			takeRemote = true
+		case a != nil && !slices.Contains(remoteChanged, field):
+			takeRemote = false
+		default:
+			conflicts = append(conflicts, SyncConflict{AccountID: l.ID, Platform: l.Platform, Field: field, Winner: winner})
+			takeRemote = winner == SyncRemote
+		}
+		if takeRemote {
+			copyField(merged, r, field)
+			tookRemote = true
+		} else {
+			keptLocal = true
+		}
+	}
+
+	if r.UpdatedAt.After(merged.UpdatedAt) {
+		merged.UpdatedAt = r.UpdatedAt
+	}
+	if !r.CreatedAt.IsZero() && r.CreatedAt.Before(merged.CreatedAt) {
+		merged.CreatedAt = r.CreatedAt
+	}
+	// The merged account must win over both versions on other replicas,
+	// unless it is one of them
+	switch {
+	case tookRemote && !keptLocal && r.Revision > l.Revision:
+		merged.Revision = r.Revision
+	case tookRemote || keptLocal && l.Revision <= r.Revision:
+		merged.Revision = max(l.Revision, r.Revision) + 1
+	}
+	return merged, conflicts, tookRemote || len(history) > len(l.History)
+}
+
+// copyField copies one field, named as in diffAccounts, from src to dst.
+func copyField(dst, src *model.Account, field string) {
+	switch field {
+	case "platform":
+		dst.Platform = src.Platform
+	case "username":
+		dst.Username = src.Username
+	case "email":
+		dst.Email = src.Email
+	case "password":
+		dst.EncryptedPassword = src.EncryptedPassword
+	case "url":
+		dst.URL = src.URL
+	case "notes":
+		dst.Notes = src.Notes
+	case "group":
+		dst.Group = src.Group
+	case "tags":
+		dst.Tags = slices.Clone(src.Tags)
+	case "password_rules":
+		dst.PasswordRules = src.PasswordRules
+	case "derived":
+		dst.Derived = src.Clone().Derived
+	case "ssh_key":
+		dst.SSHKey = src.Clone().SSHKey
+	case "custom_fields":
+		dst.CustomFields = slices.Clone(src.CustomFields)
+	}
+}
+
+// mergeHistory returns the history entries of both replicas in time order,
+// without the entries they share.
+func mergeHistory(local, remote []model.HistoryEntry) []model.HistoryEntry {
+	same := func(a, b model.HistoryEntry) bool {
+		return a.UpdatedAt.Equal(b.UpdatedAt) && a.Username == b.Username && a.Email == b.Email && a.URL == b.URL
+	}
+	history := slices.Clone(local)
+	for _, entry := range remote {
+		if !slices.ContainsFunc(history, func(h model.HistoryEntry) bool { return same(h, entry) }) {
+			history = append(history, entry)
+		}
+	}
+	slices.SortStableFunc(history, func(a, b model.HistoryEntry) int { return a.UpdatedAt.Compare(b.UpdatedAt) })
+	return history
+}
+
+// mergeLists merges two versions of a list against their ancestor: items
+// removed on either side are dropped, items added on either side are kept.
+func mergeLists(local, remote, ancestor []string) []string {
+	var merged []string
+	for _, item := range local {
+		if !slices.Contains(ancestor, item) || slices.Contains(remote, item) {
+			merged = append(merged, item)
+		}
+	}
+	for _, item := range remote {
+		if !slices.Contains(ancestor, item) && !slices.Contains(merged, item) {
+			merged = append(merged, item)
+		}
+	}
+	return merged
+}
+
+func indexAccounts(accounts []*model.Account) map[string]*model.Account {
+	index := make(map[string]*model.Account, len(accounts))
+	for _, account := range accounts {
+		index[account.ID] = account
+	}
+	return index
+}
+
+// indexTombstones returns the newest tombstone of each deleted account.
+func indexTombstones(tombstones []model.Tombstone) map[string]model.Tombstone {
+	index := make(map[string]model.Tombstone, len(tombstones))
+	for _, tombstone := range tombstones {
+		if current, ok := index[tombstone.ID]; !ok || newerTombstone(tombstone, current) {
+			index[tombstone.ID] = tombstone
+		}
+	}
+	return index
+}
+
+// newerTombstone reports whether tombstone a records a later deletion than b.
+func newerTombstone(a, b model.Tombstone) bool {
+	if a.Revision != b.Revision {
+		return a.Revision > b.Revision
+	}
+	return a.DeletedAt.After(b.DeletedAt)
+}
+
+// changedSince reports whether account was changed after the deletion
+// recorded by tombstone, which then does not apply to it.
+func changedSince(account *model.Account, tombstone model.Tombstone) bool {
+	if tombstone.Revision > 0 {
+		return account.Revision > tombstone.Revision
+	}
+	// Tombstones written before revisions were recorded
+	return account.UpdatedAt.After(tombstone.DeletedAt)
+}
+
+// pruneTombstones drops tombstones older than tombstoneLifetime.
+func pruneTombstones(tombstones []model.Tombstone, now time.Time) []model.Tombstone {
+	return slices.DeleteFunc(slices.Clone(tombstones), func(t model.Tombstone) bool {
+		return now.Sub(t.DeletedAt) > tombstoneLifetime
+	})
+}
@@ -0,0 +1,404 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/simp-lee/passwordmanager/internal/errors"
+	"github.com/simp-lee/passwordmanager/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupReplicas returns two synced replicas of a vault with three accounts
+// and the path of the shared remote file.
+func setupReplicas(t *testing.T) (*Storage, *Storage, string) {
+	remotePath := filepath.Join(t.TempDir(), "shared.encrypted")
+
+	laptop := newMergeVault(t, "master-password",
+		&model.Account{ID: "a", Platform: "GitHub", Username: "octo", EncryptedPassword: "gh", Notes: "original"},
+		&model.Account{ID: "b", Platform: "Mail", EncryptedPassword: "mail"},
+		&model.Account{ID: "c", Platform: "Bank", EncryptedPassword: "bank"},
+	)
+	result, err := laptop.Sync(remotePath)
+	require.NoError(t, err, "First sync should create the remote")
+	assert.True(t, result.FirstSync)
+
+	desktop, err := New(t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, desktop.ImportVault(remotePath))
+	require.NoError(t, desktop.UnlockVault("master-password"))
+	result, err = desktop.Sync(remotePath)
+	require.NoError(t, err)
+	assert.Zero(t, result.Added+result.Updated+result.Deleted)
+	return laptop, desktop, remotePath
+}
+
+// edit changes an account and waits so that later edits are strictly newer.
+func edit(t *testing.T, s *Storage, id string, change func(account *model.Account)) {
+	account, err := s.GetAccountByID(id)
+	require.NoError(t, err)
+	updated := account.Clone()
+	change(updated)
+	require.NoError(t, s.UpdateAccount(updated))
+	time.Sleep(10 * time.Millisecond)
+}
+
+func TestSync(t *testing.T) {
+	t.Run("FieldLevelMerge", func(t *testing.T) {
+		laptop, desktop, remotePath := setupReplicas(t)
+
+		edit(t, laptop, "a", func(account *model.Account) { account.Notes = "from laptop" })
+		edit(t, desktop, "a", func(account *model.Account) {
+			account.EncryptedPassword = encryptFor(t, desktop, "new-gh")
+		})
+
+		_, err := laptop.Sync(remotePath)
+		require.NoError(t, err)
+		result, err := desktop.Sync(remotePath)
+		require.NoError(t, err)
+		assert.Empty(t, result.Conflicts, "Different fields changed on each side do not conflict")
+		assert.Equal(t, 1, result.Updated)
+
+		_, err = laptop.Sync(remotePath)
+		require.NoError(t, err)
+		for _, replica := range []*Storage{laptop, desktop} {
+			account, err := replica.GetAccountByID("a")
+			require.NoError(t, err)
+			assert.Equal(t, "from laptop", account.Notes)
+			assert.Equal(t, "new-gh", decryptWith(t, replica, account.EncryptedPassword))
+		}
+	})
+
+	t.Run("ConflictNewestWins", func(t *testing.T) {
+		laptop, desktop, remotePath := setupReplicas(t)
+
+		edit(t, laptop, "a", func(account *model.Account) { account.Notes = "laptop" })
+		edit(t, desktop, "a", func(account *model.Account) { account.Notes = "desktop" })
+
+		_, err := laptop.Sync(remotePath)
+		require.NoError(t, err)
+		result, err := desktop.Sync(remotePath)
+		require.NoError(t, err)
+		require.Len(t, result.Conflicts, 1)
+		assert.Equal(t, SyncConflict{AccountID: "a", Platform: "GitHub", Field: "notes", Winner: SyncLocal}, result.Conflicts[0])
+
+		_, err = laptop.Sync(remotePath)
+		require.NoError(t, err)
+		account, err := laptop.GetAccountByID("a")
+		require.NoError(t, err)
+		assert.Equal(t, "desktop", account.Notes, "On equal revisions the account updated last wins")
+	})
+
+	t.Run("ConflictHigherRevisionWins", func(t *testing.T) {
+		laptop, desktop, remotePath := setupReplicas(t)
+
+		edit(t, laptop, "a", func(account *model.Account) { account.Notes = "laptop draft" })
+		edit(t, laptop, "a", func(account *model.Account) { account.Notes = "laptop" })
+		edit(t, desktop, "a", func(account *model.Account) { account.Notes = "desktop" })
+
+		_, err := laptop.Sync(remotePath)
+		require.NoError(t, err)
+		result, err := desktop.Sync(remotePath)
+		require.NoError(t, err)
+		require.Len(t, result.Conflicts, 1)
+		assert.Equal(t, SyncRemote, result.Conflicts[0].Winner, "The clock does not decide")
+
+		account, err := desktop.GetAccountByID("a")
+		require.NoError(t, err)
+		assert.Equal(t, "laptop", account.Notes)
+	})
+
+	t.Run("RemoteChangedDuringSync", func(t *testing.T) {
+		laptop, desktop, remotePath := setupReplicas(t)
+
+		edit(t, laptop, "a", func(account *model.Account) { account.Notes = "from laptop" })
+		edit(t, desktop, "b", func(account *model.Account) { account.Notes = "from desktop" })
+
+		// The desktop syncs while the laptop is merging
+		interrupted := false
+		laptop.SetSaveHook(func(operation string) error {
+			if !interrupted {
+				interrupted = true
+				_, err := desktop.Sync(remotePath)
+				require.NoError(t, err)
+			}
+			return nil
+		})
+		result, err := laptop.Sync(remotePath)
+		require.NoError(t, err)
+		assert.True(t, interrupted)
+		assert.Equal(t, 1, result.Updated, "The second merge brings in the desktop change")
+
+		_, err = desktop.Sync(remotePath)
+		require.NoError(t, err)
+		for _, replica := range []*Storage{laptop, desktop} {
+			for id, notes := range map[string]string{"a": "from laptop", "b": "from desktop"} {
+				account, err := replica.GetAccountByID(id)
+				require.NoError(t, err)
+				assert.Equal(t, notes, account.Notes, "Neither change is lost")
+			}
+		}
+	})
+
+	t.Run("Deletions", func(t *testing.T) {
+		laptop, desktop, remotePath := setupReplicas(t)
+
+		require.NoError(t, laptop.DeleteAccount("b"))
+		require.NoError(t, laptop.DeleteAccount("c"))
+		edit(t, desktop, "c", func(account *model.Account) { account.Notes = "still needed" })
+
+		_, err := laptop.Sync(remotePath)
+		require.NoError(t, err)
+		result, err := desktop.Sync(remotePath)
+		require.NoError(t, err)
+		assert.Equal(t, 1, result.Deleted, "Unchanged accounts deleted elsewhere are deleted")
+		require.Len(t, result.Conflicts, 1)
+		assert.Equal(t, "deleted", result.Conflicts[0].Field)
+
+		_, err = desktop.GetAccountByID("b")
+		assert.ErrorIs(t, err, errors.ErrAccountNotFound)
+
+		result, err = laptop.Sync(remotePath)
+		require.NoError(t, err)
+		assert.Equal(t, 1, result.Added, "An account changed after its deletion comes back")
+		account, err := laptop.GetAccountByID("c")
+		require.NoError(t, err)
+		assert.Equal(t, "still needed", account.Notes)
+
+		// Syncing again is a no-op on both sides
+		for _, replica := range []*Storage{desktop, laptop} {
+			result, err = replica.Sync(remotePath)
+			require.NoError(t, err)
+			assert.Zero(t, result.Added+result.Updated+result.Deleted)
+			assert.Empty(t, result.Conflicts)
+			accounts, err := replica.GetAccounts()
+			require.NoError(t, err)
+			assert.Len(t, accounts, 2)
+		}
+	})
+
+	t.Run("AddedOnBothSides", func(t *testing.T) {
+		laptop, desktop, remotePath := setupReplicas(t)
+
+		require.NoError(t, laptop.AddAccount(&model.Account{ID: "laptop-new", Platform: "Forum", EncryptedPassword: encryptFor(t, laptop, "x")}))
+		require.NoError(t, desktop.AddAccount(&model.Account{ID: "desktop-new", Platform: "Shop", EncryptedPassword: encryptFor(t, desktop, "y")}))
+
+		_, err := laptop.Sync(remotePath)
+		require.NoError(t, err)
+		result, err := desktop.Sync(remotePath)
+		require.NoError(t, err)
+		assert.Equal(t, 1, result.Added)
+
+		accounts, err := desktop.GetAccounts()
+		require.NoError(t, err)
+		require.Len(t, accounts, 5)
+		assert.Equal(t, "laptop-new", accounts[4].ID, "Accounts from the remote are appended")
+	})
+
+	t.Run("RemotePasswordChanged", func(t *testing.T) {
+		laptop, desktop, remotePath := setupReplicas(t)
+
+		require.NoError(t, desktop.ChangeMasterPassword("master-password", "new-master-password"))
+		_, err := desktop.Sync(remotePath)
+		require.ErrorIs(t, err, errors.ErrRemotePassword, "The remote still uses the old password")
+		_, err = desktop.SyncWithPassword(remotePath, "master-password")
+		require.NoError(t, err)
+
+		_, err = laptop.Sync(remotePath)
+		assert.ErrorIs(t, err, errors.ErrRemotePassword)
+		_, err = laptop.SyncWithPassword(remotePath, "wrong-password")
+		assert.ErrorIs(t, err, errors.ErrInvalidPassword)
+		_, err = laptop.SyncWithPassword(remotePath, "new-master-password")
+		require.NoError(t, err)
+		account, err := laptop.GetAccountByID("a")
+		require.NoError(t, err)
+		assert.Equal(t, "gh", decryptWith(t, laptop, account.EncryptedPassword))
+	})
+}
+
+func TestMergeVaultData(t *testing.T) {
+	laptop, desktop, _ := setupReplicas(t)
+	ancestor, _, err := laptop.backend.Read()
+	require.NoError(t, err)
+
+	edit(t, laptop, "a", func(account *model.Account) { account.Notes = "from laptop" })
+	edit(t, desktop, "b", func(account *model.Account) { account.Notes = "from desktop" })
+	remote, _, err := laptop.backend.Read()
+	require.NoError(t, err)
+
+	result, err := desktop.MergeVaultData(remote, ancestor, "", "merge laptop")
+	require.NoError(t, err)
+	assert.Equal(t, 1, result.Updated)
+	assert.Empty(t, result.Conflicts)
+	for id, notes := range map[string]string{"a": "from laptop", "b": "from desktop"} {
+		account, err := desktop.GetAccountByID(id)
+		require.NoError(t, err)
+		assert.Equal(t, notes, account.Notes)
+	}
+}
+
+func TestRevertVaultData(t *testing.T) {
+	s := newMergeVault(t, "master-password",
+		&model.Account{ID: "a", Platform: "GitHub", EncryptedPassword: "gh", Notes: "original"},
+		&model.Account{ID: "b", Platform: "Mail", EncryptedPassword: "mail"},
+	)
+	var operations []string
+	s.SetSaveHook(func(operation string) error {
+		operations = append(operations, operation)
+		return nil
+	})
+	snapshot := func() []byte {
+		data, _, err := s.backend.Read()
+		require.NoError(t, err)
+		return data
+	}
+
+	before := snapshot()
+	edit(t, s, "a", func(account *model.Account) { account.Notes = "changed" })
+	notesChanged := snapshot()
+	require.NoError(t, s.DeleteAccount("b"))
+	bDeleted := snapshot()
+	require.NoError(t, s.AddAccount(&model.Account{ID: "c", Platform: "Bank", EncryptedPassword: encryptFor(t, s, "bank")}))
+	cAdded := snapshot()
+	edit(t, s, "a", func(account *model.Account) {
+		account.EncryptedPassword = encryptFor(t, s, "new-gh")
+	})
+	assert.Equal(t, []string{"update account a", "delete account b", "add account c", "update account a"}, operations)
+
+	_, err := s.RevertVaultData(before, notesChanged, "", "revert notes")
+	require.NoError(t, err)
+	a, err := s.GetAccountByID("a")
+	require.NoError(t, err)
+	assert.Equal(t, "original", a.Notes, "The reverted change is undone")
+	assert.Equal(t, "new-gh", decryptWith(t, s, a.EncryptedPassword), "Later changes are kept")
+
+	_, err = s.RevertVaultData(notesChanged, bDeleted, "", "revert delete")
+	require.NoError(t, err)
+	_, err = s.GetAccountByID("b")
+	assert.NoError(t, err, "A reverted deletion restores the account")
+
+	result, err := s.RevertVaultData(bDeleted, cAdded, "", "revert add")
+	require.NoError(t, err)
+	assert.Equal(t, 1, result.Deleted)
+	_, err = s.GetAccountByID("c")
+	assert.ErrorIs(t, err, errors.ErrAccountNotFound, "A reverted addition deletes the account")
+	assert.True(t, slices.ContainsFunc(s.vault.Tombstones, func(t model.Tombstone) bool { return t.ID == "c" }),
+		"Other replicas learn about the deletion")
+
+	assert.Equal(t, []string{"revert notes", "revert delete", "revert add"}, operations[4:])
+}
+
+func TestMergeReplicasWithoutAncestor(t *testing.T) {
+	older := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	newer := older.Add(time.Hour)
+
+	local := &model.Vault{
+		Accounts: []*model.Account{
+			{ID: "kept", Platform: "Kept", Notes: "local", UpdatedAt: older},
+			{ID: "deleted-remotely", Platform: "Old", UpdatedAt: older},
+		},
+		Tombstones:   []model.Tombstone{{ID: "deleted-locally", DeletedAt: newer}},
+		EmailAliases: []string{"a+{tag}@example.com"},
+	}
+	remote := &model.Vault{
+		Accounts: []*model.Account{
+			{ID: "kept", Platform: "Kept", Notes: "remote", UpdatedAt: newer},
+			{ID: "deleted-locally", Platform: "Gone", UpdatedAt: older},
+		},
+		Tombstones:   []model.Tombstone{{ID: "deleted-remotely", DeletedAt: newer}},
+		EmailAliases: []string{"b+{tag}@example.com"},
+	}
+
+	merged, result := mergeReplicas(local, remote, nil, nil)
+	assert.True(t, result.FirstSync)
+	require.Len(t, merged.Accounts, 1)
+	assert.Equal(t, "remote", merged.Accounts[0].Notes, "Without an ancestor the newer version wins")
+	assert.Equal(t, 1, result.Deleted)
+	assert.Zero(t, result.Added)
+	assert.ElementsMatch(t, []string{"deleted-locally", "deleted-remotely"},
+		[]string{merged.Tombstones[0].ID, merged.Tombstones[1].ID})
+	assert.Equal(t, []string{"a+{tag}@example.com", "b+{tag}@example.com"}, merged.EmailAliases)
+}
+
+func TestMergeReplicasClockSkew(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	ancestor := &model.Vault{Accounts: []*model.Account{
+		{ID: "a", Platform: "A", Notes: "original", Revision: 2, UpdatedAt: now},
+		{ID: "b", Platform: "B", Revision: 4, UpdatedAt: now},
+	}}
+	// The remote clock is an hour behind: its changes look older
+	local := &model.Vault{Accounts: []*model.Account{
+		{ID: "a", Platform: "A", Notes: "local", Revision: 3, UpdatedAt: now.Add(time.Minute)},
+		{ID: "b", Platform: "B", Revision: 4, UpdatedAt: now},
+	}}
+	remote := &model.Vault{
+		Accounts: []*model.Account{
+			{ID: "a", Platform: "A", Notes: "remote", Revision: 5, UpdatedAt: now.Add(-time.Hour)},
+		},
+		Tombstones: []model.Tombstone{{ID: "b", DeletedAt: now.Add(-time.Hour), Revision: 4}},
+	}
+
+	merged, result := mergeReplicas(local, remote, ancestor, nil)
+	require.Len(t, merged.Accounts, 1)
+	assert.Equal(t, "remote", merged.Accounts[0].Notes, "The higher revision wins")
+	assert.Equal(t, 5, merged.Accounts[0].Revision)
+	assert.Equal(t, 1, result.Deleted)
+
+	// Without an ancestor, a tombstone removes the revision it saw and
+	// nothing later
+	local.Accounts[1].Revision = 5
+	merged, _ = mergeReplicas(local, remote, nil, nil)
+	assert.Len(t, merged.Accounts, 2, "An account changed after its deletion is kept")
+}
+
+func TestWriteRemote(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shared.encrypted")
+	// A write of another replica in progress
+	otherTemp := path + ".12345.tmp"
+	require.NoError(t, os.WriteFile(otherTemp, []byte("other replica"), 0600))
+
+	require.NoError(t, writeRemote(path, []byte("first"), nil))
+	assert.ErrorIs(t, writeRemote(path, []byte("other"), nil), errors.ErrVersionConflict, "The remote was created meanwhile")
+
+	require.NoError(t, writeRemote(path, []byte("second"), []byte("first")))
+	assert.ErrorIs(t, writeRemote(path, []byte("third"), []byte("first")), errors.ErrVersionConflict, "The remote was changed meanwhile")
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "second", string(data))
+	temps, err := filepath.Glob(path + ".*.tmp")
+	require.NoError(t, err)
+	assert.Equal(t, []string{otherTemp}, temps, "No temporary file is left behind, and none of another replica is touched")
+	data, err = os.ReadFile(otherTemp)
+	require.NoError(t, err)
+	assert.Equal(t, "other replica", string(data))
+}
+
+func TestTombstoneExpiry(t *testing.T) {
+	s := newMergeVault(t, "master-password",
+		&model.Account{ID: "a", Platform: "GitHub", EncryptedPassword: "gh"},
+		&model.Account{ID: "b", Platform: "Mail", EncryptedPassword: "mail"},
+	)
+	s.vault.Tombstones = []model.Tombstone{
+		{ID: "expired", DeletedAt: time.Now().Add(-tombstoneLifetime - time.Hour)},
+		{ID: "recent", DeletedAt: time.Now().Add(-time.Hour)},
+	}
+	require.NoError(t, s.DeleteAccount("b"))
+
+	require.NoError(t, s.UnlockVault("master-password"))
+	ids := make([]string, len(s.vault.Tombstones))
+	for i, tombstone := range s.vault.Tombstones {
+		ids[i] = tombstone.ID
+	}
+	assert.Equal(t, []string{"recent", "b"}, ids, "Expired tombstones are dropped on save")
+	assert.Equal(t, 1, s.vault.Tombstones[1].Revision, "The tombstone records the deleted revision")
+}
+
+func TestMergeLists(t *testing.T) {
+	assert.Equal(t, []string{"keep", "new-local", "new-remote"},
+		mergeLists([]string{"keep", "new-local"}, []string{"keep", "removed-locally", "new-remote"}, []string{"keep", "removed-locally", "removed-remotely"}))
+}
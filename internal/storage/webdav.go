@@ -0,0 +1,362 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/simp-lee/passwordmanager/internal/errors"
+)
+
+const webdavTimeout = 30 * time.Second
+
+// propfindBody asks only for the size of the backups.
+const propfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:"><d:prop><d:getcontentlength/></d:prop></d:propfind>`
+
+// WebDAVBackend keeps the vault on a WebDAV server such as Nextcloud.
+// Versions are the server's ETags; writes use If-Match / If-None-Match so the
+// server rejects a write based on a stale version. Backups are server-side
+// copies in a "backups" collection next to the vault file.
+type WebDAVBackend struct {
+	url      *url.URL // URL of the vault file
+	user     string
+	password string
+	client   *http.Client
+}
+
+// NewWebDAVBackend returns a backend for the vault file at rawURL, e.g.
+// https://cloud.example.com/remote.php/dav/files/me/vault.encrypted.
+// If user is empty, credentials in the URL are used.
+func NewWebDAVBackend(rawURL, user, password string) (*WebDAVBackend, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" ||
+		u.Path == "" || strings.HasSuffix(u.Path, "/") {
+		return nil, errors.Wrap(errors.ErrBackendURL, rawURL)
+	}
+	if user == "" && u.User != nil {
+		user = u.User.Username()
+		password, _ = u.User.Password()
+	}
+	u.User = nil
+
+	return &WebDAVBackend{
+		url:      u,
+		user:     user,
+		password: password,
+		client:   &http.Client{Timeout: webdavTimeout},
+	}, nil
+}
+
+// Read downloads the vault file.
+func (b *WebDAVBackend) Read() ([]byte, string, error) {
+	resp, err := b.do(http.MethodGet, b.url, nil, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, "", errors.ErrVaultNotExists
+	default:
+		return nil, "", unexpectedStatus(resp)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "error reading vault file")
+	}
+	etag, err := requireETag(resp)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, etag, nil
+}
+
+// Version returns the ETag of the vault file.
+func (b *WebDAVBackend) Version() (string, error) {
+	resp, err := b.do(http.MethodHead, b.url, nil, nil)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return requireETag(resp)
+	case http.StatusNotFound:
+		return "", errors.ErrVaultNotExists
+	default:
+		return "", unexpectedStatus(resp)
+	}
+}
+
+// Write uploads the vault file if the server still has version.
+func (b *WebDAVBackend) Write(data []byte, version string) (string, error) {
+	header := map[string]string{"Content-Type": "application/octet-stream"}
+	var backup *url.URL
+	if version == "" {
+		header["If-None-Match"] = "*"
+	} else {
+		header["If-Match"] = version
+		// The server copies the version being replaced, so it is never
+		// downloaded. The copy is taken first as the PUT replaces the
+		// original, and dropped again if the PUT is rejected.
+		var err error
+		if backup, err = b.backup(version); err != nil {
+			return "", err
+		}
+	}
+
+	resp, err := b.do(http.MethodPut, b.url, data, header)
+	if err == nil {
+		resp.Body.Close()
+		switch resp.StatusCode {
+		case http.StatusOK, http.StatusCreated, http.StatusNoContent:
+		case http.StatusPreconditionFailed:
+			err = b.conflict(version)
+		default:
+			err = unexpectedStatus(resp)
+		}
+	}
+	if err != nil {
+		if backup != nil {
+			b.delete(backup)
+		}
+		return "", err
+	}
+	if backup != nil {
+		b.pruneBackups()
+	}
+
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		return etag, nil
+	}
+	return b.Version()
+}
+
+// KeepBackup uploads data into the backups collection as a kept backup.
+func (b *WebDAVBackend) KeepBackup(data []byte) error {
+	if err := b.createBackupCollection(); err != nil {
+		return err
+	}
+	destination := b.backupURL(backupName(path.Base(b.url.Path), time.Now(), true))
+	resp, err := b.do(http.MethodPut, destination, data, map[string]string{
+		"Content-Type":  "application/octet-stream",
+		"If-None-Match": "*",
+	})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated, http.StatusNoContent:
+		return nil
+	default:
+		return unexpectedStatus(resp)
+	}
+}
+
+// ListBackups lists the backups collection, newest first.
+func (b *WebDAVBackend) ListBackups() ([]Backup, error) {
+	resp, err := b.do("PROPFIND", b.backupCollection(), []byte(propfindBody),
+		map[string]string{"Depth": "1", "Content-Type": "application/xml"})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusMultiStatus:
+	case http.StatusNotFound:
+		return nil, nil
+	default:
+		return nil, unexpectedStatus(resp)
+	}
+
+	var status struct {
+		Responses []struct {
+			Href     string `xml:"DAV: href"`
+			Propstat []struct {
+				Length int64 `xml:"DAV: prop>getcontentlength"`
+			} `xml:"DAV: propstat"`
+		} `xml:"DAV: response"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return nil, errors.Wrap(err, "failed to parse backup list")
+	}
+
+	base := path.Base(b.url.Path)
+	var backups []Backup
+	for _, response := range status.Responses {
+		href, err := url.PathUnescape(response.Href)
+		if err != nil || strings.HasSuffix(href, "/") {
+			continue // The collection itself
+		}
+		name := path.Base(href)
+		backup, ok := parseBackupName(base, name)
+		if !ok {
+			continue
+		}
+		for _, propstat := range response.Propstat {
+			if propstat.Length > 0 {
+				backup.Size = propstat.Length
+			}
+		}
+		backups = append(backups, backup)
+	}
+	sortBackups(backups)
+	return backups, nil
+}
+
+// ReadBackup downloads a backup.
+func (b *WebDAVBackend) ReadBackup(name string) ([]byte, error) {
+	if _, ok := parseBackupName(path.Base(b.url.Path), name); !ok {
+		return nil, errors.ErrBackupNotFound
+	}
+	resp, err := b.do(http.MethodGet, b.backupURL(name), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, errors.ErrBackupNotFound
+	default:
+		return nil, unexpectedStatus(resp)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read backup")
+	}
+	return data, nil
+}
+
+// backup copies the vault file into the backups collection and returns the
+// URL of the copy. Fails with a conflict if the vault is gone.
+func (b *WebDAVBackend) backup(version string) (*url.URL, error) {
+	if err := b.createBackupCollection(); err != nil {
+		return nil, err
+	}
+
+	destination := b.backupURL(backupName(path.Base(b.url.Path), time.Now(), false))
+	resp, err := b.do("COPY", b.url, nil, map[string]string{
+		"Destination": destination.String(),
+		"Overwrite":   "F",
+	})
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusCreated, http.StatusNoContent:
+		return destination, nil
+	case http.StatusNotFound:
+		return nil, &errors.ConflictError{Expected: version}
+	default:
+		return nil, unexpectedStatus(resp)
+	}
+}
+
+// createBackupCollection creates the backups collection if it is missing.
+func (b *WebDAVBackend) createBackupCollection() error {
+	// MKCOL fails with 405 if the collection already exists
+	resp, err := b.do("MKCOL", b.backupCollection(), nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusMethodNotAllowed {
+		return unexpectedStatus(resp)
+	}
+	return nil
+}
+
+// pruneBackups removes the oldest backups beyond maxBackups.
+func (b *WebDAVBackend) pruneBackups() {
+	backups, err := b.ListBackups()
+	if err != nil {
+		return
+	}
+	for _, old := range prunedBackups(backups) {
+		b.delete(b.backupURL(old.Name))
+	}
+}
+
+// delete removes target, ignoring failures.
+func (b *WebDAVBackend) delete(target *url.URL) {
+	if resp, err := b.do(http.MethodDelete, target, nil, nil); err == nil {
+		resp.Body.Close()
+	}
+}
+
+// conflict builds the error for a rejected write, with the current version
+// if it can be found.
+func (b *WebDAVBackend) conflict(version string) error {
+	actual, _ := b.Version()
+	return &errors.ConflictError{Expected: version, Actual: actual}
+}
+
+// do sends a request with the backend's credentials.
+func (b *WebDAVBackend) do(method string, target *url.URL, body []byte, header map[string]string) (*http.Response, error) {
+	req, err := http.NewRequest(method, target.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create WebDAV request")
+	}
+	if b.user != "" {
+		req.SetBasicAuth(b.user, b.password)
+	}
+	for key, value := range header {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "WebDAV request failed")
+	}
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		resp.Body.Close()
+		return nil, errors.ErrBackendAuth
+	}
+	return resp, nil
+}
+
+// backupCollection returns the URL of the backups collection.
+func (b *WebDAVBackend) backupCollection() *url.URL {
+	u := *b.url
+	u.Path = path.Join(path.Dir(b.url.Path), backupDirName) + "/"
+	u.RawPath = ""
+	return &u
+}
+
+// backupURL returns the URL of the backup with the given name.
+func (b *WebDAVBackend) backupURL(name string) *url.URL {
+	u := *b.url
+	u.Path = path.Join(path.Dir(b.url.Path), backupDirName, name)
+	u.RawPath = ""
+	return &u
+}
+
+// requireETag returns the ETag of a response; optimistic writes are not
+// possible without one.
+func requireETag(resp *http.Response) (string, error) {
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		return "", fmt.Errorf("WebDAV server did not return an ETag for %s", resp.Request.URL.Path)
+	}
+	return etag, nil
+}
+
+func unexpectedStatus(resp *http.Response) error {
+	return fmt.Errorf("WebDAV %s %s: unexpected status %s", resp.Request.Method, resp.Request.URL.Path, resp.Status)
+}
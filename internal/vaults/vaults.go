@@ -0,0 +1,217 @@
+// Package vaults keeps a registry of named vaults. Every vault has its own
+// data directory, holding its vault file, backend configuration, backups and
+// sync state. The registry lives in the root directory, which is also the
+// data directory of the vault named "default".
+package vaults
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/simp-lee/passwordmanager/internal/errors"
+)
+
+const (
+	EnvDataDir       = "PASSWORDMANAGER_DIR" // Overrides the root directory
+	DefaultName      = "default"             // Vault kept in the root directory itself
+	RegistryFileName = "vaults.json"         // Registry file in the root directory
+	vaultsDirName    = "vaults"              // Parent of new vault directories
+	rootDirName      = ".passwordmanager"    // Root directory in the home directory
+)
+
+// vaultFiles are the files and directories a vault keeps in its data
+// directory: the vault file, backend configuration, backups, sync state, git
+// history, API tokens and audit log, browser extensions, the native messaging
+// launcher and the SSH agent socket. Deleting a vault removes only these.
+var vaultFiles = []string{
+	"vault.encrypted", "backend.json", "backups", "sync", ".git", ".gitignore",
+	"api_tokens.json", "api_audit.log", "browser_extensions.json", "native-host", "ssh-agent.sock",
+}
+
+// validName restricts names to something safe as a directory name.
+var validName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+// Vault is a named vault and its data directory.
+type Vault struct {
+	Name string `json:"name"`
+	Dir  string `json:"dir"`
+}
+
+// Registry lists the named vaults below a root directory.
+type Registry struct {
+	root    string
+	Default string  `json:"default"` // Vault used when none is named
+	Vaults  []Vault `json:"vaults"`  // Vaults other than "default"
+}
+
+// RootDir returns the root directory: dir if it is not empty, otherwise
+// $PASSWORDMANAGER_DIR, otherwise ~/.passwordmanager.
+func RootDir(dir string) (string, error) {
+	if dir == "" {
+		dir = os.Getenv(EnvDataDir)
+	}
+	if dir == "" {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return "", errors.Wrap(err, "failed to get user home directory")
+		}
+		dir = filepath.Join(homeDir, rootDirName)
+	}
+	return filepath.Abs(dir)
+}
+
+// Load reads the registry in root. Without a registry file only the
+// "default" vault exists.
+func Load(root string) (*Registry, error) {
+	r := &Registry{root: root, Default: DefaultName}
+	data, err := os.ReadFile(filepath.Join(root, RegistryFileName))
+	if os.IsNotExist(err) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read vault registry")
+	}
+	if err := json.Unmarshal(data, r); err != nil {
+		return nil, errors.Wrap(errors.ErrDataCorrupted, "vault registry: "+err.Error())
+	}
+	if r.Default == "" {
+		r.Default = DefaultName
+	}
+	return r, nil
+}
+
+// Root returns the root directory of the registry.
+func (r *Registry) Root() string {
+	return r.root
+}
+
+// List returns all vaults, "default" first and the others by name.
+func (r *Registry) List() []Vault {
+	vaults := append([]Vault{{Name: DefaultName, Dir: r.root}}, r.Vaults...)
+	sort.SliceStable(vaults[1:], func(i, j int) bool {
+		return vaults[i+1].Name < vaults[j+1].Name
+	})
+	return vaults
+}
+
+// Get returns the vault called name, or the default vault if name is empty.
+func (r *Registry) Get(name string) (Vault, error) {
+	if name == "" {
+		name = r.Default
+	}
+	for _, vault := range r.List() {
+		if vault.Name == name {
+			return vault, nil
+		}
+	}
+	return Vault{}, errors.Wrap(errors.ErrVaultNotFound, name)
+}
+
+// Create registers a new vault called name with its data directory at dir,
+// by default root/vaults/<name>, and creates the directory. dir must be new
+// or empty, and must not contain the root directory or another vault. The
+// vault file itself is created when the vault is initialized.
+func (r *Registry) Create(name, dir string) (Vault, error) {
+	if !validName.MatchString(name) {
+		return Vault{}, errors.Wrap(errors.ErrInvalidVaultName, name)
+	}
+	if _, err := r.Get(name); err == nil {
+		return Vault{}, errors.Wrap(errors.ErrVaultNameExists, name)
+	}
+
+	if dir == "" {
+		dir = filepath.Join(r.root, vaultsDirName, name)
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return Vault{}, errors.Wrap(err, "invalid vault directory")
+	}
+	for _, vault := range r.List() {
+		if vault.Dir == dir {
+			return Vault{}, errors.Wrap(errors.ErrVaultNameExists, "directory already used by vault "+vault.Name)
+		}
+		if isWithin(vault.Dir, dir) {
+			return Vault{}, errors.Wrap(errors.ErrInvalidVaultDir, dir)
+		}
+	}
+	if entries, err := os.ReadDir(dir); err == nil && len(entries) > 0 {
+		return Vault{}, errors.Wrap(errors.ErrVaultDirNotEmpty, dir)
+	} else if err != nil && !os.IsNotExist(err) {
+		return Vault{}, errors.Wrap(err, "invalid vault directory")
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return Vault{}, errors.Wrap(err, "error creating data directory")
+	}
+
+	vault := Vault{Name: name, Dir: dir}
+	r.Vaults = append(r.Vaults, vault)
+	return vault, r.save()
+}
+
+// Use makes the vault called name the default.
+func (r *Registry) Use(name string) error {
+	if _, err := r.Get(name); err != nil {
+		return err
+	}
+	r.Default = name
+	return r.save()
+}
+
+// Remove unregisters the vault called name. If deleteFiles is set, the files
+// of the vault are deleted from its data directory, and the directory too
+// if nothing else is left in it. The "default" vault and the vault currently
+// used by default cannot be removed.
+func (r *Registry) Remove(name string, deleteFiles bool) error {
+	vault, err := r.Get(name)
+	if err != nil {
+		return err
+	}
+	if name == DefaultName || name == r.Default {
+		return errors.Wrap(errors.ErrVaultInUse, name)
+	}
+
+	for i := range r.Vaults {
+		if r.Vaults[i].Name == name {
+			r.Vaults = append(r.Vaults[:i], r.Vaults[i+1:]...)
+			break
+		}
+	}
+	if err := r.save(); err != nil {
+		return err
+	}
+	if deleteFiles {
+		for _, name := range vaultFiles {
+			if err := os.RemoveAll(filepath.Join(vault.Dir, name)); err != nil {
+				return errors.Wrap(err, "failed to delete vault files")
+			}
+		}
+		// Fails if other files are left, which are kept
+		os.Remove(vault.Dir)
+	}
+	return nil
+}
+
+// isWithin reports whether path is dir or inside it.
+func isWithin(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// save writes the registry file.
+func (r *Registry) save() error {
+	if err := os.MkdirAll(r.root, 0700); err != nil {
+		return errors.Wrap(err, "error creating data directory")
+	}
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "failed to encode vault registry")
+	}
+	if err := os.WriteFile(filepath.Join(r.root, RegistryFileName), data, 0600); err != nil {
+		return errors.Wrap(err, "failed to write vault registry")
+	}
+	return nil
+}
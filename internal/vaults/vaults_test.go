@@ -0,0 +1,106 @@
+package vaults
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/simp-lee/passwordmanager/internal/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRootDir(t *testing.T) {
+	flag := t.TempDir()
+	env := t.TempDir()
+	t.Setenv(EnvDataDir, env)
+
+	root, err := RootDir(flag)
+	require.NoError(t, err)
+	assert.Equal(t, flag, root, "The flag wins over the environment")
+
+	root, err = RootDir("")
+	require.NoError(t, err)
+	assert.Equal(t, env, root)
+
+	t.Setenv(EnvDataDir, "")
+	t.Setenv("HOME", flag)
+	root, err = RootDir("")
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(flag, rootDirName), root)
+}
+
+func TestRegistry(t *testing.T) {
+	root := t.TempDir()
+	r, err := Load(root)
+	require.NoError(t, err)
+	assert.Equal(t, []Vault{{Name: DefaultName, Dir: root}}, r.List(), "Without a registry only the default vault exists")
+
+	team, err := r.Create("team", "")
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(root, vaultsDirName, "team"), team.Dir)
+	assert.DirExists(t, team.Dir)
+	clientDir := filepath.Join(t.TempDir(), "acme")
+	_, err = r.Create("acme", clientDir)
+	require.NoError(t, err)
+
+	for _, name := range []string{"", "../x", "a/b", ".hidden", "with space"} {
+		_, err = r.Create(name, "")
+		assert.ErrorIs(t, err, errors.ErrInvalidVaultName, name)
+	}
+	_, err = r.Create("team", "")
+	assert.ErrorIs(t, err, errors.ErrVaultNameExists)
+	_, err = r.Create("copy", clientDir)
+	assert.ErrorIs(t, err, errors.ErrVaultNameExists, "Two vaults cannot share a directory")
+	_, err = r.Create("home", filepath.Dir(root))
+	assert.ErrorIs(t, err, errors.ErrInvalidVaultDir, "A vault directory must not contain the root")
+	_, err = r.Create("root", root)
+	assert.ErrorIs(t, err, errors.ErrVaultNameExists)
+	_, err = r.Create("parent", filepath.Dir(clientDir))
+	assert.ErrorIs(t, err, errors.ErrInvalidVaultDir, "A vault directory must not contain another vault")
+	busyDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(busyDir, "notes.txt"), []byte("mine"), 0600))
+	_, err = r.Create("busy", busyDir)
+	assert.ErrorIs(t, err, errors.ErrVaultDirNotEmpty)
+
+	require.NoError(t, r.Use("team"))
+
+	// The registry is persisted
+	r, err = Load(root)
+	require.NoError(t, err)
+	var names []string
+	for _, vault := range r.List() {
+		names = append(names, vault.Name)
+	}
+	assert.Equal(t, []string{DefaultName, "acme", "team"}, names)
+	current, err := r.Get("")
+	require.NoError(t, err)
+	assert.Equal(t, team, current)
+	_, err = r.Get("missing")
+	assert.ErrorIs(t, err, errors.ErrVaultNotFound)
+
+	assert.ErrorIs(t, r.Remove("team", false), errors.ErrVaultInUse)
+	assert.ErrorIs(t, r.Remove(DefaultName, false), errors.ErrVaultInUse)
+	require.NoError(t, r.Remove("acme", false))
+	assert.DirExists(t, clientDir, "Files are kept unless asked otherwise")
+	require.NoError(t, r.Use(DefaultName))
+	require.NoError(t, os.WriteFile(filepath.Join(team.Dir, "vault.encrypted"), []byte("vault"), 0600))
+	require.NoError(t, os.MkdirAll(filepath.Join(team.Dir, "backups"), 0700))
+	require.NoError(t, r.Remove("team", true))
+	assert.NoDirExists(t, team.Dir)
+	assert.Len(t, r.List(), 1)
+}
+
+func TestRemoveKeepsOtherFiles(t *testing.T) {
+	r, err := Load(t.TempDir())
+	require.NoError(t, err)
+	dir := filepath.Join(t.TempDir(), "work")
+	_, err = r.Create("work", dir)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "vault.encrypted"), []byte("vault"), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "report.pdf"), []byte("not ours"), 0600))
+
+	require.NoError(t, r.Remove("work", true))
+	assert.NoFileExists(t, filepath.Join(dir, "vault.encrypted"))
+	assert.FileExists(t, filepath.Join(dir, "report.pdf"), "Only the files of the vault are deleted")
+}